@@ -0,0 +1,197 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+const (
+	// maxArchiveFiles and maxArchiveBytes bound the number of files and the uncompressed size of the archive option.
+	maxArchiveFiles = 10000
+	maxArchiveBytes = 64 << 20
+)
+
+// bundle is the content of the archive option: the regular files of a zip, tar or gzipped tar archive by path.
+type bundle struct {
+	format string
+	names  []string
+	files  map[string][]byte
+	size   int
+}
+
+func (b *bundle) add(name string, r io.Reader) error {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(b.names) == maxArchiveFiles {
+		return fmt.Errorf("the archive has more than %d files", maxArchiveFiles)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxArchiveBytes-b.size)+1))
+	if err != nil {
+		return fmt.Errorf("failed to read the file %q: %v", name, err)
+	}
+	if b.size += len(data); b.size > maxArchiveBytes {
+		return fmt.Errorf("the files of the archive are larger than %d bytes", maxArchiveBytes)
+	}
+	if _, ok := b.files[name]; !ok {
+		b.names = append(b.names, name)
+	}
+	b.files[name] = data
+	return nil
+}
+
+// parseArchive detects the format of the archive from its first bytes.
+func parseArchive(data []byte) (*bundle, error) {
+	b := &bundle{files: map[string][]byte{}}
+	switch {
+	case bytes.HasPrefix(data, []byte("PK")):
+		b.format = "zip"
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range reader.File {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			r, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open the file %q: %v", file.Name, err)
+			}
+			err = b.add(file.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		b.format = "tar.gz"
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if err := b.addTar(tar.NewReader(gz)); err != nil {
+			return nil, err
+		}
+	default:
+		b.format = "tar"
+		if err := b.addTar(tar.NewReader(bytes.NewReader(data))); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(b.names)
+	return b, nil
+}
+
+func (b *bundle) addTar(reader *tar.Reader) error {
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("the archive is not a valid zip, tar or gzipped tar archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := b.add(header.Name, reader); err != nil {
+			return err
+		}
+	}
+}
+
+// parseArchiveOption returns the content of the archive option, nil if it is missing.
+func parseArchiveOption(options js.Value) (*bundle, error) {
+	value := getOption(options, "archive")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if !value.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, fmt.Errorf("the option \"archive\" must be a Uint8Array. Actual type %s", value.Type())
+	}
+	data := make([]byte, value.Length())
+	js.CopyBytesToGo(data, value)
+	b, err := parseArchive(data)
+	if err != nil {
+		return nil, fmt.Errorf("the option \"archive\" can't be read: %v", err)
+	}
+	return b, nil
+}
+
+// newArchiveModule returns the archive module over the files of the archive option, it is empty if the option is missing.
+// archive.list(pattern = "*") returns the sorted paths matching the pattern (see path.Match) and
+// archive.read(path, binary = False) returns the content of a file as a string or as bytes.
+func newArchiveModule(b *bundle) *starlarkstruct.Module {
+	if b == nil {
+		b = &bundle{files: map[string][]byte{}}
+	}
+	return &starlarkstruct.Module{
+		Name: "archive",
+		Members: starlark.StringDict{
+			"list": starlark.NewBuiltin("archive.list", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				checkpoint(thread)
+				pattern := ""
+				if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "pattern?", &pattern); err != nil {
+					return nil, err
+				}
+				list := []starlark.Value{}
+				for _, name := range b.names {
+					if pattern != "" {
+						matched, err := path.Match(pattern, name)
+						if err != nil {
+							return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+						}
+						if !matched {
+							continue
+						}
+					}
+					list = append(list, starlark.String(name))
+				}
+				return starlark.NewList(list), nil
+			}),
+			"read": starlark.NewBuiltin("archive.read", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				checkpoint(thread)
+				var name string
+				binary := false
+				if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "path", &name, "binary?", &binary); err != nil {
+					return nil, err
+				}
+				data, ok := b.files[strings.TrimPrefix(path.Clean("/"+name), "/")]
+				if !ok {
+					if b.format == "" {
+						return nil, fmt.Errorf("%s: there is no archive, see the option \"archive\"", fn.Name())
+					}
+					return nil, fmt.Errorf("%s: the file %q is not in the archive", fn.Name(), name)
+				}
+				if binary {
+					return starlark.Bytes(data), nil
+				}
+				return starlark.String(data), nil
+			}),
+		},
+	}
+}
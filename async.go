@@ -0,0 +1,164 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+// newPromise returns a javascript Promise. start is called immediately with the functions that resolve and reject the promise.
+func newPromise(start func(resolve, reject func(interface{}))) js.Value {
+	executor := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		start(func(value interface{}) { resolve.Invoke(value) }, func(reason interface{}) { reject.Invoke(reason) })
+		return nil
+	})
+	// the executor is called synchronously by the Promise constructor
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
+
+// awaitPromise blocks until the javascript Promise settles and returns its value or the reason it was rejected.
+// It must only be called from a goroutine that is allowed to block (e.g. an execution of run_starlark_code_async),
+// blocking a synchronous call from javascript deadlocks the page.
+func awaitPromise(promise js.Value) (js.Value, error) {
+	done := make(chan struct{})
+	var value js.Value
+	var err error
+	onFulfilled := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		value = args[0]
+		close(done)
+		return nil
+	})
+	defer onFulfilled.Release()
+	onRejected := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		err = fmt.Errorf("%s", js.Global().Get("String").Invoke(args[0]).String())
+		close(done)
+		return nil
+	})
+	defer onRejected.Release()
+	promise.Call("then", onFulfilled, onRejected)
+	<-done
+	return value, err
+}
+
+// isThenable returns true if the value is a javascript Promise or another object with a then method.
+func isThenable(value js.Value) bool {
+	return value.Type() == js.TypeObject && value.Get("then").Type() == js.TypeFunction
+}
+
+// settleHostValue returns the value returned by a javascript callback, or the value it resolves to if it is a Promise.
+// Promises can only be awaited if the caller is allowed to block.
+func settleHostValue(value js.Value, canBlock bool) (js.Value, error) {
+	if !isThenable(value) {
+		return value, nil
+	}
+	if !canBlock {
+		return js.Undefined(), fmt.Errorf("returned a Promise, which can only be awaited by run_starlark_code_async")
+	}
+	value, err := awaitPromise(value)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("returned a Promise that was rejected: %v", err)
+	}
+	return value, nil
+}
+
+// allowBlocking lets the execution and the javascript callbacks of its options wait for promises.
+func (opts *runOptions) allowBlocking() {
+	opts.canBlock = true
+	if fs, ok := opts.fs.(jsFileSystem); ok {
+		fs.canBlock = true
+		opts.fs = fs
+	}
+	if c, ok := opts.clock.(jsClock); ok {
+		c.canBlock = true
+		opts.clock = c
+	}
+}
+
+// newStarlarkError converts a failed result object into a javascript Error named StarlarkError.
+// The other fields of the result (errorCode, details, stats, etc.) are copied to the error.
+func newStarlarkError(result map[string]interface{}) js.Value {
+	message, _ := result["error"].(string)
+	err := js.Global().Get("Error").New(message)
+	err.Set("name", "StarlarkError")
+	for key, value := range result {
+		if key != "error" {
+			err.Set(key, value)
+		}
+	}
+	return err
+}
+
+// startAsyncRun schedules an execution of run_starlark_code_async with its arguments (the source code and the options).
+// settle is called with the result once the execution is done, on the goroutine of the execution.
+func startAsyncRun(args []js.Value, settle func(result map[string]interface{})) {
+	if len(args) < 1 {
+		err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+		settle(map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()})
+		return
+	}
+	starlark_code := args[0].String()
+	options := js.Undefined()
+	if len(args) > 1 {
+		options = args[1]
+	}
+	opts, err := parseRunOptions(options)
+	if err != nil {
+		err := fmt.Errorf("Error: invalid options. Error: %q", err)
+		settle(map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()})
+		return
+	}
+	opts.allowBlocking()
+	err = asyncScheduler.schedule(func(queue queueInfo) {
+		var result map[string]interface{}
+		if opts.verifyDeterminism {
+			result = runDeterminismCheck(starlark_code, func() (runOptions, error) {
+				opts, err := parseRunOptions(options)
+				opts.allowBlocking()
+				return opts, err
+			})
+		} else {
+			result = runStarlarkCode(starlark_code, opts)
+		}
+		result["queue"] = queue.toJS()
+		settle(result)
+	})
+	if err != nil {
+		err := fmt.Errorf("Error: too many executions. Error: %q", err)
+		settle(map[string]interface{}{"error": err.Error(), "errorCode": "resource_exhausted", "stats": executionStats{}.toJS()})
+	}
+}
+
+func getAsyncStarlarkRunner() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return newPromise(func(resolve, reject func(interface{})) {
+			options := js.Undefined()
+			if len(args) > 1 {
+				options = args[1]
+			}
+			// the promise is rejected with a StarlarkError instead of resolved with a failed result if rejectOnError is set
+			rejectOnError, _ := getBoolOption(options, "rejectOnError")
+			startAsyncRun(args, func(result map[string]interface{}) {
+				if _, failed := result["error"]; failed && rejectOnError {
+					reject(newStarlarkError(result))
+					return
+				}
+				resolve(result)
+			})
+		})
+	})
+}
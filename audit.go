@@ -0,0 +1,142 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// auditArgumentBytes is the maximum length of the repr of an argument in the summary of an audit record.
+const auditArgumentBytes = 64
+
+// auditOptions control the audit log of the calls the script makes to the host, for security reviews of untrusted scripts.
+// The audited calls are the calls to the builtins registered by Go and javascript, the file builtins, the time module,
+// the modules fetched over https and the attempts to use a builtin whose capability has not been granted.
+type auditOptions struct {
+	// enabled records the calls, it is set by the audit option or by onAudit.
+	enabled bool
+	// onAudit is called with each record, undefined (the zero value) to add the records to the result instead.
+	onAudit js.Value
+}
+
+func parseAuditOptions(options js.Value) (auditOptions, error) {
+	opts := auditOptions{}
+	enabled, err := getBoolOption(options, "audit")
+	if err != nil {
+		return opts, err
+	}
+	onAudit, ok, err := getFunctionOption(options, "onAudit")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		opts.onAudit = onAudit
+	}
+	opts.enabled = enabled || ok
+	return opts, nil
+}
+
+// summarizeValue returns the repr of a value truncated to auditArgumentBytes.
+func summarizeValue(value starlark.Value) string {
+	repr := value.String()
+	if len(repr) > auditArgumentBytes {
+		repr = strings.ToValidUTF8(repr[:auditArgumentBytes], "") + "..."
+	}
+	return repr
+}
+
+// summarizeArgs returns the reprs of the arguments separated by commas, each one truncated to auditArgumentBytes.
+func summarizeArgs(args starlark.Tuple, kwargs []starlark.Tuple) string {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, summarizeValue(arg))
+	}
+	for _, kwarg := range kwargs {
+		parts = append(parts, string(kwarg[0].(starlark.String))+"="+summarizeValue(kwarg[1]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// audit records a call to the host. status is "ok", "error", "denied", "quota" or "dry_run", err the error of the call if it failed.
+func (e *execution) audit(thread *starlark.Thread, name, args string, start time.Time, status string, err error) {
+	if !e.opts.audit.enabled {
+		return
+	}
+	record := map[string]interface{}{
+		"name":        name,
+		"args":        args,
+		"status":      status,
+		"durationMs":  float64(time.Since(start)) / float64(time.Millisecond),
+		"timestampMs": float64(start.UnixNano()) / float64(time.Millisecond),
+	}
+	if err != nil {
+		record["error"] = err.Error()
+	}
+	if thread.CallStackDepth() > 1 {
+		pos := thread.CallFrame(1).Pos
+		record["position"] = map[string]interface{}{"file": pos.Filename(), "line": int(pos.Line), "col": int(pos.Col)}
+	}
+	if e.opts.audit.onAudit.IsUndefined() {
+		e.auditLog = append(e.auditLog, record)
+	} else {
+		e.opts.audit.onAudit.Invoke(record)
+	}
+}
+
+// hostCalls wraps a host builtin, or the builtins of a host module, so that their calls are recorded and count against
+// the quotas (and are intercepted in dry runs, recorded or replayed), with the name (the members of modules with the name of the module as prefix) and the capability of the builtin.
+// Denied builtins record the attempts to use them. The other values are returned unchanged.
+func (e *execution) hostCalls(name, capability string, value starlark.Value) starlark.Value {
+	switch v := value.(type) {
+	case *starlark.Builtin:
+		return starlark.NewBuiltin(v.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			start := time.Now()
+			if e.session != nil {
+				e.session.countHostCall()
+			}
+			if err := e.useQuota(name, capability); err != nil {
+				e.audit(thread, name, summarizeArgs(args, kwargs), start, "quota", err)
+				return nil, err
+			}
+			if e.opts.dryRun.intercepts(name, capability) {
+				e.recordEffect(thread, name, args, kwargs)
+				e.audit(thread, name, summarizeArgs(args, kwargs), start, "dry_run", nil)
+				return starlark.None, nil
+			}
+			result, err := e.callRecorded(thread, name, v, args, kwargs)
+			status := "ok"
+			if err != nil {
+				status = "error"
+			}
+			e.audit(thread, name, summarizeArgs(args, kwargs), start, status, err)
+			return result, err
+		})
+	case *starlarkstruct.Module:
+		members := starlark.StringDict{}
+		for member, value := range v.Members {
+			members[member] = e.hostCalls(name+"."+member, capability, value)
+		}
+		return &starlarkstruct.Module{Name: v.Name, Members: members}
+	case deniedBuiltin:
+		v.e = e
+		return v
+	}
+	return value
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// batchCall is one of the function calls of run_starlark_batch.
+type batchCall struct {
+	funcName    string
+	args        []js.Value
+	argsJSON    string
+	argsMsgpack []byte
+}
+
+func parseBatchCalls(value js.Value) ([]batchCall, error) {
+	if !value.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("the calls must be an array. Actual type %s", value.Type())
+	}
+	calls := []batchCall{}
+	for i := 0; i < value.Length(); i++ {
+		call := value.Index(i)
+		if call.Type() != js.TypeObject {
+			return nil, fmt.Errorf("the call %d must be an object. Actual type %s", i, call.Type())
+		}
+		// the calls take the same function options as run_starlark_code_with_options
+		opts, err := parseRunOptions(call)
+		if err != nil {
+			return nil, fmt.Errorf("invalid call %d. Error: %q", i, err)
+		}
+		calls = append(calls, batchCall{funcName: opts.funcName, args: opts.args, argsJSON: opts.argsJSON, argsMsgpack: opts.argsMsgpack})
+	}
+	return calls, nil
+}
+
+// runStarlarkBatch executes the starlark code once and then calls each function in order.
+// A failed call doesn't stop the following ones, each call has its own result.
+func runStarlarkBatch(starlark_code string, calls []batchCall, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	program, errResult := e.compileSource(starlark_code, e.predeclared().Has)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	globals, err := program.Init(e.thread, e.predeclared())
+	globals.Freeze()
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark code", err))
+	}
+	message, truncated := e.output.String(), e.output.truncated
+	results := []interface{}{}
+	for _, call := range calls {
+		results = append(results, e.runBatchCall(globals, call))
+	}
+	result = map[string]interface{}{"message": message, "results": results}
+	if truncated {
+		result["truncated"] = true
+	}
+	return e.finish(result)
+}
+
+// runBatchCall calls one function of the batch. The message of its result is the output of the call only,
+// so the output budget (maxOutputBytes) applies to each call.
+func (e *execution) runBatchCall(globals starlark.StringDict, call batchCall) map[string]interface{} {
+	e.opts.funcName, e.opts.args, e.opts.argsJSON, e.opts.argsTagged = call.funcName, call.args, call.argsJSON, ""
+	e.opts.argsMsgpack = call.argsMsgpack
+	e.output.reset()
+	defer e.output.reset()
+	stepsStart := e.thread.ExecutionSteps()
+	callResult := func() map[string]interface{} {
+		funcArgs, err := e.convertArgs()
+		if err != nil {
+			return invalidArgumentsResult(err)
+		}
+		fn, errResult := lookupFunction(globals, call.funcName, "starlark code")
+		if errResult != nil {
+			return errResult
+		}
+		returnValue, errResult := callStarlarkFunction(e, call.funcName, fn, funcArgs)
+		if errResult != nil {
+			return errResult
+		}
+		return e.withReturnValue(map[string]interface{}{"message": e.output.String()}, returnValue)
+	}()
+	if _, ok := callResult["message"]; ok && e.output.truncated {
+		callResult["truncated"] = true
+	}
+	callResult["funcName"] = call.funcName
+	callResult["steps"] = float64(e.thread.ExecutionSteps() - stepsStart)
+	return callResult
+}
+
+func getStarlarkBatchRunner() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected at least two arguments with the source code and the calls. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[0].String()
+		calls, err := parseBatchCalls(args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid calls. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		options := js.Undefined()
+		if len(args) > 2 {
+			options = args[2]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		return runStarlarkBatch(starlark_code, calls, opts)
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"syscall/js"
+	"time"
+)
+
+// defaultWarmupIterations is the number of calls made before the measured ones if the warmup option is not given.
+const defaultWarmupIterations = 3
+
+// benchmarkSummary returns the min, median, p95, mean and max of the measurements (nearest rank percentiles).
+func benchmarkSummary(values []float64) map[string]interface{} {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := func(p float64) float64 {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	return map[string]interface{}{"min": sorted[0], "median": rank(0.5), "p95": rank(0.95), "mean": sum / float64(len(sorted)), "max": sorted[len(sorted)-1]}
+}
+
+// runStarlarkBenchmark executes the starlark code once and then calls the function warmup times and iterations times,
+// measuring the duration and the number of steps of the measured calls. The arguments are converted again for each call,
+// outside of the measurements, since the function may modify them. The first failed call stops the benchmark.
+func runStarlarkBenchmark(starlark_code string, iterations, warmup int, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	program, errResult := e.compileSource(starlark_code, e.predeclared().Has)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	globals, err := program.Init(e.thread, e.predeclared())
+	globals.Freeze()
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark code", err))
+	}
+	message := e.output.String()
+	fn, errResult := lookupFunction(globals, opts.funcName, "starlark code")
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	durations, steps := []float64{}, []float64{}
+	for i := 0; i < warmup+iterations; i++ {
+		funcArgs, err := e.convertArgs()
+		if err != nil {
+			return e.finish(invalidArgumentsResult(err))
+		}
+		e.output.reset()
+		stepsStart, start := e.thread.ExecutionSteps(), time.Now()
+		_, errResult := callStarlarkFunction(e, opts.funcName, fn, funcArgs)
+		duration := time.Since(start)
+		if errResult != nil {
+			details, ok := errResult["details"].(map[string]interface{})
+			if !ok {
+				details = map[string]interface{}{}
+				errResult["details"] = details
+			}
+			details["iteration"], details["warmup"] = i, i < warmup
+			return e.finish(errResult)
+		}
+		if i >= warmup {
+			durations = append(durations, float64(duration)/float64(time.Millisecond))
+			steps = append(steps, float64(e.thread.ExecutionSteps()-stepsStart))
+		}
+	}
+	e.output.reset()
+	return e.finish(map[string]interface{}{
+		"message":    message,
+		"funcName":   opts.funcName,
+		"iterations": iterations,
+		"warmup":     warmup,
+		"durationMs": benchmarkSummary(durations),
+		"steps":      benchmarkSummary(steps),
+	})
+}
+
+func getStarlarkBenchmarker() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 4 || args[1].Type() != js.TypeString || !args[2].InstanceOf(jsArray) || args[3].Type() != js.TypeNumber {
+			err := fmt.Errorf("Error: expected four arguments with the source code, the function name, the array of arguments and the number of iterations. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[0].String()
+		iterations := args[3].Float()
+		if iterations < 1 || iterations != math.Trunc(iterations) {
+			err := fmt.Errorf("Error: the number of iterations must be a positive integer. Actual value %v", iterations)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		options := js.Undefined()
+		if len(args) > 4 {
+			options = args[4]
+		}
+		opts, err := parseRunOptions(options)
+		warmup := defaultWarmupIterations
+		if err == nil {
+			var value float64
+			var ok bool
+			if value, ok, err = getNumberOption(options, "warmup"); ok && (value < 0 || value != math.Trunc(value)) {
+				err = fmt.Errorf("the option \"warmup\" must be a non negative integer. Actual value %v", value)
+			} else if ok {
+				warmup = int(value)
+			}
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		opts.funcName, opts.args = args[1].String(), nil
+		for i := 0; i < args[2].Length(); i++ {
+			opts.args = append(opts.args, args[2].Index(i))
+		}
+		return runStarlarkBenchmark(starlark_code, int(iterations), warmup, opts)
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// convertToBinaryJSValue copies bytes and lists of numbers into a typed array in one shot
+// instead of setting the elements of a javascript array one by one.
+// Bytes and lists of ints between 0 and 255 become a Uint8Array, other lists of 32 bit ints become an Int32Array
+// and lists of floats become a Float64Array.
+// The boolean is false if the value can't be converted to a typed array.
+func convertToBinaryJSValue(value starlark.Value) (js.Value, bool) {
+	switch v := value.(type) {
+	case starlark.Bytes:
+		return copyBytesToUint8Array([]byte(v)), true
+	case *starlark.List, starlark.Tuple:
+		list := v.(starlark.Indexable)
+		if list.Len() > 0 {
+			if _, ok := list.Index(0).(starlark.Float); ok {
+				return convertFloatsToTypedArray(list)
+			}
+		}
+		return convertIntsToTypedArray(list)
+	}
+	return js.Undefined(), false
+}
+
+func convertFloatsToTypedArray(list starlark.Indexable) (js.Value, bool) {
+	data := make([]byte, 8*list.Len())
+	for i := 0; i < list.Len(); i++ {
+		elem, ok := list.Index(i).(starlark.Float)
+		if !ok {
+			return js.Undefined(), false
+		}
+		binary.LittleEndian.PutUint64(data[8*i:], math.Float64bits(float64(elem)))
+	}
+	return js.Global().Get("Float64Array").New(copyBytesToUint8Array(data).Get("buffer")), true
+}
+
+// typedArrayElementSizes are the sizes in bytes of the elements of the typed arrays.
+var typedArrayElementSizes = map[string]int{
+	"Int8Array": 1, "Uint8Array": 1, "Uint8ClampedArray": 1, "Int16Array": 2, "Uint16Array": 2, "Int32Array": 4, "Uint32Array": 4,
+	"Float32Array": 4, "Float64Array": 8, "BigInt64Array": 8, "BigUint64Array": 8,
+}
+
+// typedArrayKind returns the name of the constructor of a typed array and the size of its elements.
+// The boolean is false if the value is not a typed array (DataViews are not).
+func typedArrayKind(value js.Value) (string, int, bool) {
+	if !js.Global().Get("ArrayBuffer").Call("isView", value).Bool() {
+		return "", 0, false
+	}
+	kind := value.Get("constructor").Get("name").String()
+	size, ok := typedArrayElementSizes[kind]
+	return kind, size, ok
+}
+
+// convertTypedArrayToStarlarkValue copies the contents of a typed array in one shot and converts them into a list of ints or floats.
+func convertTypedArrayToStarlarkValue(value js.Value, kind string, size int) *starlark.List {
+	data := make([]byte, value.Get("byteLength").Int())
+	js.CopyBytesToGo(data, js.Global().Get("Uint8Array").New(value.Get("buffer"), value.Get("byteOffset"), len(data)))
+	elems := make([]starlark.Value, len(data)/size)
+	for i := range elems {
+		// WebAssembly is little endian, like the typed arrays of the hosts it runs on
+		b := data[i*size:]
+		switch kind {
+		case "Int8Array":
+			elems[i] = starlark.MakeInt(int(int8(b[0])))
+		case "Uint8Array", "Uint8ClampedArray":
+			elems[i] = starlark.MakeInt(int(b[0]))
+		case "Int16Array":
+			elems[i] = starlark.MakeInt(int(int16(binary.LittleEndian.Uint16(b))))
+		case "Uint16Array":
+			elems[i] = starlark.MakeInt(int(binary.LittleEndian.Uint16(b)))
+		case "Int32Array":
+			elems[i] = starlark.MakeInt(int(int32(binary.LittleEndian.Uint32(b))))
+		case "Uint32Array":
+			elems[i] = starlark.MakeUint64(uint64(binary.LittleEndian.Uint32(b)))
+		case "Float32Array":
+			elems[i] = starlark.Float(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case "Float64Array":
+			elems[i] = starlark.Float(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		case "BigInt64Array":
+			elems[i] = starlark.MakeInt64(int64(binary.LittleEndian.Uint64(b)))
+		case "BigUint64Array":
+			elems[i] = starlark.MakeUint64(binary.LittleEndian.Uint64(b))
+		}
+	}
+	return starlark.NewList(elems)
+}
+
+func copyBytesToUint8Array(data []byte) js.Value {
+	array := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(array, data)
+	return array
+}
+
+func convertIntsToTypedArray(list starlark.Indexable) (js.Value, bool) {
+	ints := make([]int64, list.Len())
+	isByteArray := true
+	for i := range ints {
+		elem, ok := list.Index(i).(starlark.Int)
+		if !ok {
+			return js.Undefined(), false
+		}
+		intVal, ok := elem.Int64()
+		if !ok || intVal < math.MinInt32 || intVal > math.MaxInt32 {
+			return js.Undefined(), false
+		}
+		if intVal < 0 || intVal > math.MaxUint8 {
+			isByteArray = false
+		}
+		ints[i] = intVal
+	}
+	if isByteArray {
+		data := make([]byte, len(ints))
+		for i, intVal := range ints {
+			data[i] = byte(intVal)
+		}
+		return copyBytesToUint8Array(data), true
+	}
+	// WebAssembly is little endian so the bytes can be reinterpreted as an Int32Array.
+	data := make([]byte, 4*len(ints))
+	for i, intVal := range ints {
+		binary.LittleEndian.PutUint32(data[4*i:], uint32(int32(intVal)))
+	}
+	return js.Global().Get("Int32Array").New(copyBytesToUint8Array(data).Get("buffer")), true
+}
@@ -0,0 +1,250 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Forks can add builtins to the predeclared environment of every execution and session without patching main.go,
+// by calling registerBuiltin from an init function in a file of their own:
+//
+//	func init() {
+//		registerBuiltin("hash", starlark.NewBuiltin("hash", hashBuiltin))
+//		registerBuiltin("crypto", &starlarkstruct.Module{Name: "crypto", Members: starlark.StringDict{...}})
+//	}
+//
+// The javascript host can do the same for simple cases with register_starlark_builtin, these builtins are only
+// available to the executions that are granted their capability (see capabilities.go).
+
+// alwaysBuiltins, optionBuiltins and sessionBuiltins are the names of the builtins added to the universal ones by
+// execution.predeclared and session.predeclared, which can't be used by the registered builtins since they would be hidden.
+var (
+	alwaysBuiltins  = []string{"archive", "channel", "check_cancelled", "env", "fail_with", "input", "intl", "log", "report_progress", "result", "semver", "template"}
+	optionBuiltins  = map[string]string{"time": "timeModule", "read_file": "fileBuiltins", "write_file": "fileBuiltins", "glob": "fileBuiltins"}
+	sessionBuiltins = []string{"handle", "on", "schedule"}
+)
+
+// checkBuiltinName fails if the name is not an identifier or is the name of a builtin of the runtime.
+func checkBuiltinName(name string) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("the name %q is not a valid starlark identifier", name)
+	}
+	_, isOption := optionBuiltins[name]
+	if checkedUniverse.Has(name) || starlark.Universe.Has(name) || isOption || containsString(alwaysBuiltins, name) || containsString(sessionBuiltins, name) {
+		return fmt.Errorf("the name %q is already used by a builtin of the runtime", name)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// registerBuiltin adds a frozen value to the predeclared environment. It is meant to be called from init functions
+// and panics if the name can't be used. Unlike the builtins registered by javascript it survives starlark_reset.
+func registerBuiltin(name string, value starlark.Value) {
+	if err := checkBuiltinName(name); err != nil {
+		panic(err)
+	}
+	if builtin, ok := value.(*starlark.Builtin); ok {
+		value = withCheckpoint(builtin)
+	}
+	value.Freeze()
+	preludes.Lock()
+	defer preludes.Unlock()
+	preludes.builtins[name] = value
+	// the universal builtins may not be wrapped yet, the environment is rebuilt when it is first used
+	preludes.predeclared = nil
+}
+
+// hostBuiltinCapabilities returns the builtins that reach the host with their capability: the builtins that require
+// a capability and the builtins registered by javascript and Go, which have no capability.
+func hostBuiltinCapabilities() map[string]string {
+	capabilities := map[string]string{}
+	for capability, builtins := range capabilityBuiltins {
+		for _, name := range builtins {
+			capabilities[name] = capability
+		}
+	}
+	preludes.Lock()
+	defer preludes.Unlock()
+	for name := range preludes.builtins {
+		capabilities[name] = ""
+	}
+	for name, builtin := range preludes.jsBuiltins {
+		capabilities[name] = builtin.capability
+	}
+	return capabilities
+}
+
+// withCheckpoint wraps a builtin so that it runs the checkpoint hooks, like the universal builtins.
+func withCheckpoint(builtin *starlark.Builtin) *starlark.Builtin {
+	return starlark.NewBuiltin(builtin.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		checkpoint(thread)
+		return builtin.CallInternal(thread, args, kwargs)
+	})
+}
+
+// jsBuiltin is a builtin registered by javascript and the capability an execution needs to use it.
+type jsBuiltin struct {
+	value      starlark.Value
+	capability string
+}
+
+// registeredJSBuiltins returns the builtins registered by javascript. They are not part of the predeclared environment
+// shared by the executions and the modules, execution.predeclared adds the ones whose capability has been granted.
+func registeredJSBuiltins() map[string]jsBuiltin {
+	preludes.Lock()
+	defer preludes.Unlock()
+	builtins := make(map[string]jsBuiltin, len(preludes.jsBuiltins))
+	for name, builtin := range preludes.jsBuiltins {
+		builtins[name] = builtin
+	}
+	return builtins
+}
+
+// registerJSBuiltin adds a javascript function as a builtin, or an object as a module whose functions are builtins
+// and whose other values are converted once. Executions can only use it if they are granted the capability,
+// which is the name of the builtin if it is empty. It returns the sorted names of the builtins registered by javascript.
+func registerJSBuiltin(name string, value js.Value, capability string) ([]string, error) {
+	if err := checkBuiltinName(name); err != nil {
+		return nil, err
+	}
+	if capability == "" {
+		capability = name
+	}
+	var builtin starlark.Value
+	switch value.Type() {
+	case js.TypeFunction:
+		builtin = newJSBuiltin(name, value)
+	case js.TypeObject:
+		if value.IsNull() || value.InstanceOf(jsArray) {
+			return nil, fmt.Errorf("the builtin %q must be a function or an object. Actual value %s", name, jsJSON.Call("stringify", value).String())
+		}
+		members := starlark.StringDict{}
+		keys := jsObject.Call("keys", value)
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			if !isIdentifier(key) {
+				return nil, fmt.Errorf("the member %q of the module %q is not a valid starlark identifier", key, name)
+			}
+			member := value.Get(key)
+			if member.Type() == js.TypeFunction {
+				members[key] = newJSBuiltin(name+"."+key, member)
+				continue
+			}
+			converted, err := (&converter{}).convertToStarlarkValue(member)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert the member %q of the module %q. Error: %v", key, name, err)
+			}
+			members[key] = converted
+		}
+		builtin = &starlarkstruct.Module{Name: name, Members: members}
+	default:
+		return nil, fmt.Errorf("the builtin %q must be a function or an object. Actual type %s", name, value.Type())
+	}
+	builtin.Freeze()
+	preludes.Lock()
+	defer preludes.Unlock()
+	preludes.jsBuiltins[name] = jsBuiltin{value: builtin, capability: capability}
+	names := []string{}
+	for name := range preludes.jsBuiltins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// newJSBuiltin wraps a javascript function in a builtin. The positional arguments are converted to javascript,
+// keyword arguments are not supported. The function may return a promise when the code is run by run_starlark_code_async,
+// a thrown error becomes a starlark error.
+func newJSBuiltin(name string, fn js.Value) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (result starlark.Value, err error) {
+		checkpoint(thread)
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword argument %s", b.Name(), kwargs[0][0])
+		}
+		conv := &converter{}
+		if e := threadExecution(thread); e != nil {
+			conv.depthLimit, conv.intOverflow, conv.invalidUnicode = e.opts.maxConversionDepth, e.opts.intOverflow, e.opts.invalidUnicode
+		}
+		jsArgs := []interface{}{}
+		for i, arg := range args {
+			converted, err := conv.convertToJSValue(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: failed to convert the argument %d. Error: %v", b.Name(), i, err)
+			}
+			jsArgs = append(jsArgs, converted)
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				jsErr, ok := r.(js.Error)
+				if !ok {
+					panic(r)
+				}
+				result, err = nil, fmt.Errorf("%s: %s", b.Name(), jsErr.Error())
+			}
+		}()
+		value, err := settleHostValue(fn.Invoke(jsArgs...), threadCanBlock(thread))
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", b.Name(), err)
+		}
+		conv.path = b.Name() + "()"
+		converted, err := conv.convertToStarlarkValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to convert the return value. Error: %v", b.Name(), err)
+		}
+		if e := threadExecution(thread); e != nil {
+			for _, w := range conv.warnings {
+				e.conv.warn(w.path, w.message)
+			}
+		}
+		return converted, nil
+	})
+}
+
+func getBuiltinRegisterer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 || args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: expected two arguments with the name and the function or module. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		var names []string
+		var options js.Value
+		if len(args) > 2 {
+			options = args[2]
+		}
+		capability, _, err := getStringOption(options, "capability")
+		if err == nil {
+			names, err = registerJSBuiltin(args[0].String(), args[1], capability)
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: failed to register the builtin. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		return map[string]interface{}{"message": fmt.Sprintf("the builtin %q has been registered", args[0].String()), "builtins": toJSList(names)}
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// defaultCancelGraceSteps is the number of steps a script may run after the cancellation before it is cancelled forcibly.
+const defaultCancelGraceSteps = 100000
+
+// cancellation lets the host cancel an execution with an AbortSignal (or any object with an aborted field).
+// Scripts that call check_cancelled can stop cleanly and return partial results,
+// scripts that keep running for more than graceSteps steps after the cancellation are cancelled forcibly.
+// Like the memory monitor the signal is only read at checkpoints and when check_cancelled is called.
+type cancellation struct {
+	signal     js.Value
+	graceSteps uint64
+	noticed    bool
+	noticedAt  uint64
+	forced     bool
+}
+
+func parseCancelOptions(options js.Value) (*cancellation, error) {
+	signal := getOption(options, "signal")
+	if signal.IsUndefined() || signal.IsNull() {
+		return nil, nil
+	}
+	if signal.Type() != js.TypeObject {
+		return nil, fmt.Errorf("the option \"signal\" must be an AbortSignal. Actual type %s", signal.Type())
+	}
+	c := &cancellation{signal: signal, graceSteps: defaultCancelGraceSteps}
+	graceSteps, ok, err := getNumberOption(options, "cancelGraceSteps")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if graceSteps < 0 {
+			return nil, fmt.Errorf("the option \"cancelGraceSteps\" must not be negative. Actual value %v", graceSteps)
+		}
+		c.graceSteps = uint64(graceSteps)
+	}
+	return c, nil
+}
+
+// cancelled reads the signal and remembers when the cancellation was first noticed.
+func (c *cancellation) cancelled(thread *starlark.Thread) bool {
+	if !c.noticed && c.signal.Get("aborted").Truthy() {
+		c.noticed = true
+		c.noticedAt = thread.ExecutionSteps()
+	}
+	return c.noticed
+}
+
+// check cancels the thread if the script kept running for too long after the cancellation.
+func (c *cancellation) check(thread *starlark.Thread) {
+	if c.cancelled(thread) && !c.forced && thread.ExecutionSteps()-c.noticedAt >= c.graceSteps {
+		c.forced = true
+		thread.Cancel("cancelled: the host cancelled the execution")
+	}
+}
+
+// checkCancelled is the check_cancelled builtin. It returns True once the host has cancelled the execution.
+var checkCancelled = starlark.NewBuiltin("check_cancelled", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	checkpoint(thread)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	e := threadExecution(thread)
+	if e == nil || e.opts.cancellation == nil {
+		return starlark.False, nil
+	}
+	return starlark.Bool(e.opts.cancellation.cancelled(thread)), nil
+})
@@ -0,0 +1,120 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// The host facing builtins are only added to the environment of an execution if the embedder grants their capability
+// with the capabilities option, so the same runner can serve trusted and untrusted scripts. Nothing is granted by default.
+// The builtins that are not granted are replaced by a deniedBuiltin, so scripts get a clear error when they use them.
+//
+// The capabilities of the runtime are "time" (the time module, also granted by timeModule), "fs" (the file builtins,
+// also granted by fileBuiltins) and "fetch" (loading modules over https). The builtins registered by javascript
+// require the capability given when they were registered, their name by default.
+
+// capabilityBuiltins are the names of the builtins of the runtime that each capability grants.
+// They are always predeclared, as the builtins or as deniedBuiltins.
+var capabilityBuiltins = map[string][]string{"time": {"time"}, "fs": {"read_file", "write_file", "glob"}}
+
+// granted returns true if the capability has been granted to the execution.
+func (opts *runOptions) granted(capability string) bool {
+	return opts.capabilities[capability]
+}
+
+// parseCapabilitiesOption returns the set of the capabilities listed in the capabilities option.
+func parseCapabilitiesOption(options js.Value) (map[string]bool, error) {
+	list, err := getStringListOption(options, "capabilities")
+	if err != nil {
+		return nil, err
+	}
+	capabilities := map[string]bool{}
+	for _, capability := range list {
+		if capability == "" {
+			return nil, fmt.Errorf("the option \"capabilities\" can't contain an empty string")
+		}
+		capabilities[capability] = true
+	}
+	return capabilities, nil
+}
+
+// threadGranted returns true if the capability has been granted to the execution of the thread.
+func threadGranted(thread *starlark.Thread, capability string) bool {
+	e := threadExecution(thread)
+	return e != nil && e.opts.granted(capability)
+}
+
+// deniedBuiltin takes the place of a builtin whose capability has not been granted. Calling it or reading its attributes fails.
+type deniedBuiltin struct {
+	name       string
+	capability string
+	// e is the execution whose audit log records the attempts, nil if they are not recorded (see execution.audited).
+	e *execution
+}
+
+var (
+	_ starlark.Callable = deniedBuiltin{}
+	_ starlark.HasAttrs = deniedBuiltin{}
+)
+
+func (d deniedBuiltin) err() error {
+	return fmt.Errorf("%s: the capability %q has not been granted, see the option \"capabilities\"", d.name, d.capability)
+}
+
+func (d deniedBuiltin) String() string        { return fmt.Sprintf("<denied %s>", d.name) }
+func (d deniedBuiltin) Type() string          { return "denied_builtin" }
+func (d deniedBuiltin) Freeze()               {}
+func (d deniedBuiltin) Truth() starlark.Bool  { return starlark.False }
+func (d deniedBuiltin) Hash() (uint32, error) { return 0, d.err() }
+func (d deniedBuiltin) Name() string          { return d.name }
+func (d deniedBuiltin) AttrNames() []string   { return nil }
+
+func (d deniedBuiltin) Attr(name string) (starlark.Value, error) {
+	if d.e != nil {
+		d.e.audit(d.e.thread, d.name+"."+name, "", time.Now(), "denied", d.err())
+	}
+	return nil, d.err()
+}
+
+func (d deniedBuiltin) CallInternal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if d.e != nil {
+		d.e.audit(thread, d.name, summarizeArgs(args, kwargs), time.Now(), "denied", d.err())
+	}
+	return nil, d.err()
+}
+
+// capabilitiesInfo returns the names of the builtins each capability grants, for runtimeInfo.
+func capabilitiesInfo() map[string]interface{} {
+	// fetch is checked by load instead of a builtin
+	names := map[string][]string{"fetch": {"load"}}
+	for capability, builtins := range capabilityBuiltins {
+		names[capability] = append(names[capability], builtins...)
+	}
+	for name, builtin := range registeredJSBuiltins() {
+		names[builtin.capability] = append(names[builtin.capability], name)
+	}
+	info := map[string]interface{}{}
+	for capability, builtins := range names {
+		sort.Strings(builtins)
+		info[capability] = toJSList(builtins)
+	}
+	return info
+}
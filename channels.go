@@ -0,0 +1,177 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// maxChannelMessages is the number of messages a channel holds before send fails.
+const maxChannelMessages = 1000
+
+// channels are named queues of messages shared by all the executions, sessions and javascript.
+// A message is a frozen copy of the value that was sent, so the sender and the receiver never share mutable state.
+var channels = struct {
+	sync.Mutex
+	byName map[string][]starlark.Value
+}{byName: map[string][]starlark.Value{}}
+
+func init() {
+	registerResetHook(func() {
+		channels.Lock()
+		defer channels.Unlock()
+		channels.byName = map[string][]starlark.Value{}
+	})
+}
+
+// copyMessage returns a frozen deep copy of a data value (the types that can be stored in a session snapshot).
+func copyMessage(value starlark.Value) (starlark.Value, error) {
+	encoded, err := encodeTaggedValue(value, map[starlark.Value]bool{})
+	if err != nil {
+		return nil, err
+	}
+	message, err := decodeTaggedValue(encoded, nil)
+	if err != nil {
+		return nil, err
+	}
+	message.Freeze()
+	return message, nil
+}
+
+func sendMessage(name string, message starlark.Value) error {
+	channels.Lock()
+	defer channels.Unlock()
+	if len(channels.byName[name]) >= maxChannelMessages {
+		return fmt.Errorf("the channel %q is full, it already has %d messages", name, maxChannelMessages)
+	}
+	channels.byName[name] = append(channels.byName[name], message)
+	return nil
+}
+
+// receiveMessage removes the oldest message of the channel, the boolean is false if the channel is empty.
+func receiveMessage(name string) (starlark.Value, bool) {
+	channels.Lock()
+	defer channels.Unlock()
+	queue := channels.byName[name]
+	if len(queue) == 0 {
+		return nil, false
+	}
+	if len(queue) == 1 {
+		delete(channels.byName, name)
+	} else {
+		channels.byName[name] = queue[1:]
+	}
+	return queue[0], true
+}
+
+func pendingMessages(name string) int {
+	channels.Lock()
+	defer channels.Unlock()
+	return len(channels.byName[name])
+}
+
+// channelModule is the channel module predeclared in every execution.
+// recv does not wait for messages, it returns the default if the channel is empty.
+var channelModule = &starlarkstruct.Module{
+	Name: "channel",
+	Members: starlark.StringDict{
+		"send": starlark.NewBuiltin("channel.send", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var name string
+			var value starlark.Value
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &name, &value); err != nil {
+				return nil, err
+			}
+			message, err := copyMessage(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			if err := sendMessage(name, message); err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			return starlark.None, nil
+		}),
+		"recv": starlark.NewBuiltin("channel.recv", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var name string
+			var dflt starlark.Value = starlark.None
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name, &dflt); err != nil {
+				return nil, err
+			}
+			if message, ok := receiveMessage(name); ok {
+				return message, nil
+			}
+			return dflt, nil
+		}),
+		"pending": starlark.NewBuiltin("channel.pending", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			return starlark.MakeInt(pendingMessages(name)), nil
+		}),
+	},
+}
+
+func getChannelSender() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected two arguments with the channel name and the value. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		if args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: the channel name must be a string. Actual type %s", args[0].Type())
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		name := args[0].String()
+		conv := &converter{}
+		message, err := conv.convertToStarlarkValue(args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: failed to convert the message. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		message.Freeze()
+		if err := sendMessage(name, message); err != nil {
+			err := fmt.Errorf("Error: failed to send the message. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "resource_exhausted"}
+		}
+		return map[string]interface{}{"pending": pendingMessages(name)}
+	})
+}
+
+func getChannelReceiver() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 || args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: expected one argument with the channel name. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		message, ok := receiveMessage(args[0].String())
+		if !ok {
+			return map[string]interface{}{"received": false}
+		}
+		conv := &converter{}
+		value, err := conv.convertToJSValue(message)
+		if err != nil {
+			err := fmt.Errorf("Error: failed to convert the message. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "internal"}
+		}
+		return map[string]interface{}{"received": true, "value": value}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// clock gives the current time to the time.now builtin of an execution.
+type clock interface {
+	now() (time.Time, error)
+}
+
+// realClock is the wall clock.
+type realClock struct{}
+
+func (realClock) now() (time.Time, error) {
+	return time.Now(), nil
+}
+
+// scriptedClock starts at a fixed time and advances by step every time it is read.
+type scriptedClock struct {
+	next time.Time
+	step time.Duration
+}
+
+func (c *scriptedClock) now() (time.Time, error) {
+	t := c.next
+	c.next = c.next.Add(c.step)
+	return t, nil
+}
+
+// jsClock calls a javascript function that returns the time as milliseconds since the Unix epoch,
+// or a promise of it if the execution can block.
+type jsClock struct {
+	fn       js.Value
+	canBlock bool
+}
+
+func (c jsClock) now() (time.Time, error) {
+	value, err := settleHostValue(c.fn.Invoke(), c.canBlock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("the clock %v", err)
+	}
+	if value.Type() != js.TypeNumber {
+		return time.Time{}, fmt.Errorf("the clock must return a number of milliseconds. Actual type %s", value.Type())
+	}
+	return millisToTime(value.Float()), nil
+}
+
+func millisToTime(ms float64) time.Time {
+	return time.Unix(0, int64(ms*float64(time.Millisecond))).UTC()
+}
+
+// parseClockOption returns the clock given by the clock option: a number of milliseconds since the Unix epoch (a fixed time),
+// an object { startMs, stepMs } (a time that advances by stepMs every time it is read) or a function returning milliseconds.
+// It returns nil if the option is missing.
+func parseClockOption(options js.Value) (clock, error) {
+	value := getOption(options, "clock")
+	switch {
+	case value.IsUndefined() || value.IsNull():
+		return nil, nil
+	case value.Type() == js.TypeNumber:
+		return &scriptedClock{next: millisToTime(value.Float())}, nil
+	case value.Type() == js.TypeFunction:
+		return jsClock{fn: value}, nil
+	case value.Type() == js.TypeObject:
+		startMs, ok, err := getNumberOption(value, "startMs")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("the clock object must have a \"startMs\" field")
+		}
+		stepMs, _, err := getNumberOption(value, "stepMs")
+		if err != nil {
+			return nil, err
+		}
+		return &scriptedClock{next: millisToTime(startMs), step: time.Duration(stepMs * float64(time.Millisecond))}, nil
+	}
+	return nil, fmt.Errorf("the option \"clock\" must be a number, an object or a function. Actual type %s", value.Type())
+}
+
+// newTimeModule returns the time module of go.starlark.net with a now function that reads the clock of the execution.
+func newTimeModule(c clock) *starlarkstruct.Module {
+	members := starlark.StringDict{}
+	for name, value := range starlarktime.Module.Members {
+		members[name] = value
+	}
+	members["now"] = starlark.NewBuiltin("now", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		checkpoint(thread)
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		t, err := c.now()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		return starlarktime.Time(t), nil
+	})
+	return &starlarkstruct.Module{Name: "time", Members: members}
+}
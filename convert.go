@@ -0,0 +1,585 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// The constructors are looked up once instead of once per converted value.
+var (
+	jsArray  = js.Global().Get("Array")
+	jsObject = js.Global().Get("Object")
+	jsJSON   = js.Global().Get("JSON")
+	jsMap    = js.Global().Get("Map")
+)
+
+// defaultMaxConversionDepth is the depth limit of the converters if the maxConversionDepth option is not given.
+const defaultMaxConversionDepth = 10000
+
+// maxInternedKeys bounds the number of object keys a converter interns.
+const maxInternedKeys = 4096
+
+// maxConversionWarnings bounds the number of warnings a converter keeps.
+const maxConversionWarnings = 100
+
+// conversionWarning is a part of a javascript value that has no starlark equivalent and was converted to None,
+// or a key of a Map whose value was replaced by the value of a later key that converts to the same starlark value.
+type conversionWarning struct {
+	path    string
+	message string
+}
+
+func (w conversionWarning) toJS() map[string]interface{} {
+	return map[string]interface{}{"path": w.path, "message": w.message}
+}
+
+// converter converts values between javascript and starlark.
+// It keeps track of how deeply nested the converted values are.
+type converter struct {
+	depth    int
+	maxDepth int
+	// depthLimit is the maximum nesting depth of the values converted between javascript and starlark, defaultMaxConversionDepth if 0.
+	depthLimit int
+	// elementLimit and stringBytesLimit bound the number of values and the length of the strings converted to starlark, 0 means unlimited.
+	// elements counts the values converted to starlark since the counter was last reset.
+	elementLimit     int
+	stringBytesLimit int
+	elements         int
+	// numbersAsFloats converts all the javascript numbers to floats instead of converting whole numbers to ints.
+	numbersAsFloats bool
+	// freeze freezes the converted javascript arrays and objects with Object.freeze.
+	freeze bool
+	// functions converts starlark functions into javascript functions, they are converted to null if it is nil.
+	functions func(fn starlark.Callable) js.Value
+	// awaitPromises waits for the javascript promises and converts their values instead of converting them to empty dicts.
+	// It must only be set if the conversion runs on a goroutine that may block.
+	awaitPromises bool
+	// typedArrays converts the nested bytes and lists of numbers to typed arrays, like the returnBinary option does for the return value.
+	typedArrays bool
+	// intOverflow is how ints that don't fit in 64 bits are converted to javascript: "error" (also used if empty), "bigint", "string" or "float".
+	intOverflow string
+	// invalidUnicode is how the invalid UTF-16 and UTF-8 strings are converted: "replace" (also used if empty), "error" or "passthrough" (see unicode.go).
+	invalidUnicode string
+	// keys interns the keys of the converted objects, so arrays of objects with the same keys share the strings.
+	keys map[string]starlark.String
+	// custom are the registered converters (see converters.go), looked up when a conversion starts.
+	// decoding is set while the value returned by a javascript decode function is converted, which only uses the default bridge.
+	custom   []valueConverter
+	decoding bool
+	// path is the path of the converted value in the errors and the warnings of its parts, $ if empty.
+	path string
+	// warnings are the lossy conversions to starlark (see conversionWarning).
+	warnings []conversionWarning
+}
+
+// warn records a lossy conversion of the part of a value at the path.
+func (c *converter) warn(path, message string) {
+	if len(c.warnings) < maxConversionWarnings {
+		c.warnings = append(c.warnings, conversionWarning{path: path, message: message})
+	}
+}
+
+// warningsToJS returns the warnings of the converter as plain objects.
+func (c *converter) warningsToJS() []interface{} {
+	warnings := []interface{}{}
+	for _, w := range c.warnings {
+		warnings = append(warnings, w.toJS())
+	}
+	return warnings
+}
+
+// internKey returns the starlark string for an object key, reusing the one created for an earlier object if possible.
+func (c *converter) internKey(key string) starlark.String {
+	if interned, ok := c.keys[key]; ok {
+		return interned
+	}
+	if c.keys == nil {
+		c.keys = map[string]starlark.String{}
+	}
+	if len(c.keys) < maxInternedKeys {
+		c.keys[key] = starlark.String(key)
+	}
+	return starlark.String(key)
+}
+
+// conversionLimitError is returned when a converted value exceeds one of the limits of the converter.
+// limit is the name of the option that sets the limit.
+type conversionLimitError struct {
+	limit string
+	max   int
+}
+
+func (err *conversionLimitError) Error() string {
+	switch err.limit {
+	case "maxArgumentElements":
+		return fmt.Sprintf("the value has more than %d elements", err.max)
+	case "maxArgumentStringBytes":
+		return fmt.Sprintf("the value has a string longer than %d bytes", err.max)
+	}
+	return fmt.Sprintf("the value is nested more than %d levels deep", err.max)
+}
+
+// track records the depth of a converted value and fails if it exceeds the depth limit.
+func (c *converter) track(depth int) error {
+	if depth > c.maxDepth {
+		c.maxDepth = depth
+	}
+	limit := c.depthLimit
+	if limit == 0 {
+		limit = defaultMaxConversionDepth
+	}
+	if depth > limit {
+		return &conversionLimitError{limit: "maxConversionDepth", max: limit}
+	}
+	return nil
+}
+
+// count records a value converted to starlark and fails if there are too many.
+func (c *converter) count() error {
+	c.elements++
+	if c.elementLimit > 0 && c.elements > c.elementLimit {
+		return &conversionLimitError{limit: "maxArgumentElements", max: c.elementLimit}
+	}
+	return nil
+}
+
+// checkString fails if a string converted to starlark is longer than the limit.
+func (c *converter) checkString(length int) error {
+	if c.stringBytesLimit > 0 && length > c.stringBytesLimit {
+		return &conversionLimitError{limit: "maxArgumentStringBytes", max: c.stringBytesLimit}
+	}
+	return nil
+}
+
+func (c *converter) enter() {
+	c.depth++
+	if c.depth > c.maxDepth {
+		c.maxDepth = c.depth
+	}
+}
+
+func (c *converter) leave() {
+	c.depth--
+}
+
+// objectKeys returns the interned keys of a javascript object.
+// The keys are passed as a single JSON string: every string returned to Go is a reference with a finalizer,
+// which makes converting objects with many keys much slower than decoding them.
+// JSON.stringify escapes the lone surrogates, if there may be some the keys are converted one by one and
+// also returned as javascript strings, since the converted keys can't be used to read the values.
+func (c *converter) objectKeys(value js.Value, path func() string) ([]starlark.String, js.Value, error) {
+	var names []string
+	list := jsObject.Call("keys", value)
+	encoded := jsJSON.Call("stringify", list).String()
+	exact := !strings.Contains(encoded, `\ud`)
+	if !exact {
+		for i := 0; i < list.Length(); i++ {
+			name, err := c.convertString(list.Index(i), func() string { return fmt.Sprintf("%s (key %d)", path(), i) })
+			if err != nil {
+				return nil, js.Undefined(), err
+			}
+			names = append(names, name)
+		}
+	} else {
+		json.Unmarshal([]byte(encoded), &names)
+	}
+	keys := make([]starlark.String, len(names))
+	for i, name := range names {
+		keys[i] = c.internKey(name)
+	}
+	if exact {
+		return keys, js.Undefined(), nil
+	}
+	return keys, list, nil
+}
+
+// inboundFrame is an array, object or Map being converted to a starlark value by convertToStarlarkValue.
+type inboundFrame struct {
+	value js.Value
+	// length is the length of an array, keys the keys of an object or the converted keys of a Map
+	length int
+	keys   []starlark.Value
+	// entries are the [key, value] pairs of a Map, undefined for the other values.
+	// names are the keys of an object as javascript strings if they may have lone surrogates, undefined otherwise.
+	entries js.Value
+	names   js.Value
+	next    int
+	list    []starlark.Value
+	dict    *starlark.Dict
+}
+
+func (f *inboundFrame) done() bool {
+	if f.dict != nil {
+		return f.next >= len(f.keys)
+	}
+	return f.next >= f.length
+}
+
+func (f *inboundFrame) result() starlark.Value {
+	if f.dict != nil {
+		return f.dict
+	}
+	return starlark.NewList(f.list)
+}
+
+// inboundPath returns the path of the element being converted by the innermost of the frames, like $.items[2].name.
+func (c *converter) inboundPath(stack []*inboundFrame) string {
+	path := c.path
+	if path == "" {
+		path = "$"
+	}
+	for _, f := range stack {
+		if f.dict != nil {
+			path = dictPathKey(path, f.keys[f.next])
+		} else {
+			path += fmt.Sprintf("[%d]", f.next)
+		}
+	}
+	return path
+}
+
+// openStarlarkValue converts a scalar, or returns the frame of an array, object or Map whose elements still have to be converted.
+// depth is the depth of the value, path returns its path for the errors and the warnings.
+func (c *converter) openStarlarkValue(value js.Value, depth int, path func() string) (starlark.Value, *inboundFrame, error) {
+	if err := c.count(); err != nil {
+		return nil, nil, err
+	}
+	if c.awaitPromises && isThenable(value) {
+		settled, err := awaitPromise(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("a promise was rejected: %v", err)
+		}
+		value = settled
+	}
+	if converted, ok, err := c.customToStarlark(value); ok || err != nil {
+		return converted, nil, err
+	}
+	switch value.Type() {
+	case js.TypeBoolean:
+		return starlark.Bool(value.Bool()), nil, nil
+	case js.TypeNumber:
+		floatVal := value.Float()
+		if !c.numbersAsFloats && floatVal == float64(int(floatVal)) {
+			return starlark.MakeInt(int(floatVal)), nil, nil
+		}
+		return starlark.Float(floatVal), nil, nil
+	case js.TypeString:
+		s, err := c.convertString(value, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := c.checkString(len(s)); err != nil {
+			return nil, nil, err
+		}
+		return starlark.String(s), nil, nil
+	case js.TypeObject:
+		if kind, size, ok := typedArrayKind(value); ok {
+			length := value.Length()
+			if c.elementLimit > 0 && c.elements+length > c.elementLimit {
+				return nil, nil, &conversionLimitError{limit: "maxArgumentElements", max: c.elementLimit}
+			}
+			c.elements += length
+			// the elements are one level deeper than the list
+			if length > 0 {
+				if err := c.track(depth + 1); err != nil {
+					return nil, nil, err
+				}
+			}
+			return convertTypedArrayToStarlarkValue(value, kind, size), nil, nil
+		}
+		if value.InstanceOf(jsArray) {
+			length := value.Length()
+			if c.elementLimit > 0 && c.elements+length > c.elementLimit {
+				return nil, nil, &conversionLimitError{limit: "maxArgumentElements", max: c.elementLimit}
+			}
+			return nil, &inboundFrame{value: value, length: length, list: make([]starlark.Value, 0, length)}, nil
+		}
+		if value.InstanceOf(jsMap) {
+			return c.openMap(value, depth, path)
+		}
+		objectKeys, names, err := c.objectKeys(value, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys := make([]starlark.Value, len(objectKeys))
+		for i, key := range objectKeys {
+			if err := c.checkString(len(key)); err != nil {
+				return nil, nil, err
+			}
+			keys[i] = key
+		}
+		return nil, &inboundFrame{value: value, keys: keys, names: names, dict: starlark.NewDict(len(keys))}, nil
+	case js.TypeFunction, js.TypeSymbol:
+		c.warn(path(), fmt.Sprintf("a %s was converted to None", value.Type()))
+		return starlark.None, nil, nil
+	default:
+		return starlark.None, nil, nil
+	}
+}
+
+// openMap returns the frame of a Map with its keys converted. The keys must convert to hashable starlark values,
+// so arrays, objects and Maps can't be keys.
+func (c *converter) openMap(value js.Value, depth int, path func() string) (starlark.Value, *inboundFrame, error) {
+	entries := jsArray.Call("from", value)
+	length := entries.Length()
+	if c.elementLimit > 0 && c.elements+length > c.elementLimit {
+		return nil, nil, &conversionLimitError{limit: "maxArgumentElements", max: c.elementLimit}
+	}
+	keys := make([]starlark.Value, length)
+	for i := range keys {
+		keyPath := func() string { return fmt.Sprintf("%s (key %d)", path(), i) }
+		key, frame, err := c.openStarlarkValue(entries.Index(i).Index(0), depth+1, keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if frame != nil {
+			kind := "list"
+			if frame.dict != nil {
+				kind = "dict"
+			}
+			return nil, nil, fmt.Errorf("the key %d of the Map at %s can't be converted: unhashable type: %s", i, path(), kind)
+		}
+		if _, err := key.Hash(); err != nil {
+			return nil, nil, fmt.Errorf("the key %d of the Map at %s can't be converted: %v", i, path(), err)
+		}
+		keys[i] = key
+	}
+	return nil, &inboundFrame{value: value, keys: keys, entries: entries, dict: starlark.NewDict(length)}, nil
+}
+
+// convertToStarlarkValue converts a javascript value into a starlark value.
+// It uses an explicit stack instead of recursion so deeply nested values can't overflow the goroutine stack,
+// and fails if the nesting is deeper than the depth limit of the converter.
+func (c *converter) convertToStarlarkValue(value js.Value) (starlark.Value, error) {
+	if c.custom == nil {
+		c.custom = registeredConverters()
+	}
+	c.track(1)
+	result, frame, err := c.openStarlarkValue(value, 1, func() string { return c.inboundPath(nil) })
+	if frame == nil {
+		return result, err
+	}
+	stack := []*inboundFrame{frame}
+	for {
+		top := stack[len(stack)-1]
+		if top.done() {
+			stack = stack[:len(stack)-1]
+			v := top.result()
+			if len(stack) == 0 {
+				return v, nil
+			}
+			if err := c.add(stack, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var child js.Value
+		switch {
+		case top.dict == nil:
+			child = top.value.Index(top.next)
+		case !top.names.IsUndefined():
+			child = jsReflect.Call("get", top.value, top.names.Index(top.next))
+		case top.entries.IsUndefined():
+			child = top.value.Get(string(top.keys[top.next].(starlark.String)))
+		default:
+			child = top.entries.Index(top.next).Index(1)
+		}
+		if err := c.track(len(stack) + 1); err != nil {
+			return nil, err
+		}
+		v, frame, err := c.openStarlarkValue(child, len(stack)+1, func() string { return c.inboundPath(stack) })
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			stack = append(stack, frame)
+			continue
+		}
+		if err := c.add(stack, v); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// add stores the converted element in the innermost of the frames and moves on to the next one.
+// Two keys of a Map may convert to the same value (e.g. with a custom converter), the value of the last one is kept.
+func (c *converter) add(stack []*inboundFrame, v starlark.Value) error {
+	f := stack[len(stack)-1]
+	if f.dict != nil {
+		length := f.dict.Len()
+		if err := f.dict.SetKey(f.keys[f.next], v); err != nil {
+			return fmt.Errorf("failed to set the key at %s: %v", c.inboundPath(stack), err)
+		}
+		if f.dict.Len() == length {
+			c.warn(c.inboundPath(stack), "the key is duplicated, the value of the later key was kept")
+		}
+	} else {
+		f.list = append(f.list, v)
+	}
+	f.next++
+	return nil
+}
+
+// outboundFrame is a list or dict being converted to a javascript value by convertToJSValue.
+type outboundFrame struct {
+	target js.Value
+	list   *starlark.List
+	items  []starlark.Tuple
+	next   int
+}
+
+func (f *outboundFrame) done() bool {
+	if f.list != nil {
+		return f.next >= f.list.Len()
+	}
+	return f.next >= len(f.items)
+}
+
+// openJSValue converts a scalar, or returns the frame of a list or dict whose elements still have to be converted.
+func (c *converter) openJSValue(value starlark.Value) (js.Value, *outboundFrame, error) {
+	if c.typedArrays {
+		if array, ok := c.convertNestedBinaryValue(value); ok {
+			return array, nil, nil
+		}
+	}
+	switch v := value.(type) {
+	case starlark.Bool:
+		return js.ValueOf(bool(v)), nil, nil
+	case starlark.Float:
+		return js.ValueOf(float64(v)), nil, nil
+	case starlark.String:
+		converted, err := c.stringToJS(string(v))
+		return converted, nil, err
+	case starlark.Int:
+		if intVal, ok := v.Int64(); ok {
+			return js.ValueOf(intVal), nil, nil
+		}
+		converted, err := c.convertBigInt(v)
+		return converted, nil, err
+	case *starlark.List:
+		array := jsArray.New(v.Len())
+		return array, &outboundFrame{target: array, list: v}, nil
+	case *starlark.Dict:
+		obj := jsObject.New()
+		return obj, &outboundFrame{target: obj, items: v.Items()}, nil
+	case starlark.Callable:
+		if c.functions != nil {
+			return c.functions(v), nil, nil
+		}
+	}
+	if converted, ok, err := c.customToJS(value); ok || err != nil {
+		return converted, nil, err
+	}
+	return js.Null(), nil, nil
+}
+
+// convertNestedBinaryValue converts bytes and non empty lists and tuples of numbers into typed arrays.
+// Empty lists stay arrays since their type can't be known.
+func (c *converter) convertNestedBinaryValue(value starlark.Value) (js.Value, bool) {
+	switch v := value.(type) {
+	case starlark.Bytes:
+		return convertToBinaryJSValue(v)
+	case *starlark.List, starlark.Tuple:
+		if v.(starlark.Indexable).Len() > 0 {
+			return convertToBinaryJSValue(v)
+		}
+	}
+	return js.Undefined(), false
+}
+
+// intOverflowError is returned when an int that doesn't fit in 64 bits is converted with the "error" mode.
+type intOverflowError struct {
+	value starlark.Int
+}
+
+func (err *intOverflowError) Error() string {
+	return fmt.Sprintf("the int %s doesn't fit in 64 bits, use the option \"intOverflow\" to convert it to a BigInt, a string or a float", err.value)
+}
+
+// convertBigInt converts an int that doesn't fit in 64 bits according to the intOverflow mode.
+func (c *converter) convertBigInt(v starlark.Int) (js.Value, error) {
+	switch c.intOverflow {
+	case "bigint":
+		return js.Global().Get("BigInt").Invoke(v.String()), nil
+	case "string":
+		return js.ValueOf(v.String()), nil
+	case "float":
+		f, _ := new(big.Float).SetInt(v.BigInt()).Float64()
+		return js.ValueOf(f), nil
+	}
+	return js.Undefined(), &intOverflowError{value: v}
+}
+
+// convertToJSValue converts a starlark value into a javascript value.
+// Like convertToStarlarkValue it uses an explicit stack and fails if the nesting is deeper than the depth limit,
+// which also stops lists and dicts that contain themselves.
+func (c *converter) convertToJSValue(value starlark.Value) (js.Value, error) {
+	if c.custom == nil {
+		c.custom = registeredConverters()
+	}
+	c.track(1)
+	result, frame, err := c.openJSValue(value)
+	if frame == nil {
+		return result, err
+	}
+	stack := []*outboundFrame{frame}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.done() {
+			if c.freeze {
+				jsObject.Call("freeze", top.target)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		var child starlark.Value
+		if top.list != nil {
+			child = top.list.Index(top.next)
+		} else {
+			child = top.items[top.next][1]
+		}
+		if err := c.track(len(stack) + 1); err != nil {
+			return js.Undefined(), err
+		}
+		v, frame, err := c.openJSValue(child)
+		if err != nil {
+			return js.Undefined(), err
+		}
+		if top.list != nil {
+			top.target.SetIndex(top.next, v)
+		} else {
+			// like the JSON encoding, keys that aren't strings become their string representation
+			key := top.items[top.next][0].String()
+			if s, ok := top.items[top.next][0].(starlark.String); ok {
+				key = string(s)
+			}
+			if err := c.setKey(top.target, key, v); err != nil {
+				return js.Undefined(), err
+			}
+		}
+		top.next++
+		if frame != nil {
+			stack = append(stack, frame)
+		}
+	}
+	return result, nil
+}
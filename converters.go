@@ -0,0 +1,280 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Forks can convert the value types the default bridge doesn't know about (they become null and None otherwise)
+// by calling registerConverter from an init function, like registerBuiltin:
+//
+//	func init() {
+//		registerConverter("decimal", decimalConverter{})
+//	}
+//
+// The javascript host can do the same with register_starlark_converter(name, {type, encode, match, decode}).
+
+// valueConverter converts the values of some types between starlark and javascript.
+// The methods return false if the converter doesn't handle the value, the next converter is tried then.
+type valueConverter interface {
+	// convertToJS converts a starlark value the default bridge would convert to null: tuples, sets, structs, etc.
+	// (bytes unless returnBinary is set, functions unless returnFunctions is set).
+	convertToJS(c *converter, value starlark.Value) (js.Value, bool, error)
+	// convertToStarlark converts a javascript object or function before the default bridge converts it to a dict or None.
+	convertToStarlark(c *converter, value js.Value) (starlark.Value, bool, error)
+}
+
+// customConverter is a converter registered by Go or javascript.
+type customConverter struct {
+	name   string
+	conv   valueConverter
+	fromJS bool
+}
+
+// converters are the registered converters in the order they were registered, the ones registered by javascript
+// are removed by starlark_reset.
+var converters = struct {
+	sync.Mutex
+	all []customConverter
+}{}
+
+func init() {
+	registerResetHook(func() {
+		converters.Lock()
+		defer converters.Unlock()
+		kept := []customConverter{}
+		for _, c := range converters.all {
+			if !c.fromJS {
+				kept = append(kept, c)
+			}
+		}
+		converters.all = kept
+	})
+}
+
+// registerConverter adds a converter, or replaces the one with the same name. It is meant to be called from init functions.
+func registerConverter(name string, conv valueConverter) {
+	addConverter(customConverter{name: name, conv: conv})
+}
+
+func addConverter(custom customConverter) []string {
+	converters.Lock()
+	defer converters.Unlock()
+	replaced := false
+	for i, c := range converters.all {
+		if c.name == custom.name {
+			converters.all[i], replaced = custom, true
+		}
+	}
+	if !replaced {
+		converters.all = append(converters.all, custom)
+	}
+	names := []string{}
+	for _, c := range converters.all {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registeredConverters returns the registered converters, nil if there are none so the converters can skip them cheaply.
+func registeredConverters() []valueConverter {
+	converters.Lock()
+	defer converters.Unlock()
+	if len(converters.all) == 0 {
+		return nil
+	}
+	convs := make([]valueConverter, 0, len(converters.all))
+	for _, c := range converters.all {
+		convs = append(convs, c.conv)
+	}
+	return convs
+}
+
+// customToJS converts a starlark value with the first registered converter that handles it.
+func (c *converter) customToJS(value starlark.Value) (js.Value, bool, error) {
+	if c.custom == nil || c.decoding {
+		return js.Undefined(), false, nil
+	}
+	for _, conv := range c.custom {
+		if converted, ok, err := conv.convertToJS(c, value); ok || err != nil {
+			return converted, ok, err
+		}
+	}
+	return js.Undefined(), false, nil
+}
+
+// customToStarlark is like customToJS for the javascript objects and functions.
+func (c *converter) customToStarlark(value js.Value) (starlark.Value, bool, error) {
+	if c.custom == nil || c.decoding || (value.Type() != js.TypeObject && value.Type() != js.TypeFunction) || value.IsNull() {
+		return nil, false, nil
+	}
+	for _, conv := range c.custom {
+		if converted, ok, err := conv.convertToStarlark(c, value); ok || err != nil {
+			return converted, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// jsConverter is a converter registered by javascript. encode is called with the starlark values of the type
+// (the name returned by type(), e.g. "tuple" or "set") in a plain form: the elements of tuples and sets in an array,
+// the fields of structs and modules in an object, bytes as an Uint8Array and the repr of the other values,
+// and its second argument is {type, repr}. decode is called with the javascript values match returns true for,
+// the value it returns is converted by the default bridge.
+type jsConverter struct {
+	name   string
+	typ    string
+	encode js.Value
+	match  js.Value
+	decode js.Value
+}
+
+// converterError is the error of a javascript converter that threw. A return value that can't be converted because of it
+// fails with errorCode "internal" instead of "resource_exhausted".
+type converterError struct {
+	name    string
+	message string
+}
+
+func (err *converterError) Error() string {
+	return fmt.Sprintf("the converter %q failed: %s", err.name, err.message)
+}
+
+// callJS calls a function of the converter, a thrown error becomes an error of the conversion.
+func (j *jsConverter) callJS(fn js.Value, args ...interface{}) (result js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			result, err = js.Undefined(), &converterError{name: j.name, message: jsErr.Error()}
+		}
+	}()
+	return fn.Invoke(args...), nil
+}
+
+func (j *jsConverter) convertToJS(c *converter, value starlark.Value) (js.Value, bool, error) {
+	if j.encode.IsUndefined() || value.Type() != j.typ {
+		return js.Undefined(), false, nil
+	}
+	var plain js.Value
+	var err error
+	switch v := value.(type) {
+	case starlark.Tuple:
+		plain, err = c.convertToJSValue(starlark.NewList(v))
+	case starlark.Bytes:
+		plain, _ = convertToBinaryJSValue(v)
+	case *starlarkstruct.Struct, *starlarkstruct.Module:
+		fields := starlark.NewDict(0)
+		for _, name := range v.(starlark.HasAttrs).AttrNames() {
+			attr, _ := v.(starlark.HasAttrs).Attr(name)
+			fields.SetKey(starlark.String(name), attr)
+		}
+		plain, err = c.convertToJSValue(fields)
+	case starlark.Iterable:
+		elements := []starlark.Value{}
+		iter := v.Iterate()
+		var element starlark.Value
+		for iter.Next(&element) {
+			elements = append(elements, element)
+		}
+		iter.Done()
+		plain, err = c.convertToJSValue(starlark.NewList(elements))
+	default:
+		plain = js.ValueOf(value.String())
+	}
+	if err != nil {
+		return js.Undefined(), false, err
+	}
+	info := map[string]interface{}{"type": value.Type(), "repr": value.String()}
+	encoded, err := j.callJS(j.encode, plain, info)
+	return encoded, err == nil, err
+}
+
+func (j *jsConverter) convertToStarlark(c *converter, value js.Value) (starlark.Value, bool, error) {
+	if j.decode.IsUndefined() || j.match.IsUndefined() {
+		return nil, false, nil
+	}
+	matched, err := j.callJS(j.match, value)
+	if err != nil || !matched.Truthy() {
+		return nil, false, err
+	}
+	decoded, err := j.callJS(j.decode, value)
+	if err != nil {
+		return nil, false, err
+	}
+	c.decoding = true
+	defer func() { c.decoding = false }()
+	converted, err := c.convertToStarlarkValue(decoded)
+	return converted, err == nil, err
+}
+
+// registerJSConverter parses the converter object of register_starlark_converter and registers it.
+// It returns the sorted names of the registered converters.
+func registerJSConverter(name string, value js.Value) ([]string, error) {
+	if value.Type() != js.TypeObject || value.IsNull() {
+		return nil, fmt.Errorf("the converter %q must be an object with the functions encode and/or match and decode. Actual type %s", name, value.Type())
+	}
+	j := &jsConverter{name: name}
+	var err error
+	var hasType bool
+	if j.typ, hasType, err = getStringOption(value, "type"); err != nil {
+		return nil, err
+	}
+	var hasEncode, hasMatch, hasDecode bool
+	if j.encode, hasEncode, err = getFunctionOption(value, "encode"); err != nil {
+		return nil, err
+	}
+	if j.match, hasMatch, err = getFunctionOption(value, "match"); err != nil {
+		return nil, err
+	}
+	if j.decode, hasDecode, err = getFunctionOption(value, "decode"); err != nil {
+		return nil, err
+	}
+	if hasEncode != hasType {
+		return nil, fmt.Errorf("the converter %q must have both a type and an encode function or neither", name)
+	}
+	if hasMatch != hasDecode {
+		return nil, fmt.Errorf("the converter %q must have both a match and a decode function or neither", name)
+	}
+	if !hasEncode && !hasDecode {
+		return nil, fmt.Errorf("the converter %q must have an encode or a decode function", name)
+	}
+	return addConverter(customConverter{name: name, conv: j, fromJS: true}), nil
+}
+
+func getConverterRegisterer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 || args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: expected two arguments with the name and the converter. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		names, err := registerJSConverter(args[0].String(), args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: failed to register the converter. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		return map[string]interface{}{"message": fmt.Sprintf("the converter %q has been registered", args[0].String()), "converters": toJSList(names)}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// checkDataOnly returns an error for the first statement or expression that is not allowed in a data file.
+// Data files may only contain assignments and expressions (literals, comprehensions, arithmetic, calls of the universal builtins),
+// they can't define functions, load modules or use control flow statements.
+func checkDataOnly(file *syntax.File) (syntax.Node, error) {
+	for _, stmt := range file.Stmts {
+		switch stmt.(type) {
+		case *syntax.AssignStmt, *syntax.ExprStmt:
+		case *syntax.DefStmt:
+			return stmt, fmt.Errorf("function definitions are not allowed")
+		case *syntax.LoadStmt:
+			return stmt, fmt.Errorf("load statements are not allowed")
+		default:
+			kind := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*syntax."), "Stmt")
+			return stmt, fmt.Errorf("%s statements are not allowed, only assignments and expressions", strings.ToLower(kind))
+		}
+	}
+	var lambda syntax.Node
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if _, ok := node.(*syntax.LambdaExpr); ok && lambda == nil {
+			lambda = node
+		}
+		return lambda == nil
+	})
+	if lambda != nil {
+		return lambda, fmt.Errorf("lambda expressions are not allowed")
+	}
+	return nil, nil
+}
+
+// evalData evaluates a data file and returns its globals as the return value.
+// The file only sees the universal builtins: none of the host modules (env, log, channel, etc.), preludes or shared data.
+// The globals whose name starts with an underscore are not returned, so they can be used for intermediate values.
+func evalData(starlark_code string, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	if errResult := e.checkSourceSize(starlark_code); errResult != nil {
+		return e.finish(errResult)
+	}
+	file, err := syntax.Parse(opts.filename, starlark_code, 0)
+	if err != nil {
+		err := fmt.Errorf("Error: failed to parse the starlark code. Error: %q", err)
+		return e.finish(map[string]interface{}{"error": err.Error()})
+	}
+	if errResult := e.checkSyntaxNodes(file); errResult != nil {
+		return e.finish(errResult)
+	}
+	if node, err := checkDataOnly(file); err != nil {
+		start, _ := node.Span()
+		err := fmt.Errorf("Error: the starlark code is not a data file. Error: %q", fmt.Sprintf("%s: %v", start, err))
+		details := map[string]interface{}{"line": int(start.Line), "col": int(start.Col)}
+		return e.finish(map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": details})
+	}
+	program, err := starlark.FileProgram(file, checkedUniverse.Has)
+	if err != nil {
+		err := fmt.Errorf("Error: failed to evaluate the starlark code. Error: %q", err)
+		return e.finish(map[string]interface{}{"error": err.Error()})
+	}
+	e.addSource(opts.filename, starlark_code)
+	globals, err := program.Init(e.thread, checkedUniverse)
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark code", err))
+	}
+	return e.finish(e.withReturnValue(map[string]interface{}{"message": e.output.String()}, publicGlobals(globals)))
+}
+
+// publicGlobals returns a dict with the globals whose name doesn't start with an underscore, in the order of their names.
+func publicGlobals(globals starlark.StringDict) *starlark.Dict {
+	data := starlark.NewDict(len(globals))
+	for _, name := range globals.Keys() {
+		if !strings.HasPrefix(name, "_") {
+			data.SetKey(starlark.String(name), globals[name])
+		}
+	}
+	return data
+}
+
+func getDataEvaluator() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[0].String()
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		return evalData(starlark_code, opts)
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// deadline cancels a starlark thread once the execution has run for longer than the timeout.
+// Like the memory monitor it is checked at every checkpoint, so the script can overshoot the deadline
+// by however long it runs between two checkpoints.
+type deadline struct {
+	timeout  time.Duration
+	at       time.Time
+	exceeded bool
+	// steps and position describe how far the script got when the deadline was exceeded.
+	steps    uint64
+	position string
+}
+
+func newDeadline(start time.Time, timeout time.Duration) *deadline {
+	return &deadline{timeout: timeout, at: start.Add(timeout)}
+}
+
+// check cancels the thread if the deadline has passed.
+func (d *deadline) check(thread *starlark.Thread) {
+	if d.exceeded || time.Now().Before(d.at) {
+		return
+	}
+	d.exceeded = true
+	d.steps = thread.ExecutionSteps()
+	for _, frame := range thread.CallStack() {
+		if frame.Pos.IsValid() && frame.Pos.Filename() != "<builtin>" {
+			d.position = fmt.Sprintf("%s in %s", frame.Pos, frame.Name)
+		}
+	}
+	thread.Cancel(fmt.Sprintf("deadline exceeded: the timeout of %v was exceeded", d.timeout))
+}
+
+// errorResult returns the error result of an execution that exceeded the deadline.
+func (d *deadline) errorResult() map[string]interface{} {
+	err := fmt.Errorf("Error: deadline exceeded. The execution exceeded the timeout of %d ms after %d steps at %s.", d.timeout.Milliseconds(), d.steps, d.position)
+	return map[string]interface{}{
+		"error":     err.Error(),
+		"errorCode": "deadline_exceeded",
+		"details":   map[string]interface{}{"timeoutMs": float64(d.timeout.Milliseconds()), "steps": float64(d.steps), "position": d.position},
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/syntax"
+)
+
+// dependencyNode is a module of the dependency graph. kind is "file" for the given files, "registered" for the modules
+// registered with register_starlark_module, "remote" for the modules fetched over https and "missing" for the others.
+type dependencyNode struct {
+	name  string
+	kind  string
+	loads []dependencyEdge
+	// parseErr is set if the file or the registered module doesn't parse, it has no edges then.
+	parseErr error
+}
+
+// dependencyEdge is a load statement of a module.
+type dependencyEdge struct {
+	to      string
+	pos     syntax.Position
+	symbols []string
+}
+
+// parseLoads returns the load statements of a module without executing it.
+func parseLoads(name, starlark_code string) ([]dependencyEdge, error) {
+	f, err := syntax.Parse(name, starlark_code, 0)
+	if err != nil {
+		return nil, err
+	}
+	edges := []dependencyEdge{}
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		symbols := []string{}
+		for _, ident := range load.From {
+			symbols = append(symbols, ident.Name)
+		}
+		edges = append(edges, dependencyEdge{to: mapImport(load.Module.Value.(string)), pos: load.Load, symbols: symbols})
+	}
+	return edges, nil
+}
+
+// dependencyGraph returns the modules reachable from the files by name, resolving the loads like the runtime does:
+// the registered modules take precedence over the files, which take precedence over the modules fetched over https.
+// Nothing is executed or fetched, so the loads of the remote modules are unknown.
+func dependencyGraph(files map[string]string) map[string]*dependencyNode {
+	modules.Lock()
+	registered := make(map[string]string, len(modules.sources))
+	for name, starlark_code := range modules.sources {
+		registered[name] = starlark_code
+	}
+	modules.Unlock()
+	nodes := map[string]*dependencyNode{}
+	var visit func(name string, kind string, starlark_code string)
+	visit = func(name string, kind string, starlark_code string) {
+		node := &dependencyNode{name: name, kind: kind}
+		nodes[name] = node
+		if kind == "remote" || kind == "missing" {
+			return
+		}
+		node.loads, node.parseErr = parseLoads(name, starlark_code)
+		for _, edge := range node.loads {
+			if _, ok := nodes[edge.to]; ok {
+				continue
+			}
+			if starlark_code, ok := registered[edge.to]; ok {
+				visit(edge.to, "registered", starlark_code)
+			} else if starlark_code, ok := files[edge.to]; ok {
+				visit(edge.to, "file", starlark_code)
+			} else if strings.HasPrefix(edge.to, "https://") {
+				visit(edge.to, "remote", "")
+			} else {
+				visit(edge.to, "missing", "")
+			}
+		}
+	}
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := nodes[name]; !ok {
+			visit(name, "file", files[name])
+		}
+	}
+	return nodes
+}
+
+// dependencyCycles returns the cycles of the graph found by a depth first search from the sorted module names,
+// each one starts and ends with the same module, e.g. [a.star, b.star, a.star].
+// A cycle is reported once whichever module it is reached from.
+func dependencyCycles(nodes map[string]*dependencyNode) [][]string {
+	names := []string{}
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := map[string]int{}
+	stack := []string{}
+	seen := map[string]bool{}
+	cycles := [][]string{}
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = onStack
+		stack = append(stack, name)
+		for _, edge := range nodes[name].loads {
+			switch state[edge.to] {
+			case unvisited:
+				visit(edge.to)
+			case onStack:
+				start := len(stack) - 1
+				for stack[start] != edge.to {
+					start--
+				}
+				cycle := append(append([]string{}, stack[start:]...), edge.to)
+				if key := cycleKey(cycle); !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return cycles
+}
+
+// cycleKey identifies a cycle whatever module it starts with: it is rotated to start with the smallest name.
+func cycleKey(cycle []string) string {
+	members := cycle[:len(cycle)-1]
+	smallest := 0
+	for i, name := range members {
+		if name < members[smallest] {
+			smallest = i
+		}
+	}
+	rotated := append(append([]string{}, members[smallest:]...), members[:smallest]...)
+	return strings.Join(rotated, "\x00")
+}
+
+func dependencyGraphToJS(nodes map[string]*dependencyNode) map[string]interface{} {
+	names := []string{}
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	jsNodes, edges := []interface{}{}, []interface{}{}
+	for _, name := range names {
+		node := nodes[name]
+		jsNode := map[string]interface{}{"name": name, "kind": node.kind}
+		if node.parseErr != nil {
+			jsNode["error"] = node.parseErr.Error()
+			jsNode["parseError"] = convertSyntaxErrorToJSON(node.parseErr)
+		}
+		jsNodes = append(jsNodes, jsNode)
+		for _, edge := range node.loads {
+			edges = append(edges, map[string]interface{}{"from": name, "to": edge.to, "line": int(edge.pos.Line), "col": int(edge.pos.Col), "symbols": toJSList(edge.symbols)})
+		}
+	}
+	cycles := []interface{}{}
+	for _, cycle := range dependencyCycles(nodes) {
+		cycles = append(cycles, toJSList(cycle))
+	}
+	return map[string]interface{}{"nodes": jsNodes, "edges": edges, "cycles": cycles}
+}
+
+func getDependencyGrapher() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 || args[0].Type() != js.TypeObject || args[0].IsNull() || args[0].InstanceOf(jsArray) {
+			err := fmt.Errorf("Error: expected one argument with an object that maps the file names to their source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		files := map[string]string{}
+		keys := jsObject.Call("keys", args[0])
+		for i := 0; i < keys.Length(); i++ {
+			name := keys.Index(i).String()
+			starlark_code := args[0].Get(name)
+			if starlark_code.Type() != js.TypeString {
+				err := fmt.Errorf("Error: the source code of the file %q must be a string. Actual type %s", name, starlark_code.Type())
+				return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+			}
+			files[name] = starlark_code.String()
+		}
+		return dependencyGraphToJS(dependencyGraph(files))
+	})
+}
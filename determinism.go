@@ -0,0 +1,129 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"syscall/js"
+)
+
+// The verifyDeterminism option runs the same call twice, each time with its own environment (the options are parsed
+// again, so the in-memory filesystem, the arguments, etc. are fresh copies), and reports whether the two runs
+// printed the same output, returned the same value, failed with the same error, wrote the same files,
+// logged the same records and made the same sequence of calls to the host. The result is the result of the first run.
+
+// determinismFields are the fields of the results that are compared, with the function that summarizes them.
+var determinismFields = []struct {
+	name      string
+	summarize func(result map[string]interface{}) interface{}
+}{
+	{"message", func(result map[string]interface{}) interface{} { return result["message"] }},
+	{"returnValue", func(result map[string]interface{}) interface{} { return result["repr"] }},
+	{"error", func(result map[string]interface{}) interface{} { return result["error"] }},
+	{"files", func(result map[string]interface{}) interface{} { return result["files"] }},
+	{"logs", func(result map[string]interface{}) interface{} {
+		return summarizeRecords(result["logs"], "level", "message", "fields")
+	}},
+	{"hostCalls", func(result map[string]interface{}) interface{} {
+		return summarizeRecords(result["audit"], "name", "args", "status")
+	}},
+}
+
+// summarizeRecords returns the given keys of the log or audit records, without the timestamps and durations that always differ.
+func summarizeRecords(records interface{}, keys ...string) []string {
+	list, _ := records.([]interface{})
+	summaries := []string{}
+	for _, record := range list {
+		fields := record.(map[string]interface{})
+		summary := ""
+		for _, key := range keys {
+			value := fields[key]
+			if _, ok := value.(map[string]interface{}); ok {
+				value = jsJSON.Call("stringify", js.ValueOf(value)).String()
+			}
+			summary += fmt.Sprintf("%v ", value)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// runDeterminismCheck runs the code twice with the options and adds the comparison of the two runs to the result of the first one.
+// parse returns a fresh copy of the options for each run.
+func runDeterminismCheck(starlark_code string, parse func() (runOptions, error)) map[string]interface{} {
+	results := []map[string]interface{}{}
+	var audit auditOptions
+	var returnRepr bool
+	for run := 0; run < 2; run++ {
+		opts, err := parse()
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		// the calls to the host are compared with the audit log, which is delivered to the host after the comparison
+		audit, returnRepr = opts.audit, opts.returnRepr
+		opts.audit, opts.returnRepr = auditOptions{enabled: true}, true
+		results = append(results, runStarlarkCode(starlark_code, opts))
+	}
+	first, second := results[0], results[1]
+	mismatches := []interface{}{}
+	for _, field := range determinismFields {
+		a, b := field.summarize(first), field.summarize(second)
+		if !reflect.DeepEqual(a, b) {
+			mismatches = append(mismatches, determinismMismatch(field.name, a, b))
+		}
+	}
+	records, _ := first["audit"].([]interface{})
+	switch {
+	case !audit.onAudit.IsUndefined():
+		delete(first, "audit")
+		for _, record := range records {
+			audit.onAudit.Invoke(record)
+		}
+	case !audit.enabled:
+		delete(first, "audit")
+	}
+	if !returnRepr {
+		delete(first, "repr")
+	}
+	first["determinism"] = map[string]interface{}{"deterministic": len(mismatches) == 0, "mismatches": mismatches}
+	return first
+}
+
+// determinismMismatch describes a field that differs between the two runs. For lists of records it has the index
+// of the first record that differs and the two records, nil if a run has fewer records.
+func determinismMismatch(field string, a, b interface{}) map[string]interface{} {
+	mismatch := map[string]interface{}{"field": field}
+	listA, isList := a.([]string)
+	if !isList {
+		mismatch["first"], mismatch["second"] = a, b
+		return mismatch
+	}
+	listB := b.([]string)
+	index := 0
+	for index < len(listA) && index < len(listB) && listA[index] == listB[index] {
+		index++
+	}
+	mismatch["index"] = index
+	mismatch["first"], mismatch["second"] = nil, nil
+	if index < len(listA) {
+		mismatch["first"] = listA[index]
+	}
+	if index < len(listB) {
+		mismatch["second"] = listB[index]
+	}
+	return mismatch
+}
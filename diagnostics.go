@@ -0,0 +1,248 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// callableNames returns the sorted names of the globals that can be called.
+func callableNames(globals starlark.StringDict) []string {
+	names := []string{}
+	for name, value := range globals {
+		if _, ok := value.(starlark.Callable); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// functionSignature describes the parameters of a function e.g. "f(a, b, *args, c, **kwargs)".
+// Only the names of the parameters of builtins are unknown so they are described as "f(...)".
+func functionSignature(name string, fn starlark.Callable) string {
+	f, ok := fn.(*starlark.Function)
+	if !ok {
+		return name + "(...)"
+	}
+	numPositional := f.NumParams() - f.NumKwonlyParams()
+	if f.HasVarargs() {
+		numPositional--
+	}
+	if f.HasKwargs() {
+		numPositional--
+	}
+	params := []string{}
+	for i := 0; i < numPositional; i++ {
+		param, _ := f.Param(i)
+		params = append(params, param)
+	}
+	next := numPositional + f.NumKwonlyParams()
+	if f.HasVarargs() {
+		param, _ := f.Param(next)
+		params = append(params, "*"+param)
+		next++
+	} else if f.NumKwonlyParams() > 0 {
+		params = append(params, "*")
+	}
+	for i := numPositional; i < numPositional+f.NumKwonlyParams(); i++ {
+		param, _ := f.Param(i)
+		params = append(params, param)
+	}
+	if f.HasKwargs() {
+		param, _ := f.Param(next)
+		params = append(params, "**"+param)
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+}
+
+// lookupFunction returns the function to call. If it is missing or not callable
+// the error result lists the callable globals so the caller can fix funcName.
+func lookupFunction(globals starlark.StringDict, funcName string, where string) (starlark.Callable, map[string]interface{}) {
+	callables := callableNames(globals)
+	details := map[string]interface{}{"funcName": funcName, "callables": toJSList(callables)}
+	value, ok := globals[funcName]
+	if !ok && strings.Contains(funcName, ".") {
+		return lookupDottedFunction(globals, funcName, where, details)
+	}
+	if !ok {
+		err := fmt.Errorf("Error: the function %q is missing from the %s. The callable globals are: [%s].", funcName, where, strings.Join(callables, ", "))
+		return nil, map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "details": details}
+	}
+	fn, ok := value.(starlark.Callable)
+	if !ok {
+		err := fmt.Errorf("Error: the global %q is a %s, not a function. The callable globals are: [%s].", funcName, value.Type(), strings.Join(callables, ", "))
+		return nil, map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": details}
+	}
+	return fn, nil
+}
+
+// lookupDottedFunction returns the function at a path like "handlers.on_save", whose first name is a global
+// and whose other names are attributes of the values before them (structs, modules, etc.).
+// If a name is missing the details also have the path that was found and the attributes of its value.
+func lookupDottedFunction(globals starlark.StringDict, funcName string, where string, details map[string]interface{}) (starlark.Callable, map[string]interface{}) {
+	names := strings.Split(funcName, ".")
+	value, ok := globals[names[0]]
+	if !ok {
+		err := fmt.Errorf("Error: the function %q is missing from the %s, there is no global %q.", funcName, where, names[0])
+		return nil, map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "details": details}
+	}
+	for i, name := range names[1:] {
+		path := strings.Join(names[:i+1], ".")
+		obj, ok := value.(starlark.HasAttrs)
+		if !ok {
+			err := fmt.Errorf("Error: the function %q is missing from the %s, %q is a %s, which has no attributes.", funcName, where, path, value.Type())
+			return nil, map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "details": details}
+		}
+		attr, err := obj.Attr(name)
+		if err != nil || attr == nil {
+			attrs := obj.AttrNames()
+			details["path"], details["attributes"] = path, toJSList(attrs)
+			err := fmt.Errorf("Error: the function %q is missing from the %s, %q has no attribute %q. Its attributes are: [%s].", funcName, where, path, name, strings.Join(attrs, ", "))
+			return nil, map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "details": details}
+		}
+		value = attr
+	}
+	fn, ok := value.(starlark.Callable)
+	if !ok {
+		err := fmt.Errorf("Error: %q is a %s, not a function.", funcName, value.Type())
+		return nil, map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": details}
+	}
+	return fn, nil
+}
+
+// callStarlarkFunction calls the function found by lookupFunction.
+// Starlark checks the arguments before running the body of the function, so a call that fails
+// without executing any steps was made with the wrong arguments. The error then has the expected signature.
+func callStarlarkFunction(e *execution, funcName string, fn starlark.Callable, funcArgs []starlark.Value) (starlark.Value, map[string]interface{}) {
+	steps := e.thread.ExecutionSteps()
+	returnValue, err := starlark.Call(e.thread, fn, funcArgs, nil)
+	if err == nil {
+		return returnValue, nil
+	}
+	if _, ok := fn.(*starlark.Function); ok && e.thread.ExecutionSteps() == steps {
+		signature := functionSignature(funcName, fn)
+		err := fmt.Errorf("Error: wrong arguments for the function %q, expected the signature %s but %d positional arguments were given. Error: %q", funcName, signature, len(funcArgs), err)
+		details := map[string]interface{}{"funcName": funcName, "signature": signature, "numArgs": len(funcArgs)}
+		return nil, map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": details}
+	}
+	return nil, e.runtimeErrorResult("failed to execute the starlark code", err)
+}
+
+// runtimeErrorResult returns the error result of an execution that failed with the error.
+// The details of a runtime error have its backtrace.
+func (e *execution) runtimeErrorResult(message string, err error) map[string]interface{} {
+	result := map[string]interface{}{"error": fmt.Errorf("Error: %s. Error: %q", message, err).Error()}
+	if frames := e.backtrace(err); frames != nil {
+		result["details"] = map[string]interface{}{"backtrace": frames}
+	}
+	e.addErrorKind(result, err)
+	return result
+}
+
+// backtrace returns the frames of a starlark runtime error, outermost first, with the text of their source lines
+// and a marker with a caret under the column. It returns nil if the error is not a runtime error.
+// The error of a module that failed to load wraps the error of the module, whose call stack continues the one of the load statement,
+// so the innermost error has the frames of all the files involved.
+func (e *execution) backtrace(err error) []interface{} {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return nil
+	}
+	for {
+		var cause *starlark.EvalError
+		if !errors.As(evalErr.Unwrap(), &cause) {
+			break
+		}
+		evalErr = cause
+	}
+	frames := []interface{}{}
+	for _, frame := range evalErr.CallStack {
+		pos := frame.Pos
+		f := map[string]interface{}{"name": frame.Name, "filename": pos.Filename(), "line": int(pos.Line), "col": int(pos.Col)}
+		if source, ok := e.sourceLine(pos.Filename(), int(pos.Line)); ok {
+			f["source"] = source
+			f["caret"] = caretMarker(source, int(pos.Col))
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// addSource records the source code of a file executed by the execution, for the backtraces of its errors.
+func (e *execution) addSource(filename, starlark_code string) {
+	if e.sources == nil {
+		e.sources = map[string]string{}
+	}
+	e.sources[filename] = starlark_code
+}
+
+// sourceLine returns a line (1-based) of a file executed by the execution, its session or of a registered module.
+func (e *execution) sourceLine(filename string, line int) (string, bool) {
+	starlark_code, ok := lookupSource(e.sources, e.session, filename)
+	if !ok || line < 1 {
+		return "", false
+	}
+	lines := strings.SplitN(starlark_code, "\n", line+1)
+	if line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[line-1], "\r"), true
+}
+
+// lookupSource returns the source code of a file from the sources of an execution, the session (may be nil) or the registered modules.
+func lookupSource(sources map[string]string, s *session, filename string) (string, bool) {
+	starlark_code, ok := sources[filename]
+	if !ok && s != nil {
+		starlark_code, ok = s.sources[filename]
+	}
+	if !ok {
+		modules.Lock()
+		starlark_code, ok = modules.sources[filename]
+		modules.Unlock()
+	}
+	return starlark_code, ok
+}
+
+// caretMarker returns the marker line with a caret under the column (1-based, in runes) of the source line.
+// Tabs are kept so the caret lines up with the source however wide tabs are displayed.
+func caretMarker(source string, col int) string {
+	var marker strings.Builder
+	for i, r := range []rune(source) {
+		if i >= col-1 {
+			break
+		}
+		if r == '\t' {
+			marker.WriteRune('\t')
+		} else {
+			marker.WriteRune(' ')
+		}
+	}
+	marker.WriteString("^")
+	return marker.String()
+}
+
+func toJSList(values []string) []interface{} {
+	list := []interface{}{}
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
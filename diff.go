@@ -0,0 +1,205 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// maxDiffChanges bounds the changes reported by diff_starlark_values, the result is marked as truncated beyond it.
+const maxDiffChanges = 1000
+
+// maxDiffDepth bounds the nesting of the values compared with ==.
+const maxDiffDepth = 100
+
+// valueChange is a difference between two values at a path like $.servers[0].port.
+// op is add (only in the new value), remove (only in the old value) or change.
+type valueChange struct {
+	op     string
+	path   string
+	before starlark.Value
+	after  starlark.Value
+}
+
+type valueDiff struct {
+	changes   []valueChange
+	truncated bool
+}
+
+func (d *valueDiff) add(change valueChange) {
+	if len(d.changes) == maxDiffChanges {
+		d.truncated = true
+		return
+	}
+	d.changes = append(d.changes, change)
+}
+
+// dictPathKey returns the path of a dict entry, .name for the keys that are identifiers and [repr] for the others.
+func dictPathKey(path string, key starlark.Value) string {
+	if s, ok := key.(starlark.String); ok && isIdentifier(string(s)) {
+		return path + "." + string(s)
+	}
+	return path + "[" + key.String() + "]"
+}
+
+// compare adds the changes from a to b. Dicts are compared by key, lists by index and sets by element,
+// the other values are compared with ==, so 1 and 1.0 are equal.
+func (d *valueDiff) compare(a, b starlark.Value, path string) error {
+	switch x := a.(type) {
+	case *starlark.Dict:
+		y, ok := b.(*starlark.Dict)
+		if !ok {
+			break
+		}
+		for _, item := range x.Items() {
+			other, found, err := y.Get(item[0])
+			if err != nil {
+				return err
+			}
+			if !found {
+				d.add(valueChange{op: "remove", path: dictPathKey(path, item[0]), before: item[1]})
+				continue
+			}
+			if err := d.compare(item[1], other, dictPathKey(path, item[0])); err != nil {
+				return err
+			}
+		}
+		for _, item := range y.Items() {
+			if _, found, err := x.Get(item[0]); err != nil {
+				return err
+			} else if !found {
+				d.add(valueChange{op: "add", path: dictPathKey(path, item[0]), after: item[1]})
+			}
+		}
+		return nil
+	case *starlark.List:
+		y, ok := b.(*starlark.List)
+		if !ok {
+			break
+		}
+		for i := 0; i < x.Len() || i < y.Len(); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= y.Len():
+				d.add(valueChange{op: "remove", path: itemPath, before: x.Index(i)})
+			case i >= x.Len():
+				d.add(valueChange{op: "add", path: itemPath, after: y.Index(i)})
+			default:
+				if err := d.compare(x.Index(i), y.Index(i), itemPath); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case *starlark.Set:
+		y, ok := b.(*starlark.Set)
+		if !ok {
+			break
+		}
+		iter := x.Iterate()
+		defer iter.Done()
+		var element starlark.Value
+		for iter.Next(&element) {
+			if found, err := y.Has(element); err != nil {
+				return err
+			} else if !found {
+				d.add(valueChange{op: "remove", path: path, before: element})
+			}
+		}
+		other := y.Iterate()
+		defer other.Done()
+		for other.Next(&element) {
+			if found, err := x.Has(element); err != nil {
+				return err
+			} else if !found {
+				d.add(valueChange{op: "add", path: path, after: element})
+			}
+		}
+		return nil
+	}
+	if equal, err := starlark.EqualDepth(a, b, maxDiffDepth); err != nil {
+		return err
+	} else if equal {
+		return nil
+	}
+	d.add(valueChange{op: "change", path: path, before: a, after: b})
+	return nil
+}
+
+// diffOperand returns the value to compare: the returnValue of a script result (an object with stats) or the value itself.
+func diffOperand(value js.Value) js.Value {
+	if value.Type() == js.TypeObject && !value.InstanceOf(js.Global().Get("Array")) && value.Get("stats").Type() == js.TypeObject {
+		return value.Get("returnValue")
+	}
+	return value
+}
+
+func diffValues(a, b js.Value) (map[string]interface{}, error) {
+	conv := &converter{}
+	before, err := conv.convertToStarlarkValue(diffOperand(a))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the first value: %w", err)
+	}
+	after, err := conv.convertToStarlarkValue(diffOperand(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the second value: %w", err)
+	}
+	d := &valueDiff{}
+	if err := d.compare(before, after, "$"); err != nil {
+		return nil, err
+	}
+	changes := []interface{}{}
+	for _, change := range d.changes {
+		entry := map[string]interface{}{"op": change.op, "path": change.path}
+		if change.before != nil {
+			converted, err := conv.convertToJSValue(change.before)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert the value at %s: %w", change.path, err)
+			}
+			entry["before"] = converted
+		}
+		if change.after != nil {
+			converted, err := conv.convertToJSValue(change.after)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert the value at %s: %w", change.path, err)
+			}
+			entry["after"] = converted
+		}
+		changes = append(changes, entry)
+	}
+	result := map[string]interface{}{"equal": len(d.changes) == 0, "changes": changes}
+	if d.truncated {
+		result["truncated"] = true
+	}
+	return result, nil
+}
+
+func getValueDiffer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected two arguments with the values to compare. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		result, err := diffValues(args[0], args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: failed to compare the values. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		return result
+	})
+}
@@ -0,0 +1,530 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// maxRegionGrowth is the number of times the region re-parsed after an edit grows with the neighbouring chunks
+// (e.g. when an opening bracket joins the following statements) before the edited chunks are kept as a chunk that doesn't parse.
+const maxRegionGrowth = 3
+
+// topLevelBinding is the first binding of a name at the top level of a chunk, by an assignment, a def, a for loop or a load.
+type topLevelBinding struct {
+	ident *syntax.Ident
+	load  bool
+}
+
+// documentChunk is a run of lines of a document that starts with top-level statements (or at the start of the document)
+// and ends before the next ones. The chunks are parsed and resolved separately, so an edit only re-parses the chunks it touches.
+type documentChunk struct {
+	// start and end are the byte offsets of the chunk in the text of the document, line is the line where it starts.
+	start, end int
+	line       int
+	// astLine is the line of the start of the chunk in its syntax tree, the positions of the tree are shifted by line - astLine.
+	astLine  int
+	file     *syntax.File
+	parseErr *syntax.Error
+	bindings []topLevelBinding
+	// resolveErrs are the errors of the resolver, computed for the names bound and predeclared in the document given by resolvedFor.
+	resolved    bool
+	resolvedFor string
+	resolveErrs resolve.ErrorList
+}
+
+// document is a source file edited in an editor, kept as chunks so that every keystroke gives fresh diagnostics quickly.
+type document struct {
+	mu          sync.Mutex
+	id          uint64
+	filename    string
+	predeclared []string
+	text        string
+	version     int
+	chunks      []*documentChunk
+}
+
+var documents = struct {
+	sync.Mutex
+	nextID uint64
+	byID   map[uint64]*document
+}{byID: map[uint64]*document{}}
+
+func init() {
+	registerResetHook(func() {
+		documents.Lock()
+		defer documents.Unlock()
+		documents.byID = map[uint64]*document{}
+	})
+}
+
+func getDocument(id js.Value) (*document, error) {
+	if id.Type() != js.TypeNumber {
+		return nil, fmt.Errorf("the document id must be a number. Actual type %s", id.Type())
+	}
+	documents.Lock()
+	defer documents.Unlock()
+	d, ok := documents.byID[uint64(id.Int())]
+	if !ok {
+		return nil, fmt.Errorf("the document %d does not exist", id.Int())
+	}
+	return d, nil
+}
+
+func collectAssigned(expr syntax.Expr, add func(*syntax.Ident)) {
+	switch e := expr.(type) {
+	case *syntax.Ident:
+		add(e)
+	case *syntax.ParenExpr:
+		collectAssigned(e.X, add)
+	case *syntax.TupleExpr:
+		for _, x := range e.List {
+			collectAssigned(x, add)
+		}
+	case *syntax.ListExpr:
+		for _, x := range e.List {
+			collectAssigned(x, add)
+		}
+	}
+}
+
+// collectBindings adds the names bound at the top level by the statements, the bodies of the top-level
+// if, for and while statements bind global names too.
+func collectBindings(stmts []syntax.Stmt, add func(*syntax.Ident, bool)) {
+	assigned := func(id *syntax.Ident) { add(id, false) }
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *syntax.DefStmt:
+			add(s.Name, false)
+		case *syntax.AssignStmt:
+			if s.Op == syntax.EQ {
+				collectAssigned(s.LHS, assigned)
+			}
+		case *syntax.LoadStmt:
+			for _, id := range s.To {
+				add(id, true)
+			}
+		case *syntax.ForStmt:
+			collectAssigned(s.Vars, assigned)
+			collectBindings(s.Body, add)
+		case *syntax.WhileStmt:
+			collectBindings(s.Body, add)
+		case *syntax.IfStmt:
+			collectBindings(s.True, add)
+			collectBindings(s.False, add)
+		}
+	}
+}
+
+func newChunk(file *syntax.File, start, end, line, astLine int) *documentChunk {
+	c := &documentChunk{start: start, end: end, line: line, astLine: astLine, file: file}
+	seen := map[string]bool{}
+	collectBindings(file.Stmts, func(id *syntax.Ident, load bool) {
+		if !seen[id.Name] {
+			seen[id.Name] = true
+			c.bindings = append(c.bindings, topLevelBinding{ident: id, load: load})
+		}
+	})
+	return c
+}
+
+// parseRegion parses the text between two byte offsets, which starts at the beginning of a line of the document,
+// and splits it into chunks. A new chunk starts at each statement that starts after the end of the previous one.
+func (d *document) parseRegion(start, end, line int) ([]*documentChunk, error) {
+	src := d.text[start:end]
+	f, err := syntax.Parse(d.filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	lineStarts := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	groups := [][]syntax.Stmt{}
+	chunkLines := []int{}
+	lastLine := int32(0)
+	for _, stmt := range f.Stmts {
+		first, last := stmt.Span()
+		if len(groups) == 0 || first.Line > lastLine {
+			chunkLine := int(first.Line)
+			if len(groups) == 0 {
+				chunkLine = 1
+			}
+			groups = append(groups, nil)
+			chunkLines = append(chunkLines, chunkLine)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], stmt)
+		if last.Line > lastLine {
+			lastLine = last.Line
+		}
+	}
+	if len(groups) == 0 {
+		return []*documentChunk{newChunk(f, start, end, line, 1)}, nil
+	}
+	chunks := []*documentChunk{}
+	for i, stmts := range groups {
+		chunkStart, chunkEnd := start+lineStarts[chunkLines[i]-1], end
+		if i+1 < len(groups) {
+			chunkEnd = start + lineStarts[chunkLines[i+1]-1]
+		}
+		file := &syntax.File{Path: d.filename, Stmts: stmts}
+		chunks = append(chunks, newChunk(file, chunkStart, chunkEnd, line+chunkLines[i]-1, chunkLines[i]))
+	}
+	return chunks, nil
+}
+
+// errorChunk is a chunk that doesn't parse, it has no statements.
+func errorChunk(err error, start, end, line int) *documentChunk {
+	c := &documentChunk{start: start, end: end, line: line, astLine: 1}
+	if syntaxErr, ok := err.(syntax.Error); ok {
+		c.parseErr = &syntaxErr
+	} else {
+		c.parseErr = &syntax.Error{Pos: syntax.MakePosition(nil, 1, 1), Msg: err.Error()}
+	}
+	return c
+}
+
+// load parses the whole text of the document.
+func (d *document) load(text string) {
+	d.text = text
+	chunks, err := d.parseRegion(0, len(text), 1)
+	if err != nil {
+		chunks = []*documentChunk{errorChunk(err, 0, len(text), 1)}
+	}
+	d.chunks = chunks
+}
+
+// edit replaces the bytes between from and to with the text and re-parses the chunks the edit touches.
+// It returns the number of chunks and bytes that were parsed.
+func (d *document) edit(from, to int, text string) (int, int) {
+	delta := len(text) - (to - from)
+	lineDelta := strings.Count(text, "\n") - strings.Count(d.text[from:to], "\n")
+	d.text = d.text[:from] + text + d.text[to:]
+	lo, hi := -1, -1
+	for i, c := range d.chunks {
+		// an insertion between two chunks touches both
+		if c.end >= from && c.start <= to {
+			if lo < 0 {
+				lo = i
+			}
+			hi = i
+		}
+	}
+	// the chunks that don't parse are parsed again with the edited ones, since the edit may complete them
+	// (e.g. the closing quotes of a string opened many statements before)
+	for i, c := range d.chunks {
+		if c.parseErr != nil {
+			if i < lo {
+				lo = i
+			}
+			if i > hi {
+				hi = i
+			}
+		}
+	}
+	parsedChunks, parsedBytes := 0, 0
+	var firstErr error
+	firstLo, firstHi := lo, hi
+	for growth := 0; ; growth++ {
+		start, end := d.chunks[lo].start, d.chunks[hi].end+delta
+		chunks, err := d.parseRegion(start, end, d.chunks[lo].line)
+		parsedChunks, parsedBytes = parsedChunks+hi-lo+1, parsedBytes+end-start
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err != nil && growth < maxRegionGrowth && (lo > 0 || hi < len(d.chunks)-1) {
+			n := hi - lo + 1
+			if lo -= n; lo < 0 {
+				lo = 0
+			}
+			if hi += n; hi > len(d.chunks)-1 {
+				hi = len(d.chunks) - 1
+			}
+			continue
+		}
+		if err != nil {
+			// keep the other chunks, the edited ones become a chunk with the error of their own parse
+			lo, hi = firstLo, firstHi
+			start, end = d.chunks[lo].start, d.chunks[hi].end+delta
+			chunks = []*documentChunk{errorChunk(firstErr, start, end, d.chunks[lo].line)}
+		}
+		for _, c := range d.chunks[hi+1:] {
+			c.start, c.end, c.line = c.start+delta, c.end+delta, c.line+lineDelta
+		}
+		d.chunks = append(append(append([]*documentChunk{}, d.chunks[:lo]...), chunks...), d.chunks[hi+1:]...)
+		return parsedChunks, parsedBytes
+	}
+}
+
+// documentDiagnostic is a syntax or resolve error of a document, at its position in the document.
+type documentDiagnostic struct {
+	kind    string
+	message string
+	line    int
+	col     int
+}
+
+// shiftDeclaredAt fixes the lines of the positions in the "cannot reassign x declared at file:line:col" errors of a chunk.
+func shiftDeclaredAt(message, filename string, shift int) string {
+	marker := " declared at " + filename + ":"
+	i := strings.LastIndex(message, marker)
+	if i < 0 {
+		return message
+	}
+	var line, col int
+	if n, _ := fmt.Sscanf(message[i+len(marker):], "%d:%d", &line, &col); n != 2 {
+		return message
+	}
+	return fmt.Sprintf("%s%s%d:%d", message[:i], marker, line+shift, col)
+}
+
+// diagnostics returns the syntax errors of the chunks or, if they all parse, the errors of the resolver, and the number of chunks resolved.
+// The chunks are only resolved again if the names bound or predeclared in the document changed.
+func (d *document) diagnostics() ([]documentDiagnostic, int) {
+	diagnostics := []documentDiagnostic{}
+	for _, c := range d.chunks {
+		if c.parseErr != nil {
+			shift := c.line - c.astLine
+			diagnostics = append(diagnostics, documentDiagnostic{kind: "syntax", message: c.parseErr.Msg, line: int(c.parseErr.Pos.Line) + shift, col: int(c.parseErr.Pos.Col)})
+		}
+	}
+	if len(diagnostics) > 0 {
+		return diagnostics, 0
+	}
+	globals := map[string]bool{}
+	names := []string{}
+	type firstBinding struct {
+		binding topLevelBinding
+		chunk   *documentChunk
+	}
+	first := map[string]firstBinding{}
+	for _, c := range d.chunks {
+		shift := c.line - c.astLine
+		for _, b := range c.bindings {
+			prev, ok := first[b.ident.Name]
+			if !ok {
+				first[b.ident.Name] = firstBinding{binding: b, chunk: c}
+				globals[b.ident.Name] = true
+				names = append(names, b.ident.Name)
+				continue
+			}
+			if resolve.AllowGlobalReassign && !b.load && !prev.binding.load {
+				continue
+			}
+			scope := "global"
+			if prev.binding.load {
+				scope = "local"
+			}
+			pos := prev.binding.ident.NamePos
+			declared := syntax.MakePosition(&d.filename, pos.Line+int32(prev.chunk.line-prev.chunk.astLine), pos.Col)
+			message := fmt.Sprintf("cannot reassign %s %s declared at %s", scope, b.ident.Name, declared)
+			diagnostics = append(diagnostics, documentDiagnostic{kind: "resolve", message: message, line: int(b.ident.NamePos.Line) + shift, col: int(b.ident.NamePos.Col)})
+		}
+	}
+	isPredeclared := lintPredeclared(d.predeclared)
+	predeclared := []string{}
+	for name := range isPredeclared {
+		predeclared = append(predeclared, name)
+	}
+	sort.Strings(names)
+	sort.Strings(predeclared)
+	key := strings.Join(names, ",") + ";" + strings.Join(predeclared, ",")
+	resolved := 0
+	for _, c := range d.chunks {
+		if !c.resolved || c.resolvedFor != key {
+			c.resolveErrs = nil
+			err := resolve.REPLChunk(c.file, func(name string) bool { return globals[name] }, func(name string) bool { return isPredeclared[name] }, starlark.Universe.Has)
+			if errs, ok := err.(resolve.ErrorList); ok {
+				c.resolveErrs = errs
+			}
+			c.resolved, c.resolvedFor = true, key
+			resolved++
+		}
+		shift := c.line - c.astLine
+		for _, err := range c.resolveErrs {
+			diagnostics = append(diagnostics, documentDiagnostic{kind: "resolve", message: shiftDeclaredAt(err.Msg, d.filename, shift), line: int(err.Pos.Line) + shift, col: int(err.Pos.Col)})
+		}
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].line < diagnostics[j].line || diagnostics[i].line == diagnostics[j].line && diagnostics[i].col < diagnostics[j].col
+	})
+	return diagnostics, resolved
+}
+
+// result returns the diagnostics of the document and the work done by the last update.
+func (d *document) result(parsedChunks, parsedBytes int) map[string]interface{} {
+	diagnostics, resolved := d.diagnostics()
+	list := []interface{}{}
+	for _, diagnostic := range diagnostics {
+		pos := map[string]interface{}{"line": diagnostic.line, "col": diagnostic.col}
+		list = append(list, map[string]interface{}{"kind": diagnostic.kind, "message": diagnostic.message, "start": pos, "end": pos})
+	}
+	return map[string]interface{}{
+		"documentId":  float64(d.id),
+		"version":     d.version,
+		"diagnostics": list,
+		"incremental": map[string]interface{}{"chunks": len(d.chunks), "parsedChunks": parsedChunks, "parsedBytes": parsedBytes, "resolvedChunks": resolved},
+	}
+}
+
+// byteOffset converts an offset in UTF-16 code units, like the indices of javascript strings and the changes of editors,
+// into an offset in the bytes of the text.
+func byteOffset(text string, offset int) (int, error) {
+	units := 0
+	for i, r := range text {
+		if units == offset {
+			return i, nil
+		}
+		if units > offset {
+			return 0, fmt.Errorf("the offset %d is in the middle of a character", offset)
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	if units == offset {
+		return len(text), nil
+	}
+	if units > offset {
+		return 0, fmt.Errorf("the offset %d is in the middle of a character", offset)
+	}
+	return 0, fmt.Errorf("the offset %d is after the end of the document (%d)", offset, units)
+}
+
+// parseDocumentEdit returns the byte offsets and the text of an edit {from, to, text} of a text.
+func parseDocumentEdit(text string, edit js.Value, i int) (int, int, string, error) {
+	offsets := [2]int{}
+	for j, key := range []string{"from", "to"} {
+		value, ok, err := getNumberOption(edit, key)
+		if err == nil && (!ok || value < 0 || value != float64(int(value))) {
+			err = fmt.Errorf("the field %q must be a non-negative integer", key)
+		}
+		if err == nil {
+			offsets[j], err = byteOffset(text, int(value))
+		}
+		if err != nil {
+			return 0, 0, "", fmt.Errorf("invalid edit %d: %v", i, err)
+		}
+	}
+	if offsets[0] > offsets[1] {
+		return 0, 0, "", fmt.Errorf("invalid edit %d: from is after to", i)
+	}
+	insert, _, err := getStringOption(edit, "text")
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid edit %d: %v", i, err)
+	}
+	return offsets[0], offsets[1], insert, nil
+}
+
+func getDocumentOpener() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 || args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		filename, _, err := getStringOption(options, "filename")
+		var predeclared []string
+		if err == nil {
+			predeclared, err = getStringListOption(options, "predeclared")
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		d := &document{filename: filename, predeclared: predeclared}
+		d.load(args[0].String())
+		documents.Lock()
+		documents.nextID++
+		d.id = documents.nextID
+		documents.byID[d.id] = d
+		documents.Unlock()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.result(len(d.chunks), len(d.text))
+	})
+}
+
+func getDocumentEditor() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 || !args[1].InstanceOf(js.Global().Get("Array")) {
+			err := fmt.Errorf("Error: expected two arguments with the document id and the array of edits. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		d, err := getDocument(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid document. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		// the edits are validated first so that a bad edit leaves the document unchanged
+		text := d.text
+		type edit struct {
+			from, to int
+			text     string
+		}
+		edits := []edit{}
+		for i := 0; i < args[1].Length(); i++ {
+			from, to, insert, err := parseDocumentEdit(text, args[1].Index(i), i)
+			if err != nil {
+				err := fmt.Errorf("Error: invalid edits. Error: %q", err)
+				return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+			}
+			edits = append(edits, edit{from, to, insert})
+			text = text[:from] + insert + text[to:]
+		}
+		parsedChunks, parsedBytes := 0, 0
+		for _, e := range edits {
+			chunks, bytes := d.edit(e.from, e.to, e.text)
+			parsedChunks, parsedBytes = parsedChunks+chunks, parsedBytes+bytes
+		}
+		d.version++
+		return d.result(parsedChunks, parsedBytes)
+	})
+}
+
+func getDocumentCloser() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the document id. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		d, err := getDocument(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid document. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		documents.Lock()
+		defer documents.Unlock()
+		delete(documents.byID, d.id)
+		return map[string]interface{}{"message": fmt.Sprintf("the document %d has been closed", d.id)}
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// In a dry run the calls to the host that may have effects (e.g. the builtins registered by javascript that write to
+// storage, fetch or change the page, and write_file) are recorded as effects instead of being made, they return None.
+// The reads of the runtime (the time module, read_file and glob) and the builtins listed by dryRunAllow are made as usual,
+// so the host can preview what an untrusted script would do before approving a real run.
+
+// dryRunReads are the builtins that are called in dry runs without being listed by dryRunAllow.
+var dryRunReads = []string{"time", "read_file", "glob"}
+
+// dryRunOptions control the dry runs.
+type dryRunOptions struct {
+	enabled bool
+	// allow are the keys (builtin, module, capability or "*", like the hostCallQuotas) of the builtins that are called in dry runs.
+	allow []string
+}
+
+func parseDryRunOptions(options js.Value) (dryRunOptions, error) {
+	opts := dryRunOptions{}
+	var err error
+	if opts.enabled, err = getBoolOption(options, "dryRun"); err != nil {
+		return opts, err
+	}
+	if opts.allow, err = getStringListOption(options, "dryRunAllow"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// intercepts reports whether a call to the host is recorded instead of being made.
+func (opts dryRunOptions) intercepts(name, capability string) bool {
+	if !opts.enabled {
+		return false
+	}
+	for _, key := range hostCallKeys(name, capability) {
+		if containsString(dryRunReads, key) || containsString(opts.allow, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordEffect adds an intercepted call to the effects of the execution, with its converted arguments
+// (the repr of the ones that can't be converted) and the position of the call.
+func (e *execution) recordEffect(thread *starlark.Thread, name string, args starlark.Tuple, kwargs []starlark.Tuple) {
+	convert := func(value starlark.Value) interface{} {
+		converted, err := e.conv.convertToJSValue(value)
+		if err != nil {
+			return value.String()
+		}
+		return converted
+	}
+	jsArgs := []interface{}{}
+	for _, arg := range args {
+		jsArgs = append(jsArgs, convert(arg))
+	}
+	jsKwargs := map[string]interface{}{}
+	for _, kwarg := range kwargs {
+		jsKwargs[string(kwarg[0].(starlark.String))] = convert(kwarg[1])
+	}
+	effect := map[string]interface{}{"name": name, "args": jsArgs, "kwargs": jsKwargs}
+	if thread.CallStackDepth() > 1 {
+		pos := thread.CallFrame(1).Pos
+		effect["position"] = map[string]interface{}{"file": pos.Filename(), "line": int(pos.Line), "col": int(pos.Col)}
+	}
+	e.effects = append(e.effects, effect)
+}
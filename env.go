@@ -0,0 +1,81 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// parseEnvOption converts the env option, an object mapping names to values, into a frozen dict.
+// The dict is empty if the option is missing.
+func parseEnvOption(options js.Value) (*starlark.Dict, error) {
+	env := starlark.NewDict(0)
+	value := getOption(options, "env")
+	if value.IsUndefined() || value.IsNull() {
+		env.Freeze()
+		return env, nil
+	}
+	if value.Type() != js.TypeObject || value.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("the option \"env\" must be an object. Actual type %s", value.Type())
+	}
+	conv := &converter{}
+	keys := js.Global().Get("Object").Call("keys", value)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		converted, err := conv.convertToStarlarkValue(value.Get(key))
+		if err != nil {
+			return nil, fmt.Errorf("the value of the option \"env\" %q can't be converted: %v", key, err)
+		}
+		if err := env.SetKey(starlark.String(key), converted); err != nil {
+			return nil, err
+		}
+	}
+	env.Freeze()
+	return env, nil
+}
+
+// newEnvModule returns the env module that gives scripts read only access to the environment of the execution.
+// It is predeclared instead of adding the values to the globals, so the values can change between runs.
+func newEnvModule(env *starlark.Dict) *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "env",
+		Members: starlark.StringDict{
+			"get": starlark.NewBuiltin("env.get", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				checkpoint(thread)
+				var key string
+				var dflt starlark.Value = starlark.None
+				if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &dflt); err != nil {
+					return nil, err
+				}
+				value, found, err := env.Get(starlark.String(key))
+				if err != nil || !found {
+					return dflt, err
+				}
+				return value, nil
+			}),
+			"keys": starlark.NewBuiltin("env.keys", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				checkpoint(thread)
+				if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+					return nil, err
+				}
+				return starlark.NewList(env.Keys()), nil
+			}),
+		},
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// newOnBuiltin returns the on builtin of a session, which registers a starlark function as a handler of a named event.
+// Handlers are called in the order they were registered by dispatch_starlark_event.
+func newOnBuiltin(s *session) *starlark.Builtin {
+	return starlark.NewBuiltin("on", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var handler starlark.Callable
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "handler", &handler); err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: the event name must not be empty", b.Name())
+		}
+		s.handlers[name] = append(s.handlers[name], handler)
+		return starlark.None, nil
+	})
+}
+
+// dispatch calls the handlers of the event in a new execution.
+// The result has the number of handlers called and their return values in order.
+// The first handler that fails stops the dispatch.
+func (s *session) dispatch(name string, payload []js.Value, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	if busy := s.acquire(); busy != nil {
+		return e.finish(busy.errorResult())
+	}
+	defer s.release()
+	e.session = s
+	if errResult := e.sessionQuotaErrorResult(); errResult != nil {
+		return e.finish(errResult)
+	}
+	e.opts.args, e.opts.argsJSON, e.opts.argsTagged, e.opts.argsMsgpack = payload, "", "", nil
+	funcArgs, err := e.convertArgs()
+	if err != nil {
+		return e.finish(invalidArgumentsResult(err))
+	}
+	// copy the handlers since a handler may register more handlers for the same event
+	handlers := append([]starlark.Callable{}, s.handlers[name]...)
+	returnValues := []starlark.Value{}
+	for i, handler := range handlers {
+		returnValue, errResult := callStarlarkFunction(e, handler.Name(), handler, funcArgs)
+		if errResult != nil {
+			details, _ := errResult["details"].(map[string]interface{})
+			if details == nil {
+				details = map[string]interface{}{}
+			}
+			details["event"] = name
+			details["handler"] = i
+			errResult["details"] = details
+			return e.finish(errResult)
+		}
+		returnValues = append(returnValues, returnValue)
+	}
+	result = map[string]interface{}{"message": e.output.String(), "handlers": len(handlers)}
+	return e.finish(e.withReturnValue(result, starlark.NewList(returnValues)))
+}
+
+func getEventDispatcher() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected at least two arguments with the session id and the event name. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "stats": executionStats{}.toJS()}
+		}
+		if args[1].Type() != js.TypeString {
+			err := fmt.Errorf("Error: the event name must be a string. Actual type %s", args[1].Type())
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		name := args[1].String()
+		payload := []js.Value{}
+		if len(args) > 2 && !args[2].IsUndefined() {
+			payload = append(payload, args[2])
+		}
+		options := js.Undefined()
+		if len(args) > 3 {
+			options = args[3]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		return s.dispatch(name, payload, opts)
+	})
+}
@@ -0,0 +1,533 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// executionStats describe the cost of running some starlark code.
+type executionStats struct {
+	steps           uint64
+	duration        time.Duration
+	printCalls      int
+	conversionDepth int
+	yields          int
+}
+
+func (s executionStats) toJS() map[string]interface{} {
+	return map[string]interface{}{
+		"steps":           float64(s.steps),
+		"durationMs":      float64(s.duration) / float64(time.Millisecond),
+		"printCalls":      s.printCalls,
+		"conversionDepth": s.conversionDepth,
+		"yields":          s.yields,
+	}
+}
+
+// executions keeps track of the executions that are currently running.
+// run_starlark_code can be called again while an execution is in progress (e.g. from a javascript callback)
+// so any state shared between executions must be protected by a mutex.
+var executions = struct {
+	sync.Mutex
+	nextID  uint64
+	running map[uint64]*starlark.Thread
+	wg      sync.WaitGroup
+}{running: map[uint64]*starlark.Thread{}}
+
+// startExecution registers a new execution and returns its unique id.
+func startExecution(thread *starlark.Thread) uint64 {
+	executions.Lock()
+	defer executions.Unlock()
+	executions.nextID++
+	executions.running[executions.nextID] = thread
+	executions.wg.Add(1)
+	return executions.nextID
+}
+
+func finishExecution(id uint64) {
+	executions.Lock()
+	defer executions.Unlock()
+	delete(executions.running, id)
+	executions.wg.Done()
+}
+
+// executionLocalKey is the thread local that holds the execution of the thread.
+const executionLocalKey = "execution"
+
+// threadExecution returns the execution running on the thread, nil if there is none.
+func threadExecution(thread *starlark.Thread) *execution {
+	e, _ := thread.Local(executionLocalKey).(*execution)
+	return e
+}
+
+// canBlockLocalKey is the thread local that tells whether the execution may wait for javascript promises.
+const canBlockLocalKey = "canBlock"
+
+func threadCanBlock(thread *starlark.Thread) bool {
+	canBlock, _ := thread.Local(canBlockLocalKey).(bool)
+	return canBlock
+}
+
+// execution holds the state of a single run of some starlark code.
+type execution struct {
+	id          uint64
+	start       time.Time
+	thread      *starlark.Thread
+	stats       executionStats
+	output      outputBuffer
+	conv        *converter
+	checkpoints *checkpointer
+	monitor     *memoryMonitor
+	deadline    *deadline
+	yielder     *yielder
+	tracer      *tracer
+	callDepth   *callDepthGuard
+	opts        runOptions
+	finished    bool
+	// builtins is the predeclared environment, built on first use.
+	builtins starlark.StringDict
+	// logs are the records of the log module when there is no onLog callback.
+	logs []interface{}
+	// auditLog are the records of the calls to the host when there is no onAudit callback (see auditOptions).
+	auditLog []interface{}
+	// hostCallCounts are the numbers of calls to the host counted against each of the hostCallQuotas.
+	hostCallCounts map[string]int
+	// quotaErr is set when one of the hostCallQuotas was exceeded.
+	quotaErr *quotaExceededError
+	// effects are the calls to the host intercepted by a dry run.
+	effects []interface{}
+	// fileModules caches the modules loaded from the virtual filesystem of the execution.
+	fileModules map[string]*moduleEntry
+	// session is the session the execution runs in, nil for the other executions.
+	session *session
+	// rejected is set when the execution was refused because one of the quotas of its session was exceeded.
+	rejected bool
+	// sources are the source codes of the files executed by the execution, by file name.
+	sources map[string]string
+}
+
+// newExecution creates a new thread and registers the execution.
+// The caller must call finish once the execution is complete.
+func newExecution(opts runOptions) *execution {
+	conv := &converter{
+		depthLimit:       opts.maxConversionDepth,
+		elementLimit:     opts.maxArgumentElements,
+		stringBytesLimit: opts.maxArgumentStringBytes,
+		numbersAsFloats:  opts.numbersAsFloats,
+		intOverflow:      opts.intOverflow,
+		invalidUnicode:   opts.invalidUnicode,
+		typedArrays:      opts.returnBinary,
+		awaitPromises:    opts.canBlock,
+	}
+	e := &execution{start: time.Now(), conv: conv, opts: opts}
+	e.output.limit, e.output.keepTail = opts.maxOutputBytes, opts.keepOutputTail
+	e.thread = &starlark.Thread{Print: func(thread *starlark.Thread, msg string) {
+		e.stats.printCalls++
+		if opts.printTo != "console" {
+			e.output.WriteString(msg + "\n")
+		}
+		if opts.printTo == "console" || opts.printTo == "both" {
+			js.Global().Get("console").Call("log", fmt.Sprintf("[%s] %s", thread.Name, msg))
+		}
+		checkpoint(thread)
+	}}
+	e.thread.Load = loadModule
+	e.thread.SetLocal(executionLocalKey, e)
+	e.thread.SetLocal(canBlockLocalKey, opts.canBlock)
+	e.id = startExecution(e.thread)
+	e.thread.Name = fmt.Sprintf("js-go-starlark-thread-%d", e.id)
+	e.checkpoints = newCheckpointer(e.thread)
+	if opts.maxMemoryBytes > 0 {
+		e.monitor = newMemoryMonitor(opts.maxMemoryBytes)
+		e.checkpoints.add(e.monitor.check)
+	}
+	if opts.timeout > 0 {
+		e.deadline = newDeadline(e.start, opts.timeout)
+		e.checkpoints.add(e.deadline.check)
+	}
+	if opts.cancellation != nil {
+		e.checkpoints.add(opts.cancellation.check)
+	}
+	// only the executions of run_starlark_code_async can wait for the event loop
+	if opts.yieldEverySteps > 0 && opts.canBlock {
+		e.yielder = newYielder(opts.yieldEverySteps)
+		e.checkpoints.add(e.yielder.check)
+	}
+	if opts.maxCallDepth > 0 {
+		e.callDepth = newCallDepthGuard(opts.maxCallDepth)
+		e.checkpoints.add(e.callDepth.checkpoint)
+	}
+	if opts.trace.enabled() {
+		e.tracer = &tracer{}
+	}
+	emitTelemetry("onExecStart", e.telemetryData())
+	return e
+}
+
+// predeclared returns the predeclared environment of the execution:
+// the universal builtins, the globals of the preludes and the builtins enabled by the options.
+func (e *execution) predeclared() starlark.StringDict {
+	if e.builtins != nil {
+		return e.builtins
+	}
+	e.builtins = starlark.StringDict{}
+	for name, value := range predeclaredGlobals() {
+		e.builtins[name] = value
+	}
+	env := e.opts.env
+	if env == nil {
+		env = starlark.NewDict(0)
+	}
+	e.builtins["env"] = newEnvModule(env)
+	e.builtins["check_cancelled"] = checkCancelled
+	e.builtins["report_progress"] = reportProgress
+	e.builtins["fail_with"] = failWith
+	e.builtins["input"] = inputBuiltin
+	e.builtins["log"] = newLogModule(e)
+	e.builtins["channel"] = channelModule
+	e.builtins["template"] = templateModule
+	e.builtins["semver"] = semverModule
+	e.builtins["result"] = resultModule
+	e.builtins["intl"] = newIntlModule(e)
+	e.builtins["archive"] = newArchiveModule(e.opts.archive)
+	if e.tracer != nil {
+		e.builtins[traceBuiltinName] = traceStatement
+	}
+	if e.callDepth != nil {
+		e.builtins[callDepthBuiltinName] = e.callDepth.builtin()
+	}
+	if e.opts.timeModule {
+		var c clock = realClock{}
+		if e.opts.clock != nil {
+			c = e.opts.clock
+		}
+		e.builtins["time"] = newTimeModule(c)
+	} else {
+		e.builtins["time"] = deniedBuiltin{name: "time", capability: "time"}
+	}
+	if e.opts.fileBuiltins {
+		for name, value := range fileBuiltins(e.opts.fs) {
+			e.builtins[name] = value
+		}
+	} else {
+		for _, name := range capabilityBuiltins["fs"] {
+			e.builtins[name] = deniedBuiltin{name: name, capability: "fs"}
+		}
+	}
+	for name, builtin := range registeredJSBuiltins() {
+		if e.opts.granted(builtin.capability) {
+			e.builtins[name] = builtin.value
+		} else {
+			e.builtins[name] = deniedBuiltin{name: name, capability: builtin.capability}
+		}
+	}
+	if e.opts.record != nil || e.opts.replay != nil {
+		for _, name := range recordedBuiltins {
+			e.builtins[name] = e.recordedCalls(name, e.builtins[name])
+		}
+	}
+	if e.opts.audit.enabled || e.opts.hostCallQuotas != nil || e.opts.dryRun.enabled || e.session != nil || e.opts.record != nil || e.opts.replay != nil {
+		for name, capability := range hostBuiltinCapabilities() {
+			if value, ok := e.builtins[name]; ok {
+				e.builtins[name] = e.hostCalls(name, capability, value)
+			}
+		}
+	}
+	return e.builtins
+}
+
+// now returns the time of the clock of the execution, the wall clock if there is none or it fails.
+func (e *execution) now() time.Time {
+	if e.opts.clock != nil {
+		if t, err := e.opts.clock.now(); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// convertArgs converts the arguments of the function call to starlark values and freezes them if freezeArgs is set.
+func (e *execution) convertArgs() ([]starlark.Value, error) {
+	funcArgs, err := e.convertArgValues()
+	if err == nil && e.opts.freezeArgs {
+		for _, arg := range funcArgs {
+			arg.Freeze()
+		}
+	}
+	return funcArgs, err
+}
+
+// convertArgValues converts the arguments from the args, argsJson, argsTagged or argsMsgpack option.
+// The limits on the number of elements apply to each call separately.
+func (e *execution) convertArgValues() ([]starlark.Value, error) {
+	e.conv.elements = 0
+	if e.opts.argsTagged != "" {
+		return e.conv.convertTaggedArgsToStarlarkValues(e.opts.argsTagged)
+	}
+	if e.opts.argsJSON != "" {
+		return e.conv.convertJSONArgsToStarlarkValues(e.opts.argsJSON)
+	}
+	if e.opts.argsMsgpack != nil {
+		return e.conv.convertMsgpackArgsToStarlarkValues(e.opts.argsMsgpack)
+	}
+	funcArgs := []starlark.Value{}
+	defer func() { e.conv.path = "" }()
+	for i, arg := range e.opts.args {
+		e.conv.path = fmt.Sprintf("args[%d]", i)
+		converted, err := e.conv.convertToStarlarkValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		funcArgs = append(funcArgs, converted)
+	}
+	return funcArgs, nil
+}
+
+// invalidArgumentsResult returns the error result for arguments that can't be converted.
+// If a conversion limit was exceeded the details have the name of the option that sets the limit and its value.
+func invalidArgumentsResult(err error) map[string]interface{} {
+	result := map[string]interface{}{"error": fmt.Errorf("Error: invalid arguments. Error: %q", err).Error(), "errorCode": "invalid_argument"}
+	var limitErr *conversionLimitError
+	if errors.As(err, &limitErr) {
+		result["details"] = map[string]interface{}{"limit": limitErr.limit, "max": limitErr.max}
+	}
+	return result
+}
+
+// withReturnValue adds the converted return value of the function to the result object,
+// and its repr if it was requested (also if the conversion or the validation against returnSchema failed).
+func (e *execution) withReturnValue(result map[string]interface{}, returnValue starlark.Value) map[string]interface{} {
+	if r, ok := returnValue.(*resultValue); ok {
+		returnValue = r.toDict()
+	}
+	if errResult := e.schemaErrorResult(returnValue); errResult != nil {
+		result = errResult
+	} else {
+		result = e.convertReturnValue(result, returnValue)
+	}
+	if e.opts.returnRepr {
+		result["repr"] = returnValue.String()
+	}
+	if e.opts.returnPretty != nil {
+		result["pretty"], _ = prettyPrint(returnValue, *e.opts.returnPretty)
+	}
+	e.noteReturnValue(returnValue)
+	return result
+}
+
+// withGlobals adds the globals of a script to the result object, except the ones whose name starts with an underscore.
+// They are converted like a return value, the converted dict is in globals instead of returnValue.
+func (e *execution) withGlobals(result map[string]interface{}, globals starlark.StringDict) map[string]interface{} {
+	result = e.withReturnValue(result, publicGlobals(globals))
+	if value, ok := result["returnValue"]; ok {
+		delete(result, "returnValue")
+		result["globals"] = value
+	}
+	return result
+}
+
+func (e *execution) convertReturnValue(result map[string]interface{}, returnValue starlark.Value) map[string]interface{} {
+	if e.opts.returnTagged {
+		tagged, err := convertToTaggedJSValue(returnValue)
+		if err != nil {
+			err := fmt.Errorf("Error: failed to convert the return value. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		result["returnValueTagged"] = tagged
+		return result
+	}
+	if e.opts.returnJSON {
+		data, err := e.conv.convertToJSON(returnValue)
+		if err != nil {
+			return conversionErrorResult(err)
+		}
+		result["returnValueJson"] = data
+		return result
+	}
+	if e.opts.returnMsgpack {
+		data, err := e.conv.convertToMsgpack(returnValue)
+		if err != nil {
+			return conversionErrorResult(err)
+		}
+		result["returnValueMsgpack"] = copyBytesToUint8Array(data)
+		return result
+	}
+	if e.opts.stream.enabled() {
+		data, err := e.conv.convertToJSON(returnValue)
+		if err != nil {
+			return conversionErrorResult(err)
+		}
+		if len(data) > e.opts.stream.thresholdBytes {
+			chunks := e.opts.stream.streamChunks([]byte(data))
+			result["streamed"] = map[string]interface{}{"chunks": chunks, "bytes": len(data)}
+			return result
+		}
+	}
+	if e.opts.returnBinary {
+		if array, ok := convertToBinaryJSValue(returnValue); ok {
+			result["returnValue"] = array
+			return result
+		}
+	}
+	if dict, ok := returnValue.(*starlark.Dict); ok && e.opts.returnLazy {
+		result["returnValue"] = newLazyDict(dict, e.session, e.opts)
+		return result
+	}
+	e.conv.freeze = e.opts.freezeReturnValue
+	if e.opts.returnFunctions {
+		e.conv.functions = func(fn starlark.Callable) js.Value { return newFunctionProxy(fn, e.session, e.sources) }
+	}
+	converted, err := e.conv.convertToJSValue(returnValue)
+	e.conv.freeze, e.conv.functions = false, nil
+	if err != nil {
+		return conversionErrorResult(err)
+	}
+	result["returnValue"] = converted
+	return result
+}
+
+// conversionErrorResult returns the error result for a return value that can't be converted.
+func conversionErrorResult(err error) map[string]interface{} {
+	errorCode := "resource_exhausted"
+	if _, ok := err.(*intOverflowError); ok {
+		errorCode = "out_of_range"
+	}
+	if _, ok := err.(*converterError); ok {
+		errorCode = "internal"
+	}
+	err = fmt.Errorf("Error: failed to convert the return value. Error: %q", err)
+	return map[string]interface{}{"error": err.Error(), "errorCode": errorCode}
+}
+
+// finish unregisters the execution and adds the execution statistics to the result object.
+// The result is replaced with a structured error if the memory budget was exceeded.
+func (e *execution) finish(result map[string]interface{}) map[string]interface{} {
+	// finish is called again if a panic is recovered after the execution finished
+	first := !e.finished
+	if first {
+		e.finished = true
+		finishExecution(e.id)
+	}
+	if e.monitor != nil {
+		if e.monitor.check(e.thread); e.monitor.exceeded {
+			err := fmt.Errorf("Error: resource exhausted. The execution exceeded the memory budget of %d bytes.", e.opts.maxMemoryBytes)
+			result = map[string]interface{}{
+				"error":     err.Error(),
+				"errorCode": "resource_exhausted",
+				"details":   map[string]interface{}{"maxMemoryBytes": float64(e.opts.maxMemoryBytes), "usedMemoryBytes": float64(e.monitor.peak)},
+			}
+		}
+	}
+	if e.deadline != nil && e.deadline.exceeded {
+		result = e.deadline.errorResult()
+	}
+	if e.quotaErr != nil {
+		result = e.quotaErr.errorResult()
+	}
+	if e.callDepth != nil && e.callDepth.err != nil {
+		result = e.callDepth.err.errorResult()
+	}
+	if c := e.opts.cancellation; c != nil {
+		if c.forced {
+			err := fmt.Errorf("Error: cancelled. The execution didn't stop within %d steps after it was cancelled.", c.graceSteps)
+			result = map[string]interface{}{"error": err.Error(), "errorCode": "cancelled"}
+		} else if c.noticed {
+			result["cancelled"] = true
+		}
+	}
+	if _, failed := result["error"]; failed && result["errorKind"] == nil {
+		result["errorKind"] = "internal"
+	}
+	if len(e.logs) > 0 {
+		result["logs"] = e.logs
+	}
+	if len(e.conv.warnings) > 0 {
+		result["conversionWarnings"] = e.conv.warningsToJS()
+	}
+	if e.opts.audit.enabled && e.opts.audit.onAudit.IsUndefined() {
+		result["audit"] = append([]interface{}{}, e.auditLog...)
+	}
+	if e.opts.dryRun.enabled {
+		result["effects"] = append([]interface{}{}, e.effects...)
+	}
+	if e.opts.record != nil && e.opts.record.started {
+		if recording, err := e.opts.record.toJS(result); err == nil {
+			result["recording"] = recording
+		}
+	}
+	if e.opts.replay != nil {
+		result["replay"] = e.opts.replay.report(result)
+	}
+	if e.tracer != nil {
+		result["trace"] = e.tracer.toJS()
+	}
+	if _, ok := result["message"]; ok && e.output.truncated {
+		result["truncated"] = true
+	}
+	if fs, ok := e.opts.fs.(*memoryFileSystem); ok {
+		result["files"] = fs.toJS()
+	}
+	e.stats.steps = e.thread.ExecutionSteps()
+	e.stats.duration = time.Since(e.start)
+	e.stats.conversionDepth = e.conv.maxDepth
+	if e.yielder != nil {
+		e.stats.yields = e.yielder.yields
+	}
+	result["stats"] = e.stats.toJS()
+	if first && e.session != nil && !e.rejected {
+		e.session.recordUsage(e.stats.steps, e.stats.duration)
+	}
+	if first {
+		e.emitEndTelemetry(result)
+	}
+	return result
+}
+
+// emitEndTelemetry emits the onError event if the execution failed and then the onExecEnd event.
+func (e *execution) emitEndTelemetry(result map[string]interface{}) {
+	if message, failed := result["error"]; failed {
+		data := e.telemetryData()
+		data["error"] = message
+		if errorCode, ok := result["errorCode"]; ok {
+			data["errorCode"] = errorCode
+		}
+		emitTelemetry("onError", data)
+	}
+	data := e.telemetryData()
+	data["durationMs"] = float64(e.stats.duration) / float64(time.Millisecond)
+	data["stats"] = result["stats"]
+	_, failed := result["error"]
+	data["ok"] = !failed
+	emitTelemetry("onExecEnd", data)
+}
+
+// recoverPanic must be deferred by the functions that run an execution. It turns a Go panic
+// (e.g. while converting a value that has no javascript equivalent) into an error result
+// instead of letting it kill the wasm instance.
+func (e *execution) recoverPanic(result *map[string]interface{}) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := fmt.Errorf("Error: internal error while running the starlark code. Error: %q", fmt.Sprint(r))
+	*result = e.finish(map[string]interface{}{"error": err.Error(), "errorCode": "internal"})
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+
+	"go.starlark.net/starlark"
+)
+
+// userError is the error of fail_with, a deliberate failure of the script with a structured payload for the host.
+type userError struct {
+	message string
+	payload starlark.Value
+}
+
+func (err *userError) Error() string {
+	return "fail: " + err.message
+}
+
+// failWith is the fail_with builtin. It fails like fail, with a message and a payload (any value that can be converted,
+// e.g. a dict with the field that was invalid) that the host receives in the details of the error.
+var failWith = starlark.NewBuiltin("fail_with", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	checkpoint(thread)
+	var message string
+	var payload starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "message", &message, "payload?", &payload); err != nil {
+		return nil, err
+	}
+	payload.Freeze()
+	return nil, &userError{message: message, payload: payload}
+})
+
+// isUserError reports whether the error was raised deliberately by the script, with fail or fail_with,
+// instead of a fault (a syntax error, a type error, an exceeded limit, etc.).
+// The innermost frame of a call to fail is the builtin itself, a function of the script called fail is in a file.
+func isUserError(err error) bool {
+	var userErr *userError
+	if errors.As(err, &userErr) {
+		return true
+	}
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return false
+	}
+	for {
+		var cause *starlark.EvalError
+		if !errors.As(evalErr.Unwrap(), &cause) {
+			break
+		}
+		evalErr = cause
+	}
+	stack := evalErr.CallStack
+	return len(stack) > 0 && stack[len(stack)-1].Name == "fail" && stack[len(stack)-1].Pos.Filename() == "<builtin>"
+}
+
+// addErrorKind marks the error result of a runtime error with errorKind "user" if the script failed deliberately and adds
+// the payload of fail_with to its details. The other errors are marked "internal" by finish.
+func (e *execution) addErrorKind(result map[string]interface{}, err error) {
+	if !isUserError(err) {
+		result["errorKind"] = "internal"
+		return
+	}
+	result["errorKind"] = "user"
+	var userErr *userError
+	if !errors.As(err, &userErr) || userErr.payload == starlark.None {
+		return
+	}
+	details, ok := result["details"].(map[string]interface{})
+	if !ok {
+		details = map[string]interface{}{}
+		result["details"] = details
+	}
+	payload, convErr := e.conv.convertToJSValue(userErr.payload)
+	if convErr != nil {
+		details["payload"] = userErr.payload.String()
+		return
+	}
+	details["payload"] = payload
+}
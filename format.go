@@ -0,0 +1,452 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/syntax"
+)
+
+const formatIndent = "    "
+
+// formatter pretty prints a syntax tree in a canonical (buildifier like) style:
+// 4 space indentation, double quoted strings, spaces around binary operators and
+// one element per line (with a trailing comma) for lists, dicts and calls that
+// spanned multiple lines in the original source.
+type formatter struct {
+	out           strings.Builder
+	indent        int
+	brackets      int
+	atLineStart   bool
+	pendingSuffix []syntax.Comment
+}
+
+func (f *formatter) write(s string) {
+	if f.atLineStart {
+		f.out.WriteString(strings.Repeat(formatIndent, f.indent))
+		f.atLineStart = false
+	}
+	f.out.WriteString(s)
+}
+
+// newline ends the current line, flushing any end-of-line comments.
+func (f *formatter) newline() {
+	for _, comment := range f.pendingSuffix {
+		if !f.atLineStart {
+			f.out.WriteString("  ")
+		}
+		f.write(comment.Text)
+	}
+	f.pendingSuffix = nil
+	f.out.WriteString("\n")
+	f.atLineStart = true
+}
+
+// before prints the whole-line comments attached to a node.
+// They can only be placed on their own line at the start of a line or inside brackets,
+// otherwise they are moved to the end of the current line.
+func (f *formatter) before(node syntax.Node) {
+	comments := node.Comments()
+	if comments == nil || len(comments.Before) == 0 {
+		return
+	}
+	if !f.atLineStart && f.brackets == 0 {
+		f.pendingSuffix = append(f.pendingSuffix, comments.Before...)
+		return
+	}
+	if !f.atLineStart {
+		f.newline()
+	}
+	for _, comment := range comments.Before {
+		f.write(comment.Text)
+		f.newline()
+	}
+}
+
+// suffix queues the end-of-line comments attached to a node.
+func (f *formatter) suffix(node syntax.Node) {
+	f.pendingSuffix = append(f.pendingSuffix, trailingComments(node)...)
+}
+
+func (f *formatter) file(file *syntax.File) {
+	f.stmts(file.Stmts, nil)
+	if comments := file.Comments(); comments != nil && len(comments.After) > 0 {
+		if len(file.Stmts) > 0 && comments.After[0].Start.Line-syntax.End(file.Stmts[len(file.Stmts)-1]).Line > 1 {
+			f.newline()
+		}
+		for _, comment := range comments.After {
+			f.write(comment.Text)
+			f.newline()
+		}
+	}
+}
+
+// firstLine returns the line of the first comment before a node or the line the node starts at.
+func firstLine(node syntax.Node) int32 {
+	if comments := node.Comments(); comments != nil && len(comments.Before) > 0 {
+		return comments.Before[0].Start.Line
+	}
+	return syntax.Start(node).Line
+}
+
+// stmts prints a block of statements.
+// The trailing end-of-line comments are printed after the last statement of the block.
+func (f *formatter) stmts(stmts []syntax.Stmt, trailing []syntax.Comment) {
+	for i, stmt := range stmts {
+		// keep (at most one) blank line where the original source had some
+		if i > 0 && firstLine(stmt)-syntax.End(stmts[i-1]).Line > 1 {
+			f.newline()
+		}
+		if i == len(stmts)-1 {
+			f.stmt(stmt, trailing)
+		} else {
+			f.stmt(stmt, nil)
+		}
+	}
+}
+
+func (f *formatter) suite(stmts []syntax.Stmt, trailing []syntax.Comment) {
+	f.write(":")
+	f.newline()
+	f.indent++
+	f.stmts(stmts, trailing)
+	f.indent--
+}
+
+func trailingComments(node syntax.Node) []syntax.Comment {
+	if comments := node.Comments(); comments != nil {
+		return comments.Suffix
+	}
+	return nil
+}
+
+// stmt prints a single statement. The end-of-line comments of compound statements
+// are attached to their whole block, so they are moved to the last line of the block.
+func (f *formatter) stmt(stmt syntax.Stmt, trailing []syntax.Comment) {
+	f.before(stmt)
+	trailing = append(append([]syntax.Comment{}, trailingComments(stmt)...), trailing...)
+	switch stmt.(type) {
+	case *syntax.DefStmt, *syntax.ForStmt, *syntax.WhileStmt, *syntax.IfStmt:
+	default:
+		f.pendingSuffix = append(f.pendingSuffix, trailing...)
+	}
+	switch s := stmt.(type) {
+	case *syntax.AssignStmt:
+		f.expr(s.LHS)
+		f.write(" " + s.Op.String() + " ")
+		f.expr(s.RHS)
+		f.newline()
+	case *syntax.BranchStmt:
+		f.write(s.Token.String())
+		f.newline()
+	case *syntax.ExprStmt:
+		f.expr(s.X)
+		f.newline()
+	case *syntax.ReturnStmt:
+		f.write("return")
+		if s.Result != nil {
+			f.write(" ")
+			f.expr(s.Result)
+		}
+		f.newline()
+	case *syntax.LoadStmt:
+		f.write("load(")
+		f.brackets++
+		f.literal(s.Module)
+		for i := range s.To {
+			f.write(", ")
+			if s.To[i].Name == s.From[i].Name {
+				f.write(syntax.Quote(s.From[i].Name, false))
+			} else {
+				f.write(s.To[i].Name + " = " + syntax.Quote(s.From[i].Name, false))
+			}
+		}
+		f.brackets--
+		f.write(")")
+		f.newline()
+	case *syntax.DefStmt:
+		f.write("def " + s.Name.Name)
+		f.sequence("(", s.Params, ")", s.Def, syntax.Position{}, false)
+		f.suite(s.Body, trailing)
+	case *syntax.ForStmt:
+		f.write("for ")
+		f.expr(s.Vars)
+		f.write(" in ")
+		f.expr(s.X)
+		f.suite(s.Body, trailing)
+	case *syntax.WhileStmt:
+		f.write("while ")
+		f.expr(s.Cond)
+		f.suite(s.Body, trailing)
+	case *syntax.IfStmt:
+		f.write("if ")
+		f.ifStmt(s, trailing)
+	default:
+		panic(fmt.Errorf("unexpected statement type %T", stmt))
+	}
+}
+
+func (f *formatter) ifStmt(s *syntax.IfStmt, trailing []syntax.Comment) {
+	f.expr(s.Cond)
+	if len(s.False) == 0 {
+		f.suite(s.True, trailing)
+		return
+	}
+	f.suite(s.True, nil)
+	// an elif is parsed as an else block containing just an if statement starting at the elif keyword
+	if elif, ok := s.False[0].(*syntax.IfStmt); ok && len(s.False) == 1 && elif.If == s.ElsePos {
+		f.before(elif)
+		f.write("elif ")
+		f.ifStmt(elif, append(append([]syntax.Comment{}, trailingComments(elif)...), trailing...))
+		return
+	}
+	f.write("else")
+	f.suite(s.False, trailing)
+}
+
+// sequence prints a bracketed, comma separated list of expressions.
+// It uses one element per line if the original source had a line break
+// after the opening bracket or before the closing one.
+// closePos may be invalid if the position of the closing bracket is unknown.
+func (f *formatter) sequence(open string, exprs []syntax.Expr, close string, openPos, closePos syntax.Position, singleTuple bool) {
+	f.write(open)
+	f.brackets++
+	multiLine := false
+	if len(exprs) > 0 {
+		if firstLine(exprs[0]) != openPos.Line {
+			multiLine = true
+		} else if closePos.IsValid() && closePos.Line != syntax.End(exprs[len(exprs)-1]).Line {
+			multiLine = true
+		}
+	}
+	if multiLine {
+		f.newline()
+		f.indent++
+		for _, expr := range exprs {
+			f.expr(expr)
+			f.write(",")
+			f.newline()
+		}
+		f.indent--
+	} else {
+		for i, expr := range exprs {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expr(expr)
+		}
+		if singleTuple && len(exprs) == 1 {
+			f.write(",")
+		}
+	}
+	f.brackets--
+	f.write(close)
+}
+
+func (f *formatter) literal(lit *syntax.Literal) {
+	switch lit.Token {
+	case syntax.STRING, syntax.BYTES:
+		// canonicalize simple single quoted strings to double quotes
+		raw := lit.Raw
+		if strings.HasPrefix(raw, "'") && !strings.HasPrefix(raw, "'''") {
+			f.write(syntax.Quote(lit.Value.(string), false))
+			return
+		}
+		if strings.HasPrefix(raw, "b'") && !strings.HasPrefix(raw, "b'''") {
+			f.write(syntax.Quote(lit.Value.(string), true))
+			return
+		}
+		f.write(raw)
+	default:
+		f.write(lit.Raw)
+	}
+}
+
+func (f *formatter) expr(expr syntax.Expr) {
+	f.before(expr)
+	defer f.suffix(expr)
+	switch e := expr.(type) {
+	case *syntax.Ident:
+		f.write(e.Name)
+	case *syntax.Literal:
+		f.literal(e)
+	case *syntax.ParenExpr:
+		f.write("(")
+		f.brackets++
+		f.expr(e.X)
+		f.brackets--
+		f.write(")")
+	case *syntax.BinaryExpr:
+		f.expr(e.X)
+		f.write(" " + e.Op.String() + " ")
+		f.expr(e.Y)
+	case *syntax.UnaryExpr:
+		switch e.Op {
+		case syntax.NOT:
+			f.write("not ")
+		default:
+			f.write(e.Op.String())
+		}
+		if e.X != nil {
+			f.expr(e.X)
+		}
+	case *syntax.CallExpr:
+		f.expr(e.Fn)
+		f.sequence("(", e.Args, ")", e.Lparen, e.Rparen, false)
+	case *syntax.DotExpr:
+		f.expr(e.X)
+		f.write("." + e.Name.Name)
+	case *syntax.IndexExpr:
+		f.expr(e.X)
+		f.write("[")
+		f.brackets++
+		f.expr(e.Y)
+		f.brackets--
+		f.write("]")
+	case *syntax.SliceExpr:
+		f.expr(e.X)
+		f.write("[")
+		f.brackets++
+		if e.Lo != nil {
+			f.expr(e.Lo)
+		}
+		f.write(":")
+		if e.Hi != nil {
+			f.expr(e.Hi)
+		}
+		if e.Step != nil {
+			f.write(":")
+			f.expr(e.Step)
+		}
+		f.brackets--
+		f.write("]")
+	case *syntax.ListExpr:
+		f.sequence("[", e.List, "]", e.Lbrack, e.Rbrack, false)
+	case *syntax.DictExpr:
+		f.sequence("{", e.List, "}", e.Lbrace, e.Rbrace, false)
+	case *syntax.DictEntry:
+		f.expr(e.Key)
+		f.write(": ")
+		f.expr(e.Value)
+	case *syntax.TupleExpr:
+		if !e.Lparen.IsValid() {
+			for i, x := range e.List {
+				if i > 0 {
+					f.write(", ")
+				}
+				f.expr(x)
+			}
+			if len(e.List) == 1 {
+				f.write(",")
+			}
+			return
+		}
+		f.sequence("(", e.List, ")", e.Lparen, e.Rparen, true)
+	case *syntax.CondExpr:
+		f.expr(e.True)
+		f.write(" if ")
+		f.expr(e.Cond)
+		f.write(" else ")
+		f.expr(e.False)
+	case *syntax.LambdaExpr:
+		f.write("lambda")
+		for i, param := range e.Params {
+			if i > 0 {
+				f.write(",")
+			}
+			f.write(" ")
+			f.expr(param)
+		}
+		f.write(": ")
+		f.expr(e.Body)
+	case *syntax.Comprehension:
+		open, close := "[", "]"
+		if e.Curly {
+			open, close = "{", "}"
+		}
+		f.write(open)
+		f.brackets++
+		f.expr(e.Body)
+		for _, clause := range e.Clauses {
+			f.before(clause)
+			switch c := clause.(type) {
+			case *syntax.ForClause:
+				f.write(" for ")
+				f.expr(c.Vars)
+				f.write(" in ")
+				f.expr(c.X)
+			case *syntax.IfClause:
+				f.write(" if ")
+				f.expr(c.Cond)
+			}
+			f.suffix(clause)
+		}
+		f.brackets--
+		f.write(close)
+	default:
+		panic(fmt.Errorf("unexpected expression type %T", expr))
+	}
+}
+
+// formatStarlarkCode returns the canonical formatting of the given source code.
+func formatStarlarkCode(filename, starlark_code string) (formatted string, err error) {
+	file, err := syntax.Parse(filename, starlark_code, syntax.RetainComments)
+	if err != nil {
+		return "", err
+	}
+	f := formatter{atLineStart: true}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to format the syntax tree: %v", r)
+		}
+	}()
+	f.file(file)
+	formatted = f.out.String()
+	// sanity check, the formatter should never produce invalid code
+	if _, err := syntax.Parse(filename, formatted, 0); err != nil {
+		return "", fmt.Errorf("the formatted code is invalid: %w", err)
+	}
+	return formatted, nil
+}
+
+// convertSyntaxErrorToJSON returns the position and message of a parse error.
+// It returns nil if the error is not a syntax error.
+func convertSyntaxErrorToJSON(err error) interface{} {
+	syntaxErr, ok := err.(syntax.Error)
+	if !ok {
+		return nil
+	}
+	return map[string]interface{}{"line": int(syntaxErr.Pos.Line), "col": int(syntaxErr.Pos.Col), "message": syntaxErr.Msg}
+}
+
+func getStarlarkFormatter() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		starlark_code := args[0].String()
+		formatted, err := formatStarlarkCode("", starlark_code)
+		if err != nil {
+			parseError := convertSyntaxErrorToJSON(err)
+			err := fmt.Errorf("Error: failed to format the starlark code. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "parseError": parseError}
+		}
+		return map[string]interface{}{"formatted": formatted}
+	})
+}
@@ -1,7 +1,7 @@
 module foo.com/b
 
-go 1.17
+go 1.25.0
 
-require go.starlark.net v0.0.0-20220328144851-d1966c6b9fcd
+require go.starlark.net v0.0.0-20260908191801-89a6a09411d5
 
-require golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f // indirect
+require golang.org/x/sys v0.42.0 // indirect
@@ -0,0 +1,85 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go.starlark.net/starlark"
+)
+
+const checkpointerLocalKey = "checkpointer"
+
+// checkpointer runs hooks while the starlark code is running.
+// Goroutines are never preempted in wasm, so Go code only gets control back during an execution when the
+// interpreter reaches the step limit of the thread (see Thread.OnMaxSteps), which the checkpointer moves
+// forward by checkpointInterval steps every time, and when the script calls a builtin (len, str, range, print, etc.).
+// Hooks are run at those points, at most once every checkpointInterval steps, so loops that never call
+// a builtin are stopped by the hooks too.
+type checkpointer struct {
+	hooks     []func(thread *starlark.Thread)
+	lastSteps uint64
+}
+
+// checkpointInterval is the minimum number of steps between two runs of the hooks.
+const checkpointInterval = 1000
+
+// checkedUniverse contains the universal builtins wrapped so that they run the checkpoint hooks.
+var checkedUniverse = starlark.StringDict{}
+
+func init() {
+	for name, value := range starlark.Universe {
+		builtin, ok := value.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+		checkedUniverse[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			return builtin.CallInternal(thread, args, kwargs)
+		})
+	}
+}
+
+func newCheckpointer(thread *starlark.Thread) *checkpointer {
+	c := &checkpointer{}
+	thread.SetLocal(checkpointerLocalKey, c)
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		checkpoint(thread)
+		thread.SetMaxExecutionSteps(thread.ExecutionSteps() + checkpointInterval)
+	}
+	thread.SetMaxExecutionSteps(checkpointInterval)
+	return c
+}
+
+// add registers a hook. Hooks may cancel the thread to stop the execution.
+func (c *checkpointer) add(hook func(thread *starlark.Thread)) {
+	c.hooks = append(c.hooks, hook)
+}
+
+func (c *checkpointer) run(thread *starlark.Thread) {
+	for _, hook := range c.hooks {
+		hook(thread)
+	}
+}
+
+// checkpoint runs the hooks of the thread if enough steps have been executed since the last time.
+func checkpoint(thread *starlark.Thread) {
+	c, ok := thread.Local(checkpointerLocalKey).(*checkpointer)
+	if !ok || len(c.hooks) == 0 {
+		return
+	}
+	if steps := thread.ExecutionSteps(); steps-c.lastSteps >= checkpointInterval {
+		c.lastSteps = steps
+		c.run(thread)
+	}
+}
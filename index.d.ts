@@ -0,0 +1,840 @@
+// Code generated by tools/gendts. DO NOT EDIT.
+
+export interface Position {
+    line: number;
+    col: number;
+}
+
+/** The cost of an execution. */
+export interface Stats {
+    /** Starlark computation steps executed. */
+    steps: number;
+    /** Wall-clock duration. */
+    durationMs: number;
+    /** Number of print calls. */
+    printCalls: number;
+    /** The deepest nesting of the values converted between Javascript and Starlark. */
+    conversionDepth: number;
+    /** The number of times the execution yielded to the event loop (see yieldEverySteps). */
+    yields: number;
+}
+
+export type ErrorCode = "invalid_argument" | "resource_exhausted" | "not_found" | "failed_precondition" | "internal" | "cancelled" | "deadline_exceeded" | "out_of_range";
+
+/** Returned when something fails. */
+export interface ErrorResult {
+    error: string;
+    /** Set for errors caused by the host environment (invalid options, exceeded limits, etc.) */
+    errorCode?: ErrorCode;
+    /** user if the script failed deliberately with fail() or fail_with(), internal otherwise. Set on the results of executions. */
+    errorKind?: "user" | "internal";
+    details?: Record<string, unknown>;
+}
+
+/** A frame of the backtrace of a runtime error, in details.backtrace. */
+export interface BacktraceFrame {
+    /** The name of the function. */
+    name: string;
+    filename: string;
+    line: number;
+    col: number;
+    /** The text of the source line, missing for builtins. */
+    source?: string;
+    /** A line with a caret under the column of the source line. */
+    caret?: string;
+}
+
+export interface MessageResult {
+    message: string;
+}
+
+export interface LogRecord {
+    level: "debug" | "info" | "warn" | "error";
+    message: string;
+    fields: Record<string, unknown>;
+    thread: string;
+    timestampMs: number;
+    position?: { file: string; line: number; col: number };
+}
+
+/** A call the script made to the host. */
+export interface AuditRecord {
+    /** The name of the builtin, load for the modules fetched over https. */
+    name: string;
+    /** The reprs of the arguments, each one truncated to 64 bytes. */
+    args: string;
+    /** denied if the capability of the builtin has not been granted, quota if the call exceeded one of the hostCallQuotas, dry_run if it was recorded as an effect. */
+    status: "ok" | "error" | "denied" | "quota" | "dry_run";
+    error?: string;
+    durationMs: number;
+    timestampMs: number;
+    position?: { file: string; line: number; col: number };
+}
+
+/** A call to the host intercepted by a dry run. */
+export interface DryRunEffect {
+    name: string;
+    args: unknown[];
+    kwargs: Record<string, unknown>;
+    position?: { file: string; line: number; col: number };
+}
+
+export interface RunOptions {
+    /** The name of the function to call (default: main), or a dotted path like handlers.on_save to an attribute of a global. */
+    funcName?: string;
+    /** The name of the file of the code in error positions and backtraces. */
+    filename?: string;
+    /** Execute the code without calling a function, the result has the globals. */
+    script?: boolean;
+    /** Functions called in order with the return value of the previous one, used instead of funcName. */
+    pipeline?: string[];
+    /** The arguments passed to the function. */
+    args?: unknown[];
+    /** The arguments encoded as a JSON array, used instead of args. */
+    argsJson?: string;
+    /** The arguments as values tagged with their starlark type, used instead of args. */
+    argsTagged?: TaggedValue[];
+    /** Return the return value tagged with its starlark type in returnValueTagged. */
+    returnTagged?: boolean;
+    /** Return the return value encoded as JSON in returnValueJson. */
+    returnJson?: boolean;
+    /** The arguments encoded as a MessagePack array, used instead of args. */
+    argsMsgpack?: Uint8Array;
+    /** Return the return value encoded as MessagePack in returnValueMsgpack. */
+    returnMsgpack?: boolean;
+    /** The expected shape of the return value, a small subset of JSON Schema. */
+    returnSchema?: ValueSchema;
+    /** Return bytes and lists of ints as typed arrays. */
+    returnBinary?: boolean;
+    /** Freeze the arguments before the call. */
+    freezeArgs?: boolean;
+    /** Deep freeze the converted return value with Object.freeze. */
+    freezeReturnValue?: boolean;
+    /** Return starlark functions as callable javascript functions instead of null. */
+    returnFunctions?: boolean;
+    /** Return a dict as a read only Proxy that converts its values when they are read, with materialize() and release() methods. */
+    returnLazy?: boolean;
+    /** Add the repr of the return value to the result. */
+    returnRepr?: boolean;
+    /** Add the pretty printed return value to the result. */
+    returnPretty?: boolean | PrettyOptions;
+    /** Receives large return values encoded as JSON. */
+    onChunk?: (chunk: Uint8Array, index: number) => void;
+    chunkSizeBytes?: number;
+    streamThresholdBytes?: number;
+    /** The virtual filesystem used by load and the file builtins. */
+    fs?: FileSystem | Record<string, string>;
+    /** Add the read_file, write_file and glob builtins. */
+    fileBuiltins?: boolean;
+    /** A zip, tar or gzipped tar archive read by the archive module. */
+    archive?: Uint8Array;
+    /** The default locale of the intl module, e.g. de-DE. */
+    locale?: string;
+    /** The environment scripts read with env.get(key, default). */
+    env?: Record<string, unknown>;
+    /** The capabilities granted to the script (time, fs, fetch and the ones of the registered builtins), none by default. */
+    capabilities?: string[];
+    /** Add the time module. */
+    timeModule?: boolean;
+    /** The clock read by time.now, in milliseconds since the Unix epoch. */
+    clock?: number | { startMs: number; stepMs?: number } | (() => number);
+    /** Cancels the execution, scripts can check it with check_cancelled(). */
+    signal?: AbortSignal;
+    /** The steps a script may run after the cancellation before it is stopped forcibly. */
+    cancelGraceSteps?: number;
+    /** The maximum number of bytes of output kept in the message. */
+    maxOutputBytes?: number;
+    /** Keep the first and the last half of maxOutputBytes instead of the first bytes. */
+    keepOutputTail?: boolean;
+    /** Where the output of print goes (default: buffer, the message of the result). */
+    printTo?: "buffer" | "console" | "both";
+    /** Receives the records of the log module, they are added to the result (logs) otherwise. */
+    onLog?: (record: LogRecord) => void;
+    /** Record the calls to the host in the audit field of the result. */
+    audit?: boolean;
+    /** Receives the records of the calls to the host instead of the audit field. */
+    onAudit?: (record: AuditRecord) => void;
+    /** The maximum numbers of calls to the host by builtin, module, capability or * for all of them. */
+    hostCallQuotas?: Record<string, number>;
+    /** Record the calls to the host that may have effects in effects instead of making them. */
+    dryRun?: boolean;
+    /** The builtins, modules or capabilities that are called as usual in a dry run. */
+    dryRunAllow?: string[];
+    /** The lowest level that is recorded (default: debug). */
+    logLevel?: "debug" | "info" | "warn" | "error";
+    /** Called by report_progress(fraction, message). */
+    onProgress?: (progress: { fraction: number; message: string; steps: number }) => void;
+    /** Answers the prompts of input(prompt), only in run_starlark_code_async. */
+    onInput?: (prompt: string) => string | null | Promise<string | null>;
+    /** Called before every statement executed. */
+    onTrace?: (event: TraceEvent) => void;
+    /** The maximum number of calls to onTrace (default: 10000). */
+    maxTraceEvents?: number;
+    /** The maximum duration of the execution. */
+    timeoutMs?: number;
+    /** Run the call twice and report whether the two runs behaved the same in determinism. */
+    verifyDeterminism?: boolean;
+    /** Capture the inputs and the calls to the host of the execution into a recording that replay_starlark_recording runs again. */
+    record?: boolean;
+    /** Yield to the event loop every yieldEverySteps steps in run_starlark_code_async. */
+    yieldEverySteps?: number;
+    /** The maximum amount the heap may grow during the execution. */
+    maxMemoryBytes?: number;
+    /** Convert the numbers of the arguments to floats even if they are whole numbers. */
+    numbersAsFloats?: boolean;
+    /** How returned ints that don't fit in 64 bits are converted. */
+    intOverflow?: "error" | "bigint" | "string" | "float";
+    /** How the strings with lone surrogates or invalid UTF-8 are converted (default: replace). */
+    invalidUnicode?: "replace" | "error" | "passthrough";
+    /** The maximum nesting depth of the arguments and the return value. */
+    maxConversionDepth?: number;
+    /** The maximum size of the source code in bytes. */
+    maxSourceBytes?: number;
+    /** The maximum number of nodes in the syntax tree of the source code. */
+    maxSyntaxNodes?: number;
+    /** Allows recursion and limits the number of frames of the call stack. */
+    maxCallDepth?: number;
+    /** Allows while loops in the code. */
+    allowWhile?: boolean;
+    /** The maximum number of values in the arguments of a call. */
+    maxArgumentElements?: number;
+    /** The maximum length in UTF-8 bytes of the strings in the arguments. */
+    maxArgumentStringBytes?: number;
+}
+
+/** A virtual filesystem implemented in Javascript, all the methods are synchronous. */
+export interface FileSystem {
+    readFile: (name: string) => string | undefined;
+    writeFile?: (name: string, content: string) => void;
+    glob?: (pattern: string) => string[];
+}
+
+export interface RunSuccess {
+    /** The output of the print calls. */
+    message: string;
+    /** Set if some output was dropped because of maxOutputBytes. */
+    truncated?: boolean;
+    /** Set if the script noticed the cancellation and stopped by itself. */
+    cancelled?: boolean;
+    returnValue?: unknown;
+    /** The globals of the code run with the script option. */
+    globals?: Record<string, unknown>;
+    returnValueJson?: string;
+    returnValueMsgpack?: Uint8Array;
+    returnValueTagged?: TaggedValue;
+    repr?: string;
+    /** The pretty printed return value, with returnPretty. */
+    pretty?: string;
+    streamed?: { chunks: number; bytes: number };
+}
+
+export interface DeterminismMismatch {
+    field: "message" | "returnValue" | "error" | "files" | "logs" | "hostCalls";
+    /** The value of the first run, the first record that differs for logs and hostCalls. */
+    first: unknown;
+    second: unknown;
+    /** The index of the first record that differs. */
+    index?: number;
+}
+
+export interface DeterminismReport {
+    deterministic: boolean;
+    mismatches: DeterminismMismatch[];
+}
+
+export interface RecordedCall {
+    /** The name of the builtin, module members are prefixed with the name of the module. */
+    name: string;
+    /** The reprs of the arguments separated by commas. */
+    args: string;
+    /** The result, time values and durations are tagged time and duration. */
+    result?: TaggedValue;
+    error?: string;
+    /** Why the result couldn't be recorded, the call fails in replays. */
+    unserializable?: string;
+}
+
+/** A recorded execution, it can be serialized with JSON.stringify. */
+export interface Recording {
+    format: "starlark-recording";
+    version: number;
+    code: string;
+    /** The recorded options, without the callbacks and the host objects. */
+    options: RunOptions;
+    argsTagged: TaggedValue[];
+    /** The modules loaded by the execution. */
+    modules: Record<string, { source?: string; filesystem?: boolean; error?: string }>;
+    /** The calls to the host in order. */
+    calls: RecordedCall[];
+    /** The output, the repr of the return value and the error of the execution. */
+    outcome: { message?: string; returnValue?: string; error?: string };
+}
+
+export interface ReplayMismatch {
+    field: "hostCalls" | "message" | "returnValue" | "error";
+    /** The recorded value, the first call that differs for hostCalls (null if the replay made more calls). */
+    recorded: unknown;
+    /** The value of the replay, null for hostCalls if the replay made fewer calls. */
+    replayed: unknown;
+    /** The index of the first call that differs. */
+    index?: number;
+}
+
+export interface ReplayReport {
+    /** Set if the replay made the same calls and had the same outcome as the recorded execution. */
+    deterministic: boolean;
+    /** The number of recorded calls. */
+    calls: number;
+    /** The number of recorded calls answered before the end or the divergence of the replay. */
+    replayedCalls: number;
+    mismatches: ReplayMismatch[];
+}
+
+/** A statement about to be executed in the trace mode. */
+export interface TraceEvent {
+    index: number;
+    file: string;
+    line: number;
+    col: number;
+    /** The kind of the statement, like AssignStmt or ForStmt. */
+    statement: string;
+    /** The name of the function running the statement, <toplevel> for the statements of the module. */
+    function: string;
+    /** The number of calls under the function on the stack, 0 at the top level and in the function called by the host. */
+    depth: number;
+    steps: number;
+    /** The truncated reprs of the assigned local variables, or of the globals at the top level. */
+    locals: Record<string, string>;
+}
+
+export interface TraceSummary {
+    events: number;
+    /** Set if statements were executed after maxTraceEvents was reached. */
+    truncated: boolean;
+}
+
+/** The return value of a function that returned result.ok(value) or result.err(code, message, details). */
+export type ScriptResult = { ok: true; value: unknown } | { ok: false; code: string; message: string; details: unknown };
+
+/** A part of an argument, or of the value of a builtin registered by Javascript, that was converted lossily. */
+export interface ConversionWarning {
+    /** The path of the part, like args[0].items[2].name. */
+    path: string;
+    message: string;
+}
+
+export type RunResult = (RunSuccess | ErrorResult) & { stats: Stats; conversionWarnings?: ConversionWarning[]; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[]; determinism?: DeterminismReport; recording?: Recording; replay?: ReplayReport; trace?: TraceSummary };
+
+export interface BatchCall {
+    /** The name of the function to call (default: main), or a dotted path like handlers.on_save to an attribute of a global. */
+    funcName?: string;
+    args?: unknown[];
+    argsJson?: string;
+    argsMsgpack?: Uint8Array;
+}
+
+export interface ValueSchema {
+    type?: SchemaType | SchemaType[];
+    enum?: unknown[];
+    items?: ValueSchema;
+    properties?: Record<string, ValueSchema>;
+    required?: string[];
+    additionalProperties?: boolean;
+}
+
+export type SchemaType = "string" | "integer" | "number" | "boolean" | "null" | "array" | "object";
+
+export interface FunctionParameter {
+    name: string;
+    kind: "positional" | "varargs" | "keyword_only" | "kwargs";
+    /** The source text of the default value. */
+    default?: string;
+    /** The parameter was bound by partial. */
+    bound?: boolean;
+}
+
+export interface FunctionSignature {
+    name: string;
+    signature: string;
+    /** Missing for builtins. */
+    params?: FunctionParameter[];
+}
+
+/** A starlark function returned with returnFunctions. */
+export type StarlarkFunction = ((...args: unknown[]) => RunResult) & { release(): void; partial(args?: unknown[], kwargs?: Record<string, unknown>): { function: StarlarkFunction } | ErrorResult; signature(): FunctionSignature };
+
+export interface BenchmarkSummary {
+    min: number;
+    median: number;
+    p95: number;
+    mean: number;
+    max: number;
+}
+
+export interface BenchmarkSuccess {
+    /** The output of the execution of the code, the output of the calls is discarded. */
+    message: string;
+    funcName: string;
+    iterations: number;
+    warmup: number;
+    durationMs: BenchmarkSummary;
+    steps: BenchmarkSummary;
+}
+
+export type BenchmarkResult = (BenchmarkSuccess | ErrorResult) & { stats: Stats };
+
+export type BatchCallResult = (RunSuccess | ErrorResult) & { funcName: string; steps: number };
+
+export interface BatchSuccess {
+    /** The output of the print calls made while executing the module. */
+    message: string;
+    results: BatchCallResult[];
+}
+
+export type BatchResult = (BatchSuccess | ErrorResult) & { stats: Stats };
+
+export interface QueueInfo {
+    position: number;
+    waitMs: number;
+}
+
+export type AsyncRunOptions = RunOptions & { rejectOnError?: boolean };
+
+export type AsyncRunResult = RunResult & { queue?: QueueInfo };
+
+/** The reason of the rejected promises when rejectOnError is set. */
+export type StarlarkError = Error & Omit<ErrorResult, "error"> & { name: "StarlarkError"; stats: Stats; queue?: QueueInfo };
+
+/** A value tagged with its starlark type, see the README for the encoding of each type. */
+export interface TaggedValue {
+    t: "NoneType" | "bool" | "int" | "float" | "string" | "bytes" | "list" | "tuple" | "set" | "dict";
+    v?: unknown;
+    /** Set for the strings that are not valid UTF-8, whose v is their bytes in base64. */
+    e?: "base64";
+}
+
+export interface SchedulerOptions {
+    maxConcurrency?: number;
+    maxQueueLength?: number;
+}
+
+export interface SchedulerStatus {
+    running: number;
+    queued: number;
+    maxConcurrency: number;
+    maxQueueLength: number;
+}
+
+/** Describes the interpreter, its builtins and the configured limits. */
+export interface RuntimeInfo {
+    /** The version of go.starlark.net. */
+    starlarkVersion: string;
+    goVersion: string;
+    /** The compiler the wasm binary was built with. */
+    compiler: "gc" | "tinygo";
+    /** The optional language features that are enabled. */
+    dialect: Record<"set" | "globalReassign" | "recursion" | "nestedDef" | "lambda" | "float" | "bitwise", boolean>;
+    /** The exported functions. */
+    functions: string[];
+    /** The universal builtins. */
+    builtins: string[];
+    /** The other predeclared names, the optional ones with the option that enables them and the ones registered by the host. */
+    modules: { always: string[]; options: Record<string, string>; session: string[]; host: string[] };
+    /** The builtins each capability grants. */
+    capabilities: Record<string, string[]>;
+    registeredModules: string[];
+    preludeGlobals: string[];
+    limits: { maxConcurrency: number; maxQueueLength: number; maxChannelMessages: number; defaultMaxConversionDepth: number; defaultCancelGraceSteps: number; allowedHosts: string[] };
+}
+
+/** The memory of the Go runtime and the state kept between the executions. */
+export interface RuntimeStats {
+    memory: { heapAllocBytes: number; heapSysBytes: number; heapObjects: number; totalAllocBytes: number; sysBytes: number; numGC: number; gcPauseTotalMs: number };
+    goroutines: number;
+    /** total counts all the executions since the instance started. */
+    executions: { total: number; running: number; queued: number };
+    /** The sessions that have not been destroyed. */
+    sessions: number;
+    /** The documents that have not been closed. */
+    documents: number;
+    /** cached counts the modules whose globals are cached. */
+    modules: { registered: number; cached: number };
+    /** The function proxies that have not been released. */
+    proxies: number;
+    /** The lazy dicts that have not been released. */
+    lazyDicts: number;
+    channels: { channels: number; messages: number };
+}
+
+export interface WorkersOptions {
+    /** The number of workers, 0 terminates the current ones. */
+    count: number;
+    /** Creates a worker that loads the package and calls serve_starlark_worker (e.g. src/worker.js). */
+    spawn?: (index: number) => Worker;
+}
+
+export interface WorkerStatus {
+    index: number;
+    /** Set once the worker called serve_starlark_worker. */
+    ready: boolean;
+    /** Set if the worker emitted an error event, it doesn't get new runs. */
+    failed: boolean;
+    pending: number;
+    completed: number;
+}
+
+export interface WorkerPoolStatus {
+    workers: WorkerStatus[];
+}
+
+export interface SessionResult {
+    sessionId: number;
+}
+
+/** SessionQuotas limit the usage of a session over its lifetime, a missing quota is unlimited. */
+export interface SessionQuotas {
+    maxExecutions?: number;
+    maxSteps?: number;
+    maxDurationMs?: number;
+    maxHostCalls?: number;
+}
+
+export interface SessionUsage {
+    sessionId: number;
+    executions: number;
+    steps: number;
+    durationMs: number;
+    hostCalls: number;
+    rejected: number;
+    quotas: Required<SessionQuotas>;
+}
+
+export interface SessionRunSuccess {
+    message: string;
+    globals: string[];
+    returnValue?: unknown;
+    returnValueJson?: string;
+    returnValueMsgpack?: Uint8Array;
+    returnValueTagged?: TaggedValue;
+    repr?: string;
+    /** The pretty printed return value, with returnPretty. */
+    pretty?: string;
+    streamed?: { chunks: number; bytes: number };
+}
+
+export type SessionRunResult = (SessionRunSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] };
+
+export interface DispatchSuccess {
+    message: string;
+    handlers: number;
+    returnValue?: unknown[];
+    returnValueJson?: string;
+    returnValueMsgpack?: Uint8Array;
+    returnValueTagged?: TaggedValue;
+    repr?: string;
+    /** The pretty printed return value, with returnPretty. */
+    pretty?: string;
+    streamed?: { chunks: number; bytes: number };
+}
+
+export interface HandlerSuccess {
+    message: string;
+    /** The name of the handler. */
+    handler: string;
+    returnValue?: unknown;
+    returnValueJson?: string;
+    returnValueMsgpack?: Uint8Array;
+    returnValueTagged?: TaggedValue;
+    repr?: string;
+    /** The pretty printed return value, with returnPretty. */
+    pretty?: string;
+    streamed?: { chunks: number; bytes: number };
+}
+
+export type HandlerResult = (HandlerSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] };
+
+export type DispatchResult = (DispatchSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] };
+
+export interface ChannelSendResult {
+    pending: number;
+}
+
+export type ChannelRecvResult = { received: false } | { received: true; value: unknown };
+
+export interface PublishResult {
+    message: string;
+    globals: string[];
+    conversionDepth: number;
+}
+
+export interface BuiltinResult {
+    message: string;
+    /** The names of the builtins registered by register_starlark_builtin. */
+    builtins: string[];
+}
+
+/** A converter of the values the default bridge doesn't know about, see register_starlark_converter. */
+export interface Converter {
+    /** The starlark type converted by encode, e.g. tuple or set. */
+    type?: string;
+    encode?: (value: unknown, info: { type: string; repr: string }) => unknown;
+    /** Selects the javascript objects and functions converted by decode. */
+    match?: (value: unknown) => boolean;
+    /** Returns a value converted by the default bridge. */
+    decode?: (value: any) => unknown;
+}
+
+export interface ConverterResult {
+    message: string;
+    /** The names of the registered converters. */
+    converters: string[];
+}
+
+export type PreludeResult = ({ message: string; globals: string[] } | ErrorResult) & { stats: Stats };
+
+export interface SnapshotResult {
+    snapshot: Uint8Array;
+    skipped: string[];
+}
+
+/** A node of the syntax tree, the other fields depend on the kind. */
+export interface SyntaxNode {
+    kind: string;
+    start: Position;
+    end: Position;
+    [key: string]: unknown;
+}
+
+export interface ParseError {
+    line: number;
+    col: number;
+    message: string;
+}
+
+export type FormatResult = { formatted: string } | (ErrorResult & { parseError?: ParseError });
+
+export interface LintOptions {
+    checks?: string[];
+    predeclared?: string[];
+}
+
+export interface LintFinding {
+    check: string;
+    message: string;
+    start: Position;
+    end: Position;
+}
+
+export interface DependencyNode {
+    name: string;
+    kind: "file" | "registered" | "remote" | "missing";
+    /** Set if the module doesn't parse, its loads are unknown. */
+    error?: string;
+    parseError?: ParseError;
+}
+
+export interface DependencyEdge {
+    from: string;
+    to: string;
+    line: number;
+    col: number;
+    /** The names loaded from the module. */
+    symbols: string[];
+}
+
+export interface DependencyGraph {
+    nodes: DependencyNode[];
+    edges: DependencyEdge[];
+    /** Each cycle starts and ends with the same module. */
+    cycles: string[][];
+}
+
+export interface DocumentOptions {
+    /** The file name of the document, in the positions of the errors. */
+    filename?: string;
+    /** Extra predeclared names, like the option of lint_starlark_code. */
+    predeclared?: string[];
+}
+
+/** Replaces the text between two offsets in UTF-16 code units, like the indices of Javascript strings. */
+export interface DocumentEdit {
+    from: number;
+    to: number;
+    text?: string;
+}
+
+export interface DocumentDiagnostic {
+    kind: 'syntax' | 'resolve';
+    message: string;
+    start: Position;
+    end: Position;
+}
+
+export interface DocumentResult {
+    documentId: number;
+    /** The number of edit_starlark_document calls applied. */
+    version: number;
+    diagnostics: DocumentDiagnostic[];
+    /** The work done by the update. */
+    incremental: { chunks: number; parsedChunks: number; parsedBytes: number; resolvedChunks: number };
+}
+
+/** A difference between two values, the path is like $.servers[0].port. */
+export interface ValueChange {
+    op: 'add' | 'remove' | 'change';
+    path: string;
+    /** The old value, missing for the additions. */
+    before?: unknown;
+    /** The new value, missing for the removals. */
+    after?: unknown;
+}
+
+export interface PrettyOptions {
+    /** The nesting depth past which the containers are elided (default: 8). */
+    maxDepth?: number;
+    /** The length of the lines, longer containers have one element per line (default: 80). */
+    width?: number;
+    /** The maximum number of elements rendered by container (default: 100). */
+    maxItems?: number;
+    /** The maximum length of the strings in bytes (default: 200). */
+    maxStringLength?: number;
+    /** The number of spaces by nesting level (default: 2). */
+    indent?: number;
+}
+
+export interface PrettyText {
+    text: string;
+    /** Set if containers, elements or strings were elided. */
+    truncated: boolean;
+}
+
+export interface ValueDiff {
+    equal: boolean;
+    changes: ValueChange[];
+    /** Set when there are more than 1000 changes. */
+    truncated?: boolean;
+}
+
+export type LintResult = { findings: LintFinding[] } | (ErrorResult & { parseError?: ParseError });
+
+export interface ExecutionIdentity {
+    executionId: number;
+    thread: string;
+    funcName: string;
+    startMs: number;
+}
+
+/** Callbacks observing all the executions, errors they throw are ignored. */
+export interface TelemetryCallbacks {
+    onExecStart?: (event: ExecutionIdentity) => void;
+    onExecEnd?: (event: ExecutionIdentity & { durationMs: number; stats: Stats; ok: boolean }) => void;
+    onError?: (event: ExecutionIdentity & { error: string; errorCode?: ErrorCode }) => void;
+    onLoadModule?: (event: { module: string; cached: boolean; durationMs: number; executionId?: number; thread?: string; error?: string }) => void;
+}
+
+/** Read from the STARLARK_WASM_OPTIONS global when the wasm module starts. */
+export interface ExportOptions {
+    namespace?: string;
+    names?: Partial<Record<keyof StarlarkAPI, string>>;
+}
+
+/** The functions exported by the wasm module. */
+export interface StarlarkAPI {
+    run_starlark_code(starlark_code: string, funcName?: string, ...args: unknown[]): RunResult;
+    run_starlark_code_with_options(starlark_code: string, options?: RunOptions): RunResult;
+    run_starlark_code_async(starlark_code: string, options?: AsyncRunOptions): Promise<AsyncRunResult>;
+    replay_starlark_recording(recording: Recording, options?: RunOptions): RunResult;
+    benchmark_starlark_function(starlark_code: string, funcName: string, args: unknown[], iterations: number, options?: RunOptions & { warmup?: number }): BenchmarkResult;
+    run_starlark_batch(starlark_code: string, calls: BatchCall[], options?: RunOptions): BatchResult;
+    eval_starlark_data(starlark_code: string, options?: RunOptions): RunResult;
+    configure_starlark_scheduler(options: SchedulerOptions): SchedulerStatus | ErrorResult;
+    starlark_scheduler_status(): SchedulerStatus;
+    configure_starlark_workers(options: WorkersOptions): WorkerPoolStatus | ErrorResult;
+    run_starlark_code_in_worker(starlark_code: string, options?: AsyncRunOptions): Promise<AsyncRunResult & { worker?: number }>;
+    starlark_worker_status(): WorkerPoolStatus;
+    serve_starlark_worker(): MessageResult | ErrorResult;
+    create_starlark_session(): SessionResult;
+    run_starlark_session(sessionId: number, starlark_code: string, options?: RunOptions): SessionRunResult;
+    destroy_starlark_session(sessionId: number): MessageResult | ErrorResult;
+    starlark_session_usage(sessionId: number): SessionUsage | ErrorResult;
+    set_starlark_session_quotas(sessionId: number, quotas: SessionQuotas): SessionUsage | ErrorResult;
+    snapshot_starlark_session(sessionId: number): SnapshotResult | ErrorResult;
+    restore_starlark_session(snapshot: Uint8Array): SessionResult | ErrorResult;
+    dispatch_starlark_event(sessionId: number, name: string, payload?: unknown, options?: RunOptions): DispatchResult;
+    call_starlark_handler(sessionId: number, name: string, request?: unknown, options?: RunOptions): HandlerResult;
+    starlark_session_handlers(sessionId: number): { sessionId: number; handlers: string[] } | ErrorResult;
+    send_starlark_channel(name: string, value: unknown): ChannelSendResult | ErrorResult;
+    recv_starlark_channel(name: string): ChannelRecvResult | ErrorResult;
+    parse_starlark_code(starlark_code: string): { ast: SyntaxNode } | ErrorResult;
+    format_starlark_code(starlark_code: string): FormatResult;
+    lint_starlark_code(starlark_code: string, options?: LintOptions): LintResult;
+    starlark_dependency_graph(files: Record<string, string>): DependencyGraph | ErrorResult;
+    open_starlark_document(starlark_code: string, options?: DocumentOptions): DocumentResult | ErrorResult;
+    edit_starlark_document(documentId: number, edits: DocumentEdit[]): DocumentResult | ErrorResult;
+    close_starlark_document(documentId: number): MessageResult | ErrorResult;
+    diff_starlark_values(a: unknown, b: unknown): ValueDiff | ErrorResult;
+    pretty_print_starlark_value(value: unknown, options?: PrettyOptions): PrettyText | ErrorResult;
+    register_starlark_prelude(starlark_code: string): PreludeResult;
+    register_starlark_builtin(name: string, value: ((...args: any[]) => unknown) | Record<string, unknown>, options?: { capability?: string }): BuiltinResult | ErrorResult;
+    register_starlark_converter(name: string, converter: Converter): ConverterResult | ErrorResult;
+    publish_starlark_data(name: string, value: unknown): PublishResult | ErrorResult;
+    register_starlark_module(name: string, starlark_code: string): MessageResult | ErrorResult;
+    configure_starlark_module_loader(options: { allowedHosts?: string[]; importMap?: Record<string, string> }): { allowedHosts: string[]; importMap: Record<string, string> } | ErrorResult;
+    register_starlark_telemetry(callbacks: TelemetryCallbacks): { registered: string[] } | ErrorResult;
+    starlark_runtime_info(): RuntimeInfo;
+    starlark_runtime_stats(): RuntimeStats;
+    starlark_reset(): MessageResult;
+    starlark_shutdown(): Promise<MessageResult | ErrorResult>;
+}
+
+declare global {
+    const run_starlark_code: StarlarkAPI["run_starlark_code"];
+    const run_starlark_code_with_options: StarlarkAPI["run_starlark_code_with_options"];
+    const run_starlark_code_async: StarlarkAPI["run_starlark_code_async"];
+    const replay_starlark_recording: StarlarkAPI["replay_starlark_recording"];
+    const benchmark_starlark_function: StarlarkAPI["benchmark_starlark_function"];
+    const run_starlark_batch: StarlarkAPI["run_starlark_batch"];
+    const eval_starlark_data: StarlarkAPI["eval_starlark_data"];
+    const configure_starlark_scheduler: StarlarkAPI["configure_starlark_scheduler"];
+    const starlark_scheduler_status: StarlarkAPI["starlark_scheduler_status"];
+    const configure_starlark_workers: StarlarkAPI["configure_starlark_workers"];
+    const run_starlark_code_in_worker: StarlarkAPI["run_starlark_code_in_worker"];
+    const starlark_worker_status: StarlarkAPI["starlark_worker_status"];
+    const serve_starlark_worker: StarlarkAPI["serve_starlark_worker"];
+    const create_starlark_session: StarlarkAPI["create_starlark_session"];
+    const run_starlark_session: StarlarkAPI["run_starlark_session"];
+    const destroy_starlark_session: StarlarkAPI["destroy_starlark_session"];
+    const starlark_session_usage: StarlarkAPI["starlark_session_usage"];
+    const set_starlark_session_quotas: StarlarkAPI["set_starlark_session_quotas"];
+    const snapshot_starlark_session: StarlarkAPI["snapshot_starlark_session"];
+    const restore_starlark_session: StarlarkAPI["restore_starlark_session"];
+    const dispatch_starlark_event: StarlarkAPI["dispatch_starlark_event"];
+    const call_starlark_handler: StarlarkAPI["call_starlark_handler"];
+    const starlark_session_handlers: StarlarkAPI["starlark_session_handlers"];
+    const send_starlark_channel: StarlarkAPI["send_starlark_channel"];
+    const recv_starlark_channel: StarlarkAPI["recv_starlark_channel"];
+    const parse_starlark_code: StarlarkAPI["parse_starlark_code"];
+    const format_starlark_code: StarlarkAPI["format_starlark_code"];
+    const lint_starlark_code: StarlarkAPI["lint_starlark_code"];
+    const starlark_dependency_graph: StarlarkAPI["starlark_dependency_graph"];
+    const open_starlark_document: StarlarkAPI["open_starlark_document"];
+    const edit_starlark_document: StarlarkAPI["edit_starlark_document"];
+    const close_starlark_document: StarlarkAPI["close_starlark_document"];
+    const diff_starlark_values: StarlarkAPI["diff_starlark_values"];
+    const pretty_print_starlark_value: StarlarkAPI["pretty_print_starlark_value"];
+    const register_starlark_prelude: StarlarkAPI["register_starlark_prelude"];
+    const register_starlark_builtin: StarlarkAPI["register_starlark_builtin"];
+    const register_starlark_converter: StarlarkAPI["register_starlark_converter"];
+    const publish_starlark_data: StarlarkAPI["publish_starlark_data"];
+    const register_starlark_module: StarlarkAPI["register_starlark_module"];
+    const configure_starlark_module_loader: StarlarkAPI["configure_starlark_module_loader"];
+    const register_starlark_telemetry: StarlarkAPI["register_starlark_telemetry"];
+    const starlark_runtime_info: StarlarkAPI["starlark_runtime_info"];
+    const starlark_runtime_stats: StarlarkAPI["starlark_runtime_stats"];
+    const starlark_reset: StarlarkAPI["starlark_reset"];
+    const starlark_shutdown: StarlarkAPI["starlark_shutdown"];
+    var STARLARK_WASM_OPTIONS: ExportOptions | undefined;
+}
+
+export function initialize(options?: ExportOptions): Promise<StarlarkAPI>;
+export function shutdown(): Promise<MessageResult | ErrorResult>;
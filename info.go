@@ -0,0 +1,105 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"sort"
+	"syscall/js"
+
+	"go.starlark.net/resolve"
+)
+
+// runtimeInfo describes the interpreter, the builtins it provides and the limits currently configured,
+// so the host can detect features instead of probing them.
+func runtimeInfo() map[string]interface{} {
+	builtins := []string{}
+	for name := range checkedUniverse {
+		builtins = append(builtins, name)
+	}
+	sort.Strings(builtins)
+	functions := []string{}
+	for _, export := range exported {
+		functions = append(functions, export.name)
+	}
+	modules.Lock()
+	registered := []string{}
+	for name := range modules.sources {
+		registered = append(registered, name)
+	}
+	allowedHosts := toJSList(modules.allowedHosts)
+	modules.Unlock()
+	sort.Strings(registered)
+	preludes.Lock()
+	preludeGlobals := []string{}
+	for name := range preludes.globals {
+		preludeGlobals = append(preludeGlobals, name)
+	}
+	hostBuiltins := []string{}
+	for name := range preludes.builtins {
+		hostBuiltins = append(hostBuiltins, name)
+	}
+	for name := range preludes.jsBuiltins {
+		hostBuiltins = append(hostBuiltins, name)
+	}
+	preludes.Unlock()
+	sort.Strings(preludeGlobals)
+	sort.Strings(hostBuiltins)
+	options := map[string]interface{}{}
+	for name, option := range optionBuiltins {
+		options[name] = option
+	}
+	scheduler := asyncScheduler.status()
+	return map[string]interface{}{
+		"starlarkVersion": starlarkVersion(),
+		"goVersion":       runtime.Version(),
+		"compiler":        runtime.Compiler,
+		"dialect": map[string]interface{}{
+			"set":            resolve.AllowSet,
+			"globalReassign": resolve.AllowGlobalReassign,
+			"recursion":      resolve.AllowRecursion,
+			"nestedDef":      resolve.AllowNestedDef,
+			"lambda":         resolve.AllowLambda,
+			"float":          resolve.AllowFloat,
+			"bitwise":        resolve.AllowBitwise,
+		},
+		"functions": toJSList(functions),
+		"builtins":  toJSList(builtins),
+		// the modules and builtins added to the universal ones, see execution.predeclared and session.predeclared
+		"modules": map[string]interface{}{
+			"always":  toJSList(alwaysBuiltins),
+			"options": options,
+			"session": toJSList(sessionBuiltins),
+			"host":    toJSList(hostBuiltins),
+		},
+		"capabilities":      capabilitiesInfo(),
+		"registeredModules": toJSList(registered),
+		"preludeGlobals":    toJSList(preludeGlobals),
+		"limits": map[string]interface{}{
+			"maxConcurrency":            scheduler["maxConcurrency"],
+			"maxQueueLength":            scheduler["maxQueueLength"],
+			"maxChannelMessages":        maxChannelMessages,
+			"defaultMaxConversionDepth": defaultMaxConversionDepth,
+			"defaultCancelGraceSteps":   defaultCancelGraceSteps,
+			"allowedHosts":              allowedHosts,
+		},
+	}
+}
+
+func getRuntimeInfo() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return runtimeInfo()
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// inputBuiltin is the input(prompt = "") builtin. It passes the prompt to the onInput callback of the execution
+// and returns its answer, the script waits while the host asks the user (e.g. with a dialog or a form) so it only
+// works in the executions of run_starlark_code_async. A null or undefined answer (e.g. a cancelled dialog) is None.
+var inputBuiltin = starlark.NewBuiltin("input", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (result starlark.Value, err error) {
+	checkpoint(thread)
+	var prompt string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "prompt?", &prompt); err != nil {
+		return nil, err
+	}
+	e := threadExecution(thread)
+	if e == nil || e.opts.onInput.IsUndefined() {
+		return nil, fmt.Errorf("%s: the host doesn't accept input, see the option \"onInput\"", b.Name())
+	}
+	if !threadCanBlock(thread) {
+		return nil, fmt.Errorf("%s: the script can only wait for input in the executions of run_starlark_code_async", b.Name())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, fmt.Errorf("%s: %s", b.Name(), jsErr.Error())
+		}
+	}()
+	answer, err := settleHostValue(e.opts.onInput.Invoke(prompt), true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: onInput %v", b.Name(), err)
+	}
+	if answer.IsUndefined() || answer.IsNull() {
+		return starlark.None, nil
+	}
+	if answer.Type() != js.TypeString {
+		return nil, fmt.Errorf("%s: the answer of onInput must be a string. Actual type %s", b.Name(), answer.Type())
+	}
+	return starlark.String(answer.String()), nil
+})
@@ -0,0 +1,130 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// catchJSError turns the errors thrown by a javascript call into a Go error.
+func catchJSError(call func() js.Value) (value js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", jsErr.Error())
+		}
+	}()
+	return call(), nil
+}
+
+// parseLocaleOption returns the canonical form of the locale option, empty if the option is missing.
+func parseLocaleOption(options js.Value) (string, error) {
+	locale, ok, err := getStringOption(options, "locale")
+	if err != nil || !ok {
+		return "", err
+	}
+	canonical, err := catchJSError(func() js.Value { return js.Global().Get("Intl").Call("getCanonicalLocales", locale) })
+	if err != nil {
+		return "", fmt.Errorf("the option \"locale\" is not a valid locale: %v", err)
+	}
+	return canonical.Index(0).String(), nil
+}
+
+// newIntlModule returns the intl module that formats numbers and dates with the Intl APIs of the host.
+// The locale defaults to the locale option, then to the default locale of the host, and the keyword arguments
+// are the options of Intl.NumberFormat and Intl.DateTimeFormat, e.g. intl.format_number(3.5, style = "currency", currency = "EUR").
+func newIntlModule(e *execution) *starlarkstruct.Module {
+	format := func(name, constructor string, convert func(starlark.Value) (js.Value, error)) *starlark.Builtin {
+		return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var value starlark.Value
+			var locale starlark.Value = starlark.None
+			options := js.Global().Get("Object").New()
+			for _, kwarg := range kwargs {
+				if kwarg[0] == starlark.String("locale") {
+					locale = kwarg[1]
+					continue
+				}
+				option, err := e.conv.convertToJSValue(kwarg[1])
+				if err != nil {
+					return nil, fmt.Errorf("%s: the option %s can't be converted: %v", b.Name(), kwarg[0], err)
+				}
+				options.Set(string(kwarg[0].(starlark.String)), option)
+			}
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, nil, 1, &value, &locale); err != nil {
+				return nil, err
+			}
+			locales := js.Undefined()
+			if s, ok := starlark.AsString(locale); ok {
+				locales = js.ValueOf(s)
+			} else if locale != starlark.None {
+				return nil, fmt.Errorf("%s: for parameter locale: got %s, want string or None", b.Name(), locale.Type())
+			} else if e.opts.locale != "" {
+				locales = js.ValueOf(e.opts.locale)
+			}
+			converted, err := convert(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			formatted, err := catchJSError(func() js.Value {
+				return js.Global().Get("Intl").Get(constructor).New(locales, options).Call("format", converted)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			return starlark.String(formatted.String()), nil
+		})
+	}
+	return &starlarkstruct.Module{
+		Name: "intl",
+		Members: starlark.StringDict{
+			"format_number": format("intl.format_number", "NumberFormat", func(value starlark.Value) (js.Value, error) {
+				// ints are formatted exactly as BigInts, whatever their size
+				switch v := value.(type) {
+				case starlark.Int:
+					return js.Global().Get("BigInt").Invoke(v.String()), nil
+				case starlark.Float:
+					return js.ValueOf(float64(v)), nil
+				}
+				return js.Undefined(), fmt.Errorf("got %s, want int or float", value.Type())
+			}),
+			// the dates are time values of the time module or numbers of seconds since the Unix epoch
+			"format_date": format("intl.format_date", "DateTimeFormat", func(value starlark.Value) (js.Value, error) {
+				var ms float64
+				switch v := value.(type) {
+				case starlarktime.Time:
+					ms = float64(time.Time(v).UnixNano()) / float64(time.Millisecond)
+				case starlark.Int:
+					seconds, _ := starlark.AsFloat(v)
+					ms = seconds * 1000
+				case starlark.Float:
+					ms = float64(v) * 1000
+				default:
+					return js.Undefined(), fmt.Errorf("got %s, want time or number of seconds", value.Type())
+				}
+				return js.Global().Get("Date").New(ms), nil
+			}),
+		},
+	}
+}
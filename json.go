@@ -0,0 +1,247 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// The JSON conversions follow the same rules as convertToStarlarkValue and convertToJSValue
+// but they skip the syscall/js bridge, which is much faster for large values.
+
+// convertJSONArgsToStarlarkValues decodes a JSON array into the arguments of a function call.
+func (c *converter) convertJSONArgsToStarlarkValues(data string) ([]starlark.Value, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	// the array itself is not converted, so the arguments have the same depth and count as the args option
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected a JSON array. Actual token %v", token)
+	}
+	values := []starlark.Value{}
+	for decoder.More() {
+		value, err := c.convertJSONToStarlarkValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON array")
+	}
+	return values, nil
+}
+
+// convertJSONToStarlarkValue decodes the next JSON value, keeping the order of the keys of objects.
+func (c *converter) convertJSONToStarlarkValue(decoder *json.Decoder) (starlark.Value, error) {
+	c.enter()
+	defer c.leave()
+	if err := c.track(c.depth); err != nil {
+		return nil, err
+	}
+	if err := c.count(); err != nil {
+		return nil, err
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(t), nil
+	case string:
+		if err := c.checkString(len(t)); err != nil {
+			return nil, err
+		}
+		return starlark.String(t), nil
+	case json.Number:
+		if i, ok := new(big.Int).SetString(string(t), 10); ok {
+			return starlark.MakeBigInt(i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		// with numbersAsFloats only the numbers written as integers are ints
+		if !c.numbersAsFloats && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			i, _ := big.NewFloat(f).Int(nil)
+			return starlark.MakeBigInt(i), nil
+		}
+		return starlark.Float(f), nil
+	case json.Delim:
+		switch t {
+		case '[':
+			list := []starlark.Value{}
+			for decoder.More() {
+				elem, err := c.convertJSONToStarlarkValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, elem)
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return starlark.NewList(list), nil
+		case '{':
+			dict := starlark.NewDict(0)
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				if err := c.checkString(len(key.(string))); err != nil {
+					return nil, err
+				}
+				value, err := c.convertJSONToStarlarkValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				if err := dict.SetKey(starlark.String(key.(string)), value); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return dict, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", token)
+}
+
+// jsonFrame is a list or dict being encoded by convertToJSON.
+type jsonFrame struct {
+	container starlark.Value
+	list      *starlark.List
+	items     []starlark.Tuple
+	next      int
+	close     byte
+}
+
+func (f *jsonFrame) done() bool {
+	if f.list != nil {
+		return f.next >= f.list.Len()
+	}
+	return f.next >= len(f.items)
+}
+
+// convertToJSON encodes a starlark value as JSON. Values that have no JSON equivalent are encoded as null.
+// Like convertToJSValue it uses an explicit stack and fails if the nesting is deeper than the depth limit,
+// it also fails as soon as a list or dict contains itself.
+func (c *converter) convertToJSON(value starlark.Value) (string, error) {
+	buf := bytes.Buffer{}
+	visiting := map[starlark.Value]bool{}
+	c.track(1)
+	frame, err := c.openJSON(&buf, value, visiting)
+	if err != nil {
+		return "", err
+	}
+	stack := []*jsonFrame{}
+	if frame != nil {
+		stack = append(stack, frame)
+	}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.done() {
+			buf.WriteByte(top.close)
+			delete(visiting, top.container)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if top.next > 0 {
+			buf.WriteByte(',')
+		}
+		var child starlark.Value
+		if top.list != nil {
+			child = top.list.Index(top.next)
+		} else {
+			if key, ok := top.items[top.next][0].(starlark.String); ok {
+				writeJSONString(&buf, string(key))
+			} else {
+				writeJSONString(&buf, top.items[top.next][0].String())
+			}
+			buf.WriteByte(':')
+			child = top.items[top.next][1]
+		}
+		top.next++
+		if err := c.track(len(stack) + 1); err != nil {
+			return "", err
+		}
+		frame, err := c.openJSON(&buf, child, visiting)
+		if err != nil {
+			return "", err
+		}
+		if frame != nil {
+			stack = append(stack, frame)
+		}
+	}
+	return buf.String(), nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoded, _ := json.Marshal(s)
+	buf.Write(encoded)
+}
+
+// openJSON encodes a scalar, or opens a list or dict and returns its frame.
+func (c *converter) openJSON(buf *bytes.Buffer, value starlark.Value, visiting map[starlark.Value]bool) (*jsonFrame, error) {
+	switch v := value.(type) {
+	case starlark.Bool:
+		buf.WriteString(strconv.FormatBool(bool(v)))
+	case starlark.Float:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			buf.WriteString("null")
+			return nil, nil
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	case starlark.String:
+		writeJSONString(buf, string(v))
+	case starlark.Int:
+		buf.WriteString(v.String())
+	case *starlark.List, *starlark.Dict:
+		if visiting[value] {
+			return nil, fmt.Errorf("the %s contains itself", value.Type())
+		}
+		visiting[value] = true
+		if list, ok := v.(*starlark.List); ok {
+			buf.WriteByte('[')
+			return &jsonFrame{container: value, list: list, close: ']'}, nil
+		}
+		buf.WriteByte('{')
+		return &jsonFrame{container: value, items: v.(*starlark.Dict).Items(), close: '}'}, nil
+	default:
+		buf.WriteString("null")
+	}
+	return nil, nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// lazyDict is a returned starlark dict exposed to javascript as a read only Proxy.
+// The values are converted when they are read, the nested dicts become lazy dicts too (released with the root one).
+// Proxies of dicts returned by a session read them with the session mutex held, since the session may modify them.
+type lazyDict struct {
+	id uint64
+	// root is the id of the returned dict, the dict itself for the root.
+	root     uint64
+	dict     *starlark.Dict
+	session  *session
+	opts     runOptions
+	target   js.Value
+	proxy    js.Value
+	children map[string]js.Value
+}
+
+// lazyDicts are the lazy dicts that have not been released, they are released by starlark_reset
+// and the ones of a session when it is destroyed. The traps of all the proxies are the same functions,
+// they find the dict with the id stored in the target of the proxy.
+var lazyDicts = struct {
+	sync.Mutex
+	nextID  uint64
+	byID    map[uint64]*lazyDict
+	handler js.Value
+}{byID: map[uint64]*lazyDict{}}
+
+// lazyHandleKey is the property of the targets that holds the id of their dict.
+const lazyHandleKey = "__starlarkLazyDict"
+
+func init() {
+	registerResetHook(func() {
+		releaseLazyDicts(nil)
+	})
+}
+
+// newLazyDict returns the Proxy of a returned dict, the options are the ones of the execution that returned it.
+func newLazyDict(dict *starlark.Dict, s *session, opts runOptions) js.Value {
+	lazyDicts.Lock()
+	defer lazyDicts.Unlock()
+	return newLazyDictLocked(dict, s, opts, 0)
+}
+
+// newLazyDictLocked must be called with the lazy dicts lock held. root is 0 for a returned dict.
+func newLazyDictLocked(dict *starlark.Dict, s *session, opts runOptions, root uint64) js.Value {
+	if lazyDicts.handler.IsUndefined() {
+		lazyDicts.handler = newLazyHandler()
+	}
+	lazyDicts.nextID++
+	d := &lazyDict{id: lazyDicts.nextID, root: root, dict: dict, session: s, opts: opts, children: map[string]js.Value{}}
+	if root == 0 {
+		d.root = d.id
+	}
+	// the target holds the id and the methods of the proxy, bound to the target so they work after the dict was released
+	d.target = jsObject.New()
+	d.target.Set(lazyHandleKey, d.id)
+	d.target.Set("materialize", lazyDicts.handler.Get("materialize").Call("bind", d.target))
+	d.target.Set("release", lazyDicts.handler.Get("release").Call("bind", d.target))
+	d.proxy = js.Global().Get("Proxy").New(d.target, lazyDicts.handler)
+	lazyDicts.byID[d.id] = d
+	return d.proxy
+}
+
+// releaseLazyDicts releases the lazy dicts of a session, or all of them if the session is nil.
+func releaseLazyDicts(s *session) {
+	lazyDicts.Lock()
+	defer lazyDicts.Unlock()
+	for id, d := range lazyDicts.byID {
+		if s == nil || d.session == s {
+			delete(lazyDicts.byID, id)
+		}
+	}
+}
+
+// lookupLazyDict returns the dict of a proxy target, nil if it was released.
+func lookupLazyDict(target js.Value) *lazyDict {
+	lazyDicts.Lock()
+	defer lazyDicts.Unlock()
+	return lazyDicts.byID[uint64(target.Get(lazyHandleKey).Float())]
+}
+
+// converter returns a converter with the conversion options of the execution that returned the dict.
+func (d *lazyDict) converter() *converter {
+	c := &converter{depthLimit: d.opts.maxConversionDepth, intOverflow: d.opts.intOverflow, invalidUnicode: d.opts.invalidUnicode, typedArrays: d.opts.returnBinary, freeze: d.opts.freezeReturnValue}
+	if d.opts.returnFunctions {
+		c.functions = func(fn starlark.Callable) js.Value { return newFunctionProxy(fn, d.session, nil) }
+	}
+	return c
+}
+
+// lock acquires the session of the dict, the returned function releases it.
+// It fails if the session is busy, e.g. when the dict is read by a callback of an execution of the session.
+func (d *lazyDict) lock() (func(), error) {
+	if d.session == nil {
+		return func() {}, nil
+	}
+	if busy := d.session.acquire(); busy != nil {
+		return nil, busy
+	}
+	return d.session.release, nil
+}
+
+// get returns the converted value of a key, the boolean is false if the dict doesn't have the key.
+// A value that can't be converted is reported to console.error and read as undefined.
+func (d *lazyDict) get(key string) (js.Value, bool) {
+	if child, ok := d.children[key]; ok {
+		return child, true
+	}
+	value, found, _ := d.dict.Get(starlark.String(key))
+	if !found {
+		return js.Undefined(), false
+	}
+	if dict, ok := value.(*starlark.Dict); ok {
+		lazyDicts.Lock()
+		child := newLazyDictLocked(dict, d.session, d.opts, d.root)
+		lazyDicts.Unlock()
+		d.children[key] = child
+		return child, true
+	}
+	converted, err := d.converter().convertToJSValue(value)
+	if err != nil {
+		js.Global().Get("console").Call("error", fmt.Sprintf("failed to convert the value of the key %q of a lazy dict. Error: %v", key, err))
+		return js.Undefined(), true
+	}
+	return converted, true
+}
+
+// keys returns the string keys of the dict, the other keys can't be read from javascript.
+func (d *lazyDict) keys() []interface{} {
+	keys := []interface{}{}
+	for _, item := range d.dict.Items() {
+		if key, ok := item[0].(starlark.String); ok {
+			keys = append(keys, string(key))
+		}
+	}
+	return keys
+}
+
+// reportLazyError reports to console.error a read of a lazy dict that failed, which then returns undefined or the fallback value.
+func reportLazyError(err error) {
+	js.Global().Get("console").Call("error", fmt.Sprintf("failed to read a lazy dict. Error: %v", err))
+}
+
+// recoverLazyPanic reports a panic of a trap (e.g. a nested dict with keys that are not strings) to console.error
+// instead of crashing the program, the trap then returns the fallback value.
+func recoverLazyPanic(result *interface{}, fallback interface{}) {
+	if r := recover(); r != nil {
+		js.Global().Get("console").Call("error", fmt.Sprintf("failed to read a lazy dict. Error: %v", r))
+		*result = fallback
+	}
+}
+
+// newLazyHandler returns the handler of the proxies. Reads of released dicts return undefined.
+func newLazyHandler() js.Value {
+	trap := func(fn func(d *lazyDict, args []js.Value) interface{}, fallback interface{}) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+			defer recoverLazyPanic(&result, fallback)
+			d := lookupLazyDict(args[0])
+			if d == nil {
+				return fallback
+			}
+			unlock, err := d.lock()
+			if err != nil {
+				reportLazyError(err)
+				return fallback
+			}
+			defer unlock()
+			return fn(d, args)
+		})
+	}
+	readOnly := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return false
+	})
+	handler := jsObject.New()
+	handler.Set("get", js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		defer recoverLazyPanic(&result, js.Undefined())
+		if args[1].Type() != js.TypeString {
+			return js.Undefined()
+		}
+		key := args[1].String()
+		if key == "materialize" || key == "release" {
+			return args[0].Get(key)
+		}
+		d := lookupLazyDict(args[0])
+		if d == nil {
+			return js.Undefined()
+		}
+		unlock, err := d.lock()
+		if err != nil {
+			reportLazyError(err)
+			return js.Undefined()
+		}
+		defer unlock()
+		value, _ := d.get(key)
+		return value
+	}))
+	handler.Set("has", trap(func(d *lazyDict, args []js.Value) interface{} {
+		if args[1].Type() != js.TypeString {
+			return false
+		}
+		_, found, _ := d.dict.Get(starlark.String(args[1].String()))
+		return found
+	}, false))
+	handler.Set("ownKeys", trap(func(d *lazyDict, args []js.Value) interface{} {
+		return d.keys()
+	}, []interface{}{}))
+	// the descriptors have a getter so Object.keys and the spread syntax don't convert every value to check that it is enumerable
+	handler.Set("getOwnPropertyDescriptor", trap(func(d *lazyDict, args []js.Value) interface{} {
+		if args[1].Type() != js.TypeString {
+			return js.Undefined()
+		}
+		if _, found, _ := d.dict.Get(starlark.String(args[1].String())); !found {
+			return js.Undefined()
+		}
+		getter := lazyDicts.handler.Get("get").Call("bind", nil, d.target, args[1])
+		return map[string]interface{}{"get": getter, "enumerable": true, "configurable": true}
+	}, js.Undefined()))
+	handler.Set("set", readOnly)
+	handler.Set("deleteProperty", readOnly)
+	handler.Set("defineProperty", readOnly)
+	// the methods are called with the target as this (see newLazyDictLocked)
+	handler.Set("materialize", js.FuncOf(func(this js.Value, args []js.Value) (result interface{}) {
+		defer recoverLazyPanic(&result, js.Null())
+		d := lookupLazyDict(this)
+		if d == nil {
+			return js.Null()
+		}
+		unlock, err := d.lock()
+		if err != nil {
+			reportLazyError(err)
+			return js.Null()
+		}
+		defer unlock()
+		converted, err := d.converter().convertToJSValue(d.dict)
+		if err != nil {
+			js.Global().Get("console").Call("error", fmt.Sprintf("failed to materialize a lazy dict. Error: %v", err))
+			return js.Null()
+		}
+		return converted
+	}))
+	handler.Set("release", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		lazyDicts.Lock()
+		defer lazyDicts.Unlock()
+		d := lazyDicts.byID[uint64(this.Get(lazyHandleKey).Float())]
+		if d == nil {
+			return nil
+		}
+		for id, other := range lazyDicts.byID {
+			if other.root == d.root {
+				delete(lazyDicts.byID, id)
+			}
+		}
+		return nil
+	}))
+	return handler
+}
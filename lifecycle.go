@@ -0,0 +1,186 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+)
+
+// export is a Go function made available to javascript.
+type export struct {
+	name string
+	fn   js.Func
+}
+
+var (
+	// exported are the functions added to the javascript globals.
+	exported []export
+	// shuttingDown is set once starlark_shutdown has been called.
+	shuttingDown int32
+	// exited is closed once the shutdown is complete, which makes main return.
+	exited = make(chan struct{})
+	// resetHooks clear the state kept between executions (sessions, caches, registered modules, etc.)
+	resetHooks = struct {
+		sync.Mutex
+		hooks []func()
+	}{}
+)
+
+// registerResetHook adds a function that is called by starlark_reset.
+// Every feature that keeps state between executions must register a hook that clears it.
+func registerResetHook(hook func()) {
+	resetHooks.Lock()
+	defer resetHooks.Unlock()
+	resetHooks.hooks = append(resetHooks.hooks, hook)
+}
+
+// getReset returns starlark_reset which clears all the state kept between executions
+// without reloading the wasm module. Executions that are in progress are not affected.
+func getReset() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resetHooks.Lock()
+		defer resetHooks.Unlock()
+		for _, hook := range resetHooks.hooks {
+			hook()
+		}
+		return map[string]interface{}{"message": "the starlark interpreter state has been reset"}
+	})
+}
+
+// exportOptionsGlobal is the name of the global that can be set before starting the wasm module
+// to control where the functions are exported.
+const exportOptionsGlobal = "STARLARK_WASM_OPTIONS"
+
+// exportTarget is where the functions are exported, either the javascript globals or a namespace object.
+var exportTarget = struct {
+	obj       js.Value
+	namespace string
+	// created is true if the namespace object didn't exist before the functions were exported.
+	created bool
+}{}
+
+// readExportOptions reads the namespace and the custom function names from the STARLARK_WASM_OPTIONS global.
+// The names map the default names of the functions to the names they are exported as.
+func readExportOptions() (string, map[string]string, error) {
+	options := js.Global().Get(exportOptionsGlobal)
+	namespace, _, err := getStringOption(options, "namespace")
+	if err != nil {
+		return "", nil, err
+	}
+	names := map[string]string{}
+	value := getOption(options, "names")
+	if value.IsUndefined() || value.IsNull() {
+		return namespace, names, nil
+	}
+	if value.Type() != js.TypeObject {
+		return "", nil, fmt.Errorf("the option \"names\" must be an object. Actual type %s", value.Type())
+	}
+	keys := js.Global().Get("Object").Call("keys", value)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		name, _, err := getStringOption(value, key)
+		if err != nil {
+			return "", nil, err
+		}
+		names[key] = name
+	}
+	return namespace, names, nil
+}
+
+func registerExports(exports []export) {
+	namespace, names, err := readExportOptions()
+	if err != nil {
+		fmt.Printf("Error: invalid %s, using the defaults. Error: %q\n", exportOptionsGlobal, err)
+		namespace, names = "", map[string]string{}
+	}
+	exportTarget.obj = js.Global()
+	exportTarget.namespace = namespace
+	where := "the javascript globals (window object)"
+	if namespace != "" {
+		exportTarget.obj = js.Global().Get(namespace)
+		if exportTarget.obj.Type() != js.TypeObject {
+			exportTarget.obj = js.Global().Get("Object").New()
+			exportTarget.created = true
+			js.Global().Set(namespace, exportTarget.obj)
+		}
+		where = fmt.Sprintf("the javascript global %s", namespace)
+	}
+	exported = []export{}
+	exportedNames := []string{}
+	for _, export := range exports {
+		if name, ok := names[export.name]; ok && name != "" {
+			export.name = name
+		}
+		exportTarget.obj.Set(export.name, export.fn)
+		exported = append(exported, export)
+		exportedNames = append(exportedNames, export.name)
+	}
+	fmt.Printf("the functions %s have been added to %s\n", strings.Join(exportedNames, ", "), where)
+}
+
+func unregisterExports() {
+	for _, export := range exported {
+		if exportTarget.obj.Get(export.name).Equal(export.fn.Value) {
+			exportTarget.obj.Delete(export.name)
+		}
+	}
+	if exportTarget.created && js.Global().Get(exportTarget.namespace).Equal(exportTarget.obj) {
+		js.Global().Delete(exportTarget.namespace)
+	}
+}
+
+// getShutdown returns starlark_shutdown which removes the exported functions from the globals,
+// waits for the in-flight executions to finish, stops the timers of the sessions and the workers of the pool
+// so that no callback runs after the exit, and then lets the Go program exit.
+// It returns a Promise that resolves once the shutdown is complete.
+func getShutdown() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return newPromise(func(resolve, reject func(interface{})) {
+			if !atomic.CompareAndSwapInt32(&shuttingDown, 0, 1) {
+				err := fmt.Errorf("Error: starlark_shutdown has already been called.")
+				resolve(map[string]interface{}{"error": err.Error(), "errorCode": "failed_precondition"})
+				return
+			}
+			unregisterExports()
+			go func() {
+				asyncScheduler.drain()
+				executions.wg.Wait()
+				cancelAllTimers()
+				stopServing()
+				terminateWorkers("Error: cancelled. The worker was terminated because the instance was shut down.", "cancelled")
+				// a timer that fired before it was cancelled may have started an execution
+				executions.wg.Wait()
+				for _, export := range exported {
+					export.fn.Release()
+				}
+				resolve(map[string]interface{}{"message": "the starlark interpreter has been shut down"})
+				// Nothing may be blocked at this point so the goroutine could still be running inside the
+				// javascript call to starlark_shutdown, the program must not exit before that call returns.
+				// The callback of setTimeout only runs once javascript has regained control.
+				var exit js.Func
+				exit = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+					exit.Release()
+					close(exited)
+					return nil
+				})
+				js.Global().Call("setTimeout", exit, 0)
+			}()
+		})
+	})
+}
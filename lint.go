@@ -0,0 +1,450 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	lintResolve              = "resolve"
+	lintUnusedVariable       = "unused-variable"
+	lintShadowedName         = "shadowed-name"
+	lintUnreachableCode      = "unreachable-code"
+	lintSuspiciousComparison = "suspicious-comparison"
+	lintLoadOrder            = "load-order"
+)
+
+// lintChecks are all the checks supported by lint_starlark_code, in the order they are run.
+var lintChecks = []string{lintResolve, lintUnusedVariable, lintShadowedName, lintUnreachableCode, lintSuspiciousComparison, lintLoadOrder}
+
+// pythonTypeNames maps common python type names to the names returned by the starlark type() function.
+var pythonTypeNames = map[string]string{"str": "string", "unicode": "string", "long": "int", "integer": "int", "boolean": "bool", "none": "NoneType"}
+
+type lintFinding struct {
+	check   string
+	message string
+	node    syntax.Node
+	pos     syntax.Position
+}
+
+type linter struct {
+	file     *syntax.File
+	findings []lintFinding
+}
+
+func (l *linter) report(check string, node syntax.Node, format string, args ...interface{}) {
+	l.findings = append(l.findings, lintFinding{check: check, message: fmt.Sprintf(format, args...), node: node})
+}
+
+// exprString returns the canonical source code of an expression.
+func exprString(expr syntax.Expr) string {
+	f := formatter{}
+	f.expr(expr)
+	return f.out.String()
+}
+
+func isParam(binding *resolve.Binding, function *resolve.Function) bool {
+	for _, param := range function.Params {
+		switch p := param.(type) {
+		case *syntax.Ident:
+			if p == binding.First {
+				return true
+			}
+		case *syntax.BinaryExpr:
+			if p.X == binding.First {
+				return true
+			}
+		case *syntax.UnaryExpr:
+			if p.X == binding.First {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUnusedVariables reports local variables and loaded symbols that are bound but never used.
+func (l *linter) checkUnusedVariables() {
+	// the identifiers that bind a name are not uses, only the ones that read it
+	assigned := map[*syntax.Ident]bool{}
+	syntax.Walk(l.file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.AssignStmt:
+			if n.Op == syntax.EQ {
+				collectAssignedIdents(n.LHS, assigned)
+			}
+		case *syntax.ForStmt:
+			collectAssignedIdents(n.Vars, assigned)
+		case *syntax.ForClause:
+			collectAssignedIdents(n.Vars, assigned)
+		case *syntax.LoadStmt:
+			for _, to := range n.To {
+				assigned[to] = true
+			}
+		case *syntax.DefStmt:
+			assigned[n.Name] = true
+		}
+		return true
+	})
+	uses := map[*resolve.Binding]int{}
+	functions := map[*resolve.Binding]*resolve.Function{}
+	syntax.Walk(l.file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.Ident:
+			if binding, ok := n.Binding.(*resolve.Binding); ok && !assigned[n] {
+				uses[binding]++
+			}
+		case *syntax.DefStmt:
+			function := n.Function.(*resolve.Function)
+			for _, binding := range function.Locals {
+				functions[binding] = function
+			}
+		case *syntax.LambdaExpr:
+			function := n.Function.(*resolve.Function)
+			for _, binding := range function.Locals {
+				functions[binding] = function
+			}
+		}
+		return true
+	})
+	module := l.file.Module.(*resolve.Module)
+	bindings := append([]*resolve.Binding{}, module.Locals...)
+	for binding := range functions {
+		bindings = append(bindings, binding)
+	}
+	for _, binding := range bindings {
+		if binding.First == nil || uses[binding] > 0 || strings.HasPrefix(binding.First.Name, "_") {
+			continue
+		}
+		if function, ok := functions[binding]; ok && isParam(binding, function) {
+			continue
+		}
+		l.report(lintUnusedVariable, binding.First, "%q is assigned but never used", binding.First.Name)
+	}
+}
+
+// collectAssignedIdents adds the identifiers bound by the target of an assignment or a for loop.
+// The operands of index and dot expressions in a target are read, not bound.
+func collectAssignedIdents(target syntax.Expr, assigned map[*syntax.Ident]bool) {
+	switch t := target.(type) {
+	case *syntax.Ident:
+		assigned[t] = true
+	case *syntax.ParenExpr:
+		collectAssignedIdents(t.X, assigned)
+	case *syntax.TupleExpr:
+		for _, x := range t.List {
+			collectAssignedIdents(x, assigned)
+		}
+	case *syntax.ListExpr:
+		for _, x := range t.List {
+			collectAssignedIdents(x, assigned)
+		}
+	}
+}
+
+// checkShadowedNames reports bindings that hide a global or a builtin with the same name.
+func (l *linter) checkShadowedNames() {
+	module := l.file.Module.(*resolve.Module)
+	toplevel := []*syntax.Ident{}
+	for _, binding := range module.Globals {
+		toplevel = append(toplevel, binding.First)
+	}
+	for _, stmt := range l.file.Stmts {
+		if load, ok := stmt.(*syntax.LoadStmt); ok {
+			toplevel = append(toplevel, load.To...)
+		}
+	}
+	globals := map[string]bool{}
+	for _, ident := range toplevel {
+		globals[ident.Name] = true
+		if starlark.Universe.Has(ident.Name) {
+			l.report(lintShadowedName, ident, "%q shadows the builtin with the same name", ident.Name)
+		}
+	}
+	checkFunction := func(function *resolve.Function) {
+		for _, binding := range function.Locals {
+			name := binding.First.Name
+			if globals[name] {
+				l.report(lintShadowedName, binding.First, "%q shadows the global with the same name", name)
+			} else if starlark.Universe.Has(name) {
+				l.report(lintShadowedName, binding.First, "%q shadows the builtin with the same name", name)
+			}
+		}
+	}
+	syntax.Walk(l.file, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.DefStmt:
+			checkFunction(n.Function.(*resolve.Function))
+		case *syntax.LambdaExpr:
+			checkFunction(n.Function.(*resolve.Function))
+		}
+		return true
+	})
+}
+
+// terminates returns true if control never flows past the statement.
+func terminates(stmt syntax.Stmt) bool {
+	switch s := stmt.(type) {
+	case *syntax.ReturnStmt:
+		return true
+	case *syntax.BranchStmt:
+		return s.Token == syntax.BREAK || s.Token == syntax.CONTINUE
+	case *syntax.ExprStmt:
+		call, ok := s.X.(*syntax.CallExpr)
+		if !ok {
+			return false
+		}
+		fn, ok := call.Fn.(*syntax.Ident)
+		return ok && (fn.Name == "fail" || fn.Name == "fail_with")
+	case *syntax.IfStmt:
+		return len(s.False) > 0 && blockTerminates(s.True) && blockTerminates(s.False)
+	default:
+		return false
+	}
+}
+
+func blockTerminates(stmts []syntax.Stmt) bool {
+	for _, stmt := range stmts {
+		if terminates(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUnreachableCode reports the first statement following a return, break, continue, fail() or fail_with() in each block.
+func (l *linter) checkUnreachableCode() {
+	var checkBlock func(stmts []syntax.Stmt)
+	checkBlock = func(stmts []syntax.Stmt) {
+		for i, stmt := range stmts {
+			switch s := stmt.(type) {
+			case *syntax.DefStmt:
+				checkBlock(s.Body)
+			case *syntax.ForStmt:
+				checkBlock(s.Body)
+			case *syntax.WhileStmt:
+				checkBlock(s.Body)
+			case *syntax.IfStmt:
+				checkBlock(s.True)
+				checkBlock(s.False)
+			}
+			if terminates(stmt) && i+1 < len(stmts) {
+				l.report(lintUnreachableCode, stmts[i+1], "this code is unreachable")
+				return
+			}
+		}
+	}
+	checkBlock(l.file.Stmts)
+}
+
+func isTypeCall(expr syntax.Expr) bool {
+	call, ok := expr.(*syntax.CallExpr)
+	if !ok {
+		return false
+	}
+	fn, ok := call.Fn.(*syntax.Ident)
+	return ok && fn.Name == "type" && len(call.Args) == 1
+}
+
+// checkSuspiciousComparisons reports comparisons that are almost certainly mistakes.
+func (l *linter) checkSuspiciousComparisons() {
+	syntax.Walk(l.file, func(node syntax.Node) bool {
+		cmp, ok := node.(*syntax.BinaryExpr)
+		if !ok {
+			return true
+		}
+		switch cmp.Op {
+		case syntax.EQL, syntax.NEQ, syntax.LT, syntax.GT, syntax.LE, syntax.GE:
+		default:
+			return true
+		}
+		if exprString(cmp.X) == exprString(cmp.Y) {
+			l.report(lintSuspiciousComparison, cmp, "the expression %s is compared with itself", exprString(cmp.X))
+			return true
+		}
+		for _, pair := range [][2]syntax.Expr{{cmp.X, cmp.Y}, {cmp.Y, cmp.X}} {
+			if lit, ok := pair[1].(*syntax.Literal); ok && lit.Token == syntax.STRING && isTypeCall(pair[0]) {
+				if name, ok := pythonTypeNames[lit.Value.(string)]; ok {
+					l.report(lintSuspiciousComparison, cmp, "type() never returns %q, the starlark name of the type is %q", lit.Value, name)
+				}
+			}
+			if ident, ok := pair[1].(*syntax.Ident); ok && (cmp.Op == syntax.EQL || cmp.Op == syntax.NEQ) && (ident.Name == "True" || ident.Name == "False") {
+				l.report(lintSuspiciousComparison, cmp, "comparison with %s, use the expression (or its negation) directly instead", ident.Name)
+			}
+		}
+		return true
+	})
+}
+
+func isDocString(stmt syntax.Stmt) bool {
+	expr, ok := stmt.(*syntax.ExprStmt)
+	if !ok {
+		return false
+	}
+	lit, ok := expr.X.(*syntax.Literal)
+	return ok && lit.Token == syntax.STRING
+}
+
+// checkLoadOrder reports load statements that are not at the top of the file,
+// not sorted by module or that load the same module more than once.
+func (l *linter) checkLoadOrder() {
+	seenOther := false
+	modules := map[string]bool{}
+	previous := ""
+	for i, stmt := range l.file.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			// a module doc string is allowed before the loads
+			if i > 0 || !isDocString(stmt) {
+				seenOther = true
+			}
+			continue
+		}
+		module := load.Module.Value.(string)
+		if seenOther {
+			l.report(lintLoadOrder, load, "load statements should be at the top of the file")
+		}
+		if modules[module] {
+			l.report(lintLoadOrder, load, "the module %q is loaded more than once", module)
+		} else if module < previous {
+			l.report(lintLoadOrder, load, "load statements should be sorted by module, %q should be loaded before %q", module, previous)
+		}
+		modules[module] = true
+		previous = module
+	}
+}
+
+// lintPredeclared returns the names predeclared in every execution and the extra names given by the caller.
+func lintPredeclared(predeclared []string) map[string]bool {
+	// the env, log, channel, template, semver, intl and archive modules, check_cancelled, fail_with, input, report_progress, the builtins that require a capability
+	// and the globals of the preludes are predeclared in every execution
+	isPredeclared := map[string]bool{}
+	for _, name := range alwaysBuiltins {
+		isPredeclared[name] = true
+	}
+	for _, names := range capabilityBuiltins {
+		for _, name := range names {
+			isPredeclared[name] = true
+		}
+	}
+	for name := range registeredJSBuiltins() {
+		isPredeclared[name] = true
+	}
+	for name := range predeclaredGlobals() {
+		isPredeclared[name] = true
+	}
+	for _, name := range predeclared {
+		isPredeclared[name] = true
+	}
+	return isPredeclared
+}
+
+// lintStarlarkCode runs the given checks on the source code and returns the findings sorted by position.
+func lintStarlarkCode(filename, starlark_code string, checks []string, predeclared []string) ([]lintFinding, error) {
+	file, err := syntax.Parse(filename, starlark_code, 0)
+	if err != nil {
+		return nil, err
+	}
+	isPredeclared := lintPredeclared(predeclared)
+	resolveErr := resolve.File(file, func(name string) bool { return isPredeclared[name] }, starlark.Universe.Has)
+	l := linter{file: file}
+	for _, check := range checks {
+		switch check {
+		case lintResolve:
+			if errs, ok := resolveErr.(resolve.ErrorList); ok {
+				for _, err := range errs {
+					l.findings = append(l.findings, lintFinding{check: lintResolve, message: err.Msg, pos: err.Pos})
+				}
+			}
+		case lintUnusedVariable:
+			l.checkUnusedVariables()
+		case lintShadowedName:
+			l.checkShadowedNames()
+		case lintUnreachableCode:
+			l.checkUnreachableCode()
+		case lintSuspiciousComparison:
+			l.checkSuspiciousComparisons()
+		case lintLoadOrder:
+			l.checkLoadOrder()
+		default:
+			return nil, fmt.Errorf("unknown check %q. Valid checks are %q", check, lintChecks)
+		}
+	}
+	for i, finding := range l.findings {
+		if finding.node != nil {
+			l.findings[i].pos = syntax.Start(finding.node)
+		}
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].pos.Line < l.findings[j].pos.Line || (l.findings[i].pos.Line == l.findings[j].pos.Line && l.findings[i].pos.Col < l.findings[j].pos.Col)
+	})
+	return l.findings, nil
+}
+
+func convertLintFindingToJSON(finding lintFinding) interface{} {
+	obj := map[string]interface{}{"check": finding.check, "message": finding.message, "start": convertPositionToJSON(finding.pos)}
+	if finding.node != nil {
+		obj["end"] = convertPositionToJSON(syntax.End(finding.node))
+	} else {
+		obj["end"] = convertPositionToJSON(finding.pos)
+	}
+	return obj
+}
+
+func getStarlarkLinter() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		starlark_code := args[0].String()
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		checks, err := getStringListOption(options, "checks")
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		if checks == nil {
+			checks = lintChecks
+		}
+		predeclared, err := getStringListOption(options, "predeclared")
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		findings, err := lintStarlarkCode("", starlark_code, checks, predeclared)
+		if err != nil {
+			parseError := convertSyntaxErrorToJSON(err)
+			err := fmt.Errorf("Error: failed to lint the starlark code. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "parseError": parseError}
+		}
+		jsFindings := []interface{}{}
+		for _, finding := range findings {
+			jsFindings = append(jsFindings, convertLintFindingToJSON(finding))
+		}
+		return map[string]interface{}{"findings": jsFindings}
+	})
+}
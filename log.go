@@ -0,0 +1,115 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// logLevels are the levels of the log module in increasing order of severity.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+func logLevelIndex(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// logOptions control where the records of the log module go.
+type logOptions struct {
+	// onLog is called with each record, undefined (the zero value) to add the records to the result instead.
+	onLog js.Value
+	// minLevel is the index of the lowest level that is recorded.
+	minLevel int
+}
+
+func parseLogOptions(options js.Value) (logOptions, error) {
+	opts := logOptions{}
+	onLog, ok, err := getFunctionOption(options, "onLog")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		opts.onLog = onLog
+	}
+	level, ok, err := getStringOption(options, "logLevel")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if opts.minLevel = logLevelIndex(level); opts.minLevel < 0 {
+			return opts, fmt.Errorf("the option \"logLevel\" must be one of %s. Actual value %q", strings.Join(logLevels, ", "), level)
+		}
+	}
+	return opts, nil
+}
+
+// newLogModule returns the log module whose functions emit structured records, separately from the output of print.
+// log.info("a", 1, user = "x") records the message "a 1" with the fields {user: "x"}.
+func newLogModule(e *execution) *starlarkstruct.Module {
+	members := starlark.StringDict{}
+	for i, level := range logLevels {
+		i, level := i, level
+		members[level] = starlark.NewBuiltin("log."+level, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			if i < e.opts.log.minLevel {
+				return starlark.None, nil
+			}
+			parts := []string{}
+			for _, arg := range args {
+				if s, ok := starlark.AsString(arg); ok {
+					parts = append(parts, s)
+				} else {
+					parts = append(parts, arg.String())
+				}
+			}
+			fields := map[string]interface{}{}
+			for _, kwarg := range kwargs {
+				field, err := e.conv.convertToJSValue(kwarg[1])
+				if err != nil {
+					return nil, fmt.Errorf("%s: the field %s can't be converted: %v", b.Name(), kwarg[0], err)
+				}
+				fields[string(kwarg[0].(starlark.String))] = field
+			}
+			record := map[string]interface{}{
+				"level":       level,
+				"message":     strings.Join(parts, " "),
+				"fields":      fields,
+				"thread":      thread.Name,
+				"timestampMs": float64(e.now().UnixNano()) / float64(time.Millisecond),
+			}
+			if thread.CallStackDepth() > 1 {
+				pos := thread.CallFrame(1).Pos
+				record["position"] = map[string]interface{}{"file": pos.Filename(), "line": int(pos.Line), "col": int(pos.Col)}
+			}
+			if e.opts.log.onLog.IsUndefined() {
+				e.logs = append(e.logs, record)
+			} else {
+				e.opts.log.onLog.Invoke(record)
+			}
+			return starlark.None, nil
+		})
+	}
+	return &starlarkstruct.Module{Name: "log", Members: members}
+}
@@ -12,124 +12,62 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:generate go run ./tools/gendts -main main.go -o index.d.ts
+
 package main
 
 import (
 	"fmt"
-	"strings"
-	"syscall/js"
-
-	"go.starlark.net/starlark"
 )
 
-func convertToStarlarkValue(value js.Value) starlark.Value {
-	switch value.Type() {
-	case js.TypeBoolean:
-		return starlark.Bool(value.Bool())
-	case js.TypeNumber:
-		floatVal := value.Float()
-		if floatVal == float64(int(floatVal)) {
-			return starlark.MakeInt(value.Int())
-		}
-		return starlark.Float(floatVal)
-	case js.TypeString:
-		return starlark.String(value.String())
-	case js.TypeObject:
-		if value.InstanceOf(js.Global().Get("Array")) {
-			list := []starlark.Value{}
-			length := value.Length()
-			for i := 0; i < length; i++ {
-				list = append(list, convertToStarlarkValue(value.Index(i)))
-			}
-			return starlark.NewList(list)
-		} else {
-			dict := starlark.NewDict(value.Length())
-			keys := js.Global().Get("Object").Call("keys", value)
-			length := keys.Length()
-			for i := 0; i < length; i++ {
-				key := keys.Index(i).String()
-				dict.SetKey(starlark.String(key), convertToStarlarkValue(value.Get(key)))
-			}
-			return dict
-		}
-	default:
-		return starlark.None
-	}
-}
-
-func convertToJSValue(value starlark.Value) js.Value {
-	switch v := value.(type) {
-	case starlark.Bool:
-		return js.ValueOf(bool(v))
-	case starlark.Float:
-		return js.ValueOf(float64(v))
-	case starlark.String:
-		return js.ValueOf(string(v))
-	case starlark.Int:
-		intVal, _ := v.Int64()
-		return js.ValueOf(intVal)
-	case *starlark.List:
-		array := js.Global().Get("Array").New(v.Len())
-		for i := 0; i < v.Len(); i++ {
-			array.SetIndex(i, convertToJSValue(v.Index(i)))
-		}
-		return array
-	case *starlark.Dict:
-		obj := js.Global().Get("Object").New()
-		for _, item := range v.Items() {
-			key := item[0].(starlark.String)
-			obj.Set(string(key), convertToJSValue(item[1]))
-		}
-		return obj
-	default:
-		return js.Null()
-	}
-}
-
-func getStarlarkRunner() js.Func {
-	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		if len(args) < 1 {
-			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
-			return map[string]interface{}{"error": err.Error()}
-		}
-		starlark_code := args[0].String()
-		funcName := "main"
-		if len(args) > 1 {
-			funcName = args[1].String()
-		}
-		funcArgs := []starlark.Value{}
-		if len(args) > 2 {
-			for _, arg := range args[2:] {
-				funcArgs = append(funcArgs, convertToStarlarkValue(arg))
-			}
-		}
-
-		output := strings.Builder{}
-		thread := &starlark.Thread{Name: "js-go-starlark-thread", Print: func(_ *starlark.Thread, msg string) {
-			output.WriteString(msg + "\n")
-		}}
-		globals, err := starlark.ExecFile(thread, "", starlark_code, nil)
-		if err != nil {
-			err := fmt.Errorf("Error: failed to evaluate the starlark code. Error: %q", err)
-			return map[string]interface{}{"error": err.Error()}
-		}
-		mainFn, ok := globals[funcName]
-		if !ok {
-			err := fmt.Errorf("Error: the function %q is missing from the starlark code.", funcName)
-			return map[string]interface{}{"error": err.Error()}
-		}
-		// Call the Starlark function from Go.
-		result, err := starlark.Call(thread, mainFn, funcArgs, nil)
-		if err != nil {
-			err := fmt.Errorf("Error: failed to execute the starlark code. Error: %q", err)
-			return map[string]interface{}{"error": err.Error()}
-		}
-		return map[string]interface{}{"message": output.String(), "returnValue": convertToJSValue(result)}
-	})
-}
-
 func main() {
-	js.Global().Set("run_starlark_code", getStarlarkRunner())
-	fmt.Println("the run_starlark_code has been added to the javascript globals (window object)")
-	<-make(chan bool) // keep thread running forever so Javascript can call the function we exported.
+	registerExports([]export{
+		{"run_starlark_code", getStarlarkRunner()},
+		{"run_starlark_code_with_options", getStarlarkRunnerWithOptions()},
+		{"run_starlark_code_async", getAsyncStarlarkRunner()},
+		{"replay_starlark_recording", getStarlarkRecordingReplayer()},
+		{"run_starlark_batch", getStarlarkBatchRunner()},
+		{"benchmark_starlark_function", getStarlarkBenchmarker()},
+		{"eval_starlark_data", getDataEvaluator()},
+		{"configure_starlark_scheduler", getSchedulerConfigurer()},
+		{"starlark_scheduler_status", getSchedulerStatus()},
+		{"configure_starlark_workers", getWorkersConfigurer()},
+		{"run_starlark_code_in_worker", getWorkerRunner()},
+		{"starlark_worker_status", getWorkerStatus()},
+		{"serve_starlark_worker", getWorkerServer()},
+		{"create_starlark_session", getSessionCreator()},
+		{"run_starlark_session", getSessionRunner()},
+		{"destroy_starlark_session", getSessionDestroyer()},
+		{"starlark_session_usage", getSessionUsageReader()},
+		{"set_starlark_session_quotas", getSessionQuotasSetter()},
+		{"snapshot_starlark_session", getSessionSnapshotter()},
+		{"restore_starlark_session", getSessionRestorer()},
+		{"dispatch_starlark_event", getEventDispatcher()},
+		{"call_starlark_handler", getHandlerCaller()},
+		{"starlark_session_handlers", getHandlerLister()},
+		{"send_starlark_channel", getChannelSender()},
+		{"recv_starlark_channel", getChannelReceiver()},
+		{"parse_starlark_code", getStarlarkParser()},
+		{"format_starlark_code", getStarlarkFormatter()},
+		{"lint_starlark_code", getStarlarkLinter()},
+		{"starlark_dependency_graph", getDependencyGrapher()},
+		{"open_starlark_document", getDocumentOpener()},
+		{"edit_starlark_document", getDocumentEditor()},
+		{"close_starlark_document", getDocumentCloser()},
+		{"diff_starlark_values", getValueDiffer()},
+		{"pretty_print_starlark_value", getValuePrettyPrinter()},
+		{"register_starlark_prelude", getPreludeRegisterer()},
+		{"publish_starlark_data", getDataPublisher()},
+		{"register_starlark_builtin", getBuiltinRegisterer()},
+		{"register_starlark_converter", getConverterRegisterer()},
+		{"register_starlark_module", getModuleRegisterer()},
+		{"configure_starlark_module_loader", getModuleLoaderConfigurer()},
+		{"register_starlark_telemetry", getTelemetryRegisterer()},
+		{"starlark_runtime_info", getRuntimeInfo()},
+		{"starlark_runtime_stats", getRuntimeStats()},
+		{"starlark_reset", getReset()},
+		{"starlark_shutdown", getShutdown()},
+	})
+	<-exited // keep thread running until starlark_shutdown is called so Javascript can call the functions we exported.
+	fmt.Println("the starlark functions have been removed from the javascript globals")
 }
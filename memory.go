@@ -0,0 +1,56 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"go.starlark.net/starlark"
+)
+
+// memoryMonitor cancels a starlark thread once the heap has grown by more than the budget since the monitor started.
+// The heap is checked at every checkpoint (see checkpointer) and when the execution ends,
+// so a script can overshoot the budget by whatever it allocates between two checkpoints.
+// The heap is shared by all the executions, so allocations made by overlapping executions are counted as well.
+type memoryMonitor struct {
+	budget   uint64
+	baseline uint64
+	peak     uint64
+	exceeded bool
+}
+
+func newMemoryMonitor(budget uint64) *memoryMonitor {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	return &memoryMonitor{budget: budget, baseline: stats.HeapAlloc}
+}
+
+// check cancels the thread if the heap grew past the budget.
+func (m *memoryMonitor) check(thread *starlark.Thread) {
+	stats := runtime.MemStats{}
+	runtime.ReadMemStats(&stats)
+	used := uint64(0)
+	if stats.HeapAlloc > m.baseline {
+		used = stats.HeapAlloc - m.baseline
+	}
+	if used > m.peak {
+		m.peak = used
+	}
+	if used > m.budget && !m.exceeded {
+		m.exceeded = true
+		thread.Cancel(fmt.Sprintf("resource exhausted: the memory budget of %d bytes was exceeded", m.budget))
+	}
+}
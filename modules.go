@@ -0,0 +1,394 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// moduleEntry is a module in the cache. globals and err are set once loading is complete, when done is closed.
+type moduleEntry struct {
+	done    chan struct{}
+	globals starlark.StringDict
+	err     error
+	// source is the source code of the module, kept for the recordings of the executions that load it.
+	source string
+	// loader is the thread that loads the module.
+	loader *starlark.Thread
+}
+
+func newModuleEntry(loader *starlark.Thread) *moduleEntry {
+	return &moduleEntry{done: make(chan struct{}), loader: loader}
+}
+
+// loaded returns true once loading the module is complete.
+func (entry *moduleEntry) loaded() bool {
+	select {
+	case <-entry.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// loadStackLocalKey is the thread local with the names of the modules the thread is loading, innermost last.
+// A cycle in the load graph is a module loaded again by the same thread, the modules loaded by other executions
+// at the same time are waited for instead.
+const loadStackLocalKey = "loadStack"
+
+// enterModule pushes a module on the load stack of the thread, it fails if the module is already being loaded by it.
+func enterModule(thread *starlark.Thread, name string) error {
+	stack, _ := thread.Local(loadStackLocalKey).([]string)
+	for _, loading := range stack {
+		if loading == name {
+			return fmt.Errorf("cycle in the load graph, the module %q is already being loaded", name)
+		}
+	}
+	thread.SetLocal(loadStackLocalKey, append(stack, name))
+	return nil
+}
+
+// leaveModule pops the module on top of the load stack of the thread.
+func leaveModule(thread *starlark.Thread) {
+	stack, _ := thread.Local(loadStackLocalKey).([]string)
+	thread.SetLocal(loadStackLocalKey, stack[:len(stack)-1])
+}
+
+// modules holds the source of the modules registered with register_starlark_module
+// and the cache of the executed modules, which are frozen so they can be shared by all executions.
+var modules = struct {
+	sync.Mutex
+	sources      map[string]string
+	cache        map[string]*moduleEntry
+	allowedHosts []string
+	// waiting has the module each thread waits for while another thread loads it, to detect the cycles between them.
+	waiting map[*starlark.Thread]*moduleEntry
+	// importMap rewrites the labels of the load statements into module names, see mapImport.
+	importMap map[string]string
+}{sources: map[string]string{}, cache: map[string]*moduleEntry{}, waiting: map[*starlark.Thread]*moduleEntry{}}
+
+func init() {
+	registerResetHook(func() {
+		modules.Lock()
+		defer modules.Unlock()
+		modules.sources = map[string]string{}
+		modules.cache = map[string]*moduleEntry{}
+		modules.allowedHosts = nil
+		modules.importMap = nil
+	})
+}
+
+// registerModule adds or replaces a module. The cache is cleared since other modules may depend on it.
+func registerModule(name, starlark_code string) {
+	modules.Lock()
+	defer modules.Unlock()
+	modules.sources[name] = starlark_code
+	modules.cache = map[string]*moduleEntry{}
+}
+
+// findModuleSource returns the source code of a module.
+// Modules that are not registered and have an https URL as name are fetched if their host is allowed.
+func findModuleSource(thread *starlark.Thread, name string) (string, error) {
+	modules.Lock()
+	starlark_code, ok := modules.sources[name]
+	modules.Unlock()
+	if ok {
+		return starlark_code, nil
+	}
+	if strings.HasPrefix(name, "https://") {
+		return fetchModule(thread, name)
+	}
+	return "", fmt.Errorf("the module %q has not been registered", name)
+}
+
+// isHostAllowed returns true if the host matches one of the allowed hosts.
+// An allowed host starting with "*." matches all its subdomains.
+func isHostAllowed(host string) bool {
+	modules.Lock()
+	defer modules.Unlock()
+	for _, allowed := range modules.allowedHosts {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchModule downloads the source code of a module with the javascript fetch function.
+// Waiting for the response blocks, so it is only possible in executions started by run_starlark_code_async.
+func fetchModule(thread *starlark.Thread, rawURL string) (source string, err error) {
+	if e := threadExecution(thread); e != nil {
+		start := time.Now()
+		if err := e.useQuota("load", "fetch"); err != nil {
+			e.audit(thread, "load", rawURL, start, "quota", err)
+			return "", err
+		}
+		defer func() {
+			status := "ok"
+			if err != nil {
+				status = "error"
+			}
+			e.audit(thread, "load", rawURL, start, status, err)
+		}()
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid module URL %q. Error: %q", rawURL, err)
+	}
+	if !isHostAllowed(u.Hostname()) {
+		return "", fmt.Errorf("the host %q is not in the allowed hosts of the module loader", u.Hostname())
+	}
+	if !threadCanBlock(thread) {
+		return "", fmt.Errorf("the module %q must be fetched, which is only possible with run_starlark_code_async", rawURL)
+	}
+	response, err := awaitPromise(js.Global().Call("fetch", rawURL))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the module %q. Error: %q", rawURL, err)
+	}
+	// fetch follows the redirects, which may lead to a host that isn't allowed: its response isn't read
+	if final := response.Get("url").String(); final != "" && final != rawURL {
+		u, err := url.Parse(final)
+		if err != nil || u.Scheme != "https" || !isHostAllowed(u.Hostname()) {
+			return "", fmt.Errorf("the module %q was redirected to %q, which is not in the allowed hosts of the module loader", rawURL, final)
+		}
+	}
+	if !response.Get("ok").Bool() {
+		return "", fmt.Errorf("failed to fetch the module %q. Status %d %s", rawURL, response.Get("status").Int(), response.Get("statusText").String())
+	}
+	text, err := awaitPromise(response.Call("text"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the module %q. Error: %q", rawURL, err)
+	}
+	return text.String(), nil
+}
+
+// resolveModule implements the load statement. The module is executed on the thread that loads it
+// so the execution limits and hooks also apply to the module, and its globals are cached.
+// The label is mapped to the name of the module by the import map. Registered modules take precedence over the files
+// of the virtual filesystem of the execution, which take precedence over the modules fetched over https.
+// The recordings have the modules by label, so they are replayed whatever the import map of the instance.
+func resolveModule(thread *starlark.Thread, label string) (starlark.StringDict, error) {
+	name := mapImport(label)
+	// the cache is shared by all the executions, so the capability is checked before it
+	if strings.HasPrefix(name, "https://") && !isModuleRegistered(name) && !threadGranted(thread, "fetch") {
+		return nil, fmt.Errorf("the module %q must be fetched, which requires the capability \"fetch\"", name)
+	}
+	if err := enterModule(thread, name); err != nil {
+		return nil, err
+	}
+	defer leaveModule(thread)
+	e := threadExecution(thread)
+	if e != nil && e.opts.replay != nil {
+		return e.replayModule(label)
+	}
+	if e != nil && e.opts.fs != nil && !isModuleRegistered(name) {
+		starlark_code, ok, err := e.opts.fs.readFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the module %q from the filesystem. Error: %q", name, err)
+		}
+		if ok {
+			e.recordModule(label, starlark_code, true, nil)
+			return e.loadFileModule(name, starlark_code)
+		}
+	}
+	modules.Lock()
+	entry, ok := modules.cache[name]
+	if !ok {
+		entry = newModuleEntry(thread)
+		modules.cache[name] = entry
+	}
+	modules.Unlock()
+	if ok {
+		if !entry.loaded() {
+			if !threadCanBlock(thread) {
+				// the module is being loaded by an execution waiting for a promise, which can't resume before this one returns
+				private := newModuleEntry(thread)
+				execModule(thread, label, name, private)
+				return private.globals, private.err
+			}
+			if err := waitForModule(thread, name, entry); err != nil {
+				return nil, err
+			}
+		}
+		if e != nil {
+			e.recordModule(label, entry.source, false, entry.err)
+		}
+		return entry.globals, entry.err
+	}
+	execModule(thread, label, name, entry)
+	modules.Lock()
+	defer modules.Unlock()
+	if entry.err != nil && modules.cache[name] == entry {
+		// failures are not cached, e.g. the module may be registered later
+		delete(modules.cache, name)
+	}
+	return entry.globals, entry.err
+}
+
+// execModule finds the source of a module and executes it on the thread, then completes its entry.
+func execModule(thread *starlark.Thread, label, name string, entry *moduleEntry) {
+	defer close(entry.done)
+	e := threadExecution(thread)
+	starlark_code, err := findModuleSource(thread, name)
+	if err == nil {
+		if e != nil {
+			e.addSource(name, starlark_code)
+		}
+		entry.source = starlark_code
+		entry.globals, entry.err = starlark.ExecFile(thread, name, starlark_code, predeclaredGlobals())
+	} else {
+		entry.err = err
+	}
+	if e != nil {
+		e.recordModule(label, starlark_code, false, entry.err)
+	}
+}
+
+// waitForModule blocks until another thread has loaded a module. It fails instead if that thread waits,
+// directly or through other threads, for a module this thread is loading, which would never complete.
+func waitForModule(thread *starlark.Thread, name string, entry *moduleEntry) error {
+	modules.Lock()
+	for waited := entry; waited != nil; waited = modules.waiting[waited.loader] {
+		if waited.loader == thread {
+			modules.Unlock()
+			return fmt.Errorf("cycle in the load graph, the module %q is being loaded by another execution that waits for a module loaded by this one", name)
+		}
+	}
+	modules.waiting[thread] = entry
+	modules.Unlock()
+	<-entry.done
+	modules.Lock()
+	delete(modules.waiting, thread)
+	modules.Unlock()
+	return nil
+}
+
+// mapImport returns the name of the module a load label is mapped to by the import map, the label if it isn't mapped.
+// Like the import maps of the browsers, a key equal to the label takes precedence over the keys that end with a slash,
+// which map the labels they prefix (e.g. "@stdlib//" maps "@stdlib//strings.star" to "stdlib/strings.star" if its
+// value is "stdlib/"). The longest prefix wins and the mapped name isn't mapped again.
+func mapImport(label string) string {
+	modules.Lock()
+	defer modules.Unlock()
+	if name, ok := modules.importMap[label]; ok {
+		return name
+	}
+	prefix := ""
+	for key := range modules.importMap {
+		if strings.HasSuffix(key, "/") && strings.HasPrefix(label, key) && len(key) > len(prefix) {
+			prefix = key
+		}
+	}
+	if prefix == "" {
+		return label
+	}
+	return modules.importMap[prefix] + strings.TrimPrefix(label, prefix)
+}
+
+// parseImportMap returns the importMap option of configure_starlark_module_loader, nil if it is missing.
+func parseImportMap(options js.Value) (map[string]string, error) {
+	value := getOption(options, "importMap")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if value.Type() != js.TypeObject || value.InstanceOf(jsArray) {
+		return nil, fmt.Errorf("the option \"importMap\" must be an object. Actual type %s", value.Type())
+	}
+	importMap := map[string]string{}
+	keys := jsObject.Call("keys", value)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		name := value.Get(key)
+		if key == "" || name.Type() != js.TypeString || name.String() == "" {
+			return nil, fmt.Errorf("the label %q of the option \"importMap\" must be mapped to a non empty string", key)
+		}
+		if strings.HasSuffix(key, "/") && !strings.HasSuffix(name.String(), "/") {
+			return nil, fmt.Errorf("the prefix %q of the option \"importMap\" must be mapped to a prefix ending with a slash. Actual value %q", key, name.String())
+		}
+		importMap[key] = name.String()
+	}
+	return importMap, nil
+}
+
+func isModuleRegistered(name string) bool {
+	modules.Lock()
+	defer modules.Unlock()
+	_, ok := modules.sources[name]
+	return ok
+}
+
+// loadFileModule executes a module from the virtual filesystem. Each execution has its own filesystem,
+// so these modules are cached by the execution instead of the global module cache.
+func (e *execution) loadFileModule(name, starlark_code string) (starlark.StringDict, error) {
+	if e.fileModules == nil {
+		e.fileModules = map[string]*moduleEntry{}
+	}
+	// the cycles are detected by resolveModule, so a module in the cache is loaded
+	if entry, ok := e.fileModules[name]; ok {
+		return entry.globals, entry.err
+	}
+	entry := newModuleEntry(e.thread)
+	e.fileModules[name] = entry
+	e.addSource(name, starlark_code)
+	entry.globals, entry.err = starlark.ExecFile(e.thread, name, starlark_code, e.predeclared())
+	close(entry.done)
+	return entry.globals, entry.err
+}
+
+func getModuleRegisterer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected two arguments with the module name and the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		name, starlark_code := args[0].String(), args[1].String()
+		registerModule(name, starlark_code)
+		return map[string]interface{}{"message": fmt.Sprintf("the module %q has been registered", name)}
+	})
+}
+
+func getModuleLoaderConfigurer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		options := js.Undefined()
+		if len(args) > 0 {
+			options = args[0]
+		}
+		allowedHosts, err := getStringListOption(options, "allowedHosts")
+		var importMap map[string]string
+		if err == nil {
+			importMap, err = parseImportMap(options)
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		modules.Lock()
+		defer modules.Unlock()
+		modules.allowedHosts = allowedHosts
+		modules.importMap = importMap
+		mapped := map[string]interface{}{}
+		for label, name := range importMap {
+			mapped[label] = name
+		}
+		return map[string]interface{}{"allowedHosts": toJSList(allowedHosts), "importMap": mapped}
+	})
+}
@@ -0,0 +1,410 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// The MessagePack conversions encode the arguments and the return value in a Uint8Array, which is more compact than JSON
+// and skips the syscall/js bridge too. Unlike JSON they keep the difference between 2 and 2.0, bytes are binary
+// and the keys of dicts don't have to be strings. Ext types are not supported.
+
+// convertMsgpackArgsToStarlarkValues decodes a MessagePack array into the arguments of a function call.
+func (c *converter) convertMsgpackArgsToStarlarkValues(data []byte) ([]starlark.Value, error) {
+	d := &msgpackDecoder{conv: c, data: data}
+	length, err := d.readArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+	// the array itself is not converted, so the arguments have the same depth and count as the args option
+	values := []starlark.Value{}
+	for i := 0; i < length; i++ {
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("unexpected data after the MessagePack array at offset %d", d.pos)
+	}
+	return values, nil
+}
+
+// parseMsgpackArgsOption returns a copy of the bytes of the argsMsgpack option, nil if it is missing.
+func parseMsgpackArgsOption(options js.Value) ([]byte, error) {
+	value := getOption(options, "argsMsgpack")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if !value.InstanceOf(js.Global().Get("Uint8Array")) {
+		return nil, fmt.Errorf("the option \"argsMsgpack\" must be a Uint8Array. Actual type %s", value.Type())
+	}
+	data := make([]byte, value.Length())
+	js.CopyBytesToGo(data, value)
+	return data, nil
+}
+
+type msgpackDecoder struct {
+	conv *converter
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("unexpected end of the MessagePack data at offset %d", d.pos)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// readLength reads a big endian unsigned integer of size bytes.
+func (d *msgpackDecoder) readLength(size int) (int, error) {
+	b, err := d.read(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	}
+	return int(binary.BigEndian.Uint32(b)), nil
+}
+
+func (d *msgpackDecoder) readArrayHeader() (int, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b[0] >= 0x90 && b[0] <= 0x9f:
+		return int(b[0] & 0x0f), nil
+	case b[0] == 0xdc:
+		return d.readLength(2)
+	case b[0] == 0xdd:
+		return d.readLength(4)
+	}
+	return 0, fmt.Errorf("expected a MessagePack array. Actual type byte 0x%02x", b[0])
+}
+
+func (d *msgpackDecoder) decodeString(length int) (starlark.Value, error) {
+	if err := d.conv.checkString(length); err != nil {
+		return nil, err
+	}
+	b, err := d.read(length)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(b), nil
+}
+
+func (d *msgpackDecoder) decodeBytes(length int) (starlark.Value, error) {
+	if err := d.conv.checkString(length); err != nil {
+		return nil, err
+	}
+	b, err := d.read(length)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bytes(b), nil
+}
+
+func (d *msgpackDecoder) decodeList(length int) (starlark.Value, error) {
+	list := []starlark.Value{}
+	for i := 0; i < length; i++ {
+		elem, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, elem)
+	}
+	return starlark.NewList(list), nil
+}
+
+func (d *msgpackDecoder) decodeDict(length int) (starlark.Value, error) {
+	dict := starlark.NewDict(0)
+	for i := 0; i < length; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if err := dict.SetKey(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
+// decode decodes the next MessagePack value.
+func (d *msgpackDecoder) decode() (starlark.Value, error) {
+	c := d.conv
+	c.enter()
+	defer c.leave()
+	if err := c.track(c.depth); err != nil {
+		return nil, err
+	}
+	if err := c.count(); err != nil {
+		return nil, err
+	}
+	b, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+	switch {
+	case t <= 0x7f:
+		return starlark.MakeInt(int(t)), nil
+	case t >= 0xe0:
+		return starlark.MakeInt(int(int8(t))), nil
+	case t >= 0xa0 && t <= 0xbf:
+		return d.decodeString(int(t & 0x1f))
+	case t >= 0x90 && t <= 0x9f:
+		return d.decodeList(int(t & 0x0f))
+	case t >= 0x80 && t <= 0x8f:
+		return d.decodeDict(int(t & 0x0f))
+	}
+	switch t {
+	case 0xc0:
+		return starlark.None, nil
+	case 0xc2:
+		return starlark.False, nil
+	case 0xc3:
+		return starlark.True, nil
+	case 0xcc, 0xcd, 0xce, 0xcf, 0xd0, 0xd1, 0xd2, 0xd3:
+		return d.decodeInt(t)
+	case 0xca:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.readLength(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(length)
+	case 0xc4, 0xc5, 0xc6:
+		length, err := d.readLength(1 << (t - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeBytes(length)
+	case 0xdc, 0xdd:
+		length, err := d.readLength(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeList(length)
+	case 0xde, 0xdf:
+		length, err := d.readLength(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeDict(length)
+	}
+	return nil, fmt.Errorf("unsupported MessagePack type byte 0x%02x at offset %d", t, d.pos-1)
+}
+
+func (d *msgpackDecoder) decodeInt(t byte) (starlark.Value, error) {
+	signed := t >= 0xd0
+	size := 1 << (t & 0x03)
+	b, err := d.read(size)
+	if err != nil {
+		return nil, err
+	}
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	if !signed {
+		return starlark.MakeUint64(u), nil
+	}
+	// sign extend the value from its size
+	shift := 64 - 8*uint(size)
+	return starlark.MakeInt64(int64(u<<shift) >> shift), nil
+}
+
+// convertToMsgpack encodes a starlark value as MessagePack. Values that have no equivalent are encoded as nil.
+// Ints that don't fit in 64 bits follow the intOverflow mode, except that "bigint" fails like "error".
+func (c *converter) convertToMsgpack(value starlark.Value) ([]byte, error) {
+	e := &msgpackEncoder{conv: c}
+	if err := e.encode(value); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type msgpackEncoder struct {
+	conv *converter
+	buf  []byte
+}
+
+// writeHeader writes a type byte followed by a big endian length, using the smallest of the 3 sizes that fits.
+// fixType is used for lengths below fixLimit, 0 if the type has no fix format.
+func (e *msgpackEncoder) writeHeader(fixType byte, fixLimit int, types [3]byte, length int) {
+	switch {
+	case fixType != 0 && length < fixLimit:
+		e.buf = append(e.buf, fixType|byte(length))
+	case types[0] != 0 && length <= math.MaxUint8:
+		e.buf = append(e.buf, types[0], byte(length))
+	case length <= math.MaxUint16:
+		e.buf = append(e.buf, types[1], 0, 0)
+		binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], uint16(length))
+	default:
+		e.buf = append(e.buf, types[2], 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], uint32(length))
+	}
+}
+
+func (e *msgpackEncoder) writeUint(t byte, size int, u uint64) {
+	e.buf = append(e.buf, t)
+	for i := size - 1; i >= 0; i-- {
+		e.buf = append(e.buf, byte(u>>(8*uint(i))))
+	}
+}
+
+func (e *msgpackEncoder) writeFloat(f float64) {
+	e.writeUint(0xcb, 8, math.Float64bits(f))
+}
+
+func (e *msgpackEncoder) writeString(s string) {
+	e.writeHeader(0xa0, 32, [3]byte{0xd9, 0xda, 0xdb}, len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) writeInt(v starlark.Int) error {
+	if i, ok := v.Int64(); ok {
+		switch {
+		case i >= 0 && i <= math.MaxInt8:
+			e.buf = append(e.buf, byte(i))
+		case i < 0 && i >= -32:
+			e.buf = append(e.buf, byte(int8(i)))
+		case i >= math.MinInt8 && i <= math.MaxInt8:
+			e.writeUint(0xd0, 1, uint64(i))
+		case i > 0 && i <= math.MaxUint8:
+			e.writeUint(0xcc, 1, uint64(i))
+		case i >= math.MinInt16 && i <= math.MaxInt16:
+			e.writeUint(0xd1, 2, uint64(i))
+		case i > 0 && i <= math.MaxUint16:
+			e.writeUint(0xcd, 2, uint64(i))
+		case i >= math.MinInt32 && i <= math.MaxInt32:
+			e.writeUint(0xd2, 4, uint64(i))
+		case i > 0 && i <= math.MaxUint32:
+			e.writeUint(0xce, 4, uint64(i))
+		default:
+			e.writeUint(0xd3, 8, uint64(i))
+		}
+		return nil
+	}
+	if u, ok := v.Uint64(); ok {
+		e.writeUint(0xcf, 8, u)
+		return nil
+	}
+	switch e.conv.intOverflow {
+	case "string":
+		e.writeString(v.String())
+		return nil
+	case "float":
+		f, _ := new(big.Float).SetInt(v.BigInt()).Float64()
+		e.writeFloat(f)
+		return nil
+	}
+	return &intOverflowError{value: v}
+}
+
+func (e *msgpackEncoder) encode(value starlark.Value) error {
+	c := e.conv
+	c.enter()
+	defer c.leave()
+	if err := c.track(c.depth); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case starlark.NoneType:
+		e.buf = append(e.buf, 0xc0)
+	case starlark.Bool:
+		if v {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case starlark.Int:
+		return e.writeInt(v)
+	case starlark.Float:
+		e.writeFloat(float64(v))
+	case starlark.String:
+		e.writeString(string(v))
+	case starlark.Bytes:
+		e.writeHeader(0, 0, [3]byte{0xc4, 0xc5, 0xc6}, len(v))
+		e.buf = append(e.buf, v...)
+	case *starlark.List, starlark.Tuple, *starlark.Set:
+		var elems []starlark.Value
+		if indexable, ok := v.(starlark.Indexable); ok {
+			for i := 0; i < indexable.Len(); i++ {
+				elems = append(elems, indexable.Index(i))
+			}
+		} else {
+			iter := v.(starlark.Iterable).Iterate()
+			var elem starlark.Value
+			for iter.Next(&elem) {
+				elems = append(elems, elem)
+			}
+			iter.Done()
+		}
+		// the array16 format has no 1 byte length
+		e.writeHeader(0x90, 16, [3]byte{0, 0xdc, 0xdd}, len(elems))
+		for _, elem := range elems {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case *starlark.Dict:
+		items := v.Items()
+		e.writeHeader(0x80, 16, [3]byte{0, 0xde, 0xdf}, len(items))
+		for _, item := range items {
+			if err := e.encode(item[0]); err != nil {
+				return err
+			}
+			if err := e.encode(item[1]); err != nil {
+				return err
+			}
+		}
+	default:
+		e.buf = append(e.buf, 0xc0)
+	}
+	return nil
+}
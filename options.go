@@ -0,0 +1,157 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"syscall/js"
+)
+
+// getOption returns the value of a field in an options object.
+// It returns undefined if the options are not an object or the field is missing.
+func getOption(options js.Value, key string) js.Value {
+	if options.Type() != js.TypeObject {
+		return js.Undefined()
+	}
+	return options.Get(key)
+}
+
+// getStringListOption returns the array of strings stored in a field of an options object.
+// It returns nil if the field is missing.
+func getStringListOption(options js.Value, key string) ([]string, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if !value.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("the option %q must be an array of strings. Actual type %s", key, value.Type())
+	}
+	list := []string{}
+	length := value.Length()
+	for i := 0; i < length; i++ {
+		item := value.Index(i)
+		if item.Type() != js.TypeString {
+			return nil, fmt.Errorf("the option %q must be an array of strings. Actual type of element %d is %s", key, i, item.Type())
+		}
+		list = append(list, item.String())
+	}
+	return list, nil
+}
+
+// getNumberOption returns the number stored in a field of an options object.
+// The boolean is false if the field is missing.
+func getNumberOption(options js.Value, key string) (float64, bool, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return 0, false, nil
+	}
+	if value.Type() != js.TypeNumber {
+		return 0, false, fmt.Errorf("the option %q must be a number. Actual type %s", key, value.Type())
+	}
+	return value.Float(), true, nil
+}
+
+// getStringOption returns the string stored in a field of an options object.
+// The boolean is false if the field is missing.
+func getStringOption(options js.Value, key string) (string, bool, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return "", false, nil
+	}
+	if value.Type() != js.TypeString {
+		return "", false, fmt.Errorf("the option %q must be a string. Actual type %s", key, value.Type())
+	}
+	return value.String(), true, nil
+}
+
+// getArrayOption returns the elements of the array stored in a field of an options object.
+// It returns nil if the field is missing.
+func getArrayOption(options js.Value, key string) ([]js.Value, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if !value.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("the option %q must be an array. Actual type %s", key, value.Type())
+	}
+	elements := []js.Value{}
+	length := value.Length()
+	for i := 0; i < length; i++ {
+		elements = append(elements, value.Index(i))
+	}
+	return elements, nil
+}
+
+// getBoolOption returns the boolean stored in a field of an options object, false if the field is missing.
+func getBoolOption(options js.Value, key string) (bool, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return false, nil
+	}
+	if value.Type() != js.TypeBoolean {
+		return false, fmt.Errorf("the option %q must be a boolean. Actual type %s", key, value.Type())
+	}
+	return value.Bool(), nil
+}
+
+// getFunctionOption returns the function stored in a field of an options object.
+// The boolean is false if the field is missing.
+func getFunctionOption(options js.Value, key string) (js.Value, bool, error) {
+	value := getOption(options, key)
+	if value.IsUndefined() || value.IsNull() {
+		return js.Undefined(), false, nil
+	}
+	if value.Type() != js.TypeFunction {
+		return js.Undefined(), false, fmt.Errorf("the option %q must be a function. Actual type %s", key, value.Type())
+	}
+	return value, true, nil
+}
+
+// maxSafeInteger is Number.MAX_SAFE_INTEGER, the integers above it can't be represented exactly by a javascript number.
+const maxSafeInteger = 1<<53 - 1
+
+// isSafeInteger reports whether a javascript number is an integer that converts to an int exactly, unlike Infinity or 1.5.
+func isSafeInteger(value float64) bool {
+	return value == math.Trunc(value) && math.Abs(value) <= maxSafeInteger
+}
+
+// getLimitOption returns the positive integer stored in a field of an options object, 0 if the field is missing.
+func getLimitOption(options js.Value, key string) (int, error) {
+	value, ok, err := getNumberOption(options, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if value < 1 || !isSafeInteger(value) {
+		return 0, fmt.Errorf("the option %q must be a positive integer. Actual value %v", key, value)
+	}
+	return int(value), nil
+}
+
+// getIntegerOption returns the integer stored in a field of an options object, which must be at least min.
+// The boolean is false if the field is missing.
+func getIntegerOption(options js.Value, key string, min int) (int, bool, error) {
+	value, ok, err := getNumberOption(options, key)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if !isSafeInteger(value) {
+		return 0, false, fmt.Errorf("the option %q must be an integer. Actual value %v", key, value)
+	}
+	if value < float64(min) {
+		return 0, false, fmt.Errorf("the option %q must be at least %d. Actual value %v", key, min, value)
+	}
+	return int(value), true, nil
+}
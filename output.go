@@ -0,0 +1,101 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// outputBuffer collects the output of print. Once it holds limit bytes the following output is dropped,
+// or with keepTail only the first and the last limit/2 bytes are kept, so a script that keeps printing can't grow it without bounds.
+type outputBuffer struct {
+	// limit is the maximum number of bytes kept, 0 means unlimited.
+	limit    int
+	keepTail bool
+	head     strings.Builder
+	// tail holds the latest output once the head is full, it is trimmed to the tail limit when it grows past twice that.
+	tail    []byte
+	omitted int
+	// truncated is true if some output was dropped.
+	truncated bool
+}
+
+func (o *outputBuffer) headLimit() int {
+	if o.keepTail {
+		return o.limit / 2
+	}
+	return o.limit
+}
+
+func (o *outputBuffer) WriteString(s string) {
+	if o.limit == 0 {
+		o.head.WriteString(s)
+		return
+	}
+	if n := o.headLimit() - o.head.Len(); n > 0 {
+		if n >= len(s) {
+			o.head.WriteString(s)
+			return
+		}
+		// cut at the start of a rune so the kept output stays valid UTF-8
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		o.head.WriteString(s[:n])
+		s = s[n:]
+	}
+	o.truncated = true
+	if !o.keepTail {
+		o.omitted += len(s)
+		return
+	}
+	o.tail = append(o.tail, s...)
+	if tailLimit := o.limit - o.headLimit(); len(o.tail) > 2*tailLimit {
+		o.trimTail(tailLimit)
+	}
+}
+
+// trimTail drops the start of the tail so it holds at most max bytes, starting at a rune.
+func (o *outputBuffer) trimTail(max int) {
+	drop := len(o.tail) - max
+	if drop <= 0 {
+		return
+	}
+	for drop < len(o.tail) && !utf8.RuneStart(o.tail[drop]) {
+		drop++
+	}
+	o.omitted += drop
+	o.tail = append(o.tail[:0], o.tail[drop:]...)
+}
+
+// String returns the output. If the tail is kept a line with the number of omitted bytes separates it from the head.
+func (o *outputBuffer) String() string {
+	if !o.keepTail || !o.truncated {
+		return o.head.String()
+	}
+	o.trimTail(o.limit - o.headLimit())
+	if o.omitted == 0 {
+		return o.head.String() + string(o.tail)
+	}
+	return o.head.String() + fmt.Sprintf("\n... %d bytes omitted ...\n", o.omitted) + string(o.tail)
+}
+
+// reset clears the output, the limits are kept.
+func (o *outputBuffer) reset() {
+	o.head.Reset()
+	o.tail, o.omitted, o.truncated = nil, 0, false
+}
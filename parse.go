@@ -0,0 +1,216 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"syscall/js"
+
+	"go.starlark.net/syntax"
+)
+
+func convertPositionToJSON(pos syntax.Position) map[string]interface{} {
+	return map[string]interface{}{"line": int(pos.Line), "col": int(pos.Col)}
+}
+
+func convertExprsToJSON(exprs []syntax.Expr) []interface{} {
+	nodes := []interface{}{}
+	for _, expr := range exprs {
+		nodes = append(nodes, convertNodeToJSON(expr))
+	}
+	return nodes
+}
+
+func convertStmtsToJSON(stmts []syntax.Stmt) []interface{} {
+	nodes := []interface{}{}
+	for _, stmt := range stmts {
+		nodes = append(nodes, convertNodeToJSON(stmt))
+	}
+	return nodes
+}
+
+func convertIdentsToJSON(idents []*syntax.Ident) []interface{} {
+	nodes := []interface{}{}
+	for _, ident := range idents {
+		nodes = append(nodes, convertNodeToJSON(ident))
+	}
+	return nodes
+}
+
+func convertLiteralValueToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *big.Int:
+		return v.String()
+	case int64:
+		if v != int64(float64(v)) {
+			return fmt.Sprint(v)
+		}
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+// convertNodeToJSON converts a syntax tree node into nested maps and slices
+// that js.ValueOf can turn into plain javascript objects and arrays.
+// Every node has a "kind" (the Go type name of the node) and its "start" and "end" positions.
+// Missing optional children (e.g. the result of a bare return) are converted to null.
+func convertNodeToJSON(node syntax.Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	start, end := node.Span()
+	obj := map[string]interface{}{"start": convertPositionToJSON(start), "end": convertPositionToJSON(end)}
+	switch n := node.(type) {
+	case *syntax.File:
+		obj["kind"] = "File"
+		obj["path"] = n.Path
+		obj["stmts"] = convertStmtsToJSON(n.Stmts)
+	case *syntax.AssignStmt:
+		obj["kind"] = "AssignStmt"
+		obj["op"] = n.Op.String()
+		obj["lhs"] = convertNodeToJSON(n.LHS)
+		obj["rhs"] = convertNodeToJSON(n.RHS)
+	case *syntax.BranchStmt:
+		obj["kind"] = "BranchStmt"
+		obj["token"] = n.Token.String()
+	case *syntax.DefStmt:
+		obj["kind"] = "DefStmt"
+		obj["name"] = convertNodeToJSON(n.Name)
+		obj["params"] = convertExprsToJSON(n.Params)
+		obj["body"] = convertStmtsToJSON(n.Body)
+	case *syntax.ExprStmt:
+		obj["kind"] = "ExprStmt"
+		obj["x"] = convertNodeToJSON(n.X)
+	case *syntax.ForStmt:
+		obj["kind"] = "ForStmt"
+		obj["vars"] = convertNodeToJSON(n.Vars)
+		obj["x"] = convertNodeToJSON(n.X)
+		obj["body"] = convertStmtsToJSON(n.Body)
+	case *syntax.WhileStmt:
+		obj["kind"] = "WhileStmt"
+		obj["cond"] = convertNodeToJSON(n.Cond)
+		obj["body"] = convertStmtsToJSON(n.Body)
+	case *syntax.IfStmt:
+		obj["kind"] = "IfStmt"
+		obj["cond"] = convertNodeToJSON(n.Cond)
+		obj["true"] = convertStmtsToJSON(n.True)
+		obj["false"] = convertStmtsToJSON(n.False)
+	case *syntax.LoadStmt:
+		obj["kind"] = "LoadStmt"
+		obj["module"] = convertNodeToJSON(n.Module)
+		obj["from"] = convertIdentsToJSON(n.From)
+		obj["to"] = convertIdentsToJSON(n.To)
+	case *syntax.ReturnStmt:
+		obj["kind"] = "ReturnStmt"
+		obj["result"] = convertNodeToJSON(n.Result)
+	case *syntax.BinaryExpr:
+		obj["kind"] = "BinaryExpr"
+		obj["op"] = n.Op.String()
+		obj["x"] = convertNodeToJSON(n.X)
+		obj["y"] = convertNodeToJSON(n.Y)
+	case *syntax.CallExpr:
+		obj["kind"] = "CallExpr"
+		obj["fn"] = convertNodeToJSON(n.Fn)
+		obj["args"] = convertExprsToJSON(n.Args)
+	case *syntax.Comprehension:
+		obj["kind"] = "Comprehension"
+		obj["curly"] = n.Curly
+		obj["body"] = convertNodeToJSON(n.Body)
+		clauses := []interface{}{}
+		for _, clause := range n.Clauses {
+			clauses = append(clauses, convertNodeToJSON(clause))
+		}
+		obj["clauses"] = clauses
+	case *syntax.ForClause:
+		obj["kind"] = "ForClause"
+		obj["vars"] = convertNodeToJSON(n.Vars)
+		obj["x"] = convertNodeToJSON(n.X)
+	case *syntax.IfClause:
+		obj["kind"] = "IfClause"
+		obj["cond"] = convertNodeToJSON(n.Cond)
+	case *syntax.CondExpr:
+		obj["kind"] = "CondExpr"
+		obj["cond"] = convertNodeToJSON(n.Cond)
+		obj["true"] = convertNodeToJSON(n.True)
+		obj["false"] = convertNodeToJSON(n.False)
+	case *syntax.DictEntry:
+		obj["kind"] = "DictEntry"
+		obj["key"] = convertNodeToJSON(n.Key)
+		obj["value"] = convertNodeToJSON(n.Value)
+	case *syntax.DictExpr:
+		obj["kind"] = "DictExpr"
+		obj["list"] = convertExprsToJSON(n.List)
+	case *syntax.DotExpr:
+		obj["kind"] = "DotExpr"
+		obj["x"] = convertNodeToJSON(n.X)
+		obj["name"] = convertNodeToJSON(n.Name)
+	case *syntax.Ident:
+		obj["kind"] = "Ident"
+		obj["name"] = n.Name
+	case *syntax.IndexExpr:
+		obj["kind"] = "IndexExpr"
+		obj["x"] = convertNodeToJSON(n.X)
+		obj["y"] = convertNodeToJSON(n.Y)
+	case *syntax.LambdaExpr:
+		obj["kind"] = "LambdaExpr"
+		obj["params"] = convertExprsToJSON(n.Params)
+		obj["body"] = convertNodeToJSON(n.Body)
+	case *syntax.ListExpr:
+		obj["kind"] = "ListExpr"
+		obj["list"] = convertExprsToJSON(n.List)
+	case *syntax.Literal:
+		obj["kind"] = "Literal"
+		obj["token"] = n.Token.String()
+		obj["raw"] = n.Raw
+		obj["value"] = convertLiteralValueToJSON(n.Value)
+	case *syntax.ParenExpr:
+		obj["kind"] = "ParenExpr"
+		obj["x"] = convertNodeToJSON(n.X)
+	case *syntax.SliceExpr:
+		obj["kind"] = "SliceExpr"
+		obj["x"] = convertNodeToJSON(n.X)
+		obj["lo"] = convertNodeToJSON(n.Lo)
+		obj["hi"] = convertNodeToJSON(n.Hi)
+		obj["step"] = convertNodeToJSON(n.Step)
+	case *syntax.TupleExpr:
+		obj["kind"] = "TupleExpr"
+		obj["list"] = convertExprsToJSON(n.List)
+	case *syntax.UnaryExpr:
+		obj["kind"] = "UnaryExpr"
+		obj["op"] = n.Op.String()
+		obj["x"] = convertNodeToJSON(n.X) // nil for a bare * in parameter lists
+	default:
+		obj["kind"] = fmt.Sprintf("%T", node)
+	}
+	return obj
+}
+
+func getStarlarkParser() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		starlark_code := args[0].String()
+		f, err := syntax.Parse("", starlark_code, 0)
+		if err != nil {
+			err := fmt.Errorf("Error: failed to parse the starlark code. Error: %q", err)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		return map[string]interface{}{"ast": convertNodeToJSON(f)}
+	})
+}
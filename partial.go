@@ -0,0 +1,217 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// partialFunction is a starlark function with some of its arguments bound ahead of time, made by the partial method of a function proxy.
+// The bound positional arguments come before the ones of the call and the keyword arguments of the call override the bound ones.
+type partialFunction struct {
+	fn     starlark.Callable
+	args   starlark.Tuple
+	kwargs []starlark.Tuple
+}
+
+func (p *partialFunction) Name() string          { return p.fn.Name() }
+func (p *partialFunction) String() string        { return fmt.Sprintf("<partial %s>", p.fn.Name()) }
+func (p *partialFunction) Type() string          { return "partial" }
+func (p *partialFunction) Freeze()               {}
+func (p *partialFunction) Truth() starlark.Bool  { return true }
+func (p *partialFunction) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: partial") }
+
+func (p *partialFunction) CallInternal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	allArgs := append(append(starlark.Tuple{}, p.args...), args...)
+	allKwargs := []starlark.Tuple{}
+	for _, kwarg := range p.kwargs {
+		if !hasKeyword(kwargs, string(kwarg[0].(starlark.String))) {
+			allKwargs = append(allKwargs, kwarg)
+		}
+	}
+	return starlark.Call(thread, p.fn, allArgs, append(allKwargs, kwargs...))
+}
+
+func hasKeyword(kwargs []starlark.Tuple, name string) bool {
+	for _, kwarg := range kwargs {
+		if string(kwarg[0].(starlark.String)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bind returns the function with more arguments bound, a partial of a partial has the arguments of both.
+// The bound values are frozen since they are shared by every call.
+func bind(fn starlark.Callable, args starlark.Tuple, kwargs []starlark.Tuple) *partialFunction {
+	for _, arg := range args {
+		arg.Freeze()
+	}
+	for _, kwarg := range kwargs {
+		kwarg[1].Freeze()
+	}
+	p, ok := fn.(*partialFunction)
+	if !ok {
+		return &partialFunction{fn: fn, args: args, kwargs: kwargs}
+	}
+	kept := []starlark.Tuple{}
+	for _, kwarg := range p.kwargs {
+		if !hasKeyword(kwargs, string(kwarg[0].(starlark.String))) {
+			kept = append(kept, kwarg)
+		}
+	}
+	return &partialFunction{fn: p.fn, args: append(append(starlark.Tuple{}, p.args...), args...), kwargs: append(kept, kwargs...)}
+}
+
+// partial handles the partial method of a function proxy: fn.partial(args, kwargs) binds an array of positional arguments
+// and an object of keyword arguments (both optional) and returns {function} with a new proxy, or an error result.
+func (p *functionProxy) partial(args []js.Value) map[string]interface{} {
+	var positional js.Value
+	if len(args) > 0 && !args[0].IsUndefined() && !args[0].IsNull() {
+		if !args[0].InstanceOf(js.Global().Get("Array")) {
+			return invalidArgumentsResult(fmt.Errorf("the positional arguments must be an array. Actual type %s", args[0].Type()))
+		}
+		positional = args[0]
+	}
+	e := newExecution(runOptions{})
+	bound := starlark.Tuple{}
+	for i := 0; !positional.IsUndefined() && i < positional.Length(); i++ {
+		value, err := e.conv.convertToStarlarkValue(positional.Index(i))
+		if err != nil {
+			return invalidArgumentsResult(err)
+		}
+		bound = append(bound, value)
+	}
+	kwargs := []starlark.Tuple{}
+	if len(args) > 1 && !args[1].IsUndefined() && !args[1].IsNull() {
+		if args[1].Type() != js.TypeObject || args[1].InstanceOf(js.Global().Get("Array")) {
+			return invalidArgumentsResult(fmt.Errorf("the keyword arguments must be an object. Actual type %s", args[1].Type()))
+		}
+		value, err := e.conv.convertToStarlarkValue(args[1])
+		if err != nil {
+			return invalidArgumentsResult(err)
+		}
+		dict, ok := value.(*starlark.Dict)
+		if !ok {
+			return invalidArgumentsResult(fmt.Errorf("the keyword arguments must be a plain object. Actual type %s", value.Type()))
+		}
+		for _, item := range dict.Items() {
+			kwargs = append(kwargs, starlark.Tuple{item[0], item[1]})
+		}
+	}
+	return map[string]interface{}{"function": newFunctionProxy(bind(p.fn, bound, kwargs), p.session, p.sources)}
+}
+
+// signature handles the signature method of a function proxy. It describes the parameters of the function,
+// the ones bound by partial are marked bound. Defaults are the source text of the default expressions,
+// they are missing if the source code of the function is unknown. Builtins only have a name and a signature.
+func (p *functionProxy) signature() map[string]interface{} {
+	fn, partial := p.fn, &partialFunction{}
+	if bound, ok := fn.(*partialFunction); ok {
+		fn, partial = bound.fn, bound
+	}
+	result := map[string]interface{}{"name": fn.Name(), "signature": functionSignature(fn.Name(), fn)}
+	f, ok := fn.(*starlark.Function)
+	if !ok {
+		return result
+	}
+	defaults := p.defaults(f)
+	numPositional := f.NumParams() - f.NumKwonlyParams()
+	if f.HasVarargs() {
+		numPositional--
+	}
+	if f.HasKwargs() {
+		numPositional--
+	}
+	// The parameters are listed in the order of the signature, the keyword-only ones come after *args.
+	order := []int{}
+	for i := 0; i < numPositional; i++ {
+		order = append(order, i)
+	}
+	next := numPositional + f.NumKwonlyParams()
+	if f.HasVarargs() {
+		order = append(order, next)
+		next++
+	}
+	for i := numPositional; i < numPositional+f.NumKwonlyParams(); i++ {
+		order = append(order, i)
+	}
+	if f.HasKwargs() {
+		order = append(order, next)
+	}
+	params := []interface{}{}
+	for _, i := range order {
+		name, _ := f.Param(i)
+		kind := "positional"
+		switch {
+		case i == numPositional+f.NumKwonlyParams() && f.HasVarargs():
+			kind = "varargs"
+		case i >= numPositional+f.NumKwonlyParams():
+			kind = "kwargs"
+		case i >= numPositional:
+			kind = "keyword_only"
+		}
+		param := map[string]interface{}{"name": name, "kind": kind}
+		if value, ok := defaults[name]; ok {
+			param["default"] = value
+		}
+		if (kind == "positional" && i < len(partial.args)) || hasKeyword(partial.kwargs, name) {
+			param["bound"] = true
+		}
+		params = append(params, param)
+	}
+	result["params"] = params
+	return result
+}
+
+// defaults returns the source text of the default values of the parameters of a function, by parameter name.
+func (p *functionProxy) defaults(f *starlark.Function) map[string]string {
+	defaults := map[string]string{}
+	pos := f.Position()
+	starlark_code, ok := lookupSource(p.sources, p.session, pos.Filename())
+	if !ok {
+		return defaults
+	}
+	file, err := syntax.Parse(pos.Filename(), starlark_code, 0)
+	if err != nil {
+		return defaults
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		var params []syntax.Expr
+		switch n := node.(type) {
+		case *syntax.DefStmt:
+			if n.Def.Line == pos.Line && n.Def.Col == pos.Col {
+				params = n.Params
+			}
+		case *syntax.LambdaExpr:
+			if n.Lambda.Line == pos.Line && n.Lambda.Col == pos.Col {
+				params = n.Params
+			}
+		}
+		for _, param := range params {
+			if assign, ok := param.(*syntax.BinaryExpr); ok && assign.Op == syntax.EQ {
+				f := formatter{}
+				f.expr(assign.Y)
+				defaults[assign.X.(*syntax.Ident).Name] = f.out.String()
+			}
+		}
+		return params == nil
+	})
+	return defaults
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// runPipeline calls the functions in order, the first one with the arguments of the execution
+// and each following one with the return value of the previous one as its only argument.
+// The intermediate values stay in Go, only the last return value is converted.
+func (e *execution) runPipeline(globals starlark.StringDict, funcArgs []starlark.Value) (starlark.Value, map[string]interface{}) {
+	var returnValue starlark.Value
+	for stage, funcName := range e.opts.pipeline {
+		fn, errResult := lookupFunction(globals, funcName, "starlark code")
+		if errResult == nil {
+			returnValue, errResult = callStarlarkFunction(e, funcName, fn, funcArgs)
+		}
+		if errResult != nil {
+			errResult["error"] = fmt.Sprintf("Error: the stage %d (%s) of the pipeline failed. %s", stage, funcName, errResult["error"])
+			details, _ := errResult["details"].(map[string]interface{})
+			if details == nil {
+				details = map[string]interface{}{}
+			}
+			details["stage"] = stage
+			details["funcName"] = funcName
+			errResult["details"] = details
+			return nil, errResult
+		}
+		funcArgs = []starlark.Value{returnValue}
+	}
+	return returnValue, nil
+}
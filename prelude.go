@@ -0,0 +1,148 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// preludes holds the frozen globals of the registered preludes and the registered builtins (see registerBuiltin and registerJSBuiltin).
+// predeclared is rebuilt whenever a prelude is registered and is never modified afterwards,
+// so executions can use it without holding the lock. It is nil when it has to be rebuilt.
+var preludes = struct {
+	sync.Mutex
+	globals     starlark.StringDict
+	builtins    starlark.StringDict
+	jsBuiltins  map[string]jsBuiltin
+	predeclared starlark.StringDict
+}{globals: starlark.StringDict{}, builtins: starlark.StringDict{}, jsBuiltins: map[string]jsBuiltin{}}
+
+func init() {
+	registerResetHook(func() {
+		preludes.Lock()
+		defer preludes.Unlock()
+		preludes.globals = starlark.StringDict{}
+		preludes.jsBuiltins = map[string]jsBuiltin{}
+		preludes.predeclared = nil
+	})
+}
+
+// predeclaredGlobals returns the predeclared environment of every execution:
+// the universal builtins, the builtins registered by Go and the globals of the registered preludes.
+func predeclaredGlobals() starlark.StringDict {
+	preludes.Lock()
+	defer preludes.Unlock()
+	if preludes.predeclared == nil {
+		rebuildPredeclared()
+	}
+	return preludes.predeclared
+}
+
+// registerPrelude executes the starlark code and adds its globals to the predeclared environment of the following executions.
+// Globals of later preludes replace the globals of earlier ones with the same name.
+func registerPrelude(starlark_code string) (result map[string]interface{}) {
+	e := newExecution(runOptions{})
+	defer e.recoverPanic(&result)
+	e.addSource("prelude", starlark_code)
+	globals, err := starlark.ExecFile(e.thread, "prelude", starlark_code, predeclaredGlobals())
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark prelude", err))
+	}
+	preludes.Lock()
+	defer preludes.Unlock()
+	for name, value := range globals {
+		preludes.globals[name] = value
+	}
+	names := rebuildPredeclared()
+	return e.finish(map[string]interface{}{"message": e.output.String(), "globals": toJSList(names)})
+}
+
+// rebuildPredeclared replaces the predeclared environment with the universal builtins, the builtins registered by Go and the globals of the preludes
+// and returns the sorted names of the globals. Must be called with the preludes lock held.
+func rebuildPredeclared() []string {
+	predeclared := starlark.StringDict{}
+	for _, builtins := range []starlark.StringDict{checkedUniverse, preludes.builtins} {
+		for name, value := range builtins {
+			predeclared[name] = value
+		}
+	}
+	names := []string{}
+	for name, value := range preludes.globals {
+		predeclared[name] = value
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	preludes.predeclared = predeclared
+	return names
+}
+
+// publishData converts the value once, freezes it and adds it to the predeclared environment of the following executions and sessions
+// like a global of a prelude, so large read only data doesn't have to be passed (and converted) again for every execution.
+func publishData(name string, value js.Value) (map[string]interface{}, error) {
+	if !isIdentifier(name) {
+		return nil, fmt.Errorf("the name %q is not a valid starlark identifier", name)
+	}
+	conv := &converter{}
+	data, err := conv.convertToStarlarkValue(value)
+	if err != nil {
+		return nil, err
+	}
+	data.Freeze()
+	preludes.Lock()
+	defer preludes.Unlock()
+	preludes.globals[name] = data
+	names := rebuildPredeclared()
+	return map[string]interface{}{"message": fmt.Sprintf("the data %q has been published", name), "globals": toJSList(names), "conversionDepth": conv.maxDepth}, nil
+}
+
+func isIdentifier(name string) bool {
+	expr, err := syntax.ParseExpr("", name, 0)
+	if err != nil {
+		return false
+	}
+	ident, ok := expr.(*syntax.Ident)
+	return ok && ident.Name == name
+}
+
+func getPreludeRegisterer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		return registerPrelude(args[0].String())
+	})
+}
+
+func getDataPublisher() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 || args[0].Type() != js.TypeString {
+			err := fmt.Errorf("Error: expected two arguments with the name and the value. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		result, err := publishData(args[0].String(), args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: failed to publish the data. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		return result
+	})
+}
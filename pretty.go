@@ -0,0 +1,347 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// prettyMaxBytes bounds the text of a pretty printed value whatever the options, the rest is elided.
+const prettyMaxBytes = 1 << 20
+
+// prettyOptions control the rendering of the pretty printer.
+type prettyOptions struct {
+	// maxDepth is the nesting depth past which the containers are elided, e.g. [...].
+	maxDepth int
+	// width is the length of the lines: a container that doesn't fit on the rest of its line is broken into one element per line.
+	width int
+	// maxItems is the maximum number of elements rendered by container, maxStringLength the maximum length of the strings in bytes.
+	maxItems        int
+	maxStringLength int
+	indent          int
+}
+
+var defaultPrettyOptions = prettyOptions{maxDepth: 8, width: 80, maxItems: 100, maxStringLength: 200, indent: 2}
+
+// parsePrettyOptions returns the options of the pretty printer in an options object, the defaults for the missing ones.
+func parsePrettyOptions(options js.Value) (prettyOptions, error) {
+	opts := defaultPrettyOptions
+	for _, option := range []struct {
+		key   string
+		value *int
+	}{
+		{"maxDepth", &opts.maxDepth},
+		{"width", &opts.width},
+		{"maxItems", &opts.maxItems},
+		{"maxStringLength", &opts.maxStringLength},
+		{"indent", &opts.indent},
+	} {
+		value, err := getLimitOption(options, option.key)
+		if err != nil {
+			return opts, err
+		}
+		if value > 0 {
+			*option.value = value
+		}
+	}
+	return opts, nil
+}
+
+// parseReturnPrettyOption returns the returnPretty option, true for the default options or an object with the options.
+// It returns nil if the option is missing or false.
+func parseReturnPrettyOption(options js.Value) (*prettyOptions, error) {
+	value := getOption(options, "returnPretty")
+	switch {
+	case value.IsUndefined() || value.IsNull():
+		return nil, nil
+	case value.Type() == js.TypeBoolean:
+		if !value.Bool() {
+			return nil, nil
+		}
+		opts := defaultPrettyOptions
+		return &opts, nil
+	case value.Type() == js.TypeObject:
+		opts, err := parsePrettyOptions(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid \"returnPretty\" option: %w", err)
+		}
+		return &opts, nil
+	}
+	return nil, fmt.Errorf("the option \"returnPretty\" must be a boolean or an object. Actual type %s", value.Type())
+}
+
+// prettyPrinter renders a starlark value as indented text, without converting it.
+// The containers are rendered on one line if they fit in the width, otherwise with one element per line and a trailing comma.
+type prettyPrinter struct {
+	opts      prettyOptions
+	out       strings.Builder
+	truncated bool
+}
+
+// prettyContainer is a list, tuple, dict, set or struct being rendered: the delimiters and the number of elements,
+// each one with its prefix (the key of a dict entry or the name of a struct field).
+type prettyContainer struct {
+	open, close string
+	length      int
+	// elements calls f with the prefix and the value of the elements in order until it returns false,
+	// so only the elements that are rendered are visited.
+	elements func(f func(prefix string, value starlark.Value) bool)
+	// singleton is set for the tuples of one element, which keep their trailing comma on one line.
+	singleton bool
+}
+
+func prettyPrint(value starlark.Value, opts prettyOptions) (string, bool) {
+	p := &prettyPrinter{opts: opts}
+	p.write(value, 0, 0)
+	return p.out.String(), p.truncated
+}
+
+// iterateElements calls f with the elements of an iterable until it returns false.
+func iterateElements(iterable starlark.Iterable, f func(prefix string, value starlark.Value) bool) {
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var element starlark.Value
+	for iter.Next(&element) {
+		if !f("", element) {
+			return
+		}
+	}
+}
+
+// container returns the elements of a container at the depth, false for the other values.
+func (p *prettyPrinter) container(value starlark.Value, depth int) (prettyContainer, bool) {
+	switch v := value.(type) {
+	case *starlark.List:
+		return prettyContainer{open: "[", close: "]", length: v.Len(), elements: func(f func(string, starlark.Value) bool) {
+			iterateElements(v, f)
+		}}, true
+	case starlark.Tuple:
+		return prettyContainer{open: "(", close: ")", length: len(v), singleton: len(v) == 1, elements: func(f func(string, starlark.Value) bool) {
+			iterateElements(v, f)
+		}}, true
+	case *starlark.Dict:
+		return prettyContainer{open: "{", close: "}", length: v.Len(), elements: func(f func(string, starlark.Value) bool) {
+			iterateElements(v, func(_ string, key starlark.Value) bool {
+				value, _, _ := v.Get(key)
+				return f(p.flatString(key, depth+1)+": ", value)
+			})
+		}}, true
+	case *starlark.Set:
+		return prettyContainer{open: "set([", close: "])", length: v.Len(), elements: func(f func(string, starlark.Value) bool) {
+			iterateElements(v, f)
+		}}, true
+	case *starlarkstruct.Struct:
+		names := v.AttrNames()
+		sort.Strings(names)
+		return prettyContainer{open: "struct(", close: ")", length: len(names), elements: func(f func(string, starlark.Value) bool) {
+			for _, name := range names {
+				field, err := v.Attr(name)
+				if err == nil && !f(name+" = ", field) {
+					return
+				}
+			}
+		}}, true
+	}
+	return prettyContainer{}, false
+}
+
+// scalar returns the repr of a value that isn't a container, with the long strings truncated.
+func (p *prettyPrinter) scalar(value starlark.Value) string {
+	max := p.opts.maxStringLength
+	switch v := value.(type) {
+	case starlark.String:
+		if len(v) > max {
+			p.truncated = true
+			return starlark.String(strings.ToValidUTF8(string(v[:max]), "")).String() + "..."
+		}
+	case starlark.Bytes:
+		if len(v) > max {
+			p.truncated = true
+			return v[:max].String() + "..."
+		}
+	}
+	repr := value.String()
+	if len(repr) > max {
+		p.truncated = true
+		return strings.ToValidUTF8(repr[:max], "") + "..."
+	}
+	return repr
+}
+
+// elided returns the placeholder of a container deeper than maxDepth.
+func (p *prettyPrinter) elided(c prettyContainer) string {
+	p.truncated = true
+	if c.length == 0 {
+		return c.open + c.close
+	}
+	return c.open + "..." + c.close
+}
+
+// flatString renders a value on one line.
+func (p *prettyPrinter) flatString(value starlark.Value, depth int) string {
+	var b strings.Builder
+	p.flat(&b, value, depth, -1)
+	return b.String()
+}
+
+// flat renders a value on one line. It returns false as soon as the text is longer than the budget (unless it is negative),
+// so huge containers aren't rendered just to find out they don't fit.
+func (p *prettyPrinter) flat(b *strings.Builder, value starlark.Value, depth, budget int) bool {
+	c, ok := p.container(value, depth)
+	switch {
+	case !ok:
+		b.WriteString(p.scalar(value))
+	case depth >= p.opts.maxDepth:
+		b.WriteString(p.elided(c))
+	default:
+		b.WriteString(c.open)
+		i, fits := 0, true
+		c.elements(func(prefix string, element starlark.Value) bool {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if i == p.opts.maxItems {
+				p.truncated = true
+				fmt.Fprintf(b, "... %d more", c.length-i)
+				return false
+			}
+			b.WriteString(prefix)
+			fits = p.flat(b, element, depth+1, budget)
+			i++
+			return fits
+		})
+		if !fits {
+			return false
+		}
+		if c.singleton {
+			b.WriteString(",")
+		}
+		b.WriteString(c.close)
+	}
+	return budget < 0 || b.Len() <= budget
+}
+
+// write renders a value that starts at the column col of a line indented for the depth.
+func (p *prettyPrinter) write(value starlark.Value, depth, col int) {
+	c, ok := p.container(value, depth)
+	if !ok || depth >= p.opts.maxDepth || c.length == 0 {
+		p.out.WriteString(p.flatString(value, depth))
+		return
+	}
+	var b strings.Builder
+	if p.flat(&b, value, depth, p.opts.width-col) {
+		p.out.WriteString(b.String())
+		return
+	}
+	indent := strings.Repeat(" ", (depth+1)*p.opts.indent)
+	p.out.WriteString(c.open + "\n")
+	i := 0
+	c.elements(func(prefix string, element starlark.Value) bool {
+		if p.out.Len() > prettyMaxBytes {
+			p.truncated = true
+			p.out.WriteString(indent + "...\n")
+			return false
+		}
+		if i == p.opts.maxItems {
+			p.truncated = true
+			fmt.Fprintf(&p.out, "%s... %d more\n", indent, c.length-i)
+			return false
+		}
+		p.out.WriteString(indent + prefix)
+		p.write(element, depth+1, len(indent)+len(prefix))
+		p.out.WriteString(",\n")
+		i++
+		return true
+	})
+	p.out.WriteString(strings.Repeat(" ", depth*p.opts.indent) + c.close)
+}
+
+// prettyOperand returns the starlark value to render: the dict of a lazy dict or the function of a proxy without converting them,
+// the converted returnValue of a script result (an object with stats) or the converted value itself.
+// release is called once the value is rendered, it releases the session of a lazy dict, which fails if the session is busy.
+func prettyOperand(value js.Value) (v starlark.Value, release func(), err error) {
+	// a lazy dict is looked up before the fields of a script result are read, which would read its keys
+	d := findLazyDict(value)
+	if d == nil {
+		value = diffOperand(value)
+		d = findLazyDict(value)
+	}
+	if d != nil {
+		unlock, err := d.lock()
+		return d.dict, unlock, err
+	}
+	if value.Type() == js.TypeFunction {
+		proxies.Lock()
+		for p := range proxies.all {
+			if p.call.Value.Equal(value) {
+				proxies.Unlock()
+				return p.fn, func() {}, nil
+			}
+		}
+		proxies.Unlock()
+	}
+	converted, err := (&converter{}).convertToStarlarkValue(value)
+	return converted, func() {}, err
+}
+
+// findLazyDict returns the lazy dict of a proxy, nil if the value isn't one.
+func findLazyDict(value js.Value) *lazyDict {
+	if value.Type() != js.TypeObject {
+		return nil
+	}
+	lazyDicts.Lock()
+	defer lazyDicts.Unlock()
+	for _, d := range lazyDicts.byID {
+		if d.proxy.Equal(value) {
+			return d
+		}
+	}
+	return nil
+}
+
+func getValuePrettyPrinter() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the value to print. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		opts, err := parsePrettyOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		value, release, err := prettyOperand(args[0])
+		if busy, ok := err.(*sessionBusyError); ok {
+			return busy.errorResult()
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: failed to convert the value. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		defer release()
+		text, truncated := prettyPrint(value, opts)
+		return map[string]interface{}{"text": text, "truncated": truncated}
+	})
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// reportProgress is the report_progress(fraction, message = "") builtin. It passes the progress of the script
+// to the onProgress callback of the execution and does nothing if there is none.
+var reportProgress = starlark.NewBuiltin("report_progress", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	checkpoint(thread)
+	var value starlark.Value
+	var message string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "fraction", &value, "message?", &message); err != nil {
+		return nil, err
+	}
+	fraction, ok := starlark.AsFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%s: the fraction must be a number. Actual type %s", b.Name(), value.Type())
+	}
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("%s: the fraction must be between 0 and 1. Actual value %v", b.Name(), fraction)
+	}
+	e := threadExecution(thread)
+	if e == nil || e.opts.onProgress.IsUndefined() {
+		return starlark.None, nil
+	}
+	e.opts.onProgress.Invoke(map[string]interface{}{"fraction": fraction, "message": message, "steps": float64(thread.ExecutionSteps())})
+	return starlark.None, nil
+})
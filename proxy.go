@@ -0,0 +1,125 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// functionProxy is a javascript function that calls a starlark function returned by a script.
+// Calling the proxy runs the function in a new execution and returns the same result object as run_starlark_code.
+// Functions returned by a session run in the session, which must not be busy, since they may modify its globals.
+// The proxy also has the partial and signature methods, see partial.go.
+type functionProxy struct {
+	fn      starlark.Callable
+	session *session
+	// sources are the source codes of the execution that returned the function, for the defaults of its parameters.
+	sources     map[string]string
+	call        js.Func
+	release     js.Func
+	partialFn   js.Func
+	signatureFn js.Func
+}
+
+// proxies are the function proxies that have not been released, they are released by starlark_reset
+// and the ones of a session when it is destroyed.
+var proxies = struct {
+	sync.Mutex
+	all map[*functionProxy]bool
+}{all: map[*functionProxy]bool{}}
+
+func init() {
+	registerResetHook(func() {
+		releaseProxies(nil)
+	})
+}
+
+// newFunctionProxy wraps the starlark function in a javascript function with a release method.
+// s is the session that returned the function, nil if it was returned by another execution.
+func newFunctionProxy(fn starlark.Callable, s *session, sources map[string]string) js.Value {
+	p := &functionProxy{fn: fn, session: s, sources: sources}
+	p.call = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return p.invoke(args)
+	})
+	p.release = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		proxies.Lock()
+		defer proxies.Unlock()
+		p.free()
+		return nil
+	})
+	p.partialFn = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return p.partial(args)
+	})
+	p.signatureFn = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return p.signature()
+	})
+	p.call.Set("release", p.release)
+	p.call.Set("partial", p.partialFn)
+	p.call.Set("signature", p.signatureFn)
+	proxies.Lock()
+	defer proxies.Unlock()
+	proxies.all[p] = true
+	return p.call.Value
+}
+
+// free must be called with the proxies lock held.
+func (p *functionProxy) free() {
+	if !proxies.all[p] {
+		return
+	}
+	delete(proxies.all, p)
+	p.call.Release()
+	p.release.Release()
+	p.partialFn.Release()
+	p.signatureFn.Release()
+}
+
+// releaseProxies releases the proxies of a session, or all of them if the session is nil.
+func releaseProxies(s *session) {
+	proxies.Lock()
+	defer proxies.Unlock()
+	for p := range proxies.all {
+		if s == nil || p.session == s {
+			p.free()
+		}
+	}
+}
+
+func (p *functionProxy) invoke(args []js.Value) (result map[string]interface{}) {
+	e := newExecution(runOptions{funcName: p.fn.Name(), args: args, returnFunctions: true})
+	defer e.recoverPanic(&result)
+	if p.session != nil {
+		if busy := p.session.acquire(); busy != nil {
+			return e.finish(busy.errorResult())
+		}
+		defer p.session.release()
+	}
+	e.session = p.session
+	if errResult := e.sessionQuotaErrorResult(); errResult != nil {
+		return e.finish(errResult)
+	}
+	funcArgs, err := e.convertArgs()
+	if err != nil {
+		return e.finish(invalidArgumentsResult(err))
+	}
+	returnValue, errResult := callStarlarkFunction(e, p.fn.Name(), p.fn, funcArgs)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	return e.finish(e.withReturnValue(map[string]interface{}{"message": e.output.String()}, returnValue))
+}
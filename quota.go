@@ -0,0 +1,110 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+)
+
+// The hostCallQuotas option limits the number of calls an execution makes to the host, so a buggy script can't hammer
+// the services behind the host builtins. The keys are the name of a builtin ("kv.get"), of a module ("kv"),
+// of a capability ("fetch", "storage") or "*" for all the calls to the host, and each call counts against all the keys it matches.
+
+// quotaExceededError is returned by a call to the host that exceeds one of the hostCallQuotas.
+type quotaExceededError struct {
+	// quota is the key of the quota that was exceeded, name the builtin that was called.
+	quota string
+	max   int
+	name  string
+}
+
+func (err *quotaExceededError) Error() string {
+	return fmt.Sprintf("%s: the quota of %d calls of %q was exceeded", err.name, err.max, err.quota)
+}
+
+// errorResult returns the error result of an execution that exceeded a quota.
+func (err *quotaExceededError) errorResult() map[string]interface{} {
+	message := fmt.Errorf("Error: resource exhausted. The execution exceeded the quota of %d calls of %q when it called %s.", err.max, err.quota, err.name)
+	return map[string]interface{}{
+		"error":     message.Error(),
+		"errorCode": "resource_exhausted",
+		"details":   map[string]interface{}{"quota": err.quota, "max": err.max, "name": err.name},
+	}
+}
+
+func parseQuotasOption(options js.Value) (map[string]int, error) {
+	value := getOption(options, "hostCallQuotas")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if value.Type() != js.TypeObject || value.InstanceOf(jsArray) {
+		return nil, fmt.Errorf("the option \"hostCallQuotas\" must be an object. Actual type %s", value.Type())
+	}
+	quotas := map[string]int{}
+	keys := jsObject.Call("keys", value)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		max := value.Get(key)
+		if max.Type() != js.TypeNumber {
+			return nil, fmt.Errorf("the quota %q of the option \"hostCallQuotas\" must be a non negative integer. Actual type %s", key, max.Type())
+		}
+		if max.Float() < 0 || !isSafeInteger(max.Float()) {
+			return nil, fmt.Errorf("the quota %q of the option \"hostCallQuotas\" must be a non negative integer. Actual value %v", key, max.Float())
+		}
+		quotas[key] = max.Int()
+	}
+	return quotas, nil
+}
+
+// hostCallKeys returns the keys a call to the host matches: the name of the builtin, of its module, its capability and "*".
+func hostCallKeys(name, capability string) []string {
+	keys := []string{name}
+	if i := strings.Index(name, "."); i >= 0 {
+		keys = append(keys, name[:i])
+	}
+	return append(keys, capability, "*")
+}
+
+// useQuota counts a call to the host against the quotas it matches and fails if one of them is exceeded.
+// The first error is kept by the execution, so finish can return the structured quota error.
+func (e *execution) useQuota(name, capability string) error {
+	if e.opts.hostCallQuotas == nil {
+		return nil
+	}
+	if e.hostCallCounts == nil {
+		e.hostCallCounts = map[string]int{}
+	}
+	seen := map[string]bool{}
+	for _, key := range hostCallKeys(name, capability) {
+		max, ok := e.opts.hostCallQuotas[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		if e.hostCallCounts[key] >= max {
+			err := &quotaExceededError{quota: key, max: max, name: name}
+			if e.quotaErr == nil {
+				e.quotaErr = err
+			}
+			return err
+		}
+	}
+	for key := range seen {
+		e.hostCallCounts[key]++
+	}
+	return nil
+}
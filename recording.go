@@ -0,0 +1,452 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall/js"
+	"time"
+
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// The record option captures everything an execution of run_starlark_code_with_options or run_starlark_code_async
+// reads from outside the code into a recording: the options that change how the code runs, the arguments, the modules
+// it loads and, in order, the calls it makes to the host with their results (the builtins registered by javascript,
+// the time module and the clock, the file builtins, input, intl and archive). The runtime has no source of randomness
+// of its own, random values can only come from the host and are recorded with the calls.
+// replay_starlark_recording runs the code of a recording again, answering the calls to the host from the recording
+// instead of making them, and reports where the replay diverged from the recorded execution, if it did.
+
+const (
+	recordingFormat  = "starlark-recording"
+	recordingVersion = 1
+)
+
+// recordedOptionKeys are the options copied into the recordings. The callbacks, the signals and the
+// host objects are left out, the values they give to the code are recorded with the calls to the host.
+var recordedOptionKeys = []string{
+	"funcName", "filename", "script", "pipeline", "env", "capabilities", "timeModule", "fileBuiltins", "locale",
+	"freezeArgs", "numbersAsFloats", "intOverflow", "invalidUnicode", "returnBinary", "returnRepr", "returnPretty", "maxSteps", "maxOutputBytes", "keepOutputTail",
+	"maxConversionDepth", "maxArgumentElements", "maxArgumentStringBytes", "maxSourceBytes", "maxSyntaxNodes", "maxCallDepth", "allowWhile",
+	"hostCallQuotas", "dryRun", "dryRunAllow", "logLevel",
+}
+
+// recordedBuiltins are the builtins that read the host or the options without being host builtins (see hostCalls),
+// their calls are recorded too.
+var recordedBuiltins = []string{"input", "intl", "archive"}
+
+// recordedOutcomeFields are the fields of the results compared by the replays.
+var recordedOutcomeFields = []string{"message", "returnValue", "error"}
+
+// recording is the serialized form of a recorded execution.
+type recording struct {
+	Format     string                    `json:"format"`
+	Version    int                       `json:"version"`
+	Code       string                    `json:"code"`
+	Options    json.RawMessage           `json:"options"`
+	ArgsTagged []taggedValue             `json:"argsTagged"`
+	Modules    map[string]recordedModule `json:"modules"`
+	Calls      []recordedCall            `json:"calls"`
+	Outcome    map[string]interface{}    `json:"outcome"`
+	// Unserializable is set when the arguments couldn't be recorded, such a recording can't be replayed.
+	Unserializable string `json:"unserializable,omitempty"`
+}
+
+// recordedModule is a module loaded by the recorded execution, it has the error instead of the source if the load failed.
+type recordedModule struct {
+	Source     string  `json:"source,omitempty"`
+	Filesystem bool    `json:"filesystem,omitempty"`
+	Error      *string `json:"error,omitempty"`
+}
+
+// recordedCall is a call to the host with the reprs of its arguments and its result or its error.
+// Unserializable is set instead of the result if the result couldn't be recorded.
+type recordedCall struct {
+	Name           string       `json:"name"`
+	Args           string       `json:"args"`
+	Result         *taggedValue `json:"result,omitempty"`
+	Error          *string      `json:"error,omitempty"`
+	Unserializable string       `json:"unserializable,omitempty"`
+}
+
+func (c recordedCall) String() string {
+	return c.Name + "(" + c.Args + ")"
+}
+
+// recordedTime is the value of a time value of the time module in a recording.
+type recordedTime struct {
+	UnixNano string `json:"unixNano"`
+	Zone     string `json:"zone"`
+	Offset   int    `json:"offset"`
+}
+
+// recorder builds the recording of an execution.
+type recorder struct {
+	rec recording
+	// started is set once the inputs have been recorded, the executions that fail before have no recording.
+	started bool
+	// returnRepr is the repr of the return value, nil if there is none.
+	returnRepr *string
+}
+
+// replayer answers the calls to the host of a replayed execution from a recording.
+type replayer struct {
+	rec        recording
+	next       int
+	modules    map[string]*moduleEntry
+	divergence map[string]interface{}
+	returnRepr *string
+}
+
+// parseRecordOption returns the recorder of the execution if the record option is set, nil otherwise.
+func parseRecordOption(options js.Value) (*recorder, error) {
+	record, err := getBoolOption(options, "record")
+	if err != nil || !record {
+		return nil, err
+	}
+	copied := jsObject.New()
+	for _, key := range recordedOptionKeys {
+		if value := getOption(options, key); !value.IsUndefined() {
+			copied.Set(key, value)
+		}
+	}
+	r := &recorder{rec: recording{
+		Format:  recordingFormat,
+		Version: recordingVersion,
+		Options: json.RawMessage(jsJSON.Call("stringify", copied).String()),
+		Modules: map[string]recordedModule{},
+		Calls:   []recordedCall{},
+	}}
+	return r, nil
+}
+
+// recordInputs records the code and the arguments of the execution.
+func (e *execution) recordInputs(starlark_code string, funcArgs []starlark.Value) {
+	r := e.opts.record
+	if r == nil {
+		return
+	}
+	r.started = true
+	r.rec.Code = starlark_code
+	args, err := encodeTaggedValues(funcArgs, map[starlark.Value]bool{})
+	if err != nil {
+		r.rec.ArgsTagged, r.rec.Unserializable = []taggedValue{}, fmt.Sprintf("the arguments can't be recorded: %v", err)
+		return
+	}
+	r.rec.ArgsTagged = args
+}
+
+// noteReturnValue keeps the repr of the return value for the outcome of the recording or the comparison of the replay.
+func (e *execution) noteReturnValue(returnValue starlark.Value) {
+	if e.opts.record == nil && e.opts.replay == nil {
+		return
+	}
+	repr := returnValue.String()
+	if e.opts.record != nil {
+		e.opts.record.returnRepr = &repr
+	}
+	if e.opts.replay != nil {
+		e.opts.replay.returnRepr = &repr
+	}
+}
+
+// recordModule records a module loaded by the execution.
+func (e *execution) recordModule(name, starlark_code string, filesystem bool, err error) {
+	r := e.opts.record
+	if r == nil {
+		return
+	}
+	module := recordedModule{Source: starlark_code, Filesystem: filesystem}
+	if err != nil {
+		message := err.Error()
+		module = recordedModule{Error: &message}
+	}
+	r.rec.Modules[name] = module
+}
+
+// recordedCalls wraps a builtin, or the builtins of a module, so that their calls are recorded or replayed.
+func (e *execution) recordedCalls(name string, value starlark.Value) starlark.Value {
+	switch v := value.(type) {
+	case *starlark.Builtin:
+		return starlark.NewBuiltin(v.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return e.callRecorded(thread, name, v, args, kwargs)
+		})
+	case *starlarkstruct.Module:
+		members := starlark.StringDict{}
+		for member, value := range v.Members {
+			members[member] = e.recordedCalls(name+"."+member, value)
+		}
+		return &starlarkstruct.Module{Name: v.Name, Members: members}
+	}
+	return value
+}
+
+// callRecorded calls a builtin of the host and records the call, or answers it from the recording in replays.
+func (e *execution) callRecorded(thread *starlark.Thread, name string, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if e.opts.record == nil && e.opts.replay == nil {
+		return b.CallInternal(thread, args, kwargs)
+	}
+	// the reprs are taken before the call, which may modify the arguments
+	reprs := recordedArgs(args, kwargs)
+	if e.opts.replay != nil {
+		return e.opts.replay.call(name, reprs)
+	}
+	result, err := b.CallInternal(thread, args, kwargs)
+	if t, ok := result.(starlarktime.Time); ok {
+		// the monotonic clock reading is part of the repr of the time values and can't be replayed
+		result = starlarktime.Time(time.Time(t).Round(0))
+	}
+	call := recordedCall{Name: name, Args: reprs}
+	if err != nil {
+		message := err.Error()
+		call.Error = &message
+	} else if encoded, encodeErr := encodeRecordedValue(result); encodeErr != nil {
+		call.Unserializable = encodeErr.Error()
+	} else {
+		call.Result = &encoded
+	}
+	e.opts.record.rec.Calls = append(e.opts.record.rec.Calls, call)
+	return result, err
+}
+
+// recordedArgs returns the full reprs of the arguments separated by commas.
+func recordedArgs(args starlark.Tuple, kwargs []starlark.Tuple) string {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, arg.String())
+	}
+	for _, kwarg := range kwargs {
+		parts = append(parts, string(kwarg[0].(starlark.String))+"="+kwarg[1].String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// encodeRecordedValue encodes a result of the host as a tagged value, the time values and durations of the time module included.
+func encodeRecordedValue(value starlark.Value) (taggedValue, error) {
+	switch v := value.(type) {
+	case starlarktime.Time:
+		t := time.Time(v)
+		zone, offset := t.Zone()
+		if name := t.Location().String(); name != "Local" {
+			zone = name
+		}
+		return tag("time", recordedTime{UnixNano: strconv.FormatInt(t.UnixNano(), 10), Zone: zone, Offset: offset})
+	case starlarktime.Duration:
+		return tag("duration", strconv.FormatInt(int64(v), 10))
+	}
+	return encodeTaggedValue(value, map[starlark.Value]bool{})
+}
+
+func decodeRecordedValue(value taggedValue) (starlark.Value, error) {
+	switch value.Type {
+	case "time":
+		var t recordedTime
+		if err := json.Unmarshal(value.Value, &t); err != nil {
+			return nil, err
+		}
+		nanos, err := strconv.ParseInt(t.UnixNano, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		location, err := time.LoadLocation(t.Zone)
+		if err != nil {
+			location = time.FixedZone(t.Zone, t.Offset)
+		}
+		return starlarktime.Time(time.Unix(0, nanos).In(location)), nil
+	case "duration":
+		var s string
+		if err := json.Unmarshal(value.Value, &s); err != nil {
+			return nil, err
+		}
+		nanos, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return starlarktime.Duration(nanos), nil
+	}
+	return decodeTaggedValue(value, nil)
+}
+
+// recordingOutcome returns the fields of a result compared by the replays, the return value as its repr.
+func recordingOutcome(result map[string]interface{}, returnRepr *string) map[string]interface{} {
+	outcome := map[string]interface{}{}
+	if message, ok := result["message"].(string); ok {
+		outcome["message"] = message
+	}
+	if message, ok := result["error"].(string); ok {
+		outcome["error"] = message
+	} else if returnRepr != nil {
+		outcome["returnValue"] = *returnRepr
+	}
+	return outcome
+}
+
+// toJS returns the recording of the execution with the outcome of its result.
+func (r *recorder) toJS(result map[string]interface{}) (js.Value, error) {
+	r.rec.Outcome = recordingOutcome(result, r.returnRepr)
+	data, err := json.Marshal(r.rec)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return jsJSON.Call("parse", string(data)), nil
+}
+
+// parseRecording decodes a recording made by the record option.
+func parseRecording(value js.Value) (recording, error) {
+	var rec recording
+	if value.Type() != js.TypeObject {
+		return rec, fmt.Errorf("the recording must be an object. Actual type %s", value.Type())
+	}
+	if err := json.Unmarshal([]byte(jsJSON.Call("stringify", value).String()), &rec); err != nil {
+		return rec, err
+	}
+	if rec.Format != recordingFormat || rec.Version != recordingVersion {
+		return rec, fmt.Errorf("expected a recording with the format %q and the version %d. Actual format %q version %d", recordingFormat, recordingVersion, rec.Format, rec.Version)
+	}
+	if rec.Unserializable != "" {
+		return rec, errors.New(rec.Unserializable)
+	}
+	if rec.Options == nil {
+		rec.Options = json.RawMessage("{}")
+	}
+	return rec, nil
+}
+
+// call answers a call to the host from the recording. The first call that doesn't match the next recorded call
+// (another builtin or other arguments) is the divergence of the replay, it and all the calls after it fail.
+func (r *replayer) call(name, args string) (starlark.Value, error) {
+	if r.divergence == nil {
+		if r.next < len(r.rec.Calls) && r.rec.Calls[r.next].Name == name && r.rec.Calls[r.next].Args == args {
+			call := r.rec.Calls[r.next]
+			r.next++
+			switch {
+			case call.Error != nil:
+				return nil, errors.New(*call.Error)
+			case call.Result == nil:
+				return nil, fmt.Errorf("%s: the result of the recorded call can't be replayed: %s", name, call.Unserializable)
+			}
+			return decodeRecordedValue(*call.Result)
+		}
+		r.diverge(recordedCall{Name: name, Args: args}.String())
+	}
+	return nil, fmt.Errorf("%s: the execution diverged from the recording at the call %d to the host", name, r.divergence["index"])
+}
+
+// diverge records the divergence of the replay at the next recorded call, replayed is nil if the replay made fewer calls.
+func (r *replayer) diverge(replayed interface{}) {
+	r.divergence = map[string]interface{}{"field": "hostCalls", "index": r.next, "recorded": nil, "replayed": replayed}
+	if r.next < len(r.rec.Calls) {
+		r.divergence["recorded"] = r.rec.Calls[r.next].String()
+	}
+}
+
+// replayModule returns the globals of a module loaded by the replayed execution, from the source recorded for it.
+func (e *execution) replayModule(name string) (starlark.StringDict, error) {
+	r := e.opts.replay
+	module, ok := r.rec.Modules[name]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("the module %q wasn't loaded by the recorded execution", name)
+	case module.Error != nil:
+		return nil, errors.New(*module.Error)
+	case module.Filesystem:
+		return e.loadFileModule(name, module.Source)
+	}
+	if entry, ok := r.modules[name]; ok {
+		return entry.globals, entry.err
+	}
+	entry := newModuleEntry(e.thread)
+	r.modules[name] = entry
+	e.addSource(name, module.Source)
+	entry.globals, entry.err = starlark.ExecFile(e.thread, name, module.Source, predeclaredGlobals())
+	close(entry.done)
+	return entry.globals, entry.err
+}
+
+// grantsCapability returns true if the capabilities option lists the capability.
+func grantsCapability(options js.Value, capability string) bool {
+	capabilities, _ := getStringListOption(options, "capabilities")
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// report compares the replay with the recorded execution.
+func (r *replayer) report(result map[string]interface{}) map[string]interface{} {
+	if r.divergence == nil && r.next < len(r.rec.Calls) {
+		r.diverge(nil)
+	}
+	mismatches := []interface{}{}
+	if r.divergence != nil {
+		mismatches = append(mismatches, r.divergence)
+	}
+	outcome := recordingOutcome(result, r.returnRepr)
+	for _, field := range recordedOutcomeFields {
+		if recorded, replayed := r.rec.Outcome[field], outcome[field]; recorded != replayed {
+			mismatches = append(mismatches, map[string]interface{}{"field": field, "recorded": recorded, "replayed": replayed})
+		}
+	}
+	return map[string]interface{}{
+		"deterministic": len(mismatches) == 0,
+		"calls":         len(r.rec.Calls),
+		"replayedCalls": r.next,
+		"mismatches":    mismatches,
+	}
+}
+
+func getStarlarkRecordingReplayer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected at least one argument with the recording. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		rec, err := parseRecording(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid recording. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		// the options given to the replay (e.g. the callbacks of the logs) are added to the recorded options
+		options := jsJSON.Call("parse", string(rec.Options))
+		if len(args) > 1 && args[1].Type() == js.TypeObject {
+			jsObject.Call("assign", options, args[1])
+		}
+		// the file builtins are replayed, the filesystem they need is empty
+		if fileBuiltins, _ := getBoolOption(options, "fileBuiltins"); fileBuiltins || grantsCapability(options, "fs") {
+			if getOption(options, "fs").IsUndefined() {
+				options.Set("fs", jsObject.New())
+			}
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		argsTagged, _ := json.Marshal(rec.ArgsTagged)
+		opts.args, opts.argsJSON, opts.argsMsgpack, opts.argsTagged = nil, "", nil, string(argsTagged)
+		opts.verifyDeterminism = false
+		opts.replay = &replayer{rec: rec, modules: map[string]*moduleEntry{}}
+		return runStarlarkCode(rec.Code, opts)
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// The dialect of the instance doesn't allow recursive functions (nor while loops), the interpreter fails with
+// "function f called recursively". The maxCallDepth option allows them in the code of one execution and limits the
+// depth of the call stack instead: the code is compiled with syntax.FileOptions.Recursion, which the interpreter
+// checks per program, so the other executions, the modules and the functions of the earlier runs of a session keep
+// the dialect of the instance. The interpreter has no depth limit, so every function and lambda of the code is
+// instrumented with a call to the callDepthBuiltinName builtin that checks the depth when it is entered. The calls
+// that don't go through an instrumented function are checked at the checkpoints, which the interpreter also runs
+// every checkpointInterval steps.
+
+// callDepthBuiltinName is the name of the builtin called when a function of the code is entered.
+// It is only predeclared when the maxCallDepth option is set.
+const callDepthBuiltinName = "__check_call_depth__"
+
+// callDepthGuard enforces the maxCallDepth of an execution.
+type callDepthGuard struct {
+	max int
+	err *callDepthExceededError
+}
+
+func newCallDepthGuard(max int) *callDepthGuard {
+	return &callDepthGuard{max: max}
+}
+
+// callDepthExceededError is returned when the call stack of an execution exceeds the maxCallDepth.
+type callDepthExceededError struct {
+	max   int
+	depth int
+	// function is the function that was called, cycle the frames from its previous call to the new one (empty if it isn't recursive).
+	function string
+	cycle    []starlark.CallFrame
+}
+
+func (err *callDepthExceededError) Error() string {
+	return fmt.Sprintf("maximum recursion depth exceeded: %d frames in %s", err.depth, err.function)
+}
+
+// errorResult returns the error result of an execution that exceeded the maxCallDepth.
+func (err *callDepthExceededError) errorResult() map[string]interface{} {
+	names := []string{}
+	cycle := []interface{}{}
+	for _, frame := range err.cycle {
+		names = append(names, frame.Name)
+		cycle = append(cycle, map[string]interface{}{"name": frame.Name, "filename": frame.Pos.Filename(), "line": int(frame.Pos.Line), "col": int(frame.Pos.Col)})
+	}
+	through := "without recursion"
+	if len(names) > 0 {
+		through = "through the cycle " + strings.Join(append(names, err.function), " -> ")
+	}
+	message := fmt.Errorf("Error: maximum recursion depth exceeded. The call stack exceeded the limit (maxCallDepth) of %d frames when %s was called %s.", err.max, err.function, through)
+	return map[string]interface{}{
+		"error":     message.Error(),
+		"errorCode": "resource_exhausted",
+		"details":   map[string]interface{}{"limit": "maxCallDepth", "max": err.max, "depth": err.depth, "function": err.function, "cycle": cycle},
+	}
+}
+
+// check returns an error if the stack of the thread, without its innermost skip frames, is deeper than the limit.
+// The error is kept for the result of the execution.
+func (g *callDepthGuard) check(thread *starlark.Thread, skip int) error {
+	depth := thread.CallStackDepth() - skip
+	if depth <= g.max {
+		return nil
+	}
+	err := newCallDepthExceededError(thread, skip, g.max)
+	if g.err == nil {
+		g.err = err
+	}
+	return err
+}
+
+// checkpoint is the checkpoint hook of the guard, it catches the recursions that don't go through an instrumented function.
+// The frame of the builtin that runs the checkpoint isn't counted, the interpreter runs them between two steps of a function.
+func (g *callDepthGuard) checkpoint(thread *starlark.Thread) {
+	skip := 0
+	if _, ok := thread.DebugFrame(0).Callable().(*starlark.Builtin); ok {
+		skip = 1
+	}
+	if err := g.check(thread, skip); err != nil {
+		thread.Cancel(err.Error())
+	}
+}
+
+// newCallDepthExceededError describes the stack of the thread without its innermost skip frames.
+// The cycle ends with the nearest caller of the innermost function that runs the same function.
+func newCallDepthExceededError(thread *starlark.Thread, skip, max int) *callDepthExceededError {
+	err := &callDepthExceededError{max: max, depth: thread.CallStackDepth() - skip}
+	called := thread.DebugFrame(skip).Callable()
+	err.function = called.Name()
+	fn, ok := called.(*starlark.Function)
+	if !ok {
+		return err
+	}
+	for depth := skip + 1; depth < thread.CallStackDepth(); depth++ {
+		caller, ok := thread.DebugFrame(depth).Callable().(*starlark.Function)
+		if !ok || caller.Name() != fn.Name() || caller.Position() != fn.Position() {
+			continue
+		}
+		// the frames from the previous call, outermost first
+		for d := depth; d > skip; d-- {
+			err.cycle = append(err.cycle, thread.CallFrame(d))
+		}
+		break
+	}
+	return err
+}
+
+// instrumentCallDepth inserts the depth check at the start of every function of a file, after its docstring,
+// and in the body of every lambda, which becomes (check(), body)[1].
+func instrumentCallDepth(f *syntax.File) {
+	syntax.Walk(f, func(node syntax.Node) bool {
+		switch node := node.(type) {
+		case *syntax.DefStmt:
+			start := 0
+			if len(node.Body) > 0 && isDocString(node.Body[0]) {
+				start = 1
+			}
+			check := &syntax.ExprStmt{X: callDepthCheck(node.Def)}
+			body := append([]syntax.Stmt{}, node.Body[:start]...)
+			node.Body = append(append(body, check), node.Body[start:]...)
+		case *syntax.LambdaExpr:
+			pos := node.Lambda
+			node.Body = &syntax.IndexExpr{
+				X:      &syntax.ParenExpr{Lparen: pos, X: &syntax.TupleExpr{List: []syntax.Expr{callDepthCheck(pos), node.Body}}, Rparen: pos},
+				Lbrack: pos,
+				Y:      &syntax.Literal{Token: syntax.INT, TokenPos: pos, Raw: "1", Value: int64(1)},
+				Rbrack: pos,
+			}
+		}
+		return true
+	})
+}
+
+// callDepthCheck returns the call to the callDepthBuiltinName builtin at the position.
+func callDepthCheck(pos syntax.Position) *syntax.CallExpr {
+	return &syntax.CallExpr{Fn: &syntax.Ident{NamePos: pos, Name: callDepthBuiltinName}, Lparen: pos, Rparen: pos}
+}
+
+// builtin returns the callDepthBuiltinName builtin of the execution. The functions defined by a run of a session keep
+// the builtin of that run, so their depth is still limited when a later run without a maxCallDepth calls them.
+func (g *callDepthGuard) builtin() *starlark.Builtin {
+	return starlark.NewBuiltin(callDepthBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		guard := g
+		if e := threadExecution(thread); e != nil && e.callDepth != nil {
+			guard = e.callDepth
+		}
+		// the frame of the builtin isn't counted
+		if err := guard.check(thread, 1); err != nil {
+			return nil, err
+		}
+		return starlark.None, nil
+	})
+}
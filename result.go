@@ -0,0 +1,117 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// resultValue is the value of result.ok(value) and result.err(code, message, details), a success or a failure the
+// script returns instead of failing. The runner converts a returned result into {ok: true, value} or
+// {ok: false, code, message, details}, so the plugins of a host all signal their outcome the same way.
+type resultValue struct {
+	ok      bool
+	value   starlark.Value
+	code    string
+	message string
+	details starlark.Value
+}
+
+var _ starlark.HasAttrs = (*resultValue)(nil)
+
+func (r *resultValue) String() string {
+	if r.ok {
+		return fmt.Sprintf("result.ok(%s)", r.value)
+	}
+	if r.details == starlark.None {
+		return fmt.Sprintf("result.err(%q, %q)", r.code, r.message)
+	}
+	return fmt.Sprintf("result.err(%q, %q, %s)", r.code, r.message, r.details)
+}
+
+func (r *resultValue) Type() string          { return "result" }
+func (r *resultValue) Freeze()               {} // the value and the details are frozen when the result is created
+func (r *resultValue) Truth() starlark.Bool  { return starlark.Bool(r.ok) }
+func (r *resultValue) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: result") }
+
+func (r *resultValue) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "ok":
+		return starlark.Bool(r.ok), nil
+	case "value":
+		return r.value, nil
+	case "code", "message":
+		if r.ok {
+			return starlark.None, nil
+		}
+		if name == "code" {
+			return starlark.String(r.code), nil
+		}
+		return starlark.String(r.message), nil
+	case "details":
+		return r.details, nil
+	}
+	return nil, nil
+}
+
+func (r *resultValue) AttrNames() []string {
+	return []string{"code", "details", "message", "ok", "value"}
+}
+
+// toDict returns the dict the result is converted from.
+func (r *resultValue) toDict() *starlark.Dict {
+	d := starlark.NewDict(4)
+	d.SetKey(starlark.String("ok"), starlark.Bool(r.ok))
+	if r.ok {
+		d.SetKey(starlark.String("value"), r.value)
+		return d
+	}
+	d.SetKey(starlark.String("code"), starlark.String(r.code))
+	d.SetKey(starlark.String("message"), starlark.String(r.message))
+	d.SetKey(starlark.String("details"), r.details)
+	return d
+}
+
+// resultModule is the predeclared result module.
+var resultModule = &starlarkstruct.Module{
+	Name: "result",
+	Members: starlark.StringDict{
+		"ok": starlark.NewBuiltin("result.ok", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var value starlark.Value = starlark.None
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "value?", &value); err != nil {
+				return nil, err
+			}
+			value.Freeze()
+			return &resultValue{ok: true, value: value, details: starlark.None}, nil
+		}),
+		"err": starlark.NewBuiltin("result.err", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var code, message string
+			var details starlark.Value = starlark.None
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "code", &code, "message", &message, "details?", &details); err != nil {
+				return nil, err
+			}
+			if code == "" {
+				return nil, fmt.Errorf("%s: the code must not be empty", b.Name())
+			}
+			details.Freeze()
+			return &resultValue{code: code, message: message, value: starlark.None, details: details}, nil
+		}),
+	},
+}
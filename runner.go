@@ -0,0 +1,449 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// runOptions control how run_starlark_code_with_options runs the starlark code.
+type runOptions struct {
+	// funcName is the name of the starlark function to call.
+	funcName string
+	// filename is the name of the file of the starlark code in the positions of errors and backtraces, empty if not set.
+	filename string
+	// script executes the file without calling a function, the result has its globals instead of a return value.
+	script bool
+	// pipeline are the names of functions called in order, each one with the return value of the previous one.
+	// It is used instead of funcName if set.
+	pipeline []string
+	// args are the arguments passed to the function.
+	args []js.Value
+	// argsJSON are the arguments passed to the function encoded as a JSON array, used instead of args if set.
+	argsJSON string
+	// argsTagged are the arguments passed to the function as a JSON array of tagged values, used instead of args if set.
+	argsTagged string
+	// argsMsgpack are the arguments passed to the function encoded as a MessagePack array, used instead of args if set.
+	argsMsgpack []byte
+	// returnTagged makes the result contain the return value as a tagged value (returnValueTagged) instead of returnValue.
+	returnTagged bool
+	// returnJSON makes the result contain the return value encoded as JSON (returnValueJson) instead of returnValue.
+	returnJSON bool
+	// returnMsgpack makes the result contain the return value encoded as MessagePack (returnValueMsgpack) instead of returnValue.
+	returnMsgpack bool
+	// returnSchema is the expected shape of the return value, it is validated before the conversion (see schema.go).
+	returnSchema *valueSchema
+	// returnBinary makes bytes and lists of ints be returned as typed arrays.
+	returnBinary bool
+	// freezeArgs freezes the arguments before the call, so the function can't modify them.
+	freezeArgs bool
+	// freezeReturnValue freezes the arrays and objects of the converted return value with Object.freeze.
+	freezeReturnValue bool
+	// returnFunctions converts the returned starlark functions into javascript functions (function proxies) instead of null.
+	returnFunctions bool
+	// returnLazy returns a dict as a javascript Proxy that converts its values when they are read (see lazyDict).
+	returnLazy bool
+	// returnRepr adds the repr of the return value (repr) to the result.
+	returnRepr bool
+	// returnPretty adds the pretty printed return value (pretty) to the result, nil if it wasn't requested (see pretty.go).
+	returnPretty *prettyOptions
+	// stream controls the streaming of large return values.
+	stream streamOptions
+	// fs is the virtual filesystem used by load and the file builtins, nil if there is none.
+	fs fileSystem
+	// fileBuiltins adds the read_file, write_file and glob builtins. It is set by the capability "fs" too.
+	fileBuiltins bool
+	// locale is the default locale of the intl module, empty for the default locale of the host.
+	locale string
+	// archive is the content of the zip or tar archive read by the archive module, nil if there is none.
+	archive *bundle
+	// capabilities are the capabilities granted to the execution (see capabilities.go), nothing is granted by default.
+	capabilities map[string]bool
+	// env is the environment exposed to the script by the env module.
+	env *starlark.Dict
+	// timeModule adds the time module. It is set by the capability "time" too.
+	timeModule bool
+	// clock is read by time.now, the wall clock if nil.
+	clock clock
+	// cancellation lets the host cancel the execution, nil if no signal was given.
+	cancellation *cancellation
+	// canBlock is true if the execution runs on a goroutine that may wait for javascript promises.
+	// It is set by run_starlark_code_async (see allowBlocking), not by an option.
+	canBlock bool
+	// printTo is where the output of print goes: "buffer" (the message of the result, also used if empty),
+	// "console" (console.log, prefixed with the name of the thread) or "both".
+	printTo string
+	// maxOutputBytes is the maximum number of bytes of output kept in the message, 0 means unlimited.
+	// keepOutputTail keeps the first and the last half of the budget instead of the first maxOutputBytes bytes.
+	maxOutputBytes int
+	keepOutputTail bool
+	// log controls where the records of the log module go.
+	log logOptions
+	// audit controls the audit log of the calls to the host.
+	audit auditOptions
+	// record captures the inputs of the execution and its calls to the host into a recording (see recording.go), nil if it isn't recorded.
+	record *recorder
+	// replay answers the calls to the host from a recording, nil if the execution isn't a replay.
+	// It is set by replay_starlark_recording, not by an option.
+	replay *replayer
+	// verifyDeterminism runs the call twice and reports whether the two runs behaved the same (see runDeterminismCheck).
+	verifyDeterminism bool
+	// yieldEverySteps makes the executions of run_starlark_code_async yield to the event loop every yieldEverySteps steps, 0 if they don't.
+	yieldEverySteps int
+	// hostCallQuotas are the maximum numbers of calls to the host by builtin, module, capability or "*" for all of them, nil if there are none.
+	hostCallQuotas map[string]int
+	// dryRun records the calls to the host that may have effects instead of making them (see dryrun.go).
+	dryRun dryRunOptions
+	// onProgress is called by report_progress, undefined (the zero value) if there is no callback.
+	onProgress js.Value
+	// trace reports the statements executed to a callback (see trace.go).
+	trace traceOptions
+	// onInput answers the prompts of the input builtin, undefined if there is no callback.
+	onInput js.Value
+	// timeout is the maximum duration of the execution, 0 means unlimited.
+	timeout time.Duration
+	// maxMemoryBytes is the maximum amount the heap may grow during the execution, 0 means unlimited.
+	maxMemoryBytes uint64
+	// maxSourceBytes and maxSyntaxNodes bound the size of the starlark code, checked before it is compiled. 0 means unlimited.
+	maxSourceBytes int
+	maxSyntaxNodes int
+	// maxCallDepth allows recursion and limits the number of frames of the call stack, 0 if recursion isn't allowed (see recursion.go).
+	maxCallDepth int
+	// allowWhile allows while loops in the code, which the dialect of the instance doesn't.
+	allowWhile bool
+	// maxConversionDepth is the maximum nesting depth of the arguments and the return value, defaultMaxConversionDepth if 0.
+	maxConversionDepth int
+	// maxArgumentElements and maxArgumentStringBytes bound the number of values in the arguments and the length of their strings, 0 means unlimited.
+	maxArgumentElements    int
+	maxArgumentStringBytes int
+	// numbersAsFloats converts the numbers of the arguments to floats, even if they are whole numbers.
+	numbersAsFloats bool
+	// intOverflow is how returned ints that don't fit in 64 bits are converted: "error" (also used if empty), "bigint", "string" or "float".
+	intOverflow string
+	// invalidUnicode is how the strings that are not valid UTF-16 or UTF-8 are converted, see unicode.go.
+	invalidUnicode string
+}
+
+func parseRunOptions(options js.Value) (runOptions, error) {
+	opts := runOptions{funcName: "main"}
+	funcName, ok, err := getStringOption(options, "funcName")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		opts.funcName = funcName
+	}
+	hasFuncName := ok
+	if opts.filename, _, err = getStringOption(options, "filename"); err != nil {
+		return opts, err
+	}
+	if opts.pipeline, err = getStringListOption(options, "pipeline"); err != nil {
+		return opts, err
+	}
+	if opts.pipeline != nil && len(opts.pipeline) == 0 {
+		return opts, fmt.Errorf("the option \"pipeline\" must contain at least one function name")
+	}
+	if opts.args, err = getArrayOption(options, "args"); err != nil {
+		return opts, err
+	}
+	argsJSON, ok, err := getStringOption(options, "argsJson")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if opts.args != nil {
+			return opts, fmt.Errorf("the options \"args\" and \"argsJson\" can't be used together")
+		}
+		opts.argsJSON = argsJSON
+	}
+	if opts.argsTagged, err = parseTaggedArgsOption(options); err != nil {
+		return opts, err
+	}
+	if opts.argsTagged != "" && (opts.args != nil || opts.argsJSON != "") {
+		return opts, fmt.Errorf("the option \"argsTagged\" can't be used together with \"args\" or \"argsJson\"")
+	}
+	if opts.argsMsgpack, err = parseMsgpackArgsOption(options); err != nil {
+		return opts, err
+	}
+	if opts.argsMsgpack != nil && (opts.args != nil || opts.argsJSON != "" || opts.argsTagged != "") {
+		return opts, fmt.Errorf("the option \"argsMsgpack\" can't be used together with \"args\", \"argsJson\" or \"argsTagged\"")
+	}
+	if opts.script, err = getBoolOption(options, "script"); err != nil {
+		return opts, err
+	}
+	if opts.script && (hasFuncName || opts.pipeline != nil || opts.args != nil || opts.argsJSON != "" || opts.argsTagged != "" || opts.argsMsgpack != nil) {
+		return opts, fmt.Errorf("the option \"script\" can't be used together with \"funcName\", \"pipeline\" or the arguments")
+	}
+	if opts.returnTagged, err = getBoolOption(options, "returnTagged"); err != nil {
+		return opts, err
+	}
+	if opts.returnJSON, err = getBoolOption(options, "returnJson"); err != nil {
+		return opts, err
+	}
+	if opts.returnMsgpack, err = getBoolOption(options, "returnMsgpack"); err != nil {
+		return opts, err
+	}
+	if opts.returnBinary, err = getBoolOption(options, "returnBinary"); err != nil {
+		return opts, err
+	}
+	if opts.returnSchema, err = parseSchemaOption(options); err != nil {
+		return opts, err
+	}
+	if opts.returnRepr, err = getBoolOption(options, "returnRepr"); err != nil {
+		return opts, err
+	}
+	if opts.returnPretty, err = parseReturnPrettyOption(options); err != nil {
+		return opts, err
+	}
+	if opts.returnFunctions, err = getBoolOption(options, "returnFunctions"); err != nil {
+		return opts, err
+	}
+	if opts.returnLazy, err = getBoolOption(options, "returnLazy"); err != nil {
+		return opts, err
+	}
+	if opts.freezeArgs, err = getBoolOption(options, "freezeArgs"); err != nil {
+		return opts, err
+	}
+	if opts.freezeReturnValue, err = getBoolOption(options, "freezeReturnValue"); err != nil {
+		return opts, err
+	}
+	if opts.fs, err = parseFileSystemOption(options); err != nil {
+		return opts, err
+	}
+	if opts.fileBuiltins, err = getBoolOption(options, "fileBuiltins"); err != nil {
+		return opts, err
+	}
+	if opts.fileBuiltins && opts.fs == nil {
+		return opts, fmt.Errorf("the option \"fileBuiltins\" requires the option \"fs\"")
+	}
+	if opts.locale, err = parseLocaleOption(options); err != nil {
+		return opts, err
+	}
+	if opts.archive, err = parseArchiveOption(options); err != nil {
+		return opts, err
+	}
+	if opts.capabilities, err = parseCapabilitiesOption(options); err != nil {
+		return opts, err
+	}
+	if opts.granted("fs") {
+		if opts.fs == nil {
+			return opts, fmt.Errorf("the capability \"fs\" requires the option \"fs\"")
+		}
+		opts.fileBuiltins = true
+	}
+	if opts.env, err = parseEnvOption(options); err != nil {
+		return opts, err
+	}
+	if opts.timeModule, err = getBoolOption(options, "timeModule"); err != nil {
+		return opts, err
+	}
+	opts.timeModule = opts.timeModule || opts.granted("time")
+	if opts.clock, err = parseClockOption(options); err != nil {
+		return opts, err
+	}
+	if opts.clock != nil && !opts.timeModule {
+		return opts, fmt.Errorf("the option \"clock\" requires the option \"timeModule\" or the capability \"time\"")
+	}
+	if opts.cancellation, err = parseCancelOptions(options); err != nil {
+		return opts, err
+	}
+	if opts.stream, err = parseStreamOptions(options); err != nil {
+		return opts, err
+	}
+	if opts.printTo, _, err = getStringOption(options, "printTo"); err != nil {
+		return opts, err
+	}
+	if opts.printTo != "" && opts.printTo != "buffer" && opts.printTo != "console" && opts.printTo != "both" {
+		return opts, fmt.Errorf("the option \"printTo\" must be \"buffer\", \"console\" or \"both\". Actual value %q", opts.printTo)
+	}
+	if opts.maxOutputBytes, err = getLimitOption(options, "maxOutputBytes"); err != nil {
+		return opts, err
+	}
+	if opts.keepOutputTail, err = getBoolOption(options, "keepOutputTail"); err != nil {
+		return opts, err
+	}
+	if opts.keepOutputTail && opts.maxOutputBytes == 0 {
+		return opts, fmt.Errorf("the option \"keepOutputTail\" requires the option \"maxOutputBytes\"")
+	}
+	if opts.log, err = parseLogOptions(options); err != nil {
+		return opts, err
+	}
+	if opts.audit, err = parseAuditOptions(options); err != nil {
+		return opts, err
+	}
+	if opts.hostCallQuotas, err = parseQuotasOption(options); err != nil {
+		return opts, err
+	}
+	if opts.dryRun, err = parseDryRunOptions(options); err != nil {
+		return opts, err
+	}
+	if opts.yieldEverySteps, err = getLimitOption(options, "yieldEverySteps"); err != nil {
+		return opts, err
+	}
+	if opts.verifyDeterminism, err = getBoolOption(options, "verifyDeterminism"); err != nil {
+		return opts, err
+	}
+	if opts.record, err = parseRecordOption(options); err != nil {
+		return opts, err
+	}
+	if opts.onProgress, _, err = getFunctionOption(options, "onProgress"); err != nil {
+		return opts, err
+	}
+	if opts.onInput, _, err = getFunctionOption(options, "onInput"); err != nil {
+		return opts, err
+	}
+	if opts.trace, err = parseTraceOptions(options); err != nil {
+		return opts, err
+	}
+	timeoutMs, ok, err := getNumberOption(options, "timeoutMs")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if timeoutMs <= 0 {
+			return opts, fmt.Errorf("the option \"timeoutMs\" must be a positive number. Actual value %v", timeoutMs)
+		}
+		opts.timeout = time.Duration(timeoutMs * float64(time.Millisecond))
+	}
+	maxMemoryBytes, ok, err := getNumberOption(options, "maxMemoryBytes")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if maxMemoryBytes <= 0 {
+			return opts, fmt.Errorf("the option \"maxMemoryBytes\" must be a positive number. Actual value %v", maxMemoryBytes)
+		}
+		opts.maxMemoryBytes = uint64(maxMemoryBytes)
+	}
+	if opts.maxSourceBytes, err = getLimitOption(options, "maxSourceBytes"); err != nil {
+		return opts, err
+	}
+	if opts.maxSyntaxNodes, err = getLimitOption(options, "maxSyntaxNodes"); err != nil {
+		return opts, err
+	}
+	if opts.maxCallDepth, err = getLimitOption(options, "maxCallDepth"); err != nil {
+		return opts, err
+	}
+	if opts.allowWhile, err = getBoolOption(options, "allowWhile"); err != nil {
+		return opts, err
+	}
+	if opts.maxConversionDepth, err = getLimitOption(options, "maxConversionDepth"); err != nil {
+		return opts, err
+	}
+	if opts.maxArgumentElements, err = getLimitOption(options, "maxArgumentElements"); err != nil {
+		return opts, err
+	}
+	if opts.maxArgumentStringBytes, err = getLimitOption(options, "maxArgumentStringBytes"); err != nil {
+		return opts, err
+	}
+	if opts.numbersAsFloats, err = getBoolOption(options, "numbersAsFloats"); err != nil {
+		return opts, err
+	}
+	if opts.intOverflow, _, err = getStringOption(options, "intOverflow"); err != nil {
+		return opts, err
+	}
+	if opts.intOverflow != "" && opts.intOverflow != "error" && opts.intOverflow != "bigint" && opts.intOverflow != "string" && opts.intOverflow != "float" {
+		return opts, fmt.Errorf("the option \"intOverflow\" must be \"error\", \"bigint\", \"string\" or \"float\". Actual value %q", opts.intOverflow)
+	}
+	if opts.invalidUnicode, err = parseInvalidUnicodeOption(options); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// runStarlarkCode executes the starlark code, calls the function and returns the result object.
+func runStarlarkCode(starlark_code string, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	funcArgs, err := e.convertArgs()
+	if err != nil {
+		return e.finish(invalidArgumentsResult(err))
+	}
+	e.recordInputs(starlark_code, funcArgs)
+	program, errResult := e.compileSource(starlark_code, e.predeclared().Has)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	globals, err := program.Init(e.thread, e.predeclared())
+	globals.Freeze()
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark code", err))
+	}
+	if opts.script {
+		return e.finish(e.withGlobals(map[string]interface{}{"message": e.output.String()}, globals))
+	}
+	if opts.pipeline != nil {
+		returnValue, errResult := e.runPipeline(globals, funcArgs)
+		if errResult != nil {
+			return e.finish(errResult)
+		}
+		return e.finish(e.withReturnValue(map[string]interface{}{"message": e.output.String()}, returnValue))
+	}
+	mainFn, errResult := lookupFunction(globals, opts.funcName, "starlark code")
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	// Call the Starlark function from Go.
+	returnValue, errResult := callStarlarkFunction(e, opts.funcName, mainFn, funcArgs)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	return e.finish(e.withReturnValue(map[string]interface{}{"message": e.output.String()}, returnValue))
+}
+
+func getStarlarkRunner() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[0].String()
+		opts := runOptions{funcName: "main"}
+		if len(args) > 1 {
+			opts.funcName = args[1].String()
+		}
+		if len(args) > 2 {
+			opts.args = args[2:]
+		}
+		return runStarlarkCode(starlark_code, opts)
+	})
+}
+
+func getStarlarkRunnerWithOptions() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[0].String()
+		options := js.Undefined()
+		if len(args) > 1 {
+			options = args[1]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		if opts.verifyDeterminism {
+			return runDeterminismCheck(starlark_code, func() (runOptions, error) { return parseRunOptions(options) })
+		}
+		return runStarlarkCode(starlark_code, opts)
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// queueInfo describes how long a job waited in the queue of the scheduler.
+type queueInfo struct {
+	position int // number of jobs ahead of this one when it was queued, 0 if it started immediately
+	wait     time.Duration
+}
+
+func (q queueInfo) toJS() map[string]interface{} {
+	return map[string]interface{}{"position": q.position, "waitMs": float64(q.wait) / float64(time.Millisecond)}
+}
+
+type job struct {
+	queued   time.Time
+	position int
+	run      func(queueInfo)
+}
+
+// scheduler runs jobs on goroutines, at most maxConcurrency at a time.
+// Jobs that can't start immediately wait in a FIFO queue of at most maxQueueLength jobs (0 means unlimited).
+type scheduler struct {
+	mu             sync.Mutex
+	maxConcurrency int
+	maxQueueLength int
+	running        int
+	queue          []job
+	closed         bool
+	wg             sync.WaitGroup
+}
+
+// asyncScheduler schedules the executions started by run_starlark_code_async.
+var asyncScheduler = &scheduler{maxConcurrency: 1}
+
+// schedule starts the job or adds it to the queue.
+// It returns an error without running the job if the queue is full or the scheduler is closed.
+func (s *scheduler) schedule(run func(queueInfo)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("the scheduler has been shut down")
+	}
+	s.wg.Add(1)
+	if s.running < s.maxConcurrency && len(s.queue) == 0 {
+		s.running++
+		go s.start(job{queued: time.Now(), run: run})
+		return nil
+	}
+	if s.maxQueueLength > 0 && len(s.queue) >= s.maxQueueLength {
+		s.wg.Done()
+		return fmt.Errorf("the queue is full. There are %d executions running and %d waiting", s.running, len(s.queue))
+	}
+	s.queue = append(s.queue, job{queued: time.Now(), position: s.running + len(s.queue), run: run})
+	return nil
+}
+
+func (s *scheduler) start(j job) {
+	defer s.wg.Done()
+	defer s.finish()
+	j.run(queueInfo{position: j.position, wait: time.Since(j.queued)})
+}
+
+// finish starts as many queued jobs as the concurrency limit allows.
+func (s *scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	s.startQueued()
+}
+
+// startQueued must be called with the mutex held.
+func (s *scheduler) startQueued() {
+	for s.running < s.maxConcurrency && len(s.queue) > 0 {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+		go s.start(next)
+	}
+}
+
+// drain stops accepting new jobs and waits for the running and queued jobs to finish.
+func (s *scheduler) drain() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *scheduler) configure(maxConcurrency, maxQueueLength int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxConcurrency = maxConcurrency
+	s.maxQueueLength = maxQueueLength
+	s.startQueued()
+}
+
+func (s *scheduler) status() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"running":        s.running,
+		"queued":         len(s.queue),
+		"maxConcurrency": s.maxConcurrency,
+		"maxQueueLength": s.maxQueueLength,
+	}
+}
+
+func getSchedulerConfigurer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		options := js.Undefined()
+		if len(args) > 0 {
+			options = args[0]
+		}
+		status := asyncScheduler.status()
+		maxConcurrency, maxQueueLength := status["maxConcurrency"].(int), status["maxQueueLength"].(int)
+		value, ok, err := getIntegerOption(options, "maxConcurrency", 1)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		if ok {
+			maxConcurrency = value
+		}
+		value, ok, err = getIntegerOption(options, "maxQueueLength", 0)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		if ok {
+			maxQueueLength = value
+		}
+		asyncScheduler.configure(maxConcurrency, maxQueueLength)
+		return asyncScheduler.status()
+	})
+}
+
+func getSchedulerStatus() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return asyncScheduler.status()
+	})
+}
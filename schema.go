@@ -0,0 +1,258 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// The returnSchema option describes the expected shape of the return value with a small subset of JSON Schema:
+// type (a name or a list of names), enum, items, properties, required and additionalProperties.
+// The starlark value is validated before it is converted, so a plugin that returns the wrong shape fails with the paths of the mismatches.
+
+// maxSchemaMismatches is the maximum number of mismatches reported in the details of the error.
+const maxSchemaMismatches = 20
+
+// schemaTypes are the type names of the schemas.
+var schemaTypes = []string{"array", "boolean", "integer", "null", "number", "object", "string"}
+
+// valueSchema is a parsed schema, the missing keywords match anything.
+type valueSchema struct {
+	types                []string
+	enum                 []starlark.Value
+	items                *valueSchema
+	properties           map[string]*valueSchema
+	required             []string
+	additionalProperties *bool
+}
+
+// schemaMismatch is a part of the return value that doesn't match the schema. The path is like $.items[2].name.
+type schemaMismatch struct {
+	path     string
+	expected string
+	actual   string
+}
+
+func parseSchemaOption(options js.Value) (*valueSchema, error) {
+	value := getOption(options, "returnSchema")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	schema, err := parseSchema(value, "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema in the option \"returnSchema\": %s", err)
+	}
+	return schema, nil
+}
+
+// parseSchema parses the schema at a path of the option, the path is used in the errors.
+func parseSchema(value js.Value, path string) (*valueSchema, error) {
+	if value.Type() != js.TypeObject || value.InstanceOf(jsArray) {
+		return nil, fmt.Errorf("the schema at %s must be an object. Actual type %s", path, value.Type())
+	}
+	schema := &valueSchema{}
+	switch types := value.Get("type"); {
+	case types.Type() == js.TypeString:
+		schema.types = []string{types.String()}
+	case types.InstanceOf(jsArray):
+		for i := 0; i < types.Length(); i++ {
+			if types.Index(i).Type() != js.TypeString {
+				return nil, fmt.Errorf("the type of the schema at %s must be a string or an array of strings", path)
+			}
+			schema.types = append(schema.types, types.Index(i).String())
+		}
+	case !types.IsUndefined():
+		return nil, fmt.Errorf("the type of the schema at %s must be a string or an array of strings", path)
+	}
+	for _, name := range schema.types {
+		if !containsString(schemaTypes, name) {
+			return nil, fmt.Errorf("the type of the schema at %s must be one of [%s]. Actual value %q", path, strings.Join(schemaTypes, ", "), name)
+		}
+	}
+	if enum := value.Get("enum"); !enum.IsUndefined() {
+		if !enum.InstanceOf(jsArray) {
+			return nil, fmt.Errorf("the enum of the schema at %s must be an array", path)
+		}
+		c := &converter{}
+		for i := 0; i < enum.Length(); i++ {
+			converted, err := c.convertToStarlarkValue(enum.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("the enum of the schema at %s can't be converted: %s", path, err)
+			}
+			schema.enum = append(schema.enum, converted)
+		}
+	}
+	if items := value.Get("items"); !items.IsUndefined() {
+		parsed, err := parseSchema(items, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		schema.items = parsed
+	}
+	if properties := value.Get("properties"); !properties.IsUndefined() {
+		if properties.Type() != js.TypeObject || properties.InstanceOf(jsArray) {
+			return nil, fmt.Errorf("the properties of the schema at %s must be an object", path)
+		}
+		schema.properties = map[string]*valueSchema{}
+		keys := jsObject.Call("keys", properties)
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			parsed, err := parseSchema(properties.Get(key), path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			schema.properties[key] = parsed
+		}
+	}
+	if required := value.Get("required"); !required.IsUndefined() {
+		if !required.InstanceOf(jsArray) {
+			return nil, fmt.Errorf("the required keys of the schema at %s must be an array of strings", path)
+		}
+		for i := 0; i < required.Length(); i++ {
+			if required.Index(i).Type() != js.TypeString {
+				return nil, fmt.Errorf("the required keys of the schema at %s must be an array of strings", path)
+			}
+			schema.required = append(schema.required, required.Index(i).String())
+		}
+	}
+	if additional := value.Get("additionalProperties"); !additional.IsUndefined() {
+		if additional.Type() != js.TypeBoolean {
+			return nil, fmt.Errorf("the additionalProperties of the schema at %s must be a boolean", path)
+		}
+		allowed := additional.Bool()
+		schema.additionalProperties = &allowed
+	}
+	return schema, nil
+}
+
+// schemaType returns the schema type name of a starlark value, empty for the values that have none (e.g. functions and tuples,
+// which are not converted).
+func schemaType(value starlark.Value) string {
+	switch value.(type) {
+	case starlark.NoneType:
+		return "null"
+	case starlark.Bool:
+		return "boolean"
+	case starlark.Int:
+		return "integer"
+	case starlark.Float:
+		return "number"
+	case starlark.String:
+		return "string"
+	case *starlark.List:
+		return "array"
+	case *starlark.Dict:
+		return "object"
+	}
+	return ""
+}
+
+func (s *valueSchema) matchesType(actual string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, name := range s.types {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validate appends the mismatches of a value and of its elements to the list.
+func (s *valueSchema) validate(value starlark.Value, path string, mismatches []schemaMismatch) []schemaMismatch {
+	if len(mismatches) >= maxSchemaMismatches {
+		return mismatches
+	}
+	actual := schemaType(value)
+	if actual == "" {
+		actual = value.Type()
+	}
+	if !s.matchesType(actual) {
+		return append(mismatches, schemaMismatch{path: path, expected: strings.Join(s.types, " or "), actual: actual})
+	}
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if eq, err := starlark.Equal(value, allowed); err == nil && eq {
+				found = true
+				break
+			}
+		}
+		if !found {
+			expected := []string{}
+			for _, allowed := range s.enum {
+				expected = append(expected, allowed.String())
+			}
+			return append(mismatches, schemaMismatch{path: path, expected: "one of [" + strings.Join(expected, ", ") + "]", actual: value.String()})
+		}
+	}
+	switch v := value.(type) {
+	case *starlark.List:
+		if s.items == nil {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			mismatches = s.items.validate(v.Index(i), fmt.Sprintf("%s[%d]", path, i), mismatches)
+		}
+	case *starlark.Dict:
+		for _, key := range s.required {
+			if _, found, _ := v.Get(starlark.String(key)); !found {
+				mismatches = append(mismatches, schemaMismatch{path: path + "." + key, expected: "a required key", actual: "missing"})
+			}
+		}
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				continue
+			}
+			if property, ok := s.properties[key]; ok {
+				mismatches = property.validate(item[1], path+"."+key, mismatches)
+			} else if s.additionalProperties != nil && !*s.additionalProperties {
+				mismatches = append(mismatches, schemaMismatch{path: path + "." + key, expected: "no additional keys", actual: "the key " + item[0].String()})
+			}
+		}
+	}
+	if len(mismatches) > maxSchemaMismatches {
+		mismatches = mismatches[:maxSchemaMismatches]
+	}
+	return mismatches
+}
+
+// schemaErrorResult validates the return value of an execution against the returnSchema option.
+// It returns nil if the value matches (or there is no schema).
+func (e *execution) schemaErrorResult(returnValue starlark.Value) map[string]interface{} {
+	if e.opts.returnSchema == nil {
+		return nil
+	}
+	mismatches := e.opts.returnSchema.validate(returnValue, "$", nil)
+	if len(mismatches) == 0 {
+		return nil
+	}
+	list := []interface{}{}
+	for _, m := range mismatches {
+		list = append(list, map[string]interface{}{"path": m.path, "expected": m.expected, "actual": m.actual})
+	}
+	first := mismatches[0]
+	message := fmt.Sprintf("Error: the return value doesn't match the schema, %s should be %s but it is %s.", first.path, first.expected, first.actual)
+	if len(mismatches) > 1 {
+		message += fmt.Sprintf(" The details list %d mismatches.", len(mismatches))
+	}
+	return map[string]interface{}{"error": message, "errorCode": "failed_precondition", "details": map[string]interface{}{"mismatches": list}}
+}
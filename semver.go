@@ -0,0 +1,508 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// semVersion is a semantic version (https://semver.org). A leading v or = is accepted when parsing.
+type semVersion struct {
+	major, minor, patch uint64
+	pre                 []string
+	build               string
+}
+
+func (v semVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if len(v.pre) > 0 {
+		s += "-" + strings.Join(v.pre, ".")
+	}
+	if v.build != "" {
+		s += "+" + v.build
+	}
+	return s
+}
+
+func parseSemverNumber(s string) (uint64, error) {
+	if s == "" || len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("%q is not a valid version number", s)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid version number", s)
+	}
+	return n, nil
+}
+
+func validSemverIdentifier(s string, numeric bool) bool {
+	if s == "" {
+		return false
+	}
+	digits := true
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			continue
+		}
+		digits = false
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	// numeric prerelease identifiers can't have leading zeros
+	return !(numeric && digits && len(s) > 1 && s[0] == '0')
+}
+
+// splitSemverSuffix splits the -prerelease and +build suffixes of a version.
+func splitSemverSuffix(s string) (string, []string, string, error) {
+	build := ""
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s, build = s[:i], s[i+1:]
+		for _, id := range strings.Split(build, ".") {
+			if !validSemverIdentifier(id, false) {
+				return "", nil, "", fmt.Errorf("%q is not a valid build metadata", build)
+			}
+		}
+	}
+	var pre []string
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, pre = s[:i], strings.Split(s[i+1:], ".")
+		for _, id := range pre {
+			if !validSemverIdentifier(id, true) {
+				return "", nil, "", fmt.Errorf("%q is not a valid prerelease", strings.Join(pre, "."))
+			}
+		}
+	}
+	return s, pre, build, nil
+}
+
+func parseSemver(s string) (semVersion, error) {
+	core, pre, build, err := splitSemverSuffix(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "="), "v"))
+	if err != nil {
+		return semVersion{}, fmt.Errorf("invalid version %q: %v", s, err)
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semVersion{}, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+	v := semVersion{pre: pre, build: build}
+	for i, field := range []*uint64{&v.major, &v.minor, &v.patch} {
+		if *field, err = parseSemverNumber(parts[i]); err != nil {
+			return semVersion{}, fmt.Errorf("invalid version %q: %v", s, err)
+		}
+	}
+	return v, nil
+}
+
+// compareSemver returns -1, 0 or 1 following the precedence of semver, the build metadata is ignored.
+func compareSemver(a, b semVersion) int {
+	for _, pair := range [][2]uint64{{a.major, b.major}, {a.minor, b.minor}, {a.patch, b.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		if c := comparePrereleaseIdentifier(a.pre[i], b.pre[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.pre) < len(b.pre):
+		return -1
+	case len(a.pre) > len(b.pre):
+		return 1
+	}
+	return 0
+}
+
+// comparePrereleaseIdentifier compares numeric identifiers numerically, they have a lower precedence than the alphanumeric ones.
+func comparePrereleaseIdentifier(a, b string) int {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if x == y {
+			return 0
+		} else if x < y {
+			return -1
+		}
+		return 1
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// semverComparator is an operator (=, <, <=, > or >=) and a version.
+type semverComparator struct {
+	op      string
+	version semVersion
+}
+
+func (c semverComparator) test(v semVersion) bool {
+	cmp := compareSemver(v, c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return cmp == 0
+}
+
+// semverRange is a union of comparator sets, a version satisfies the range if it satisfies all the comparators of one of the sets.
+type semverRange [][]semverComparator
+
+// partialSemver is a version of a range where the trailing numbers may be missing or wildcards (x, X or *), n is the number of numbers given.
+type partialSemver struct {
+	numbers [3]uint64
+	n       int
+	pre     []string
+}
+
+func parsePartialSemver(s string) (partialSemver, error) {
+	core, pre, _, err := splitSemverSuffix(strings.TrimPrefix(s, "v"))
+	if err != nil {
+		return partialSemver{}, fmt.Errorf("invalid version %q: %v", s, err)
+	}
+	p := partialSemver{pre: pre}
+	if core == "" {
+		return p, nil
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return p, fmt.Errorf("invalid version %q: too many numbers", s)
+	}
+	for i, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			for _, rest := range parts[i+1:] {
+				if rest != "x" && rest != "X" && rest != "*" {
+					return p, fmt.Errorf("invalid version %q: a number follows a wildcard", s)
+				}
+			}
+			break
+		}
+		if p.numbers[i], err = parseSemverNumber(part); err != nil {
+			return p, fmt.Errorf("invalid version %q: %v", s, err)
+		}
+		p.n = i + 1
+	}
+	if p.n < 3 && len(p.pre) > 0 {
+		return p, fmt.Errorf("invalid version %q: a prerelease requires MAJOR.MINOR.PATCH", s)
+	}
+	return p, nil
+}
+
+// floor is the lowest version matching the partial version, bump the lowest version above it (the next major or minor).
+func (p partialSemver) floor() semVersion {
+	return semVersion{major: p.numbers[0], minor: p.numbers[1], patch: p.numbers[2], pre: p.pre}
+}
+
+func (p partialSemver) bump(n int) semVersion {
+	v := semVersion{pre: []string{"0"}}
+	switch n {
+	case 1:
+		v.major = p.numbers[0] + 1
+	case 2:
+		v.major, v.minor = p.numbers[0], p.numbers[1]+1
+	default:
+		v.major, v.minor, v.patch = p.numbers[0], p.numbers[1], p.numbers[2]+1
+	}
+	return v
+}
+
+// anySemver matches every version without a prerelease, noSemver matches nothing.
+var (
+	anySemver = []semverComparator{{op: ">=", version: semVersion{}}}
+	noSemver  = []semverComparator{{op: "<", version: semVersion{pre: []string{"0"}}}}
+)
+
+// desugar returns the comparators of an operator (including ~ and ^) and a partial version, like npm.
+func desugarSemver(op string, p partialSemver) []semverComparator {
+	full := p.n == 3
+	switch op {
+	case "", "=":
+		if full {
+			return []semverComparator{{op: "=", version: p.floor()}}
+		}
+		if p.n == 0 {
+			return anySemver
+		}
+		return []semverComparator{{op: ">=", version: p.floor()}, {op: "<", version: p.bump(p.n)}}
+	case ">":
+		if full {
+			return []semverComparator{{op: ">", version: p.floor()}}
+		}
+		if p.n == 0 {
+			return noSemver
+		}
+		// >1.2 is >=1.3.0, without the prerelease of bump that would let 1.3.0-beta satisfy it
+		lower := p.bump(p.n)
+		lower.pre = nil
+		return []semverComparator{{op: ">=", version: lower}}
+	case ">=":
+		return []semverComparator{{op: ">=", version: p.floor()}}
+	case "<":
+		if full {
+			return []semverComparator{{op: "<", version: p.floor()}}
+		}
+		floor := p.floor()
+		floor.pre = []string{"0"}
+		return []semverComparator{{op: "<", version: floor}}
+	case "<=":
+		if full {
+			return []semverComparator{{op: "<=", version: p.floor()}}
+		}
+		if p.n == 0 {
+			return anySemver
+		}
+		return []semverComparator{{op: "<", version: p.bump(p.n)}}
+	case "~":
+		if p.n == 0 {
+			return anySemver
+		}
+		if p.n == 1 {
+			return []semverComparator{{op: ">=", version: p.floor()}, {op: "<", version: p.bump(1)}}
+		}
+		return []semverComparator{{op: ">=", version: p.floor()}, {op: "<", version: p.bump(2)}}
+	}
+	// ^ allows the changes that don't modify the leftmost nonzero number
+	if p.n == 0 {
+		return anySemver
+	}
+	upper := p.bump(1)
+	if p.numbers[0] == 0 && p.n >= 2 {
+		upper = p.bump(2)
+		if p.numbers[1] == 0 && full {
+			upper = p.bump(3)
+		}
+	}
+	return []semverComparator{{op: ">=", version: p.floor()}, {op: "<", version: upper}}
+}
+
+// parseSemverRange parses the range syntax of npm: comparators separated by spaces (>=1.2.0 <2.0.0),
+// hyphen ranges (1.2 - 2.3.4), wildcards (1.x, *), tilde (~1.2.3) and caret (^0.2.3) ranges, and unions with ||.
+func parseSemverRange(s string) (semverRange, error) {
+	r := semverRange{}
+	for _, set := range strings.Split(s, "||") {
+		tokens := strings.Fields(set)
+		comparators := []semverComparator{}
+		if len(tokens) == 3 && tokens[1] == "-" {
+			lower, err := parsePartialSemver(tokens[0])
+			if err != nil {
+				return nil, err
+			}
+			upper, err := parsePartialSemver(tokens[2])
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, desugarSemver(">=", lower)...)
+			comparators = append(comparators, desugarSemver("<=", upper)...)
+			r = append(r, comparators)
+			continue
+		}
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			op := ""
+			for _, candidate := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+				if strings.HasPrefix(token, candidate) {
+					op, token = candidate, strings.TrimPrefix(token, candidate)
+					break
+				}
+			}
+			// the version may be separated from its operator by spaces
+			if token == "" && op != "" && i+1 < len(tokens) {
+				i++
+				token = tokens[i]
+			}
+			if token == "" || token == "-" {
+				return nil, fmt.Errorf("invalid range %q", s)
+			}
+			p, err := parsePartialSemver(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %v", s, err)
+			}
+			comparators = append(comparators, desugarSemver(op, p)...)
+		}
+		if len(comparators) == 0 {
+			comparators = anySemver
+		}
+		r = append(r, comparators)
+	}
+	return r, nil
+}
+
+// satisfies follows npm: a prerelease only satisfies a set of comparators if one of them has a prerelease of the same MAJOR.MINOR.PATCH.
+func (r semverRange) satisfies(v semVersion) bool {
+	for _, set := range r {
+		ok := true
+		for _, c := range set {
+			if !c.test(v) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if len(v.pre) == 0 {
+			return true
+		}
+		for _, c := range set {
+			if len(c.version.pre) > 0 && c.version.major == v.major && c.version.minor == v.minor && c.version.patch == v.patch {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func semverToStarlark(v semVersion) *starlark.Dict {
+	pre := []starlark.Value{}
+	for _, id := range v.pre {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil && n <= 1<<53 {
+			pre = append(pre, starlark.MakeUint64(n))
+		} else {
+			pre = append(pre, starlark.String(id))
+		}
+	}
+	d := starlark.NewDict(6)
+	d.SetKey(starlark.String("major"), starlark.MakeUint64(v.major))
+	d.SetKey(starlark.String("minor"), starlark.MakeUint64(v.minor))
+	d.SetKey(starlark.String("patch"), starlark.MakeUint64(v.patch))
+	d.SetKey(starlark.String("prerelease"), starlark.NewList(pre))
+	d.SetKey(starlark.String("build"), starlark.String(v.build))
+	d.SetKey(starlark.String("version"), starlark.String(v.String()))
+	return d
+}
+
+func newSemverBuiltin(name string, fn func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)) *starlark.Builtin {
+	return starlark.NewBuiltin("semver."+name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		checkpoint(thread)
+		result, err := fn(b, args, kwargs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		return result, nil
+	})
+}
+
+// semverModule is the semver module predeclared in every execution.
+var semverModule = &starlarkstruct.Module{
+	Name: "semver",
+	Members: starlark.StringDict{
+		"parse": newSemverBuiltin("parse", func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var version string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &version); err != nil {
+				return nil, err
+			}
+			v, err := parseSemver(version)
+			if err != nil {
+				return nil, err
+			}
+			return semverToStarlark(v), nil
+		}),
+		"valid": newSemverBuiltin("valid", func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var version string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &version); err != nil {
+				return nil, err
+			}
+			_, err := parseSemver(version)
+			return starlark.Bool(err == nil), nil
+		}),
+		"compare": newSemverBuiltin("compare", func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var a, c string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &a, &c); err != nil {
+				return nil, err
+			}
+			x, err := parseSemver(a)
+			if err != nil {
+				return nil, err
+			}
+			y, err := parseSemver(c)
+			if err != nil {
+				return nil, err
+			}
+			return starlark.MakeInt(compareSemver(x, y)), nil
+		}),
+		"satisfies": newSemverBuiltin("satisfies", func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var version, rangeSpec string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &version, &rangeSpec); err != nil {
+				return nil, err
+			}
+			v, err := parseSemver(version)
+			if err != nil {
+				return nil, err
+			}
+			r, err := parseSemverRange(rangeSpec)
+			if err != nil {
+				return nil, err
+			}
+			return starlark.Bool(r.satisfies(v)), nil
+		}),
+		"max_satisfying": newSemverBuiltin("max_satisfying", func(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var versions starlark.Iterable
+			var rangeSpec string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &versions, &rangeSpec); err != nil {
+				return nil, err
+			}
+			r, err := parseSemverRange(rangeSpec)
+			if err != nil {
+				return nil, err
+			}
+			var best starlark.Value = starlark.None
+			var bestVersion semVersion
+			iter := versions.Iterate()
+			defer iter.Done()
+			var item starlark.Value
+			for iter.Next(&item) {
+				s, ok := starlark.AsString(item)
+				if !ok {
+					return nil, fmt.Errorf("the versions must be strings. Actual type %s", item.Type())
+				}
+				v, err := parseSemver(s)
+				if err != nil {
+					return nil, err
+				}
+				if r.satisfies(v) && (best == starlark.None || compareSemver(v, bestVersion) > 0) {
+					best, bestVersion = item, v
+				}
+			}
+			return best, nil
+		}),
+	},
+}
@@ -0,0 +1,200 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// The code run in a session can serve named requests, like a tiny serverless runtime inside the page: it registers
+// its functions once with the handle builtin (or lists them in a global dict named handlers) and call_starlark_handler
+// calls one of them by name with the converted request. Unlike the event handlers, a name has a single handler
+// and the result has its return value.
+
+// serverHandlersGlobal is the name of the global dict of handlers, used for the names that were not registered with handle.
+const serverHandlersGlobal = "handlers"
+
+// newHandleBuiltin returns the handle(name, handler = None) builtin of a session, which registers the handler of the requests with the name.
+// A later registration replaces the handler. Without a handler it returns a builtin that registers its argument, so
+// handle("name")(fn) works like a decorator. Both forms return the handler.
+func newHandleBuiltin(s *session) *starlark.Builtin {
+	return starlark.NewBuiltin("handle", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var name string
+		var handler starlark.Callable
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "handler?", &handler); err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: the handler name must not be empty", b.Name())
+		}
+		if handler != nil {
+			s.routes[name] = handler
+			return handler, nil
+		}
+		return starlark.NewBuiltin(b.Name(), func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var handler starlark.Callable
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &handler); err != nil {
+				return nil, err
+			}
+			s.routes[name] = handler
+			return handler, nil
+		}), nil
+	})
+}
+
+// handler returns the handler of the requests with the name: the one registered with handle, or else the value of the name in the global dict of handlers.
+func (s *session) handler(name string) (starlark.Callable, error) {
+	if handler, ok := s.routes[name]; ok {
+		return handler, nil
+	}
+	dict, ok := s.globals[serverHandlersGlobal].(*starlark.Dict)
+	if !ok {
+		return nil, nil
+	}
+	value, found, err := dict.Get(starlark.String(name))
+	if err != nil || !found {
+		return nil, err
+	}
+	handler, ok := value.(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("the handler %q of the %s dict is not callable. Actual type %s", name, serverHandlersGlobal, value.Type())
+	}
+	return handler, nil
+}
+
+// handlerNames returns the sorted names of the handlers of the session.
+func (s *session) handlerNames() []interface{} {
+	set := map[string]bool{}
+	for name := range s.routes {
+		set[name] = true
+	}
+	if dict, ok := s.globals[serverHandlersGlobal].(*starlark.Dict); ok {
+		for _, item := range dict.Items() {
+			if name, ok := item[0].(starlark.String); ok {
+				if _, ok := item[1].(starlark.Callable); ok {
+					set[string(name)] = true
+				}
+			}
+		}
+	}
+	names := []string{}
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := []interface{}{}
+	for _, name := range names {
+		list = append(list, name)
+	}
+	return list
+}
+
+// serve calls the handler of the requests with the name in a new execution.
+func (s *session) serve(name string, request []js.Value, opts runOptions) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	if busy := s.acquire(); busy != nil {
+		return e.finish(busy.errorResult())
+	}
+	defer s.release()
+	e.session = s
+	if errResult := e.sessionQuotaErrorResult(); errResult != nil {
+		return e.finish(errResult)
+	}
+	handler, err := s.handler(name)
+	if err != nil {
+		err := fmt.Errorf("Error: invalid handler. Error: %q", err)
+		return e.finish(map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": map[string]interface{}{"handler": name}})
+	}
+	if handler == nil {
+		err := fmt.Errorf("Error: the session has no handler named %q", name)
+		return e.finish(map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "details": map[string]interface{}{"handler": name, "handlers": s.handlerNames()}})
+	}
+	if request != nil {
+		e.opts.args, e.opts.argsJSON, e.opts.argsTagged, e.opts.argsMsgpack = request, "", "", nil
+	}
+	funcArgs, err := e.convertArgs()
+	if err != nil {
+		return e.finish(invalidArgumentsResult(err))
+	}
+	returnValue, errResult := callStarlarkFunction(e, handler.Name(), handler, funcArgs)
+	if errResult != nil {
+		details, _ := errResult["details"].(map[string]interface{})
+		if details == nil {
+			details = map[string]interface{}{}
+		}
+		details["handler"] = name
+		errResult["details"] = details
+		return e.finish(errResult)
+	}
+	result = map[string]interface{}{"message": e.output.String(), "handler": name}
+	return e.finish(e.withReturnValue(result, returnValue))
+}
+
+func getHandlerCaller() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected at least two arguments with the session id and the handler name. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "stats": executionStats{}.toJS()}
+		}
+		if args[1].Type() != js.TypeString {
+			err := fmt.Errorf("Error: the handler name must be a string. Actual type %s", args[1].Type())
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		name := args[1].String()
+		var request []js.Value
+		if len(args) > 2 && !args[2].IsUndefined() {
+			request = []js.Value{args[2]}
+		}
+		options := js.Undefined()
+		if len(args) > 3 {
+			options = args[3]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		return s.serve(name, request, opts)
+	})
+}
+
+func getHandlerLister() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the session id. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		if busy := s.acquire(); busy != nil {
+			return busy.errorResult()
+		}
+		defer s.release()
+		return map[string]interface{}{"sessionId": float64(s.id), "handlers": s.handlerNames()}
+	})
+}
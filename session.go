@@ -0,0 +1,248 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// session keeps the globals defined by the code run in it, like a notebook or a REPL.
+// Unlike modules, the globals of a session are not frozen so later runs can modify them.
+type session struct {
+	// busy holds a token while an execution runs in the session, see acquire.
+	busy    chan struct{}
+	id      uint64
+	globals starlark.StringDict
+	// handlers are the functions registered with on, by event name.
+	handlers map[string][]starlark.Callable
+	// routes are the handlers of the requests registered with handle, by name (see server.go).
+	routes map[string]starlark.Callable
+	// timers are the pending calls scheduled with schedule, by id.
+	// They have their own mutex because they are cancelled when the session is destroyed, even while it is running.
+	timersMu    sync.Mutex
+	nextTimerID uint64
+	timers      map[uint64]*sessionTimer
+	// sources are the source codes run in the session by file name, for the backtraces of the functions defined by earlier runs.
+	sources map[string]string
+	// usage and quotas have their own mutex so they can be read while the session is running (see usage.go).
+	usageMu sync.Mutex
+	usage   sessionUsage
+	quotas  sessionQuotas
+}
+
+var sessions = struct {
+	sync.Mutex
+	nextID uint64
+	byID   map[uint64]*session
+}{byID: map[uint64]*session{}}
+
+func init() {
+	registerResetHook(func() {
+		sessions.Lock()
+		defer sessions.Unlock()
+		for _, s := range sessions.byID {
+			s.cancelTimers()
+		}
+		sessions.byID = map[uint64]*session{}
+	})
+}
+
+func newSession(globals starlark.StringDict) *session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	sessions.nextID++
+	s := &session{busy: make(chan struct{}, 1), id: sessions.nextID, globals: globals, handlers: map[string][]starlark.Callable{}, routes: map[string]starlark.Callable{}, timers: map[uint64]*sessionTimer{}, sources: map[string]string{}}
+	sessions.byID[s.id] = s
+	return s
+}
+
+func getSession(id js.Value) (*session, error) {
+	if id.Type() != js.TypeNumber {
+		return nil, fmt.Errorf("the session id must be a number. Actual type %s", id.Type())
+	}
+	// 1.9 must not be truncated to the session 1, nor -1 wrapped to a large id
+	value := id.Float()
+	if value < 0 || !isSafeInteger(value) {
+		return nil, fmt.Errorf("the session id must be a non negative integer. Actual value %v", value)
+	}
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.byID[uint64(value)]
+	if !ok {
+		return nil, fmt.Errorf("the session %d does not exist", uint64(value))
+	}
+	return s, nil
+}
+
+// sessionBusyError is returned when a session is needed while an execution runs in it. The executions of sessions
+// are synchronous, so the caller is a javascript callback of that execution (a host builtin, an onProgress callback,
+// a read of a lazy dict, etc.), which can't wait for the execution to finish without deadlocking the instance.
+type sessionBusyError struct {
+	id uint64
+}
+
+func (err *sessionBusyError) Error() string {
+	return fmt.Sprintf("the session %d is busy running another execution", err.id)
+}
+
+func (err *sessionBusyError) errorResult() map[string]interface{} {
+	return map[string]interface{}{"error": "Error: " + err.Error(), "errorCode": "failed_precondition", "details": map[string]interface{}{"sessionId": float64(err.id)}}
+}
+
+// acquire reserves the session for the caller until it calls release. It fails if the session is busy.
+func (s *session) acquire() *sessionBusyError {
+	select {
+	case s.busy <- struct{}{}:
+		return nil
+	default:
+		return &sessionBusyError{id: s.id}
+	}
+}
+
+// wait reserves the session once it is free, for the goroutines that don't run on behalf of a javascript call (e.g. timers).
+func (s *session) wait() {
+	s.busy <- struct{}{}
+}
+
+func (s *session) release() {
+	<-s.busy
+}
+
+// predeclared returns the environment the code run in the session sees: the predeclared environment of the execution, the on, schedule and handle builtins and the session's globals.
+// The session must be acquired.
+func (s *session) predeclared(e *execution) starlark.StringDict {
+	predeclared := starlark.StringDict{}
+	for name, value := range e.predeclared() {
+		predeclared[name] = value
+	}
+	predeclared["on"] = newOnBuiltin(s)
+	predeclared["schedule"] = newScheduleBuiltin(s)
+	predeclared["handle"] = newHandleBuiltin(s)
+	for name, value := range s.globals {
+		predeclared[name] = value
+	}
+	return predeclared
+}
+
+// run executes the starlark code in the session and then optionally calls one of the session's functions.
+func (s *session) run(starlark_code string, opts runOptions, callFunction bool) (result map[string]interface{}) {
+	e := newExecution(opts)
+	defer e.recoverPanic(&result)
+	if busy := s.acquire(); busy != nil {
+		return e.finish(busy.errorResult())
+	}
+	defer s.release()
+	e.session = s
+	if errResult := e.sessionQuotaErrorResult(); errResult != nil {
+		return e.finish(errResult)
+	}
+	funcArgs, err := e.convertArgs()
+	if err != nil {
+		return e.finish(invalidArgumentsResult(err))
+	}
+	predeclared := s.predeclared(e)
+	program, errResult := e.compileSource(starlark_code, predeclared.Has)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	s.sources[opts.filename] = starlark_code
+	globals, err := program.Init(e.thread, predeclared)
+	for name, value := range globals {
+		s.globals[name] = value
+	}
+	if err != nil {
+		return e.finish(e.runtimeErrorResult("failed to evaluate the starlark code", err))
+	}
+	result = map[string]interface{}{"message": e.output.String(), "globals": s.globalNames()}
+	if !callFunction {
+		return e.finish(result)
+	}
+	fn, errResult := lookupFunction(s.globals, opts.funcName, "session")
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	returnValue, errResult := callStarlarkFunction(e, opts.funcName, fn, funcArgs)
+	if errResult != nil {
+		return e.finish(errResult)
+	}
+	result["message"] = e.output.String()
+	return e.finish(e.withReturnValue(result, returnValue))
+}
+
+func (s *session) globalNames() []interface{} {
+	names := []interface{}{}
+	for _, name := range s.globals.Keys() {
+		names = append(names, name)
+	}
+	return names
+}
+
+func getSessionCreator() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		s := newSession(starlark.StringDict{})
+		return map[string]interface{}{"sessionId": float64(s.id)}
+	})
+}
+
+func getSessionDestroyer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the session id. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		sessions.Lock()
+		defer sessions.Unlock()
+		delete(sessions.byID, s.id)
+		s.cancelTimers()
+		releaseProxies(s)
+		releaseLazyDicts(s)
+		return map[string]interface{}{"message": fmt.Sprintf("the session %d has been destroyed", s.id)}
+	})
+}
+
+func getSessionRunner() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected at least two arguments with the session id and the source code. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found", "stats": executionStats{}.toJS()}
+		}
+		starlark_code := args[1].String()
+		options := js.Undefined()
+		if len(args) > 2 {
+			options = args[2]
+		}
+		opts, err := parseRunOptions(options)
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}
+		}
+		_, callFunction, _ := getStringOption(options, "funcName")
+		return s.run(starlark_code, opts, callFunction)
+	})
+}
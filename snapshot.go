@@ -0,0 +1,381 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"syscall/js"
+	"unicode/utf8"
+
+	"go.starlark.net/starlark"
+)
+
+// The version 2 added the strings encoded in base64, the snapshots of the version 1 are still restored.
+const (
+	snapshotFormat  = "starlark-webasm-session"
+	snapshotVersion = 2
+)
+
+// taggedValue is the serialized form of a starlark value, tagged with its type.
+// JSON strings are valid UTF-8, so the strings that aren't are encoded in base64 with the encoding "base64".
+type taggedValue struct {
+	Type     string          `json:"t"`
+	Value    json.RawMessage `json:"v,omitempty"`
+	Encoding string          `json:"e,omitempty"`
+}
+
+type sessionSnapshot struct {
+	Format  string                 `json:"format"`
+	Version int                    `json:"version"`
+	Globals map[string]taggedValue `json:"globals"`
+}
+
+func tag(t string, value interface{}) (taggedValue, error) {
+	raw, err := json.Marshal(value)
+	return taggedValue{Type: t, Value: raw}, err
+}
+
+func encodeTaggedValues(values []starlark.Value, visiting map[starlark.Value]bool) ([]taggedValue, error) {
+	encoded := []taggedValue{}
+	for _, value := range values {
+		v, err := encodeTaggedValue(value, visiting)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, v)
+	}
+	return encoded, nil
+}
+
+// encodeTaggedValue serializes the data types of starlark (None, bool, int, float, string, bytes, list, tuple, dict, set).
+// It fails for any other type (functions, modules, etc.) and for values that contain themselves.
+// A value referenced several times is serialized each time, so the decoded copies are distinct values.
+func encodeTaggedValue(value starlark.Value, visiting map[starlark.Value]bool) (taggedValue, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return taggedValue{Type: "NoneType"}, nil
+	case starlark.Bool:
+		return tag("bool", bool(v))
+	case starlark.Int:
+		return tag("int", v.String())
+	case starlark.Float:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return tag("float", v.String())
+		}
+		return tag("float", f)
+	case starlark.String:
+		if !utf8.ValidString(string(v)) {
+			encoded, err := tag("string", base64.StdEncoding.EncodeToString([]byte(v)))
+			encoded.Encoding = "base64"
+			return encoded, err
+		}
+		return tag("string", string(v))
+	case starlark.Bytes:
+		return tag("bytes", base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	switch value.(type) {
+	case *starlark.List, *starlark.Dict, *starlark.Set:
+		// only mutable values can contain themselves
+		if visiting[value] {
+			return taggedValue{}, fmt.Errorf("the value of type %s contains itself", value.Type())
+		}
+		visiting[value] = true
+		defer delete(visiting, value)
+	}
+	switch v := value.(type) {
+	case *starlark.List:
+		elems := []starlark.Value{}
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, v.Index(i))
+		}
+		encoded, err := encodeTaggedValues(elems, visiting)
+		if err != nil {
+			return taggedValue{}, err
+		}
+		return tag("list", encoded)
+	case starlark.Tuple:
+		encoded, err := encodeTaggedValues(v, visiting)
+		if err != nil {
+			return taggedValue{}, err
+		}
+		return tag("tuple", encoded)
+	case *starlark.Set:
+		elems := []starlark.Value{}
+		iter := v.Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for iter.Next(&elem) {
+			elems = append(elems, elem)
+		}
+		encoded, err := encodeTaggedValues(elems, visiting)
+		if err != nil {
+			return taggedValue{}, err
+		}
+		return tag("set", encoded)
+	case *starlark.Dict:
+		items := [][2]taggedValue{}
+		for _, item := range v.Items() {
+			key, err := encodeTaggedValue(item[0], visiting)
+			if err != nil {
+				return taggedValue{}, err
+			}
+			val, err := encodeTaggedValue(item[1], visiting)
+			if err != nil {
+				return taggedValue{}, err
+			}
+			items = append(items, [2]taggedValue{key, val})
+		}
+		return tag("dict", items)
+	default:
+		return taggedValue{}, fmt.Errorf("values of type %s can't be serialized", value.Type())
+	}
+}
+
+func decodeTaggedValues(values []taggedValue, c *converter) ([]starlark.Value, error) {
+	decoded := []starlark.Value{}
+	for _, value := range values {
+		v, err := decodeTaggedValue(value, c)
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, v)
+	}
+	return decoded, nil
+}
+
+// decodeTaggedValue is the inverse of encodeTaggedValue.
+// If c isn't nil the decoded values count against its depth, element and string limits, like the other arguments.
+func decodeTaggedValue(value taggedValue, c *converter) (starlark.Value, error) {
+	if c != nil {
+		c.enter()
+		defer c.leave()
+		if err := c.track(c.depth); err != nil {
+			return nil, err
+		}
+		if err := c.count(); err != nil {
+			return nil, err
+		}
+	}
+	switch value.Type {
+	case "NoneType":
+		return starlark.None, nil
+	case "bool":
+		var b bool
+		err := json.Unmarshal(value.Value, &b)
+		return starlark.Bool(b), err
+	case "int":
+		var s string
+		if err := json.Unmarshal(value.Value, &s); err != nil {
+			return nil, err
+		}
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid int %q", s)
+		}
+		return starlark.MakeBigInt(i), nil
+	case "float":
+		var f float64
+		if err := json.Unmarshal(value.Value, &f); err == nil {
+			return starlark.Float(f), nil
+		}
+		var s string
+		if err := json.Unmarshal(value.Value, &s); err != nil {
+			return nil, err
+		}
+		switch s {
+		case "nan":
+			return starlark.Float(math.NaN()), nil
+		case "+inf":
+			return starlark.Float(math.Inf(1)), nil
+		case "-inf":
+			return starlark.Float(math.Inf(-1)), nil
+		}
+		return nil, fmt.Errorf("invalid float %q", s)
+	case "string":
+		var s string
+		if err := json.Unmarshal(value.Value, &s); err != nil {
+			return nil, err
+		}
+		if value.Encoding != "" && value.Encoding != "base64" {
+			return nil, fmt.Errorf("unknown string encoding %q", value.Encoding)
+		}
+		if value.Encoding == "base64" {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			s = string(b)
+		}
+		if err := checkTaggedString(c, len(s)); err != nil {
+			return nil, err
+		}
+		return starlark.String(s), nil
+	case "bytes":
+		var s string
+		if err := json.Unmarshal(value.Value, &s); err != nil {
+			return nil, err
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTaggedString(c, len(b)); err != nil {
+			return nil, err
+		}
+		return starlark.Bytes(b), nil
+	case "list", "tuple", "set":
+		var encoded []taggedValue
+		if err := json.Unmarshal(value.Value, &encoded); err != nil {
+			return nil, err
+		}
+		elems, err := decodeTaggedValues(encoded, c)
+		if err != nil {
+			return nil, err
+		}
+		switch value.Type {
+		case "list":
+			return starlark.NewList(elems), nil
+		case "tuple":
+			return starlark.Tuple(elems), nil
+		}
+		set := starlark.NewSet(len(elems))
+		for _, elem := range elems {
+			if err := set.Insert(elem); err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+	case "dict":
+		var items [][2]taggedValue
+		if err := json.Unmarshal(value.Value, &items); err != nil {
+			return nil, err
+		}
+		dict := starlark.NewDict(len(items))
+		for _, item := range items {
+			key, err := decodeTaggedValue(item[0], c)
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeTaggedValue(item[1], c)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(key, val); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", value.Type)
+	}
+}
+
+// checkTaggedString applies the string limit of the converter of decodeTaggedValue, if there is one.
+func checkTaggedString(c *converter, length int) error {
+	if c == nil {
+		return nil
+	}
+	return c.checkString(length)
+}
+
+// snapshot serializes the globals of the session.
+// It also returns the names of the globals that have types that can't be serialized.
+func (s *session) snapshot() ([]byte, []interface{}, error) {
+	if busy := s.acquire(); busy != nil {
+		return nil, nil, busy
+	}
+	defer s.release()
+	snapshot := sessionSnapshot{Format: snapshotFormat, Version: snapshotVersion, Globals: map[string]taggedValue{}}
+	skipped := []interface{}{}
+	for _, name := range s.globals.Keys() {
+		encoded, err := encodeTaggedValue(s.globals[name], map[starlark.Value]bool{})
+		if err != nil {
+			skipped = append(skipped, name)
+			continue
+		}
+		snapshot.Globals[name] = encoded
+	}
+	data, err := json.Marshal(snapshot)
+	return data, skipped, err
+}
+
+func restoreSession(data []byte) (*session, error) {
+	snapshot := sessionSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Format != snapshotFormat {
+		return nil, fmt.Errorf("the data is not a session snapshot")
+	}
+	if snapshot.Version < 1 || snapshot.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d. Expected a version from 1 to %d", snapshot.Version, snapshotVersion)
+	}
+	globals := starlark.StringDict{}
+	for name, encoded := range snapshot.Globals {
+		value, err := decodeTaggedValue(encoded, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore the global %q. Error: %w", name, err)
+		}
+		globals[name] = value
+	}
+	return newSession(globals), nil
+}
+
+func getSessionSnapshotter() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the session id. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		data, skipped, err := s.snapshot()
+		if busy, ok := err.(*sessionBusyError); ok {
+			return busy.errorResult()
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: failed to snapshot the session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error()}
+		}
+		snapshot := js.Global().Get("Uint8Array").New(len(data))
+		js.CopyBytesToJS(snapshot, data)
+		return map[string]interface{}{"snapshot": snapshot, "skipped": skipped}
+	})
+}
+
+func getSessionRestorer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 || !args[0].InstanceOf(js.Global().Get("Uint8Array")) {
+			err := fmt.Errorf("Error: expected one argument with the snapshot as a Uint8Array. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		data := make([]byte, args[0].Length())
+		js.CopyBytesToGo(data, args[0])
+		s, err := restoreSession(data)
+		if err != nil {
+			err := fmt.Errorf("Error: failed to restore the session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		return map[string]interface{}{"sessionId": float64(s.id)}
+	})
+}
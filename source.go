@@ -0,0 +1,105 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// sourceTooLargeResult returns the error result of a program that exceeds one of the source limits.
+// limit is the name of the option that sets the limit.
+func sourceTooLargeResult(what string, limit string, max, actual int) map[string]interface{} {
+	err := fmt.Errorf("Error: program too large. The source code has %d %s, the limit (%s) is %d.", actual, what, limit, max)
+	details := map[string]interface{}{"limit": limit, "max": max, "actual": actual}
+	return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "details": details}
+}
+
+// checkSourceSize returns the error result of code larger than the maxSourceBytes option, nil if it may be parsed.
+func (e *execution) checkSourceSize(starlark_code string) map[string]interface{} {
+	if max := e.opts.maxSourceBytes; max > 0 && len(starlark_code) > max {
+		return sourceTooLargeResult("bytes", "maxSourceBytes", max, len(starlark_code))
+	}
+	return nil
+}
+
+// checkSyntaxNodes returns the error result of a file with more nodes than the maxSyntaxNodes option, nil if it may be compiled.
+// The walk stops once the limit is exceeded, so the count in the error is a lower bound.
+func (e *execution) checkSyntaxNodes(f *syntax.File) map[string]interface{} {
+	max := e.opts.maxSyntaxNodes
+	if max == 0 {
+		return nil
+	}
+	nodes := 0
+	syntax.Walk(f, func(node syntax.Node) bool {
+		nodes++
+		return nodes <= max
+	})
+	if nodes > max {
+		return sourceTooLargeResult("syntax nodes (at least)", "maxSyntaxNodes", max, nodes)
+	}
+	return nil
+}
+
+// fileOptions returns the dialect of the code of the execution: the dialect of the instance, with recursion
+// if the execution has a maxCallDepth and while loops if it has the allowWhile option.
+func (e *execution) fileOptions() *syntax.FileOptions {
+	opts := syntax.LegacyFileOptions()
+	if e.callDepth != nil {
+		opts.Recursion = true
+	}
+	if e.opts.allowWhile {
+		opts.While = true
+	}
+	return opts
+}
+
+// parseSource parses the starlark code of the execution after checking the source limits.
+func (e *execution) parseSource(starlark_code string) (*syntax.File, map[string]interface{}) {
+	if errResult := e.checkSourceSize(starlark_code); errResult != nil {
+		return nil, errResult
+	}
+	e.addSource(e.opts.filename, starlark_code)
+	f, err := e.fileOptions().Parse(e.opts.filename, starlark_code, 0)
+	if err != nil {
+		return nil, e.runtimeErrorResult("failed to evaluate the starlark code", err)
+	}
+	if errResult := e.checkSyntaxNodes(f); errResult != nil {
+		return nil, errResult
+	}
+	return f, nil
+}
+
+// compileSource parses and compiles the starlark code of the execution, see parseSource for the limits.
+// The statements are instrumented when the execution is traced, the functions when it has a maxCallDepth.
+func (e *execution) compileSource(starlark_code string, isPredeclared func(string) bool) (*starlark.Program, map[string]interface{}) {
+	f, errResult := e.parseSource(starlark_code)
+	if errResult != nil {
+		return nil, errResult
+	}
+	if e.tracer != nil {
+		e.tracer.instrument(f)
+	}
+	if e.callDepth != nil {
+		instrumentCallDepth(f)
+	}
+	program, err := starlark.FileProgram(f, isPredeclared)
+	if err != nil {
+		return nil, e.runtimeErrorResult("failed to evaluate the starlark code", err)
+	}
+	return program, nil
+}
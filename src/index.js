@@ -1,10 +1,29 @@
 import './wasm_exec.js';
 import wasmFileName from 'url:./main.wasm';
 
-async function initialize() {
+let exited = null;
+let api = globalThis;
+let shutdownName = 'starlark_shutdown';
+
+// options are { namespace, names } (see STARLARK_WASM_OPTIONS in the README).
+async function initialize(options) {
+    if (options) {
+        globalThis.STARLARK_WASM_OPTIONS = options;
+        shutdownName = (options.names && options.names.starlark_shutdown) || shutdownName;
+    }
     const go = new Go();
     const wasmModule = await WebAssembly.instantiateStreaming(fetch(wasmFileName), go.importObject);
-    go.run(wasmModule.instance);
+    exited = go.run(wasmModule.instance);
+    if (options && options.namespace) {
+        api = globalThis[options.namespace];
+    }
+    return api;
+}
+
+async function shutdown() {
+    const result = await api[shutdownName]();
+    await exited;
+    return result;
 }
 
-export { initialize };
+export { initialize, shutdown };
@@ -0,0 +1,4 @@
+// A Web Worker that runs the scripts sent by the coordinator (see configure_starlark_workers in the README).
+import { initialize } from './index.js';
+
+initialize().then(api => api.serve_starlark_worker());
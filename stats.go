@@ -0,0 +1,88 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"syscall/js"
+)
+
+// runtimeStats describes the health of the instance: the memory of the Go runtime and the state kept between the executions,
+// so the host can decide when to recycle the instance (e.g. if the heap keeps growing or sessions are leaked).
+// Unlike runtimeInfo it changes with every execution.
+func runtimeStats() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	executions.Lock()
+	total, running := executions.nextID, len(executions.running)
+	executions.Unlock()
+	sessions.Lock()
+	liveSessions := len(sessions.byID)
+	sessions.Unlock()
+	documents.Lock()
+	liveDocuments := len(documents.byID)
+	documents.Unlock()
+	modules.Lock()
+	registered, cached := len(modules.sources), 0
+	for _, entry := range modules.cache {
+		if entry.globals != nil {
+			cached++
+		}
+	}
+	modules.Unlock()
+	proxies.Lock()
+	liveProxies := len(proxies.all)
+	proxies.Unlock()
+	lazyDicts.Lock()
+	liveLazyDicts := len(lazyDicts.byID)
+	lazyDicts.Unlock()
+	channels.Lock()
+	messages := 0
+	for _, queue := range channels.byName {
+		messages += len(queue)
+	}
+	liveChannels := len(channels.byName)
+	channels.Unlock()
+	scheduler := asyncScheduler.status()
+	return map[string]interface{}{
+		"memory": map[string]interface{}{
+			"heapAllocBytes":  float64(mem.HeapAlloc),
+			"heapSysBytes":    float64(mem.HeapSys),
+			"heapObjects":     float64(mem.HeapObjects),
+			"totalAllocBytes": float64(mem.TotalAlloc),
+			"sysBytes":        float64(mem.Sys),
+			"numGC":           float64(mem.NumGC),
+			"gcPauseTotalMs":  float64(mem.PauseTotalNs) / 1e6,
+		},
+		"goroutines": runtime.NumGoroutine(),
+		"executions": map[string]interface{}{
+			"total":   float64(total),
+			"running": running,
+			"queued":  scheduler["queued"],
+		},
+		"sessions":  liveSessions,
+		"documents": liveDocuments,
+		"modules":   map[string]interface{}{"registered": registered, "cached": cached},
+		"proxies":   liveProxies,
+		"lazyDicts": liveLazyDicts,
+		"channels":  map[string]interface{}{"channels": liveChannels, "messages": messages},
+	}
+}
+
+func getRuntimeStats() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return runtimeStats()
+	})
+}
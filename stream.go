@@ -0,0 +1,86 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+const (
+	defaultChunkSizeBytes       = 64 * 1024
+	defaultStreamThresholdBytes = 1024 * 1024
+)
+
+// streamOptions control how large return values are streamed to the onChunk callback.
+type streamOptions struct {
+	// onChunk is called with each piece of the return value encoded as JSON, undefined (the zero value) if streaming is disabled.
+	onChunk js.Value
+	// chunkSizeBytes is the maximum size of each piece.
+	chunkSizeBytes int
+	// thresholdBytes is the size above which the return value is streamed instead of returned.
+	thresholdBytes int
+}
+
+func parseStreamOptions(options js.Value) (streamOptions, error) {
+	opts := streamOptions{onChunk: js.Undefined(), chunkSizeBytes: defaultChunkSizeBytes, thresholdBytes: defaultStreamThresholdBytes}
+	onChunk, ok, err := getFunctionOption(options, "onChunk")
+	if err != nil || !ok {
+		return opts, err
+	}
+	opts.onChunk = onChunk
+	chunkSizeBytes, ok, err := getNumberOption(options, "chunkSizeBytes")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if chunkSizeBytes < 1 {
+			return opts, fmt.Errorf("the option \"chunkSizeBytes\" must be a positive number. Actual value %v", chunkSizeBytes)
+		}
+		opts.chunkSizeBytes = int(chunkSizeBytes)
+	}
+	thresholdBytes, ok, err := getNumberOption(options, "streamThresholdBytes")
+	if err != nil {
+		return opts, err
+	}
+	if ok {
+		if thresholdBytes < 0 {
+			return opts, fmt.Errorf("the option \"streamThresholdBytes\" must not be negative. Actual value %v", thresholdBytes)
+		}
+		opts.thresholdBytes = int(thresholdBytes)
+	}
+	return opts, nil
+}
+
+// enabled returns true if the return value should be streamed when it is large enough.
+func (s streamOptions) enabled() bool {
+	return !s.onChunk.IsUndefined()
+}
+
+// streamChunks passes the data to the onChunk callback as Uint8Arrays of at most chunkSizeBytes.
+// The pieces don't respect UTF-8 boundaries, they should be decoded with a streaming TextDecoder.
+// It returns the number of chunks.
+func (s streamOptions) streamChunks(data []byte) int {
+	chunks := 0
+	for start := 0; start < len(data); start += s.chunkSizeBytes {
+		end := start + s.chunkSizeBytes
+		if end > len(data) {
+			end = len(data)
+		}
+		s.onChunk.Invoke(copyBytesToUint8Array(data[start:end]), chunks)
+		chunks++
+	}
+	return chunks
+}
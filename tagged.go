@@ -0,0 +1,72 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// The tagged conversions use the serialized form of the session snapshots, where every value is tagged with its starlark type.
+// Unlike the plain conversions they are lossless for the data types: tuples stay tuples, 1.0 stays a float, bytes stay bytes, etc.
+// so a value returned by one call can be passed back to a later call unchanged.
+
+// convertTaggedArgsToStarlarkValues decodes a JSON array of tagged values into the arguments of a function call.
+// The array itself is not counted, so the arguments have the same depth and count as the args option.
+func (c *converter) convertTaggedArgsToStarlarkValues(data string) ([]starlark.Value, error) {
+	var args []taggedValue
+	if err := json.Unmarshal([]byte(data), &args); err != nil {
+		return nil, fmt.Errorf("expected an array of tagged values. Error: %v", err)
+	}
+	for i, arg := range args {
+		if arg.Type == "" {
+			return nil, fmt.Errorf("the argument %d has no type tag", i)
+		}
+	}
+	return decodeTaggedValues(args, c)
+}
+
+// convertToTaggedJSValue encodes a starlark value as a tagged value and converts it into a plain javascript object.
+func convertToTaggedJSValue(value starlark.Value) (js.Value, error) {
+	encoded, err := encodeTaggedValue(value, map[starlark.Value]bool{})
+	if err != nil {
+		return js.Undefined(), err
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return js.Undefined(), err
+	}
+	return jsJSON.Call("parse", string(data)), nil
+}
+
+// parseTaggedArgsOption returns the argsTagged option encoded as JSON, the empty string if it is missing.
+// JSON.stringify throws for the arrays that contain themselves or are nested too deeply, which fails the option.
+func parseTaggedArgsOption(options js.Value) (string, error) {
+	value := getOption(options, "argsTagged")
+	if value.IsUndefined() || value.IsNull() {
+		return "", nil
+	}
+	if !value.InstanceOf(jsArray) {
+		return "", fmt.Errorf("the option \"argsTagged\" must be an array of tagged values. Actual type %s", value.Type())
+	}
+	encoded, err := catchJSError(func() js.Value { return jsJSON.Call("stringify", value) })
+	if err != nil {
+		return "", fmt.Errorf("the option \"argsTagged\" can't be encoded as JSON. Error: %v", err)
+	}
+	return encoded.String(), nil
+}
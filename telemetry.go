@@ -0,0 +1,120 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall/js"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// telemetryEvents are the names of the callbacks that can be registered with register_starlark_telemetry.
+var telemetryEvents = []string{"onExecStart", "onExecEnd", "onError", "onLoadModule"}
+
+// telemetry holds the callbacks that observe the activity of all the executions.
+var telemetry = struct {
+	sync.Mutex
+	callbacks map[string]js.Value
+}{callbacks: map[string]js.Value{}}
+
+func init() {
+	registerResetHook(func() {
+		telemetry.Lock()
+		defer telemetry.Unlock()
+		telemetry.callbacks = map[string]js.Value{}
+	})
+}
+
+// emitTelemetry calls the callback registered for the event, if any.
+// Errors thrown by the callback are ignored so that monitoring can never break an execution.
+func emitTelemetry(event string, data map[string]interface{}) {
+	telemetry.Lock()
+	callback, ok := telemetry.callbacks[event]
+	telemetry.Unlock()
+	if !ok {
+		return
+	}
+	defer func() { recover() }()
+	callback.Invoke(data)
+}
+
+func millisSinceEpoch(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+// telemetryData returns the metadata identifying an execution in the telemetry events.
+func (e *execution) telemetryData() map[string]interface{} {
+	return map[string]interface{}{"executionId": float64(e.id), "thread": e.thread.Name, "funcName": e.opts.funcName, "startMs": millisSinceEpoch(e.start)}
+}
+
+// isModuleCached returns true if loading the module of a load label doesn't execute it.
+func isModuleCached(thread *starlark.Thread, label string) bool {
+	name := mapImport(label)
+	if e := threadExecution(thread); e != nil {
+		if entry, ok := e.fileModules[name]; ok && entry.loaded() {
+			return true
+		}
+	}
+	modules.Lock()
+	defer modules.Unlock()
+	entry, ok := modules.cache[name]
+	return ok && entry.loaded()
+}
+
+// loadModule implements the load statement and emits the onLoadModule event.
+func loadModule(thread *starlark.Thread, name string) (starlark.StringDict, error) {
+	start := time.Now()
+	cached := isModuleCached(thread, name)
+	globals, err := resolveModule(thread, name)
+	data := map[string]interface{}{"module": name, "cached": cached, "durationMs": float64(time.Since(start)) / float64(time.Millisecond)}
+	if e := threadExecution(thread); e != nil {
+		data["executionId"] = float64(e.id)
+		data["thread"] = thread.Name
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	emitTelemetry("onLoadModule", data)
+	return globals, err
+}
+
+func getTelemetryRegisterer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		options := js.Undefined()
+		if len(args) > 0 {
+			options = args[0]
+		}
+		callbacks := map[string]js.Value{}
+		registered := []string{}
+		for _, event := range telemetryEvents {
+			callback, ok, err := getFunctionOption(options, event)
+			if err != nil {
+				err := fmt.Errorf("Error: invalid options. Error: %q", err)
+				return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+			}
+			if ok {
+				callbacks[event] = callback
+				registered = append(registered, event)
+			}
+		}
+		telemetry.Lock()
+		defer telemetry.Unlock()
+		telemetry.callbacks = callbacks
+		return map[string]interface{}{"registered": toJSList(registered)}
+	})
+}
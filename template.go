@@ -0,0 +1,148 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// templateModule is the template module predeclared in every execution.
+// template.render("${name}:${server.port}", {"name": "web"}, server = srv) replaces each ${path} with the value of the path,
+// which is a variable followed by dict keys, attributes or list indexes separated by dots. $$ is a literal $.
+var templateModule = &starlarkstruct.Module{
+	Name: "template",
+	Members: starlark.StringDict{
+		"render": starlark.NewBuiltin("template.render", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			if len(args) < 1 || len(args) > 2 {
+				return nil, fmt.Errorf("%s: got %d arguments, want 1 or 2", b.Name(), len(args))
+			}
+			tmpl, ok := starlark.AsString(args[0])
+			if !ok {
+				return nil, fmt.Errorf("%s: for parameter 1: got %s, want string", b.Name(), args[0].Type())
+			}
+			vars := map[string]starlark.Value{}
+			if len(args) == 2 && args[1] != starlark.None {
+				dict, ok := args[1].(starlark.IterableMapping)
+				if !ok {
+					return nil, fmt.Errorf("%s: for parameter vars: got %s, want dict", b.Name(), args[1].Type())
+				}
+				for _, item := range dict.Items() {
+					key, ok := starlark.AsString(item[0])
+					if !ok {
+						return nil, fmt.Errorf("%s: the keys of vars must be strings. Actual type %s", b.Name(), item[0].Type())
+					}
+					vars[key] = item[1]
+				}
+			}
+			for _, kwarg := range kwargs {
+				vars[string(kwarg[0].(starlark.String))] = kwarg[1]
+			}
+			rendered, err := renderTemplate(tmpl, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			return starlark.String(rendered), nil
+		}),
+	},
+}
+
+// renderTemplate replaces the ${path} placeholders of the template, the errors have the offset of the placeholder.
+func renderTemplate(tmpl string, vars map[string]starlark.Value) (string, error) {
+	var out strings.Builder
+	pos := 0
+	for {
+		i := strings.IndexByte(tmpl[pos:], '$')
+		if i < 0 || pos+i == len(tmpl)-1 {
+			out.WriteString(tmpl[pos:])
+			return out.String(), nil
+		}
+		start := pos + i
+		out.WriteString(tmpl[pos:start])
+		if tmpl[start+1] != '{' {
+			// $$ is a literal $, the other $ are kept as they are.
+			out.WriteByte('$')
+			pos = start + 1
+			if tmpl[start+1] == '$' {
+				pos++
+			}
+			continue
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("the placeholder at offset %d is not closed", start)
+		}
+		path := strings.TrimSpace(tmpl[start+2 : start+end])
+		value, err := lookupTemplatePath(vars, path)
+		if err != nil {
+			return "", fmt.Errorf("the placeholder ${%s} at offset %d: %v", path, start, err)
+		}
+		if s, ok := starlark.AsString(value); ok {
+			out.WriteString(s)
+		} else {
+			out.WriteString(value.String())
+		}
+		pos = start + end + 1
+	}
+}
+
+func lookupTemplatePath(vars map[string]starlark.Value, path string) (starlark.Value, error) {
+	segments := strings.Split(path, ".")
+	value, ok := vars[segments[0]]
+	if !ok {
+		if segments[0] == "" {
+			return nil, fmt.Errorf("the placeholder is empty")
+		}
+		return nil, fmt.Errorf("the variable %q is not defined", segments[0])
+	}
+	for _, segment := range segments[1:] {
+		switch v := value.(type) {
+		case starlark.String:
+			return nil, fmt.Errorf("the string has no field %q", segment)
+		case starlark.Mapping:
+			found, ok, err := v.Get(starlark.String(segment))
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("the key %q is missing", segment)
+			}
+			value = found
+		case starlark.Indexable:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= v.Len() {
+				return nil, fmt.Errorf("%q is not an index of the %s of length %d", segment, v.Type(), v.Len())
+			}
+			value = v.Index(index)
+		case starlark.HasAttrs:
+			found, err := v.Attr(segment)
+			if err != nil {
+				return nil, err
+			}
+			if found == nil {
+				return nil, fmt.Errorf("the %s has no attribute %q", v.Type(), segment)
+			}
+			value = found
+		default:
+			return nil, fmt.Errorf("the %s has no field %q", value.Type(), segment)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// sessionTimer is a call of a starlark function scheduled with the javascript timers.
+type sessionTimer struct {
+	id       uint64
+	fn       starlark.Callable
+	args     starlark.Tuple
+	repeat   bool
+	handle   js.Value
+	callback js.Func
+}
+
+// newScheduleBuiltin returns the schedule(delay_seconds, fn, *args, repeat = False) builtin of a session.
+// It calls fn with the args after the delay (every delay_seconds if repeat is true) and returns a handle with the id of the timer and a cancel method.
+func newScheduleBuiltin(s *session) *starlark.Builtin {
+	return starlark.NewBuiltin("schedule", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%s: expected at least two arguments with the delay and the function. Actual len(args) %d", b.Name(), len(args))
+		}
+		delay, ok := starlark.AsFloat(args[0])
+		if !ok {
+			return nil, fmt.Errorf("%s: the delay must be a number. Actual type %s", b.Name(), args[0].Type())
+		}
+		fn, ok := args[1].(starlark.Callable)
+		if !ok {
+			return nil, fmt.Errorf("%s: the function must be callable. Actual type %s", b.Name(), args[1].Type())
+		}
+		repeat := false
+		if err := starlark.UnpackArgs(b.Name(), nil, kwargs, "repeat?", &repeat); err != nil {
+			return nil, err
+		}
+		if math.IsNaN(delay) || delay < 0 || (repeat && delay == 0) {
+			return nil, fmt.Errorf("%s: the delay must be a positive number of seconds (or zero without repeat). Actual value %v", b.Name(), delay)
+		}
+		t := s.startTimer(delay, fn, args[2:], repeat)
+		return starlarkstruct.FromStringDict(starlark.String("timer"), starlark.StringDict{
+			"id": starlark.MakeUint64(t.id),
+			"cancel": starlark.NewBuiltin("timer.cancel", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+				if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+					return nil, err
+				}
+				return starlark.Bool(s.cancelTimer(t.id)), nil
+			}),
+		}), nil
+	})
+}
+
+func (s *session) startTimer(delay float64, fn starlark.Callable, args starlark.Tuple, repeat bool) *sessionTimer {
+	s.timersMu.Lock()
+	defer s.timersMu.Unlock()
+	s.nextTimerID++
+	t := &sessionTimer{id: s.nextTimerID, fn: fn, args: args, repeat: repeat}
+	// the timer fires on the javascript event loop, the function runs on a goroutine so that it can wait for the session to be free
+	t.callback = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		go s.fireTimer(t)
+		return nil
+	})
+	setTimer := "setTimeout"
+	if repeat {
+		setTimer = "setInterval"
+	}
+	t.handle = js.Global().Call(setTimer, t.callback, delay*1000)
+	s.timers[t.id] = t
+	return t
+}
+
+// cancelTimer stops the timer and returns false if it had already fired or had been cancelled.
+func (s *session) cancelTimer(id uint64) bool {
+	s.timersMu.Lock()
+	defer s.timersMu.Unlock()
+	t, ok := s.timers[id]
+	if !ok {
+		return false
+	}
+	s.stopTimer(t)
+	return true
+}
+
+// cancelTimers stops all the timers of the session.
+func (s *session) cancelTimers() {
+	s.timersMu.Lock()
+	defer s.timersMu.Unlock()
+	for _, t := range s.timers {
+		s.stopTimer(t)
+	}
+}
+
+// cancelAllTimers stops the timers of all the sessions.
+func cancelAllTimers() {
+	sessions.Lock()
+	defer sessions.Unlock()
+	for _, s := range sessions.byID {
+		s.cancelTimers()
+	}
+}
+
+// stopTimer must be called with the timers mutex held.
+func (s *session) stopTimer(t *sessionTimer) {
+	clearTimer := "clearTimeout"
+	if t.repeat {
+		clearTimer = "clearInterval"
+	}
+	js.Global().Call(clearTimer, t.handle)
+	t.callback.Release()
+	delete(s.timers, t.id)
+}
+
+// fireTimer calls the function of the timer in a new execution of the session.
+// Nobody is waiting for the result, the output of print goes to the console and the errors are reported to console.error and the onError telemetry callback.
+func (s *session) fireTimer(t *sessionTimer) {
+	s.wait()
+	defer s.release()
+	s.timersMu.Lock()
+	if _, ok := s.timers[t.id]; !ok {
+		// cancelled while waiting for the session
+		s.timersMu.Unlock()
+		return
+	}
+	if !t.repeat {
+		t.callback.Release()
+		delete(s.timers, t.id)
+	}
+	s.timersMu.Unlock()
+	result := s.callTimer(t)
+	if err, ok := result["error"]; ok {
+		js.Global().Get("console").Call("error", fmt.Sprintf("the timer %d of the session %d failed: %s", t.id, s.id, err))
+	}
+}
+
+func (s *session) callTimer(t *sessionTimer) (result map[string]interface{}) {
+	e := newExecution(runOptions{funcName: t.fn.Name(), printTo: "console"})
+	e.session = s
+	defer e.recoverPanic(&result)
+	if errResult := e.sessionQuotaErrorResult(); errResult != nil {
+		return e.finish(errResult)
+	}
+	if _, errResult := callStarlarkFunction(e, t.fn.Name(), t.fn, t.args); errResult != nil {
+		return e.finish(errResult)
+	}
+	return e.finish(map[string]interface{}{"message": e.output.String()})
+}
@@ -0,0 +1,726 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gendts generates the typescript type definitions of the functions exported by the wasm module.
+// It checks that every function in the export table of main.go is described below,
+// so adding an export without describing it makes go generate fail.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// field is a property of an object type or a parameter of a function.
+type field struct {
+	name     string
+	typ      string
+	optional bool
+	doc      string
+}
+
+// typeDecl is an interface or a type alias, aliases have no fields.
+type typeDecl struct {
+	name   string
+	doc    string
+	alias  string
+	fields []field
+}
+
+// function is a function exported by the wasm module.
+type function struct {
+	name   string
+	doc    string
+	params []field
+	result string
+}
+
+var types = []typeDecl{
+	{name: "Position", fields: []field{
+		{name: "line", typ: "number"},
+		{name: "col", typ: "number"},
+	}},
+	{name: "Stats", doc: "The cost of an execution.", fields: []field{
+		{name: "steps", typ: "number", doc: "Starlark computation steps executed."},
+		{name: "durationMs", typ: "number", doc: "Wall-clock duration."},
+		{name: "printCalls", typ: "number", doc: "Number of print calls."},
+		{name: "conversionDepth", typ: "number", doc: "The deepest nesting of the values converted between Javascript and Starlark."},
+		{name: "yields", typ: "number", doc: "The number of times the execution yielded to the event loop (see yieldEverySteps)."},
+	}},
+	{name: "ErrorCode", alias: `"invalid_argument" | "resource_exhausted" | "not_found" | "failed_precondition" | "internal" | "cancelled" | "deadline_exceeded" | "out_of_range"`},
+	{name: "ErrorResult", doc: "Returned when something fails.", fields: []field{
+		{name: "error", typ: "string"},
+		{name: "errorCode", typ: "ErrorCode", optional: true, doc: "Set for errors caused by the host environment (invalid options, exceeded limits, etc.)"},
+		{name: "errorKind", typ: `"user" | "internal"`, optional: true, doc: "user if the script failed deliberately with fail() or fail_with(), internal otherwise. Set on the results of executions."},
+		{name: "details", typ: "Record<string, unknown>", optional: true},
+	}},
+	{name: "BacktraceFrame", doc: "A frame of the backtrace of a runtime error, in details.backtrace.", fields: []field{
+		{name: "name", typ: "string", doc: "The name of the function."},
+		{name: "filename", typ: "string"},
+		{name: "line", typ: "number"},
+		{name: "col", typ: "number"},
+		{name: "source", typ: "string", optional: true, doc: "The text of the source line, missing for builtins."},
+		{name: "caret", typ: "string", optional: true, doc: "A line with a caret under the column of the source line."},
+	}},
+	{name: "MessageResult", fields: []field{
+		{name: "message", typ: "string"},
+	}},
+	{name: "LogRecord", fields: []field{
+		{name: "level", typ: `"debug" | "info" | "warn" | "error"`},
+		{name: "message", typ: "string"},
+		{name: "fields", typ: "Record<string, unknown>"},
+		{name: "thread", typ: "string"},
+		{name: "timestampMs", typ: "number"},
+		{name: "position", typ: "{ file: string; line: number; col: number }", optional: true},
+	}},
+	{name: "AuditRecord", doc: "A call the script made to the host.", fields: []field{
+		{name: "name", typ: "string", doc: "The name of the builtin, load for the modules fetched over https."},
+		{name: "args", typ: "string", doc: "The reprs of the arguments, each one truncated to 64 bytes."},
+		{name: "status", typ: `"ok" | "error" | "denied" | "quota" | "dry_run"`, doc: "denied if the capability of the builtin has not been granted, quota if the call exceeded one of the hostCallQuotas, dry_run if it was recorded as an effect."},
+		{name: "error", typ: "string", optional: true},
+		{name: "durationMs", typ: "number"},
+		{name: "timestampMs", typ: "number"},
+		{name: "position", typ: "{ file: string; line: number; col: number }", optional: true},
+	}},
+	{name: "DryRunEffect", doc: "A call to the host intercepted by a dry run.", fields: []field{
+		{name: "name", typ: "string"},
+		{name: "args", typ: "unknown[]"},
+		{name: "kwargs", typ: "Record<string, unknown>"},
+		{name: "position", typ: "{ file: string; line: number; col: number }", optional: true},
+	}},
+	{name: "RunOptions", fields: []field{
+		{name: "funcName", typ: "string", optional: true, doc: "The name of the function to call (default: main), or a dotted path like handlers.on_save to an attribute of a global."},
+		{name: "filename", typ: "string", optional: true, doc: "The name of the file of the code in error positions and backtraces."},
+		{name: "script", typ: "boolean", optional: true, doc: "Execute the code without calling a function, the result has the globals."},
+		{name: "pipeline", typ: "string[]", optional: true, doc: "Functions called in order with the return value of the previous one, used instead of funcName."},
+		{name: "args", typ: "unknown[]", optional: true, doc: "The arguments passed to the function."},
+		{name: "argsJson", typ: "string", optional: true, doc: "The arguments encoded as a JSON array, used instead of args."},
+		{name: "argsTagged", typ: "TaggedValue[]", optional: true, doc: "The arguments as values tagged with their starlark type, used instead of args."},
+		{name: "returnTagged", typ: "boolean", optional: true, doc: "Return the return value tagged with its starlark type in returnValueTagged."},
+		{name: "returnJson", typ: "boolean", optional: true, doc: "Return the return value encoded as JSON in returnValueJson."},
+		{name: "argsMsgpack", typ: "Uint8Array", optional: true, doc: "The arguments encoded as a MessagePack array, used instead of args."},
+		{name: "returnMsgpack", typ: "boolean", optional: true, doc: "Return the return value encoded as MessagePack in returnValueMsgpack."},
+		{name: "returnSchema", typ: "ValueSchema", optional: true, doc: "The expected shape of the return value, a small subset of JSON Schema."},
+		{name: "returnBinary", typ: "boolean", optional: true, doc: "Return bytes and lists of ints as typed arrays."},
+		{name: "freezeArgs", typ: "boolean", optional: true, doc: "Freeze the arguments before the call."},
+		{name: "freezeReturnValue", typ: "boolean", optional: true, doc: "Deep freeze the converted return value with Object.freeze."},
+		{name: "returnFunctions", typ: "boolean", optional: true, doc: "Return starlark functions as callable javascript functions instead of null."},
+		{name: "returnLazy", typ: "boolean", optional: true, doc: "Return a dict as a read only Proxy that converts its values when they are read, with materialize() and release() methods."},
+		{name: "returnRepr", typ: "boolean", optional: true, doc: "Add the repr of the return value to the result."},
+		{name: "returnPretty", typ: "boolean | PrettyOptions", optional: true, doc: "Add the pretty printed return value to the result."},
+		{name: "onChunk", typ: "(chunk: Uint8Array, index: number) => void", optional: true, doc: "Receives large return values encoded as JSON."},
+		{name: "chunkSizeBytes", typ: "number", optional: true},
+		{name: "streamThresholdBytes", typ: "number", optional: true},
+		{name: "fs", typ: "FileSystem | Record<string, string>", optional: true, doc: "The virtual filesystem used by load and the file builtins."},
+		{name: "fileBuiltins", typ: "boolean", optional: true, doc: "Add the read_file, write_file and glob builtins."},
+		{name: "archive", typ: "Uint8Array", optional: true, doc: "A zip, tar or gzipped tar archive read by the archive module."},
+		{name: "locale", typ: "string", optional: true, doc: "The default locale of the intl module, e.g. de-DE."},
+		{name: "env", typ: "Record<string, unknown>", optional: true, doc: "The environment scripts read with env.get(key, default)."},
+		{name: "capabilities", typ: "string[]", optional: true, doc: "The capabilities granted to the script (time, fs, fetch and the ones of the registered builtins), none by default."},
+		{name: "timeModule", typ: "boolean", optional: true, doc: "Add the time module."},
+		{name: "clock", typ: "number | { startMs: number; stepMs?: number } | (() => number)", optional: true, doc: "The clock read by time.now, in milliseconds since the Unix epoch."},
+		{name: "signal", typ: "AbortSignal", optional: true, doc: "Cancels the execution, scripts can check it with check_cancelled()."},
+		{name: "cancelGraceSteps", typ: "number", optional: true, doc: "The steps a script may run after the cancellation before it is stopped forcibly."},
+		{name: "maxOutputBytes", typ: "number", optional: true, doc: "The maximum number of bytes of output kept in the message."},
+		{name: "keepOutputTail", typ: "boolean", optional: true, doc: "Keep the first and the last half of maxOutputBytes instead of the first bytes."},
+		{name: "printTo", typ: `"buffer" | "console" | "both"`, optional: true, doc: "Where the output of print goes (default: buffer, the message of the result)."},
+		{name: "onLog", typ: "(record: LogRecord) => void", optional: true, doc: "Receives the records of the log module, they are added to the result (logs) otherwise."},
+		{name: "audit", typ: "boolean", optional: true, doc: "Record the calls to the host in the audit field of the result."},
+		{name: "onAudit", typ: "(record: AuditRecord) => void", optional: true, doc: "Receives the records of the calls to the host instead of the audit field."},
+		{name: "hostCallQuotas", typ: "Record<string, number>", optional: true, doc: "The maximum numbers of calls to the host by builtin, module, capability or * for all of them."},
+		{name: "dryRun", typ: "boolean", optional: true, doc: "Record the calls to the host that may have effects in effects instead of making them."},
+		{name: "dryRunAllow", typ: "string[]", optional: true, doc: "The builtins, modules or capabilities that are called as usual in a dry run."},
+		{name: "logLevel", typ: `"debug" | "info" | "warn" | "error"`, optional: true, doc: "The lowest level that is recorded (default: debug)."},
+		{name: "onProgress", typ: "(progress: { fraction: number; message: string; steps: number }) => void", optional: true, doc: "Called by report_progress(fraction, message)."},
+		{name: "onInput", typ: "(prompt: string) => string | null | Promise<string | null>", optional: true, doc: "Answers the prompts of input(prompt), only in run_starlark_code_async."},
+		{name: "onTrace", typ: "(event: TraceEvent) => void", optional: true, doc: "Called before every statement executed."},
+		{name: "maxTraceEvents", typ: "number", optional: true, doc: "The maximum number of calls to onTrace (default: 10000)."},
+		{name: "timeoutMs", typ: "number", optional: true, doc: "The maximum duration of the execution."},
+		{name: "verifyDeterminism", typ: "boolean", optional: true, doc: "Run the call twice and report whether the two runs behaved the same in determinism."},
+		{name: "record", typ: "boolean", optional: true, doc: "Capture the inputs and the calls to the host of the execution into a recording that replay_starlark_recording runs again."},
+		{name: "yieldEverySteps", typ: "number", optional: true, doc: "Yield to the event loop every yieldEverySteps steps in run_starlark_code_async."},
+		{name: "maxMemoryBytes", typ: "number", optional: true, doc: "The maximum amount the heap may grow during the execution."},
+		{name: "numbersAsFloats", typ: "boolean", optional: true, doc: "Convert the numbers of the arguments to floats even if they are whole numbers."},
+		{name: "intOverflow", typ: `"error" | "bigint" | "string" | "float"`, optional: true, doc: "How returned ints that don't fit in 64 bits are converted."},
+		{name: "invalidUnicode", typ: `"replace" | "error" | "passthrough"`, optional: true, doc: "How the strings with lone surrogates or invalid UTF-8 are converted (default: replace)."},
+		{name: "maxConversionDepth", typ: "number", optional: true, doc: "The maximum nesting depth of the arguments and the return value."},
+		{name: "maxSourceBytes", typ: "number", optional: true, doc: "The maximum size of the source code in bytes."},
+		{name: "maxSyntaxNodes", typ: "number", optional: true, doc: "The maximum number of nodes in the syntax tree of the source code."},
+		{name: "maxCallDepth", typ: "number", optional: true, doc: "Allows recursion and limits the number of frames of the call stack."},
+		{name: "allowWhile", typ: "boolean", optional: true, doc: "Allows while loops in the code."},
+		{name: "maxArgumentElements", typ: "number", optional: true, doc: "The maximum number of values in the arguments of a call."},
+		{name: "maxArgumentStringBytes", typ: "number", optional: true, doc: "The maximum length in UTF-8 bytes of the strings in the arguments."},
+	}},
+	{name: "FileSystem", doc: "A virtual filesystem implemented in Javascript, all the methods are synchronous.", fields: []field{
+		{name: "readFile", typ: "(name: string) => string | undefined"},
+		{name: "writeFile", typ: "(name: string, content: string) => void", optional: true},
+		{name: "glob", typ: "(pattern: string) => string[]", optional: true},
+	}},
+	{name: "RunSuccess", fields: []field{
+		{name: "message", typ: "string", doc: "The output of the print calls."},
+		{name: "truncated", typ: "boolean", optional: true, doc: "Set if some output was dropped because of maxOutputBytes."},
+		{name: "cancelled", typ: "boolean", optional: true, doc: "Set if the script noticed the cancellation and stopped by itself."},
+		{name: "returnValue", typ: "unknown", optional: true},
+		{name: "globals", typ: "Record<string, unknown>", optional: true, doc: "The globals of the code run with the script option."},
+		{name: "returnValueJson", typ: "string", optional: true},
+		{name: "returnValueMsgpack", typ: "Uint8Array", optional: true},
+		{name: "returnValueTagged", typ: "TaggedValue", optional: true},
+		{name: "repr", typ: "string", optional: true},
+		{name: "pretty", typ: "string", optional: true, doc: "The pretty printed return value, with returnPretty."},
+		{name: "streamed", typ: "{ chunks: number; bytes: number }", optional: true},
+	}},
+	{name: "DeterminismMismatch", fields: []field{
+		{name: "field", typ: `"message" | "returnValue" | "error" | "files" | "logs" | "hostCalls"`},
+		{name: "first", typ: "unknown", doc: "The value of the first run, the first record that differs for logs and hostCalls."},
+		{name: "second", typ: "unknown"},
+		{name: "index", typ: "number", optional: true, doc: "The index of the first record that differs."},
+	}},
+	{name: "DeterminismReport", fields: []field{
+		{name: "deterministic", typ: "boolean"},
+		{name: "mismatches", typ: "DeterminismMismatch[]"},
+	}},
+	{name: "RecordedCall", fields: []field{
+		{name: "name", typ: "string", doc: "The name of the builtin, module members are prefixed with the name of the module."},
+		{name: "args", typ: "string", doc: "The reprs of the arguments separated by commas."},
+		{name: "result", typ: "TaggedValue", optional: true, doc: "The result, time values and durations are tagged time and duration."},
+		{name: "error", typ: "string", optional: true},
+		{name: "unserializable", typ: "string", optional: true, doc: "Why the result couldn't be recorded, the call fails in replays."},
+	}},
+	{name: "Recording", doc: "A recorded execution, it can be serialized with JSON.stringify.", fields: []field{
+		{name: "format", typ: `"starlark-recording"`},
+		{name: "version", typ: "number"},
+		{name: "code", typ: "string"},
+		{name: "options", typ: "RunOptions", doc: "The recorded options, without the callbacks and the host objects."},
+		{name: "argsTagged", typ: "TaggedValue[]"},
+		{name: "modules", typ: "Record<string, { source?: string; filesystem?: boolean; error?: string }>", doc: "The modules loaded by the execution."},
+		{name: "calls", typ: "RecordedCall[]", doc: "The calls to the host in order."},
+		{name: "outcome", typ: "{ message?: string; returnValue?: string; error?: string }", doc: "The output, the repr of the return value and the error of the execution."},
+	}},
+	{name: "ReplayMismatch", fields: []field{
+		{name: "field", typ: `"hostCalls" | "message" | "returnValue" | "error"`},
+		{name: "recorded", typ: "unknown", doc: "The recorded value, the first call that differs for hostCalls (null if the replay made more calls)."},
+		{name: "replayed", typ: "unknown", doc: "The value of the replay, null for hostCalls if the replay made fewer calls."},
+		{name: "index", typ: "number", optional: true, doc: "The index of the first call that differs."},
+	}},
+	{name: "ReplayReport", fields: []field{
+		{name: "deterministic", typ: "boolean", doc: "Set if the replay made the same calls and had the same outcome as the recorded execution."},
+		{name: "calls", typ: "number", doc: "The number of recorded calls."},
+		{name: "replayedCalls", typ: "number", doc: "The number of recorded calls answered before the end or the divergence of the replay."},
+		{name: "mismatches", typ: "ReplayMismatch[]"},
+	}},
+	{name: "TraceEvent", doc: "A statement about to be executed in the trace mode.", fields: []field{
+		{name: "index", typ: "number"},
+		{name: "file", typ: "string"},
+		{name: "line", typ: "number"},
+		{name: "col", typ: "number"},
+		{name: "statement", typ: "string", doc: "The kind of the statement, like AssignStmt or ForStmt."},
+		{name: "function", typ: "string", doc: "The name of the function running the statement, <toplevel> for the statements of the module."},
+		{name: "depth", typ: "number", doc: "The number of calls under the function on the stack, 0 at the top level and in the function called by the host."},
+		{name: "steps", typ: "number"},
+		{name: "locals", typ: "Record<string, string>", doc: "The truncated reprs of the assigned local variables, or of the globals at the top level."},
+	}},
+	{name: "TraceSummary", fields: []field{
+		{name: "events", typ: "number"},
+		{name: "truncated", typ: "boolean", doc: "Set if statements were executed after maxTraceEvents was reached."},
+	}},
+	{name: "ScriptResult", doc: "The return value of a function that returned result.ok(value) or result.err(code, message, details).", alias: "{ ok: true; value: unknown } | { ok: false; code: string; message: string; details: unknown }"},
+	{name: "ConversionWarning", doc: "A part of an argument, or of the value of a builtin registered by Javascript, that was converted lossily.", fields: []field{
+		{name: "path", typ: "string", doc: "The path of the part, like args[0].items[2].name."},
+		{name: "message", typ: "string"},
+	}},
+	{name: "RunResult", alias: "(RunSuccess | ErrorResult) & { stats: Stats; conversionWarnings?: ConversionWarning[]; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[]; determinism?: DeterminismReport; recording?: Recording; replay?: ReplayReport; trace?: TraceSummary }"},
+	{name: "BatchCall", fields: []field{
+		{name: "funcName", typ: "string", optional: true, doc: "The name of the function to call (default: main), or a dotted path like handlers.on_save to an attribute of a global."},
+		{name: "args", typ: "unknown[]", optional: true},
+		{name: "argsJson", typ: "string", optional: true},
+		{name: "argsMsgpack", typ: "Uint8Array", optional: true},
+	}},
+	{name: "ValueSchema", fields: []field{
+		{name: "type", typ: "SchemaType | SchemaType[]", optional: true},
+		{name: "enum", typ: "unknown[]", optional: true},
+		{name: "items", typ: "ValueSchema", optional: true},
+		{name: "properties", typ: "Record<string, ValueSchema>", optional: true},
+		{name: "required", typ: "string[]", optional: true},
+		{name: "additionalProperties", typ: "boolean", optional: true},
+	}},
+	{name: "SchemaType", alias: `"string" | "integer" | "number" | "boolean" | "null" | "array" | "object"`},
+	{name: "FunctionParameter", fields: []field{
+		{name: "name", typ: "string"},
+		{name: "kind", typ: `"positional" | "varargs" | "keyword_only" | "kwargs"`},
+		{name: "default", typ: "string", optional: true, doc: "The source text of the default value."},
+		{name: "bound", typ: "boolean", optional: true, doc: "The parameter was bound by partial."},
+	}},
+	{name: "FunctionSignature", fields: []field{
+		{name: "name", typ: "string"},
+		{name: "signature", typ: "string"},
+		{name: "params", typ: "FunctionParameter[]", optional: true, doc: "Missing for builtins."},
+	}},
+	{name: "StarlarkFunction", doc: "A starlark function returned with returnFunctions.", alias: "((...args: unknown[]) => RunResult) & { release(): void; partial(args?: unknown[], kwargs?: Record<string, unknown>): { function: StarlarkFunction } | ErrorResult; signature(): FunctionSignature }"},
+	{name: "BenchmarkSummary", fields: []field{
+		{name: "min", typ: "number"},
+		{name: "median", typ: "number"},
+		{name: "p95", typ: "number"},
+		{name: "mean", typ: "number"},
+		{name: "max", typ: "number"},
+	}},
+	{name: "BenchmarkSuccess", fields: []field{
+		{name: "message", typ: "string", doc: "The output of the execution of the code, the output of the calls is discarded."},
+		{name: "funcName", typ: "string"},
+		{name: "iterations", typ: "number"},
+		{name: "warmup", typ: "number"},
+		{name: "durationMs", typ: "BenchmarkSummary"},
+		{name: "steps", typ: "BenchmarkSummary"},
+	}},
+	{name: "BenchmarkResult", alias: "(BenchmarkSuccess | ErrorResult) & { stats: Stats }"},
+	{name: "BatchCallResult", alias: "(RunSuccess | ErrorResult) & { funcName: string; steps: number }"},
+	{name: "BatchSuccess", fields: []field{
+		{name: "message", typ: "string", doc: "The output of the print calls made while executing the module."},
+		{name: "results", typ: "BatchCallResult[]"},
+	}},
+	{name: "BatchResult", alias: "(BatchSuccess | ErrorResult) & { stats: Stats }"},
+	{name: "QueueInfo", fields: []field{
+		{name: "position", typ: "number"},
+		{name: "waitMs", typ: "number"},
+	}},
+	{name: "AsyncRunOptions", alias: "RunOptions & { rejectOnError?: boolean }"},
+	{name: "AsyncRunResult", alias: "RunResult & { queue?: QueueInfo }"},
+	{name: "StarlarkError", doc: "The reason of the rejected promises when rejectOnError is set.", alias: "Error & Omit<ErrorResult, \"error\"> & { name: \"StarlarkError\"; stats: Stats; queue?: QueueInfo }"},
+	{name: "TaggedValue", doc: "A value tagged with its starlark type, see the README for the encoding of each type.", fields: []field{
+		{name: "t", typ: `"NoneType" | "bool" | "int" | "float" | "string" | "bytes" | "list" | "tuple" | "set" | "dict"`},
+		{name: "v", typ: "unknown", optional: true},
+		{name: "e", typ: `"base64"`, optional: true, doc: "Set for the strings that are not valid UTF-8, whose v is their bytes in base64."},
+	}},
+	{name: "SchedulerOptions", fields: []field{
+		{name: "maxConcurrency", typ: "number", optional: true},
+		{name: "maxQueueLength", typ: "number", optional: true},
+	}},
+	{name: "SchedulerStatus", fields: []field{
+		{name: "running", typ: "number"},
+		{name: "queued", typ: "number"},
+		{name: "maxConcurrency", typ: "number"},
+		{name: "maxQueueLength", typ: "number"},
+	}},
+	{name: "RuntimeInfo", doc: "Describes the interpreter, its builtins and the configured limits.", fields: []field{
+		{name: "starlarkVersion", typ: "string", doc: "The version of go.starlark.net."},
+		{name: "goVersion", typ: "string"},
+		{name: "compiler", typ: `"gc" | "tinygo"`, doc: "The compiler the wasm binary was built with."},
+		{name: "dialect", typ: "Record<\"set\" | \"globalReassign\" | \"recursion\" | \"nestedDef\" | \"lambda\" | \"float\" | \"bitwise\", boolean>", doc: "The optional language features that are enabled."},
+		{name: "functions", typ: "string[]", doc: "The exported functions."},
+		{name: "builtins", typ: "string[]", doc: "The universal builtins."},
+		{name: "modules", typ: "{ always: string[]; options: Record<string, string>; session: string[]; host: string[] }", doc: "The other predeclared names, the optional ones with the option that enables them and the ones registered by the host."},
+		{name: "capabilities", typ: "Record<string, string[]>", doc: "The builtins each capability grants."},
+		{name: "registeredModules", typ: "string[]"},
+		{name: "preludeGlobals", typ: "string[]"},
+		{name: "limits", typ: "{ maxConcurrency: number; maxQueueLength: number; maxChannelMessages: number; defaultMaxConversionDepth: number; defaultCancelGraceSteps: number; allowedHosts: string[] }"},
+	}},
+	{name: "RuntimeStats", doc: "The memory of the Go runtime and the state kept between the executions.", fields: []field{
+		{name: "memory", typ: "{ heapAllocBytes: number; heapSysBytes: number; heapObjects: number; totalAllocBytes: number; sysBytes: number; numGC: number; gcPauseTotalMs: number }"},
+		{name: "goroutines", typ: "number"},
+		{name: "executions", typ: "{ total: number; running: number; queued: number }", doc: "total counts all the executions since the instance started."},
+		{name: "sessions", typ: "number", doc: "The sessions that have not been destroyed."},
+		{name: "documents", typ: "number", doc: "The documents that have not been closed."},
+		{name: "modules", typ: "{ registered: number; cached: number }", doc: "cached counts the modules whose globals are cached."},
+		{name: "proxies", typ: "number", doc: "The function proxies that have not been released."},
+		{name: "lazyDicts", typ: "number", doc: "The lazy dicts that have not been released."},
+		{name: "channels", typ: "{ channels: number; messages: number }"},
+	}},
+	{name: "WorkersOptions", fields: []field{
+		{name: "count", typ: "number", doc: "The number of workers, 0 terminates the current ones."},
+		{name: "spawn", typ: "(index: number) => Worker", optional: true, doc: "Creates a worker that loads the package and calls serve_starlark_worker (e.g. src/worker.js)."},
+	}},
+	{name: "WorkerStatus", fields: []field{
+		{name: "index", typ: "number"},
+		{name: "ready", typ: "boolean", doc: "Set once the worker called serve_starlark_worker."},
+		{name: "failed", typ: "boolean", doc: "Set if the worker emitted an error event, it doesn't get new runs."},
+		{name: "pending", typ: "number"},
+		{name: "completed", typ: "number"},
+	}},
+	{name: "WorkerPoolStatus", fields: []field{
+		{name: "workers", typ: "WorkerStatus[]"},
+	}},
+	{name: "SessionResult", fields: []field{
+		{name: "sessionId", typ: "number"},
+	}},
+	{name: "SessionQuotas", doc: "SessionQuotas limit the usage of a session over its lifetime, a missing quota is unlimited.", fields: []field{
+		{name: "maxExecutions", typ: "number", optional: true},
+		{name: "maxSteps", typ: "number", optional: true},
+		{name: "maxDurationMs", typ: "number", optional: true},
+		{name: "maxHostCalls", typ: "number", optional: true},
+	}},
+	{name: "SessionUsage", fields: []field{
+		{name: "sessionId", typ: "number"},
+		{name: "executions", typ: "number"},
+		{name: "steps", typ: "number"},
+		{name: "durationMs", typ: "number"},
+		{name: "hostCalls", typ: "number"},
+		{name: "rejected", typ: "number"},
+		{name: "quotas", typ: "Required<SessionQuotas>"},
+	}},
+	{name: "SessionRunSuccess", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "globals", typ: "string[]"},
+		{name: "returnValue", typ: "unknown", optional: true},
+		{name: "returnValueJson", typ: "string", optional: true},
+		{name: "returnValueMsgpack", typ: "Uint8Array", optional: true},
+		{name: "returnValueTagged", typ: "TaggedValue", optional: true},
+		{name: "repr", typ: "string", optional: true},
+		{name: "pretty", typ: "string", optional: true, doc: "The pretty printed return value, with returnPretty."},
+		{name: "streamed", typ: "{ chunks: number; bytes: number }", optional: true},
+	}},
+	{name: "SessionRunResult", alias: "(SessionRunSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] }"},
+	{name: "DispatchSuccess", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "handlers", typ: "number"},
+		{name: "returnValue", typ: "unknown[]", optional: true},
+		{name: "returnValueJson", typ: "string", optional: true},
+		{name: "returnValueMsgpack", typ: "Uint8Array", optional: true},
+		{name: "returnValueTagged", typ: "TaggedValue", optional: true},
+		{name: "repr", typ: "string", optional: true},
+		{name: "pretty", typ: "string", optional: true, doc: "The pretty printed return value, with returnPretty."},
+		{name: "streamed", typ: "{ chunks: number; bytes: number }", optional: true},
+	}},
+	{name: "HandlerSuccess", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "handler", typ: "string", doc: "The name of the handler."},
+		{name: "returnValue", typ: "unknown", optional: true},
+		{name: "returnValueJson", typ: "string", optional: true},
+		{name: "returnValueMsgpack", typ: "Uint8Array", optional: true},
+		{name: "returnValueTagged", typ: "TaggedValue", optional: true},
+		{name: "repr", typ: "string", optional: true},
+		{name: "pretty", typ: "string", optional: true, doc: "The pretty printed return value, with returnPretty."},
+		{name: "streamed", typ: "{ chunks: number; bytes: number }", optional: true},
+	}},
+	{name: "HandlerResult", alias: "(HandlerSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] }"},
+	{name: "DispatchResult", alias: "(DispatchSuccess | ErrorResult) & { stats: Stats; files?: Record<string, string>; logs?: LogRecord[]; audit?: AuditRecord[]; effects?: DryRunEffect[] }"},
+	{name: "ChannelSendResult", fields: []field{
+		{name: "pending", typ: "number"},
+	}},
+	{name: "ChannelRecvResult", alias: "{ received: false } | { received: true; value: unknown }"},
+	{name: "PublishResult", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "globals", typ: "string[]"},
+		{name: "conversionDepth", typ: "number"},
+	}},
+	{name: "BuiltinResult", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "builtins", typ: "string[]", doc: "The names of the builtins registered by register_starlark_builtin."},
+	}},
+	{name: "Converter", doc: "A converter of the values the default bridge doesn't know about, see register_starlark_converter.", fields: []field{
+		{name: "type", typ: "string", optional: true, doc: "The starlark type converted by encode, e.g. tuple or set."},
+		{name: "encode", typ: "(value: unknown, info: { type: string; repr: string }) => unknown", optional: true},
+		{name: "match", typ: "(value: unknown) => boolean", optional: true, doc: "Selects the javascript objects and functions converted by decode."},
+		{name: "decode", typ: "(value: any) => unknown", optional: true, doc: "Returns a value converted by the default bridge."},
+	}},
+	{name: "ConverterResult", fields: []field{
+		{name: "message", typ: "string"},
+		{name: "converters", typ: "string[]", doc: "The names of the registered converters."},
+	}},
+	{name: "PreludeResult", alias: "({ message: string; globals: string[] } | ErrorResult) & { stats: Stats }"},
+	{name: "SnapshotResult", fields: []field{
+		{name: "snapshot", typ: "Uint8Array"},
+		{name: "skipped", typ: "string[]"},
+	}},
+	{name: "SyntaxNode", doc: "A node of the syntax tree, the other fields depend on the kind.", fields: []field{
+		{name: "kind", typ: "string"},
+		{name: "start", typ: "Position"},
+		{name: "end", typ: "Position"},
+		{name: "[key: string]", typ: "unknown"},
+	}},
+	{name: "ParseError", fields: []field{
+		{name: "line", typ: "number"},
+		{name: "col", typ: "number"},
+		{name: "message", typ: "string"},
+	}},
+	{name: "FormatResult", alias: "{ formatted: string } | (ErrorResult & { parseError?: ParseError })"},
+	{name: "LintOptions", fields: []field{
+		{name: "checks", typ: "string[]", optional: true},
+		{name: "predeclared", typ: "string[]", optional: true},
+	}},
+	{name: "LintFinding", fields: []field{
+		{name: "check", typ: "string"},
+		{name: "message", typ: "string"},
+		{name: "start", typ: "Position"},
+		{name: "end", typ: "Position"},
+	}},
+	{name: "DependencyNode", fields: []field{
+		{name: "name", typ: "string"},
+		{name: "kind", typ: `"file" | "registered" | "remote" | "missing"`},
+		{name: "error", typ: "string", optional: true, doc: "Set if the module doesn't parse, its loads are unknown."},
+		{name: "parseError", typ: "ParseError", optional: true},
+	}},
+	{name: "DependencyEdge", fields: []field{
+		{name: "from", typ: "string"},
+		{name: "to", typ: "string"},
+		{name: "line", typ: "number"},
+		{name: "col", typ: "number"},
+		{name: "symbols", typ: "string[]", doc: "The names loaded from the module."},
+	}},
+	{name: "DependencyGraph", fields: []field{
+		{name: "nodes", typ: "DependencyNode[]"},
+		{name: "edges", typ: "DependencyEdge[]"},
+		{name: "cycles", typ: "string[][]", doc: "Each cycle starts and ends with the same module."},
+	}},
+	{name: "DocumentOptions", fields: []field{
+		{name: "filename", typ: "string", optional: true, doc: "The file name of the document, in the positions of the errors."},
+		{name: "predeclared", typ: "string[]", optional: true, doc: "Extra predeclared names, like the option of lint_starlark_code."},
+	}},
+	{name: "DocumentEdit", doc: "Replaces the text between two offsets in UTF-16 code units, like the indices of Javascript strings.", fields: []field{
+		{name: "from", typ: "number"},
+		{name: "to", typ: "number"},
+		{name: "text", typ: "string", optional: true},
+	}},
+	{name: "DocumentDiagnostic", fields: []field{
+		{name: "kind", typ: "'syntax' | 'resolve'"},
+		{name: "message", typ: "string"},
+		{name: "start", typ: "Position"},
+		{name: "end", typ: "Position"},
+	}},
+	{name: "DocumentResult", fields: []field{
+		{name: "documentId", typ: "number"},
+		{name: "version", typ: "number", doc: "The number of edit_starlark_document calls applied."},
+		{name: "diagnostics", typ: "DocumentDiagnostic[]"},
+		{name: "incremental", typ: "{ chunks: number; parsedChunks: number; parsedBytes: number; resolvedChunks: number }", doc: "The work done by the update."},
+	}},
+	{name: "ValueChange", doc: "A difference between two values, the path is like $.servers[0].port.", fields: []field{
+		{name: "op", typ: "'add' | 'remove' | 'change'"},
+		{name: "path", typ: "string"},
+		{name: "before", typ: "unknown", optional: true, doc: "The old value, missing for the additions."},
+		{name: "after", typ: "unknown", optional: true, doc: "The new value, missing for the removals."},
+	}},
+	{name: "PrettyOptions", fields: []field{
+		{name: "maxDepth", typ: "number", optional: true, doc: "The nesting depth past which the containers are elided (default: 8)."},
+		{name: "width", typ: "number", optional: true, doc: "The length of the lines, longer containers have one element per line (default: 80)."},
+		{name: "maxItems", typ: "number", optional: true, doc: "The maximum number of elements rendered by container (default: 100)."},
+		{name: "maxStringLength", typ: "number", optional: true, doc: "The maximum length of the strings in bytes (default: 200)."},
+		{name: "indent", typ: "number", optional: true, doc: "The number of spaces by nesting level (default: 2)."},
+	}},
+	{name: "PrettyText", fields: []field{
+		{name: "text", typ: "string"},
+		{name: "truncated", typ: "boolean", doc: "Set if containers, elements or strings were elided."},
+	}},
+	{name: "ValueDiff", fields: []field{
+		{name: "equal", typ: "boolean"},
+		{name: "changes", typ: "ValueChange[]"},
+		{name: "truncated", typ: "boolean", optional: true, doc: "Set when there are more than 1000 changes."},
+	}},
+	{name: "LintResult", alias: "{ findings: LintFinding[] } | (ErrorResult & { parseError?: ParseError })"},
+	{name: "ExecutionIdentity", fields: []field{
+		{name: "executionId", typ: "number"},
+		{name: "thread", typ: "string"},
+		{name: "funcName", typ: "string"},
+		{name: "startMs", typ: "number"},
+	}},
+	{name: "TelemetryCallbacks", doc: "Callbacks observing all the executions, errors they throw are ignored.", fields: []field{
+		{name: "onExecStart", typ: "(event: ExecutionIdentity) => void", optional: true},
+		{name: "onExecEnd", typ: "(event: ExecutionIdentity & { durationMs: number; stats: Stats; ok: boolean }) => void", optional: true},
+		{name: "onError", typ: "(event: ExecutionIdentity & { error: string; errorCode?: ErrorCode }) => void", optional: true},
+		{name: "onLoadModule", typ: "(event: { module: string; cached: boolean; durationMs: number; executionId?: number; thread?: string; error?: string }) => void", optional: true},
+	}},
+	{name: "ExportOptions", doc: "Read from the STARLARK_WASM_OPTIONS global when the wasm module starts.", fields: []field{
+		{name: "namespace", typ: "string", optional: true},
+		{name: "names", typ: "Partial<Record<keyof StarlarkAPI, string>>", optional: true},
+	}},
+}
+
+var functions = []function{
+	{name: "run_starlark_code", params: []field{{name: "starlark_code", typ: "string"}, {name: "funcName", typ: "string", optional: true}, {name: "...args", typ: "unknown[]"}}, result: "RunResult"},
+	{name: "run_starlark_code_with_options", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "RunOptions", optional: true}}, result: "RunResult"},
+	{name: "run_starlark_code_async", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "AsyncRunOptions", optional: true}}, result: "Promise<AsyncRunResult>"},
+	{name: "replay_starlark_recording", params: []field{{name: "recording", typ: "Recording"}, {name: "options", typ: "RunOptions", optional: true}}, result: "RunResult"},
+	{name: "benchmark_starlark_function", params: []field{{name: "starlark_code", typ: "string"}, {name: "funcName", typ: "string"}, {name: "args", typ: "unknown[]"}, {name: "iterations", typ: "number"}, {name: "options", typ: "RunOptions & { warmup?: number }", optional: true}}, result: "BenchmarkResult"},
+	{name: "run_starlark_batch", params: []field{{name: "starlark_code", typ: "string"}, {name: "calls", typ: "BatchCall[]"}, {name: "options", typ: "RunOptions", optional: true}}, result: "BatchResult"},
+	{name: "eval_starlark_data", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "RunOptions", optional: true}}, result: "RunResult"},
+	{name: "configure_starlark_scheduler", params: []field{{name: "options", typ: "SchedulerOptions"}}, result: "SchedulerStatus | ErrorResult"},
+	{name: "starlark_scheduler_status", result: "SchedulerStatus"},
+	{name: "configure_starlark_workers", params: []field{{name: "options", typ: "WorkersOptions"}}, result: "WorkerPoolStatus | ErrorResult"},
+	{name: "run_starlark_code_in_worker", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "AsyncRunOptions", optional: true}}, result: "Promise<AsyncRunResult & { worker?: number }>"},
+	{name: "starlark_worker_status", result: "WorkerPoolStatus"},
+	{name: "serve_starlark_worker", result: "MessageResult | ErrorResult"},
+	{name: "create_starlark_session", result: "SessionResult"},
+	{name: "run_starlark_session", params: []field{{name: "sessionId", typ: "number"}, {name: "starlark_code", typ: "string"}, {name: "options", typ: "RunOptions", optional: true}}, result: "SessionRunResult"},
+	{name: "destroy_starlark_session", params: []field{{name: "sessionId", typ: "number"}}, result: "MessageResult | ErrorResult"},
+	{name: "starlark_session_usage", params: []field{{name: "sessionId", typ: "number"}}, result: "SessionUsage | ErrorResult"},
+	{name: "set_starlark_session_quotas", params: []field{{name: "sessionId", typ: "number"}, {name: "quotas", typ: "SessionQuotas"}}, result: "SessionUsage | ErrorResult"},
+	{name: "snapshot_starlark_session", params: []field{{name: "sessionId", typ: "number"}}, result: "SnapshotResult | ErrorResult"},
+	{name: "restore_starlark_session", params: []field{{name: "snapshot", typ: "Uint8Array"}}, result: "SessionResult | ErrorResult"},
+	{name: "dispatch_starlark_event", params: []field{{name: "sessionId", typ: "number"}, {name: "name", typ: "string"}, {name: "payload", typ: "unknown", optional: true}, {name: "options", typ: "RunOptions", optional: true}}, result: "DispatchResult"},
+	{name: "call_starlark_handler", params: []field{{name: "sessionId", typ: "number"}, {name: "name", typ: "string"}, {name: "request", typ: "unknown", optional: true}, {name: "options", typ: "RunOptions", optional: true}}, result: "HandlerResult"},
+	{name: "starlark_session_handlers", params: []field{{name: "sessionId", typ: "number"}}, result: "{ sessionId: number; handlers: string[] } | ErrorResult"},
+	{name: "send_starlark_channel", params: []field{{name: "name", typ: "string"}, {name: "value", typ: "unknown"}}, result: "ChannelSendResult | ErrorResult"},
+	{name: "recv_starlark_channel", params: []field{{name: "name", typ: "string"}}, result: "ChannelRecvResult | ErrorResult"},
+	{name: "parse_starlark_code", params: []field{{name: "starlark_code", typ: "string"}}, result: "{ ast: SyntaxNode } | ErrorResult"},
+	{name: "format_starlark_code", params: []field{{name: "starlark_code", typ: "string"}}, result: "FormatResult"},
+	{name: "lint_starlark_code", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "LintOptions", optional: true}}, result: "LintResult"},
+	{name: "starlark_dependency_graph", params: []field{{name: "files", typ: "Record<string, string>"}}, result: "DependencyGraph | ErrorResult"},
+	{name: "open_starlark_document", params: []field{{name: "starlark_code", typ: "string"}, {name: "options", typ: "DocumentOptions", optional: true}}, result: "DocumentResult | ErrorResult"},
+	{name: "edit_starlark_document", params: []field{{name: "documentId", typ: "number"}, {name: "edits", typ: "DocumentEdit[]"}}, result: "DocumentResult | ErrorResult"},
+	{name: "close_starlark_document", params: []field{{name: "documentId", typ: "number"}}, result: "MessageResult | ErrorResult"},
+	{name: "diff_starlark_values", params: []field{{name: "a", typ: "unknown"}, {name: "b", typ: "unknown"}}, result: "ValueDiff | ErrorResult"},
+	{name: "pretty_print_starlark_value", params: []field{{name: "value", typ: "unknown"}, {name: "options", typ: "PrettyOptions", optional: true}}, result: "PrettyText | ErrorResult"},
+	{name: "register_starlark_prelude", params: []field{{name: "starlark_code", typ: "string"}}, result: "PreludeResult"},
+	{name: "register_starlark_builtin", params: []field{{name: "name", typ: "string"}, {name: "value", typ: "((...args: any[]) => unknown) | Record<string, unknown>"}, {name: "options", typ: "{ capability?: string }", optional: true}}, result: "BuiltinResult | ErrorResult"},
+	{name: "register_starlark_converter", params: []field{{name: "name", typ: "string"}, {name: "converter", typ: "Converter"}}, result: "ConverterResult | ErrorResult"},
+	{name: "publish_starlark_data", params: []field{{name: "name", typ: "string"}, {name: "value", typ: "unknown"}}, result: "PublishResult | ErrorResult"},
+	{name: "register_starlark_module", params: []field{{name: "name", typ: "string"}, {name: "starlark_code", typ: "string"}}, result: "MessageResult | ErrorResult"},
+	{name: "configure_starlark_module_loader", params: []field{{name: "options", typ: "{ allowedHosts?: string[]; importMap?: Record<string, string> }"}}, result: "{ allowedHosts: string[]; importMap: Record<string, string> } | ErrorResult"},
+	{name: "register_starlark_telemetry", params: []field{{name: "callbacks", typ: "TelemetryCallbacks"}}, result: "{ registered: string[] } | ErrorResult"},
+	{name: "starlark_runtime_info", result: "RuntimeInfo"},
+	{name: "starlark_runtime_stats", result: "RuntimeStats"},
+	{name: "starlark_reset", result: "MessageResult"},
+	{name: "starlark_shutdown", result: "Promise<MessageResult | ErrorResult>"},
+}
+
+// readExportNames returns the names in the export table passed to registerExports in main.go.
+func readExportNames(path string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	ast.Inspect(f, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := call.Fun.(*ast.Ident); !ok || ident.Name != "registerExports" || len(call.Args) != 1 {
+			return true
+		}
+		table, ok := call.Args[0].(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		for _, elt := range table.Elts {
+			entry, ok := elt.(*ast.CompositeLit)
+			if !ok || len(entry.Elts) == 0 {
+				continue
+			}
+			lit, ok := entry.Elts[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				continue
+			}
+			name, err := strconv.Unquote(lit.Value)
+			if err == nil {
+				names = append(names, name)
+			}
+		}
+		return false
+	})
+	return names, nil
+}
+
+// checkFunctions returns an error if the described functions don't match the export table.
+func checkFunctions(exportNames []string) error {
+	described := map[string]bool{}
+	for _, fn := range functions {
+		described[fn.name] = true
+	}
+	missing := []string{}
+	for _, name := range exportNames {
+		if !described[name] {
+			missing = append(missing, name)
+		}
+		delete(described, name)
+	}
+	extra := []string{}
+	for name := range described {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	if len(missing) > 0 || len(extra) > 0 {
+		return fmt.Errorf("the described functions don't match the export table. Missing %v Not exported %v", missing, extra)
+	}
+	return nil
+}
+
+func writeDoc(b *strings.Builder, indent, doc string) {
+	if doc != "" {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, doc)
+	}
+}
+
+func writeFields(fields []field) string {
+	params := []string{}
+	for _, f := range fields {
+		optional := ""
+		if f.optional {
+			optional = "?"
+		}
+		params = append(params, fmt.Sprintf("%s%s: %s", f.name, optional, f.typ))
+	}
+	return strings.Join(params, ", ")
+}
+
+func generate() string {
+	b := &strings.Builder{}
+	b.WriteString("// Code generated by tools/gendts. DO NOT EDIT.\n\n")
+	for _, t := range types {
+		writeDoc(b, "", t.doc)
+		if t.alias != "" {
+			fmt.Fprintf(b, "export type %s = %s;\n\n", t.name, t.alias)
+			continue
+		}
+		fmt.Fprintf(b, "export interface %s {\n", t.name)
+		for _, f := range t.fields {
+			writeDoc(b, "    ", f.doc)
+			optional := ""
+			if f.optional {
+				optional = "?"
+			}
+			fmt.Fprintf(b, "    %s%s: %s;\n", f.name, optional, f.typ)
+		}
+		b.WriteString("}\n\n")
+	}
+	b.WriteString("/** The functions exported by the wasm module. */\nexport interface StarlarkAPI {\n")
+	for _, fn := range functions {
+		writeDoc(b, "    ", fn.doc)
+		fmt.Fprintf(b, "    %s(%s): %s;\n", fn.name, writeFields(fn.params), fn.result)
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("declare global {\n")
+	for _, fn := range functions {
+		fmt.Fprintf(b, "    const %s: StarlarkAPI[%q];\n", fn.name, fn.name)
+	}
+	b.WriteString("    var STARLARK_WASM_OPTIONS: ExportOptions | undefined;\n")
+	b.WriteString("}\n\n")
+	b.WriteString("export function initialize(options?: ExportOptions): Promise<StarlarkAPI>;\n")
+	b.WriteString("export function shutdown(): Promise<MessageResult | ErrorResult>;\n")
+	return b.String()
+}
+
+func main() {
+	mainPath := flag.String("main", "main.go", "the Go file with the export table")
+	output := flag.String("o", "index.d.ts", "the typescript definitions file to write")
+	flag.Parse()
+	exportNames, err := readExportNames(*mainPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to read the export table. Error: %q\n", err)
+		os.Exit(1)
+	}
+	if err := checkFunctions(exportNames); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*output, []byte(generate()), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to write the typescript definitions. Error: %q\n", err)
+		os.Exit(1)
+	}
+}
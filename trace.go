@@ -0,0 +1,187 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// The interpreter has no statement hook, so the trace mode instruments the syntax tree before it is compiled:
+// a call to the traceBuiltinName builtin is inserted before every statement, with the index of the statement in the
+// points of the tracer. The builtin reads the frame of its caller to report the locals at that point.
+
+// traceBuiltinName is the name of the builtin called before every statement of a traced program.
+// It is only predeclared when the onTrace option is set.
+const traceBuiltinName = "__trace__"
+
+// defaultMaxTraceEvents is the maximum number of trace events of an execution when maxTraceEvents is not set.
+const defaultMaxTraceEvents = 10000
+
+// traceOptions control the trace mode.
+type traceOptions struct {
+	// onTrace is called with an event before every statement executed, undefined if the execution isn't traced.
+	onTrace js.Value
+	// maxEvents is the maximum number of events, the statements executed after it are not reported.
+	maxEvents int
+}
+
+func parseTraceOptions(options js.Value) (traceOptions, error) {
+	opts := traceOptions{maxEvents: defaultMaxTraceEvents}
+	var err error
+	if opts.onTrace, _, err = getFunctionOption(options, "onTrace"); err != nil {
+		return opts, err
+	}
+	maxEvents, err := getLimitOption(options, "maxTraceEvents")
+	if err != nil {
+		return opts, err
+	}
+	if maxEvents > 0 {
+		opts.maxEvents = maxEvents
+	}
+	return opts, nil
+}
+
+func (opts traceOptions) enabled() bool {
+	return !opts.onTrace.IsUndefined()
+}
+
+// tracePoint is an instrumented statement.
+type tracePoint struct {
+	pos  syntax.Position
+	kind string
+	// def is the function the statement belongs to, nil for the statements at the top level.
+	// Its locals are resolved when the program is compiled, after the instrumentation.
+	def *syntax.DefStmt
+}
+
+// tracer holds the instrumented statements and the number of events of an execution.
+type tracer struct {
+	points    []tracePoint
+	events    int
+	truncated bool
+}
+
+// instrument inserts the trace calls in the statements of a file.
+func (t *tracer) instrument(f *syntax.File) {
+	f.Stmts = t.instrumentStmts(f.Stmts, nil)
+}
+
+func (t *tracer) instrumentStmts(stmts []syntax.Stmt, def *syntax.DefStmt) []syntax.Stmt {
+	instrumented := make([]syntax.Stmt, 0, 2*len(stmts))
+	for i, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *syntax.DefStmt:
+			s.Body = t.instrumentStmts(s.Body, s)
+		case *syntax.ForStmt:
+			s.Body = t.instrumentStmts(s.Body, def)
+		case *syntax.WhileStmt:
+			s.Body = t.instrumentStmts(s.Body, def)
+		case *syntax.IfStmt:
+			s.True = t.instrumentStmts(s.True, def)
+			s.False = t.instrumentStmts(s.False, def)
+		}
+		// the docstring of a function must stay its first statement
+		if i == 0 && def != nil && isDocString(stmt) {
+			instrumented = append(instrumented, stmt)
+			continue
+		}
+		instrumented = append(instrumented, t.traceCall(stmt, def), stmt)
+	}
+	return instrumented
+}
+
+// traceCall returns the statement calling the trace builtin before a statement.
+func (t *tracer) traceCall(stmt syntax.Stmt, def *syntax.DefStmt) syntax.Stmt {
+	pos, _ := stmt.Span()
+	index := len(t.points)
+	t.points = append(t.points, tracePoint{pos: pos, kind: strings.TrimPrefix(fmt.Sprintf("%T", stmt), "*syntax."), def: def})
+	return &syntax.ExprStmt{X: &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: traceBuiltinName},
+		Lparen: pos,
+		Args:   []syntax.Expr{&syntax.Literal{Token: syntax.INT, TokenPos: pos, Raw: fmt.Sprint(index), Value: int64(index)}},
+		Rparen: pos,
+	}}
+}
+
+// traceStatement is the builtin called before every statement of a traced program.
+var traceStatement = starlark.NewBuiltin(traceBuiltinName, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	checkpoint(thread)
+	var index int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &index); err != nil {
+		return nil, err
+	}
+	e := threadExecution(thread)
+	if e == nil || e.tracer == nil || index < 0 || index >= len(e.tracer.points) {
+		return starlark.None, nil
+	}
+	t := e.tracer
+	if t.events >= e.opts.trace.maxEvents {
+		t.truncated = true
+		return starlark.None, nil
+	}
+	t.events++
+	e.opts.trace.onTrace.Invoke(t.event(thread, t.points[index]))
+	return starlark.None, nil
+})
+
+// event returns the trace event of a statement about to be executed by the caller of the trace builtin.
+func (t *tracer) event(thread *starlark.Thread, point tracePoint) map[string]interface{} {
+	frame := thread.DebugFrame(1)
+	function := "<toplevel>"
+	locals := map[string]interface{}{}
+	if fn, ok := frame.Callable().(*starlark.Function); ok {
+		function = fn.Name()
+		if point.def == nil {
+			// the locals of the top level are the globals of the module
+			for name, value := range fn.Globals() {
+				locals[name] = summarizeValue(value)
+			}
+		} else if resolved, ok := point.def.Function.(*resolve.Function); ok {
+			for i, binding := range resolved.Locals {
+				_, value := frame.Local(i)
+				if value == nil {
+					continue // not assigned yet
+				}
+				if value.Type() == "cell" {
+					// the locals shared with a nested function are boxed in cells the debugger API can't open
+					locals[binding.First.Name] = "<captured>"
+					continue
+				}
+				locals[binding.First.Name] = summarizeValue(value)
+			}
+		}
+	}
+	return map[string]interface{}{
+		"index":     t.events - 1,
+		"file":      point.pos.Filename(),
+		"line":      int(point.pos.Line),
+		"col":       int(point.pos.Col),
+		"statement": point.kind,
+		"function":  function,
+		"depth":     len(thread.CallStack()) - 2,
+		"steps":     float64(thread.ExecutionSteps()),
+		"locals":    locals,
+	}
+}
+
+func (t *tracer) toJS() map[string]interface{} {
+	return map[string]interface{}{"events": t.events, "truncated": t.truncated}
+}
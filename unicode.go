@@ -0,0 +1,186 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall/js"
+	"unicode/utf8"
+)
+
+// The invalidUnicode option controls the strings that can't be converted exactly between javascript and starlark:
+// the javascript strings with lone surrogates (invalid UTF-16) and the starlark strings that are not valid UTF-8,
+// e.g. binary data decoded as latin-1 by the host or bytes sliced in the middle of a character by the script.
+//   - "replace" (the default) replaces them with U+FFFD, like the TextEncoder and TextDecoder of the host.
+//   - "error" fails the conversion with the path of the string.
+//   - "passthrough" keeps the bytes: the lone surrogates U+DC80 to U+DCFF become the bytes 0x80 to 0xFF (the other
+//     lone surrogates are encoded in 3 bytes, like in WTF-8) and back, each other byte of an invalid UTF-8 sequence
+//     becomes the lone surrogate U+DC00 + byte, so strings survive a round trip through starlark unchanged.
+//     Consecutive surrogates U+DC80 to U+DCFF whose bytes would form a character are encoded in 3 bytes instead.
+var invalidUnicodeModes = []string{"replace", "error", "passthrough"}
+
+var (
+	// loneSurrogate matches a lone surrogate: with the u flag a surrogate pair is a single code point, which the class doesn't match.
+	loneSurrogate = js.Global().Get("RegExp").New(`[\uD800-\uDFFF]`, "u")
+	// loneSurrogates splits a string around its lone surrogates, which are kept in the parts.
+	loneSurrogates = js.Global().Get("RegExp").New(`([\uD800-\uDFFF])`, "u")
+	jsString       = js.Global().Get("String")
+	jsReflect      = js.Global().Get("Reflect")
+)
+
+// callStringMethod calls a method of String.prototype on a javascript string, which Value.Call refuses since it isn't an object.
+func callStringMethod(value js.Value, method string, args ...interface{}) js.Value {
+	return jsString.Get("prototype").Get(method).Call("call", append([]interface{}{value}, args...)...)
+}
+
+func parseInvalidUnicodeOption(options js.Value) (string, error) {
+	mode, _, err := getStringOption(options, "invalidUnicode")
+	if err != nil {
+		return "", err
+	}
+	for _, valid := range invalidUnicodeModes {
+		if mode == "" || mode == valid {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("the option \"invalidUnicode\" must be \"replace\", \"error\" or \"passthrough\". Actual value %q", mode)
+}
+
+// convertString converts a javascript string to go, path returns its path for the errors.
+func (c *converter) convertString(value js.Value, path func() string) (string, error) {
+	if c.invalidUnicode == "" || c.invalidUnicode == "replace" || !loneSurrogate.Call("test", value).Bool() {
+		return value.String(), nil
+	}
+	if c.invalidUnicode == "error" {
+		return "", fmt.Errorf("the string at %s has a lone surrogate at the offset %d, which is invalid UTF-16", path(), callStringMethod(value, "search", loneSurrogate).Int())
+	}
+	parts := callStringMethod(value, "split", loneSurrogates)
+	var b strings.Builder
+	// escaped are the bytes of the consecutive lone surrogates U+DC80 to U+DCFF not written yet
+	escaped := []byte{}
+	for i := 0; i < parts.Length(); i++ {
+		part := parts.Index(i)
+		// the odd parts are the lone surrogates, the even ones between two consecutive lone surrogates are empty
+		if i%2 == 0 {
+			if text := part.String(); text != "" {
+				writeEscapedBytes(&b, escaped)
+				escaped = escaped[:0]
+				b.WriteString(text)
+			}
+			continue
+		}
+		unit := callStringMethod(part, "charCodeAt", 0).Int()
+		if unit >= 0xDC80 && unit <= 0xDCFF {
+			escaped = append(escaped, byte(unit-0xDC00))
+			continue
+		}
+		writeEscapedBytes(&b, escaped)
+		escaped = escaped[:0]
+		writeSurrogate(&b, unit)
+	}
+	writeEscapedBytes(&b, escaped)
+	return b.String(), nil
+}
+
+// writeEscapedBytes writes the bytes of consecutive lone surrogates U+DC80 to U+DCFF.
+// If some of them would be decoded back as a character instead of the same surrogates, e.g. \uDCC3\uDCA9 becoming é,
+// all of them are written as surrogates in 3 bytes instead, which stringToJS decodes back to the same surrogates.
+func writeEscapedBytes(b *strings.Builder, escaped []byte) {
+	for i := 0; i < len(escaped); i++ {
+		if _, size := utf8.DecodeRune(escaped[i:]); size > 1 || isEncodedSurrogate(string(escaped[i:])) {
+			for _, c := range escaped {
+				writeSurrogate(b, 0xDC00+int(c))
+			}
+			return
+		}
+	}
+	b.Write(escaped)
+}
+
+// writeSurrogate writes a surrogate in 3 bytes, like WTF-8.
+func writeSurrogate(b *strings.Builder, unit int) {
+	b.Write([]byte{byte(0xE0 | unit>>12), byte(0x80 | (unit>>6)&0x3F), byte(0x80 | unit&0x3F)})
+}
+
+// isEncodedSurrogate reports whether the bytes start with a surrogate encoded in 3 bytes.
+func isEncodedSurrogate(s string) bool {
+	return len(s) >= 3 && s[0] == 0xED && s[1] >= 0xA0 && s[1] <= 0xBF && s[2] >= 0x80 && s[2] <= 0xBF
+}
+
+// stringToJS converts a starlark string to javascript.
+func (c *converter) stringToJS(s string) (js.Value, error) {
+	if c.invalidUnicode == "" || c.invalidUnicode == "replace" || utf8.ValidString(s) {
+		return js.ValueOf(s), nil
+	}
+	if c.invalidUnicode == "error" {
+		offset := 0
+		for offset < len(s) {
+			r, size := utf8.DecodeRuneInString(s[offset:])
+			if r == utf8.RuneError && size == 1 {
+				break
+			}
+			offset += size
+		}
+		return js.Undefined(), fmt.Errorf("the string %s is not valid UTF-8 at the byte %d", truncateRepr(s), offset)
+	}
+	parts := []interface{}{}
+	for len(s) > 0 {
+		valid := 0
+		for valid < len(s) {
+			r, size := utf8.DecodeRuneInString(s[valid:])
+			if r == utf8.RuneError && size == 1 {
+				break
+			}
+			valid += size
+		}
+		if valid > 0 {
+			parts = append(parts, s[:valid])
+			s = s[valid:]
+			continue
+		}
+		if isEncodedSurrogate(s) {
+			// a surrogate encoded in 3 bytes, e.g. a lone surrogate of a javascript string
+			parts = append(parts, jsString.Call("fromCharCode", 0xD000|int(s[1]&0x3F)<<6|int(s[2]&0x3F)))
+			s = s[3:]
+			continue
+		}
+		parts = append(parts, jsString.Call("fromCharCode", 0xDC00+int(s[0])))
+		s = s[1:]
+	}
+	return callStringMethod(js.ValueOf(""), "concat", parts...), nil
+}
+
+// setKey sets a property of a javascript object whose name is a starlark string.
+func (c *converter) setKey(target js.Value, key string, value js.Value) error {
+	if c.invalidUnicode == "" || c.invalidUnicode == "replace" || utf8.ValidString(key) {
+		target.Set(key, value)
+		return nil
+	}
+	converted, err := c.stringToJS(key)
+	if err != nil {
+		return err
+	}
+	jsReflect.Call("set", target, converted, value)
+	return nil
+}
+
+// truncateRepr returns the repr of a string, truncated to auditArgumentBytes.
+func truncateRepr(s string) string {
+	if len(s) > auditArgumentBytes {
+		return fmt.Sprintf("%q...", s[:auditArgumentBytes])
+	}
+	return fmt.Sprintf("%q", s)
+}
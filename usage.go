@@ -0,0 +1,163 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+	"time"
+)
+
+// sessionUsage is the cumulative resource usage of the executions of a session: the runs, the dispatched events,
+// the timers and the calls of the functions it returned.
+type sessionUsage struct {
+	executions int
+	steps      uint64
+	duration   time.Duration
+	hostCalls  int
+	// rejected counts the executions rejected because a quota was exceeded.
+	rejected int
+}
+
+// sessionQuotas are the limits of the usage of a session over its lifetime, 0 means unlimited.
+// Once one is exceeded the following executions of the session are rejected.
+type sessionQuotas struct {
+	maxExecutions int
+	maxSteps      int
+	maxDurationMs int
+	maxHostCalls  int
+}
+
+// recordUsage adds the usage of an execution of the session, it is called by finish.
+func (s *session) recordUsage(steps uint64, duration time.Duration) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	s.usage.executions++
+	s.usage.steps += steps
+	s.usage.duration += duration
+}
+
+// countHostCall counts a call to the host made by a function of the session, whichever execution it runs in.
+func (s *session) countHostCall() {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	s.usage.hostCalls++
+}
+
+// sessionQuotaErrorResult returns the error result of an execution of a session if one of the session's quotas was exceeded, nil otherwise.
+// The rejected executions are not added to the usage of the session.
+func (e *execution) sessionQuotaErrorResult() map[string]interface{} {
+	s := e.session
+	if s == nil {
+		return nil
+	}
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	checks := []struct {
+		quota string
+		max   int
+		used  float64
+	}{
+		{"maxExecutions", s.quotas.maxExecutions, float64(s.usage.executions)},
+		{"maxSteps", s.quotas.maxSteps, float64(s.usage.steps)},
+		{"maxDurationMs", s.quotas.maxDurationMs, float64(s.usage.duration) / float64(time.Millisecond)},
+		{"maxHostCalls", s.quotas.maxHostCalls, float64(s.usage.hostCalls)},
+	}
+	for _, check := range checks {
+		if check.max > 0 && check.used >= float64(check.max) {
+			s.usage.rejected++
+			e.rejected = true
+			err := fmt.Errorf("Error: resource exhausted. The session %d has reached its quota %s of %d.", s.id, check.quota, check.max)
+			details := map[string]interface{}{"sessionId": float64(s.id), "quota": check.quota, "max": check.max, "used": check.used}
+			return map[string]interface{}{"error": err.Error(), "errorCode": "resource_exhausted", "details": details}
+		}
+	}
+	return nil
+}
+
+func (s *session) usageToJS() map[string]interface{} {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	return map[string]interface{}{
+		"sessionId":  float64(s.id),
+		"executions": s.usage.executions,
+		"steps":      float64(s.usage.steps),
+		"durationMs": float64(s.usage.duration) / float64(time.Millisecond),
+		"hostCalls":  s.usage.hostCalls,
+		"rejected":   s.usage.rejected,
+		"quotas": map[string]interface{}{
+			"maxExecutions": s.quotas.maxExecutions,
+			"maxSteps":      s.quotas.maxSteps,
+			"maxDurationMs": s.quotas.maxDurationMs,
+			"maxHostCalls":  s.quotas.maxHostCalls,
+		},
+	}
+}
+
+func parseSessionQuotas(options js.Value) (sessionQuotas, error) {
+	quotas := sessionQuotas{}
+	var err error
+	if quotas.maxExecutions, err = getLimitOption(options, "maxExecutions"); err != nil {
+		return quotas, err
+	}
+	if quotas.maxSteps, err = getLimitOption(options, "maxSteps"); err != nil {
+		return quotas, err
+	}
+	if quotas.maxDurationMs, err = getLimitOption(options, "maxDurationMs"); err != nil {
+		return quotas, err
+	}
+	if quotas.maxHostCalls, err = getLimitOption(options, "maxHostCalls"); err != nil {
+		return quotas, err
+	}
+	return quotas, nil
+}
+
+func getSessionUsageReader() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 1 {
+			err := fmt.Errorf("Error: expected one argument with the session id. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		return s.usageToJS()
+	})
+}
+
+func getSessionQuotasSetter() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) < 2 {
+			err := fmt.Errorf("Error: expected two arguments with the session id and the quotas. Actual len(args) %d args %+v", len(args), args)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		s, err := getSession(args[0])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid session. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "not_found"}
+		}
+		quotas, err := parseSessionQuotas(args[1])
+		if err != nil {
+			err := fmt.Errorf("Error: invalid quotas. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		s.usageMu.Lock()
+		s.quotas = quotas
+		s.usageMu.Unlock()
+		return s.usageToJS()
+	})
+}
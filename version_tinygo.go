@@ -0,0 +1,27 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build tinygo
+// +build tinygo
+
+package main
+
+// starlarkModuleVersion is set by the build-tinygo target of the Makefile (-ldflags -X),
+// TinyGo doesn't embed the versions of the modules in the binary.
+var starlarkModuleVersion = "unknown"
+
+// starlarkVersion returns the version of the go.starlark.net module the program was built with.
+func starlarkVersion() string {
+	return starlarkModuleVersion
+}
@@ -0,0 +1,209 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// fileSystem is the virtual filesystem of an execution. It is used to resolve load statements
+// and by the read_file, write_file and glob builtins.
+type fileSystem interface {
+	// readFile returns the content of the file, the boolean is false if the file doesn't exist.
+	readFile(name string) (string, bool, error)
+	writeFile(name, content string) error
+	// glob returns the sorted names of the files matching the pattern (see path.Match).
+	glob(pattern string) ([]string, error)
+}
+
+// memoryFileSystem is a filesystem backed by a map from file names to contents.
+type memoryFileSystem struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func (m *memoryFileSystem) readFile(name string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.files[name]
+	return content, ok, nil
+}
+
+func (m *memoryFileSystem) writeFile(name, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = content
+	return nil
+}
+
+func (m *memoryFileSystem) glob(pattern string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := []string{}
+	for name := range m.files {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// toJS returns the files as a javascript object.
+func (m *memoryFileSystem) toJS() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := map[string]interface{}{}
+	for name, content := range m.files {
+		files[name] = content
+	}
+	return files
+}
+
+// jsFileSystem is a filesystem backed by a javascript object with the synchronous methods
+// readFile(name) (returns a string, or undefined if the file doesn't exist), writeFile(name, content) and glob(pattern).
+// The methods may return promises if the execution can block.
+type jsFileSystem struct {
+	obj      js.Value
+	canBlock bool
+}
+
+// callMethod calls a method of the javascript object, a missing method is an error.
+func (j jsFileSystem) callMethod(method string, args ...interface{}) (js.Value, error) {
+	if j.obj.Get(method).Type() != js.TypeFunction {
+		return js.Undefined(), fmt.Errorf("the filesystem doesn't support %s", method)
+	}
+	value, err := settleHostValue(j.obj.Call(method, args...), j.canBlock)
+	if err != nil {
+		return js.Undefined(), fmt.Errorf("%s %v", method, err)
+	}
+	return value, nil
+}
+
+func (j jsFileSystem) readFile(name string) (string, bool, error) {
+	content, err := j.callMethod("readFile", name)
+	if err != nil || content.IsUndefined() || content.IsNull() {
+		return "", false, err
+	}
+	if content.Type() != js.TypeString {
+		return "", false, fmt.Errorf("readFile must return a string. Actual type %s", content.Type())
+	}
+	return content.String(), true, nil
+}
+
+func (j jsFileSystem) writeFile(name, content string) error {
+	_, err := j.callMethod("writeFile", name, content)
+	return err
+}
+
+func (j jsFileSystem) glob(pattern string) ([]string, error) {
+	list, err := j.callMethod("glob", pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !list.InstanceOf(js.Global().Get("Array")) {
+		return nil, fmt.Errorf("glob must return an array of strings. Actual type %s", list.Type())
+	}
+	names := []string{}
+	for i := 0; i < list.Length(); i++ {
+		names = append(names, list.Index(i).String())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// parseFileSystemOption returns the filesystem given by the fs option: an object with readFile/writeFile/glob methods
+// or a plain object mapping file names to contents. It returns nil if the option is missing.
+func parseFileSystemOption(options js.Value) (fileSystem, error) {
+	value := getOption(options, "fs")
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	if value.Type() != js.TypeObject {
+		return nil, fmt.Errorf("the option \"fs\" must be an object. Actual type %s", value.Type())
+	}
+	if value.Get("readFile").Type() == js.TypeFunction {
+		return jsFileSystem{obj: value}, nil
+	}
+	files := map[string]string{}
+	keys := js.Global().Get("Object").Call("keys", value)
+	for i := 0; i < keys.Length(); i++ {
+		name := keys.Index(i).String()
+		content := value.Get(name)
+		if content.Type() != js.TypeString {
+			return nil, fmt.Errorf("the content of the file %q must be a string. Actual type %s", name, content.Type())
+		}
+		files[name] = content.String()
+	}
+	return &memoryFileSystem{files: files}, nil
+}
+
+// fileBuiltins returns the read_file, write_file and glob builtins that access the filesystem.
+func fileBuiltins(fs fileSystem) starlark.StringDict {
+	return starlark.StringDict{
+		"read_file": starlark.NewBuiltin("read_file", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var name string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+				return nil, err
+			}
+			content, ok, err := fs.readFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s: the file %q doesn't exist", b.Name(), name)
+			}
+			return starlark.String(content), nil
+		}),
+		"write_file": starlark.NewBuiltin("write_file", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var name, content string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &name, &content); err != nil {
+				return nil, err
+			}
+			if err := fs.writeFile(name, content); err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			return starlark.None, nil
+		}),
+		"glob": starlark.NewBuiltin("glob", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			checkpoint(thread)
+			var pattern string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &pattern); err != nil {
+				return nil, err
+			}
+			names, err := fs.glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", b.Name(), err)
+			}
+			list := []starlark.Value{}
+			for _, name := range names {
+				list = append(list, starlark.String(name))
+			}
+			return starlark.NewList(list), nil
+		}),
+	}
+}
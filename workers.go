@@ -0,0 +1,355 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall/js"
+)
+
+// The worker pool lets one instance (the coordinator) run scripts on other instances running in Web Workers,
+// since each instance is single threaded. The protocol uses the messages of the workers, whose data is an object
+// with a field called starlark:
+//   - {starlark: "ready"} is sent by a worker once serve_starlark_worker has been called.
+//   - {starlark: "run", id, code, options} is sent by the coordinator to run the code like run_starlark_code_async.
+//   - {starlark: "result", id, result} is the reply of the worker with the result of the run.
+// The options are sent with postMessage, so they can't contain functions or other values that can't be cloned.
+
+// poolWorker is a worker of the pool. Runs sent before the worker is ready wait in backlog.
+type poolWorker struct {
+	index     int
+	target    js.Value
+	onMessage js.Func
+	onError   js.Func
+	ready     bool
+	failed    bool
+	backlog   []map[string]interface{}
+	// pending are the callbacks that settle the runs sent to the worker, by id.
+	pending   map[uint64]func(result js.Value)
+	completed int
+}
+
+// errNoWorkers is returned by runInWorker if the pool has no worker that can run the code.
+var errNoWorkers = errors.New("there are no workers, call configure_starlark_workers first")
+
+var workerPool = struct {
+	sync.Mutex
+	nextID  uint64
+	workers []*poolWorker
+}{}
+
+// configureWorkers terminates the current workers and spawns count new ones by calling spawn with the index of each worker.
+// spawn must return a Worker, or an object with the same postMessage, addEventListener and (optional) terminate methods.
+func configureWorkers(count int, spawn js.Value) (err error) {
+	workerPool.Lock()
+	defer workerPool.Unlock()
+	for _, w := range workerPool.workers {
+		w.terminate("Error: cancelled. The worker was terminated because the pool was reconfigured.", "cancelled")
+	}
+	workerPool.workers = nil
+	defer func() {
+		// spawn may throw, e.g. if the script of the worker can't be found
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to spawn the workers. Error: %v", r)
+			for _, w := range workerPool.workers {
+				w.terminate("Error: cancelled. The worker was terminated because the pool failed to start.", "cancelled")
+			}
+			workerPool.workers = nil
+		}
+	}()
+	for i := 0; i < count; i++ {
+		target := spawn.Invoke(i)
+		if target.Type() != js.TypeObject || target.Get("postMessage").Type() != js.TypeFunction {
+			return fmt.Errorf("spawn must return a Worker. Actual type %s", target.Type())
+		}
+		w := &poolWorker{index: i, target: target, pending: map[uint64]func(result js.Value){}}
+		w.onMessage = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			w.receive(args[0].Get("data"))
+			return nil
+		})
+		w.onError = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			message := js.Global().Get("String").Invoke(args[0].Get("message")).String()
+			workerPool.Lock()
+			defer workerPool.Unlock()
+			w.failed = true
+			w.settleAll(fmt.Sprintf("Error: the worker %d failed. Error: %q", w.index, message), "internal")
+			return nil
+		})
+		target.Call("addEventListener", "message", w.onMessage)
+		target.Call("addEventListener", "error", w.onError)
+		workerPool.workers = append(workerPool.workers, w)
+	}
+	return nil
+}
+
+// receive handles a message of the worker.
+func (w *poolWorker) receive(data js.Value) {
+	if data.Type() != js.TypeObject {
+		return
+	}
+	workerPool.Lock()
+	defer workerPool.Unlock()
+	switch data.Get("starlark").String() {
+	case "ready":
+		w.ready = true
+		for _, msg := range w.backlog {
+			id := msg["id"].(uint64)
+			if err := w.post(msg); err != nil {
+				if settle, ok := w.pending[id]; ok {
+					delete(w.pending, id)
+					settle(js.ValueOf(map[string]interface{}{"error": fmt.Sprintf("Error: %v", err), "errorCode": "invalid_argument", "stats": executionStats{}.toJS()}))
+				}
+			}
+		}
+		w.backlog = nil
+	case "result":
+		id := uint64(data.Get("id").Float())
+		if settle, ok := w.pending[id]; ok {
+			delete(w.pending, id)
+			w.completed++
+			settle(data.Get("result"))
+		}
+	}
+}
+
+// post sends a message to the worker. postMessage throws if the options can't be cloned.
+func (w *poolWorker) post(msg map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("the options can't be sent to the worker. Error: %v", r)
+		}
+	}()
+	w.target.Call("postMessage", msg)
+	return nil
+}
+
+// settleAll settles the pending runs of the worker with an error. Must be called with the lock held.
+func (w *poolWorker) settleAll(message, errorCode string) {
+	for id, settle := range w.pending {
+		delete(w.pending, id)
+		settle(js.ValueOf(map[string]interface{}{"error": message, "errorCode": errorCode, "stats": executionStats{}.toJS()}))
+	}
+	w.backlog = nil
+}
+
+// terminate stops the worker, its pending runs are settled with the message. Must be called with the lock held.
+func (w *poolWorker) terminate(message, errorCode string) {
+	w.target.Call("removeEventListener", "message", w.onMessage)
+	w.target.Call("removeEventListener", "error", w.onError)
+	w.onMessage.Release()
+	w.onError.Release()
+	if w.target.Get("terminate").Type() == js.TypeFunction {
+		w.target.Call("terminate")
+	}
+	w.settleAll(message, errorCode)
+}
+
+// terminateWorkers terminates the workers of the pool, their pending runs are settled with the message.
+func terminateWorkers(message, errorCode string) {
+	workerPool.Lock()
+	defer workerPool.Unlock()
+	for _, w := range workerPool.workers {
+		w.terminate(message, errorCode)
+	}
+	workerPool.workers = nil
+}
+
+// runInWorker sends the code to the worker with the fewest pending runs. settle is called with the result object.
+func runInWorker(starlark_code string, options js.Value, settle func(result js.Value)) error {
+	workerPool.Lock()
+	defer workerPool.Unlock()
+	var best *poolWorker
+	for _, w := range workerPool.workers {
+		if !w.failed && (best == nil || len(w.pending) < len(best.pending)) {
+			best = w
+		}
+	}
+	if best == nil {
+		return errNoWorkers
+	}
+	workerPool.nextID++
+	id := workerPool.nextID
+	msg := map[string]interface{}{"starlark": "run", "id": id, "code": starlark_code, "options": options}
+	if !best.ready {
+		best.backlog = append(best.backlog, msg)
+	} else if err := best.post(msg); err != nil {
+		return err
+	}
+	index := best.index
+	best.pending[id] = func(result js.Value) {
+		if result.Type() == js.TypeObject {
+			result.Set("worker", index)
+		}
+		settle(result)
+	}
+	return nil
+}
+
+func (w *poolWorker) status() map[string]interface{} {
+	return map[string]interface{}{"index": w.index, "ready": w.ready, "failed": w.failed, "pending": len(w.pending), "completed": w.completed}
+}
+
+func workerPoolStatus() map[string]interface{} {
+	workerPool.Lock()
+	defer workerPool.Unlock()
+	workers := []interface{}{}
+	for _, w := range workerPool.workers {
+		workers = append(workers, w.status())
+	}
+	return map[string]interface{}{"workers": workers}
+}
+
+// jsResultToMap returns the fields of a result object received from a worker.
+func jsResultToMap(result js.Value) map[string]interface{} {
+	fields := map[string]interface{}{}
+	keys := jsObject.Call("keys", result)
+	for i := 0; i < keys.Length(); i++ {
+		key := keys.Index(i).String()
+		fields[key] = result.Get(key)
+	}
+	if message := result.Get("error"); message.Type() == js.TypeString {
+		fields["error"] = message.String()
+	}
+	return fields
+}
+
+func getWorkersConfigurer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		options := js.Undefined()
+		if len(args) > 0 {
+			options = args[0]
+		}
+		count, ok, err := getNumberOption(options, "count")
+		if err == nil && (!ok || count < 0 || count != float64(int(count))) {
+			err = fmt.Errorf("the option \"count\" must be a non negative integer. Actual value %v", count)
+		}
+		var spawn js.Value
+		if err == nil {
+			spawn, ok, err = getFunctionOption(options, "spawn")
+			if err == nil && !ok && count > 0 {
+				err = fmt.Errorf("the option \"spawn\" is required to spawn the workers")
+			}
+		}
+		if err != nil {
+			err := fmt.Errorf("Error: invalid options. Error: %q", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument"}
+		}
+		if err := configureWorkers(int(count), spawn); err != nil {
+			err := fmt.Errorf("Error: %v", err)
+			return map[string]interface{}{"error": err.Error(), "errorCode": "internal"}
+		}
+		return workerPoolStatus()
+	})
+}
+
+func getWorkerRunner() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return newPromise(func(resolve, reject func(interface{})) {
+			if len(args) < 1 {
+				err := fmt.Errorf("Error: expected at least one argument with the source code. Actual len(args) %d args %+v", len(args), args)
+				resolve(map[string]interface{}{"error": err.Error(), "stats": executionStats{}.toJS()})
+				return
+			}
+			options := js.Undefined()
+			if len(args) > 1 {
+				options = args[1]
+			}
+			rejectOnError, _ := getBoolOption(options, "rejectOnError")
+			err := runInWorker(args[0].String(), options, func(result js.Value) {
+				if result.Type() == js.TypeObject && !result.Get("error").IsUndefined() && rejectOnError {
+					reject(newStarlarkError(jsResultToMap(result)))
+					return
+				}
+				resolve(result)
+			})
+			if err != nil {
+				errorCode := "invalid_argument"
+				if err == errNoWorkers {
+					errorCode = "failed_precondition"
+				}
+				err := fmt.Errorf("Error: failed to run the code in a worker. Error: %q", err)
+				resolve(map[string]interface{}{"error": err.Error(), "errorCode": errorCode, "stats": executionStats{}.toJS()})
+			}
+		})
+	})
+}
+
+func getWorkerStatus() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return workerPoolStatus()
+	})
+}
+
+// serving is the state of serve_starlark_worker, which can only be called once.
+var serving struct {
+	sync.Mutex
+	started bool
+	handler js.Func
+}
+
+// getWorkerServer returns serve_starlark_worker, called in a Web Worker to run the code sent by the coordinator.
+// The runs are scheduled like the ones of run_starlark_code_async, so configure_starlark_scheduler applies to them.
+func getWorkerServer() js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		serving.Lock()
+		defer serving.Unlock()
+		if serving.started {
+			return map[string]interface{}{"error": "Error: the worker is already serving.", "errorCode": "failed_precondition"}
+		}
+		if js.Global().Get("postMessage").Type() != js.TypeFunction {
+			return map[string]interface{}{"error": "Error: serve_starlark_worker must be called in a Web Worker.", "errorCode": "failed_precondition"}
+		}
+		serving.started = true
+		serving.handler = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			data := args[0].Get("data")
+			if data.Type() != js.TypeObject || data.Get("starlark").String() != "run" {
+				return nil
+			}
+			id := data.Get("id")
+			startAsyncRun([]js.Value{data.Get("code"), data.Get("options")}, func(result map[string]interface{}) {
+				postResult(id, result)
+			})
+			return nil
+		})
+		js.Global().Call("addEventListener", "message", serving.handler)
+		js.Global().Call("postMessage", map[string]interface{}{"starlark": "ready"})
+		return map[string]interface{}{"message": "the worker is serving"}
+	})
+}
+
+// stopServing stops handling the messages of the coordinator, if serve_starlark_worker was called.
+func stopServing() {
+	serving.Lock()
+	defer serving.Unlock()
+	if !serving.started {
+		return
+	}
+	js.Global().Call("removeEventListener", "message", serving.handler)
+	serving.handler.Release()
+	serving.started = false
+}
+
+// postResult sends the result of a run to the coordinator. A result that can't be cloned (e.g. with returnFunctions) is replaced with an error.
+func postResult(id js.Value, result map[string]interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("Error: the result can't be sent to the coordinator. Error: %q", fmt.Sprint(r))
+			js.Global().Call("postMessage", map[string]interface{}{"starlark": "result", "id": id, "result": map[string]interface{}{"error": err.Error(), "errorCode": "invalid_argument", "stats": result["stats"]}})
+		}
+	}()
+	js.Global().Call("postMessage", map[string]interface{}{"starlark": "result", "id": id, "result": result})
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Harikrishnan Balagopal
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"syscall/js"
+
+	"go.starlark.net/starlark"
+)
+
+// yielder gives control back to the javascript event loop every yieldEverySteps steps, so long computations of
+// run_starlark_code_async keep the page responsive without a Web Worker. The execution goroutine waits for a
+// setTimeout callback, which lets the browser handle the pending events and render before the execution continues.
+type yielder struct {
+	every  uint64
+	next   uint64
+	yields int
+}
+
+func newYielder(every int) *yielder {
+	return &yielder{every: uint64(every), next: uint64(every)}
+}
+
+// check yields if enough steps have been executed since the last time. It runs as a checkpoint hook,
+// so the steps between two yields are at least checkpointInterval.
+func (y *yielder) check(thread *starlark.Thread) {
+	steps := thread.ExecutionSteps()
+	if steps < y.next {
+		return
+	}
+	yieldToEventLoop()
+	y.yields++
+	y.next = steps + y.every
+}
+
+// yieldToEventLoop blocks the goroutine until a javascript macrotask runs. It must only be called from a goroutine
+// that is allowed to block, like awaitPromise.
+func yieldToEventLoop() {
+	done := make(chan struct{})
+	callback := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		close(done)
+		return nil
+	})
+	defer callback.Release()
+	js.Global().Call("setTimeout", callback, 0)
+	<-done
+}